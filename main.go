@@ -3,16 +3,24 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/zlib"
+	"container/list"
 	"context"
+	crand "crypto/rand"
 	"crypto/tls"
+	"embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hash/fnv"
+	"html"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/png"
 	"io"
+	"io/fs"
 	"log"
 	"math"
 	"math/rand"
@@ -21,14 +29,22 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	xdraw "golang.org/x/image/draw"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/gofont/gobold"
 	"golang.org/x/image/font/gofont/gomedium"
@@ -38,6 +54,24 @@ import (
 	"golang.org/x/image/math/fixed"
 )
 
+// Build metadata, injected at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=...".
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// aboutText describes the running build for /about, so users can tell support
+// exactly which version they are on.
+func aboutText(lang, supportURL string) string {
+	text := trf(lang, "about_text", version, commit, buildDate, runtime.Version())
+	if supportURL != "" {
+		text += "\n" + trf(lang, "about_support", supportURL)
+	}
+	return text
+}
+
 // Bot exposes a minimal Telegram client (no external deps) built on long polling.
 type Bot struct {
 	token         string
@@ -45,10 +79,11 @@ type Bot struct {
 	client        *http.Client
 	offset        int
 	state         *StateStore
-	calendars     map[string][]DayTimes
+	scheduleMu    sync.RWMutex
+	calendars     *CalendarStore
 	tz            *time.Location
 	scheduler     *ReminderManager
-	hadithsByLang map[string][]string
+	hadithsByLang map[string][]Hadith
 	niyatSuhoor   map[string]string
 	niyatIftar    map[string]string
 	ramadanStart  time.Time
@@ -57,23 +92,88 @@ type Bot struct {
 	hadithAPIURL  string
 	hadithCatsMu  sync.RWMutex
 	hadithCats    map[string]cachedHadithCategories
+	zakatRate     float64
+	zakatUnit     string
+	lastPoll      atomic.Int64
+	ready         atomic.Bool
+	workers       []chan Update
+	sender        Sender
+	adminIDs      map[int64]bool
+	supportURL    string
+	publicURL     string
+	duaAudioPaths map[string]string
+	limiter       *chatLimiter
+	useRichText   bool
+	duaAudioMu    sync.Mutex
+	duaAudio      map[string][]byte
+}
+
+// Sender is the subset of the Bot API used by handlers and reminders. Bot implements it
+// over HTTP; tests substitute a recording fake.
+type Sender interface {
+	SendMessage(chatID int64, text string, markup interface{}) (*Message, error)
+	SendMessageWithMode(chatID int64, text string, markup interface{}, parseMode string) (*Message, error)
+	SendMessageWithPreview(chatID int64, text string, markup interface{}) (*Message, error)
+	SendPhoto(chatID int64, photo []byte, caption string) (*Message, error)
+	SendPhotoWithMarkup(chatID int64, photo []byte, caption string, markup interface{}) (*Message, error)
+	EditMessagePhoto(chatID int64, messageID int, photo []byte, caption string, markup interface{}) error
+	SendVoice(chatID int64, audio []byte, caption string) (*Message, error)
+	SendDocument(chatID int64, data []byte, filename, caption string) (*Message, error)
+	EditMessageText(chatID int64, messageID int, text string, markup interface{}) error
+	EditMessageReplyMarkup(chatID int64, messageID int, markup interface{}) error
+	AnswerCallback(id string) error
+	PinChatMessage(chatID int64, messageID int) error
+	SetMessageReaction(chatID int64, messageID int, emoji string) error
+	GetChatMember(chatID, userID int64) (*ChatMember, error)
 }
 
 type Update struct {
-	UpdateID      int            `json:"update_id"`
-	Message       *Message       `json:"message,omitempty"`
-	CallbackQuery *CallbackQuery `json:"callback_query,omitempty"`
+	UpdateID      int                `json:"update_id"`
+	Message       *Message           `json:"message,omitempty"`
+	CallbackQuery *CallbackQuery     `json:"callback_query,omitempty"`
+	InlineQuery   *InlineQuery       `json:"inline_query,omitempty"`
+	MyChatMember  *ChatMemberUpdated `json:"my_chat_member,omitempty"`
+}
+
+// ChatMemberUpdated reports a change in a chat member's status; the bot receives it as
+// my_chat_member when it is added to or removed from a chat.
+type ChatMemberUpdated struct {
+	Chat          Chat       `json:"chat"`
+	From          User       `json:"from"`
+	OldChatMember ChatMember `json:"old_chat_member"`
+	NewChatMember ChatMember `json:"new_chat_member"`
+}
+
+type ChatMember struct {
+	Status string `json:"status"`
+}
+
+type InlineQuery struct {
+	ID     string `json:"id"`
+	From   User   `json:"from"`
+	Query  string `json:"query"`
+	Offset string `json:"offset"`
 }
 
 type Message struct {
-	MessageID int    `json:"message_id"`
-	Chat      Chat   `json:"chat"`
-	Text      string `json:"text"`
-	Date      int64  `json:"date"`
+	MessageID int   `json:"message_id"`
+	From      *User `json:"from,omitempty"`
+	// SenderChat is set when a group's anonymous admin writes on behalf of the group.
+	SenderChat *Chat     `json:"sender_chat,omitempty"`
+	Chat       Chat      `json:"chat"`
+	Text       string    `json:"text"`
+	Date       int64     `json:"date"`
+	Location   *Location `json:"location,omitempty"`
+}
+
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
 }
 
 type Chat struct {
-	ID int64 `json:"id"`
+	ID   int64  `json:"id"`
+	Type string `json:"type,omitempty"`
 }
 
 type CallbackQuery struct {
@@ -84,7 +184,8 @@ type CallbackQuery struct {
 }
 
 type User struct {
-	ID int64 `json:"id"`
+	ID           int64  `json:"id"`
+	LanguageCode string `json:"language_code,omitempty"`
 }
 
 type InlineKeyboardMarkup struct {
@@ -103,6 +204,11 @@ type ReplyKeyboardMarkup struct {
 	InputFieldPlaceholder string             `json:"input_field_placeholder,omitempty"`
 }
 
+// ReplyKeyboardRemove asks the client to drop the current reply keyboard.
+type ReplyKeyboardRemove struct {
+	RemoveKeyboard bool `json:"remove_keyboard"`
+}
+
 type KeyboardButton struct {
 	Text string `json:"text"`
 }
@@ -120,6 +226,33 @@ type sendMessageRequest struct {
 	DisableWebPagePreview bool        `json:"disable_web_page_preview"`
 }
 
+type answerInlineQueryRequest struct {
+	InlineQueryID string                     `json:"inline_query_id"`
+	Results       []InlineQueryResultArticle `json:"results"`
+	CacheTime     int                        `json:"cache_time"`
+	IsPersonal    bool                       `json:"is_personal"`
+}
+
+type InlineQueryResultArticle struct {
+	Type                string                  `json:"type"`
+	ID                  string                  `json:"id"`
+	Title               string                  `json:"title"`
+	Description         string                  `json:"description,omitempty"`
+	InputMessageContent InputTextMessageContent `json:"input_message_content"`
+}
+
+type InputTextMessageContent struct {
+	MessageText string `json:"message_text"`
+}
+
+type editMessageTextRequest struct {
+	ChatID                int64       `json:"chat_id"`
+	MessageID             int         `json:"message_id"`
+	Text                  string      `json:"text"`
+	ReplyMarkup           interface{} `json:"reply_markup,omitempty"`
+	DisableWebPagePreview bool        `json:"disable_web_page_preview"`
+}
+
 type hadithAPICategory struct {
 	ID    string `json:"id"`
 	Title string `json:"title"`
@@ -157,23 +290,104 @@ type DayTimes struct {
 	Fajr      int
 	Dhuhr     int
 	Asr       int
-	Maghrib   int
-	Isha      int
+	// Asr is the timetable's asr, which the muftiate publishes with the Hanafi shadow
+	// factor of 2; AsrStandard is the earlier time for a shadow factor of 1.
+	AsrStandard int
+	Maghrib     int
+	Isha        int
 }
 
 // StateStore keeps chat-specific preferences in memory.
 type StateStore struct {
-	mu          sync.Mutex
-	users       map[int64]*UserSettings
+	mu    sync.Mutex
+	users map[int64]*UserSettings
+	// members holds per-member preferences inside group chats; the group's shared
+	// settings (region, reminders) stay in users under the group's chat ID.
+	members map[memberKey]*MemberSettings
+	// calendarTokens maps each CalendarToken to its chat, so feed requests do not scan
+	// every chat under the lock.
+	calendarTokens map[string]int64
+	// latestMember maps a user to the group membership whose language they set last.
+	latestMember map[int64]memberKey
+	// sentFlush is the pending batched write of sent reminders to the state file.
+	sentFlush   *time.Timer
 	persistPath string
 	redis       *redisStore
 }
 
+// MemberSettings are one member's own preferences in a group chat.
+type MemberSettings struct {
+	Language string `json:",omitempty"`
+	// SetAt is when Language was chosen; the latest choice across groups is the
+	// member's language outside any chat.
+	SetAt time.Time
+}
+
+// memberKey identifies a member of a group chat.
+type memberKey struct {
+	ChatID int64
+	UserID int64
+}
+
+// String is the "chatID:userID" form used in state.json and redis.
+func (k memberKey) String() string {
+	return strconv.FormatInt(k.ChatID, 10) + ":" + strconv.FormatInt(k.UserID, 10)
+}
+
+func parseMemberKey(raw string) (memberKey, bool) {
+	chatRaw, userRaw, ok := strings.Cut(strings.TrimSpace(raw), ":")
+	if !ok {
+		return memberKey{}, false
+	}
+	chatID, err := strconv.ParseInt(chatRaw, 10, 64)
+	if err != nil {
+		return memberKey{}, false
+	}
+	userID, err := strconv.ParseInt(userRaw, 10, 64)
+	if err != nil {
+		return memberKey{}, false
+	}
+	return memberKey{ChatID: chatID, UserID: userID}, true
+}
+
 type UserSettings struct {
 	Language       string
 	Region         string
 	Notifications  bool
 	RegionSelected bool
+	Theme          string
+	Tahajjud       bool
+	DailyDigest    bool
+	DigestLead     int
+	Blocked        bool
+	TasbihCount    int
+	TasbihTarget   int
+	HadithCard     bool
+	MenuHidden     bool
+	AyahCard       bool `json:",omitempty"`
+	ImagesDisabled bool
+	AsrMethod      string `json:",omitempty"`
+	// QuietFrom and QuietTo bound the quiet hours in minutes of the day; equal values
+	// mean no quiet hours.
+	QuietFrom int `json:",omitempty"`
+	QuietTo   int `json:",omitempty"`
+	// FastSeason is the Ramadan start (YYYY-MM-DD) the fasting counters belong to;
+	// FastDays lists the confirmed Ramadan days, so repeated taps count once, and
+	// LastFastDay is the latest of them.
+	FastSeason  string `json:",omitempty"`
+	FastCount   int    `json:",omitempty"`
+	FastStreak  int    `json:",omitempty"`
+	LastFastDay int    `json:",omitempty"`
+	FastDays    []int  `json:",omitempty"`
+	// SentDate and SentReminders record which reminders went out on SentDate (YYYY-MM-DD)
+	// so a restart does not repeat them.
+	SentDate      string   `json:",omitempty"`
+	SentReminders []string `json:",omitempty"`
+	// MutedUntil pauses reminders until that moment (/mute); nil means not muted.
+	MutedUntil *time.Time `json:",omitempty"`
+	// CalendarToken names the chat's subscribable feed at /calendar/<token>.ics; it is
+	// created on the first /subscribe.
+	CalendarToken string `json:",omitempty"`
 }
 
 type redisStore struct {
@@ -184,6 +398,7 @@ type redisStore struct {
 	password   string
 	db         int
 	usersKey   string
+	membersKey string
 	timeout    time.Duration
 }
 
@@ -191,24 +406,167 @@ type redisStore struct {
 type ReminderManager struct {
 	mu            sync.Mutex
 	active        map[int64]*reminderState
-	calendar      map[string][]DayTimes
+	startMu       sync.RWMutex
+	calendar      *CalendarStore
 	loc           *time.Location
 	ramadanStart  time.Time
-	sendFn        func(chatID int64, text string) error
-	sendPhotoFn   func(chatID int64, photo []byte, caption string) error
+	sender        Sender
 	getLangFn     func(chatID int64) string
-	hadithsByLang map[string][]string
+	renderOptsFn  func(chatID int64) renderOptions
+	settingsFn    func(chatID int64) UserSettings
+	blockedFn     func(chatID int64)
+	sentFn        func(chatID int64, date string) map[string]bool
+	markSentFn    func(chatID int64, date, key string)
+	hadithsByLang map[string][]Hadith
 	niyatSuhoor   map[string]string
 	niyatIftar    map[string]string
 	imageCache    *imageCache
+	useRichText   bool
+	drift         driftStats
+	// reaction is an emoji the bot puts on its own reminder photos (REMINDER_REACTION);
+	// empty means none.
+	reaction string
+	// tick is how often a running loop checks for due reminders (default 30s) and
+	// outOfRangeWait how long it idles when today is outside the calendar (default 6h).
+	tick           time.Duration
+	outOfRangeWait time.Duration
+	// now is the loop's clock; nil means time.Now. Tests use it to simulate a whole day.
+	now func() time.Time
+	// afterFunc arms the timer that resumes a muted chat; nil means time.AfterFunc.
+	// Tests use it to fire the timer themselves.
+	afterFunc func(d time.Duration, f func()) (stop func() bool)
+}
+
+const (
+	defaultReminderTick           = 30 * time.Second
+	defaultReminderOutOfRangeWait = 6 * time.Hour
+	// reminderLead is how long before an event its reminder goes out.
+	reminderLead = 30 * time.Minute
+	// reminderDriftWarn is how late a reminder may go out before it is logged as a warning.
+	// A healthy loop is at most one tick late.
+	reminderDriftWarn = 2 * time.Minute
+)
+
+// driftStats tracks how late reminder loops send reminders compared with their scheduled
+// moment, to tell whether the tick interval or load delays them.
+type driftStats struct {
+	mu   sync.Mutex
+	day  string // YYYY-MM-DD that max belongs to
+	max  time.Duration
+	last time.Duration
+	sent int64
+	late int64
+}
+
+// record adds one reminder's drift. When day moves on, the previous day's maximum is
+// logged and the maximum starts over.
+func (d *driftStats) record(day string, drift time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if day != d.day {
+		if d.day != "" {
+			log.Printf("Reminder drift for %s: max %s", d.day, d.max.Round(time.Second))
+		}
+		d.day, d.max = day, 0
+	}
+	d.last = drift
+	d.max = max(d.max, drift)
+	d.sent++
+	if drift > reminderDriftWarn {
+		d.late++
+	}
+}
+
+type driftSnapshot struct {
+	Day       string
+	Max, Last time.Duration
+	Sent      int64
+	Late      int64
+}
+
+func (d *driftStats) snapshot() driftSnapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return driftSnapshot{Day: d.day, Max: d.max, Last: d.last, Sent: d.sent, Late: d.late}
+}
+
+func (rm *ReminderManager) clock() time.Time {
+	if rm.now != nil {
+		return rm.now().In(rm.loc)
+	}
+	return time.Now().In(rm.loc)
+}
+
+func (rm *ReminderManager) startTimer(d time.Duration, f func()) func() bool {
+	if rm.afterFunc != nil {
+		return rm.afterFunc(d, f)
+	}
+	return time.AfterFunc(d, f).Stop
+}
+
+func (rm *ReminderManager) tickInterval() time.Duration {
+	if rm.tick > 0 {
+		return rm.tick
+	}
+	return defaultReminderTick
 }
 
+func (rm *ReminderManager) outOfRangeDelay() time.Duration {
+	if rm.outOfRangeWait > 0 {
+		return rm.outOfRangeWait
+	}
+	return defaultReminderOutOfRangeWait
+}
+
+// imageCache is an LRU of rendered PNGs with a per-entry TTL. It is bounded by entry count
+// and, when maxBytes > 0, by the total size of the cached images.
 type imageCache struct {
-	mu    sync.RWMutex
-	items map[string]cachedImage
+	mu         sync.Mutex
+	items      map[string]*list.Element
+	order      *list.List // front = most recently used
+	maxEntries int
+	maxBytes   int
+	totalBytes int
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+	// failures counts consecutive failed builds; once it reaches renderBreakerThreshold
+	// builds are skipped until suspendedUntil, so a broken renderer is not retried on
+	// every request.
+	failures       int
+	suspendedUntil time.Time
+	// probing is set while the single trial build after a cooldown runs; other callers
+	// keep getting errRenderingSuspended until it finishes.
+	probing bool
+	// now is the clock used for expiry; tests replace it to step past a TTL.
+	now func() time.Time
+}
+
+type imageCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+	Bytes   int
+	// SuspendedUntil is set while rendering is skipped after repeated failures.
+	SuspendedUntil time.Time
+}
+
+func (c *imageCache) Stats() imageCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := imageCacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: c.order.Len(),
+		Bytes:   c.totalBytes,
+	}
+	if c.now().Before(c.suspendedUntil) {
+		stats.SuspendedUntil = c.suspendedUntil
+	}
+	return stats
 }
 
 type cachedImage struct {
+	key       string
 	data      []byte
 	expiresAt time.Time
 }
@@ -232,6 +590,9 @@ type eventSpec struct {
 	UseSuhoor bool
 }
 
+// The bot speaks these four languages only. Eastern Arabic digits for Arabic and Farsi
+// cards are out of scope until one of those languages is added with its translations
+// and fonts.
 const (
 	langTG = "tg"
 	langRU = "ru"
@@ -239,19 +600,87 @@ const (
 	langUZ = "uz"
 )
 
+// supportedLangs lists the interface languages in the order they are offered.
+var supportedLangs = []string{langTG, langRU, langEN, langUZ}
+
+// hijriMonthNames holds the twelve Hijri month names per interface language, Muharram first.
+var hijriMonthNames = map[string][12]string{
+	langTG: {"Муҳаррам", "Сафар", "Рабеъулаввал", "Рабеъуссонӣ", "Ҷумодиюлаввал", "Ҷумодиюссонӣ", "Раҷаб", "Шаъбон", "Рамазон", "Шаввол", "Зулқаъда", "Зулҳиҷҷа"},
+	langRU: {"Мухаррам", "Сафар", "Раби аль-авваль", "Раби ас-сани", "Джумада аль-уля", "Джумада ас-сани", "Раджаб", "Шаабан", "Рамадан", "Шавваль", "Зуль-када", "Зуль-хиджа"},
+	langEN: {"Muharram", "Safar", "Rabi al-Awwal", "Rabi al-Thani", "Jumada al-Awwal", "Jumada al-Thani", "Rajab", "Shaban", "Ramadan", "Shawwal", "Dhu al-Qadah", "Dhu al-Hijjah"},
+	langUZ: {"Muharram", "Safar", "Rabiul avval", "Rabiul oxir", "Jumodul avval", "Jumodul oxir", "Rajab", "Sha'bon", "Ramazon", "Shavvol", "Zulqa'da", "Zulhijja"},
+}
+
+// gregorianToHijri converts the calendar date of t to the tabular (arithmetic) Islamic
+// calendar. It is an approximation: the official Umm al-Qura and sighting-based dates
+// can differ by a day or two, so it is only used for display. name is the English month.
+func gregorianToHijri(t time.Time) (year int, month int, day int, name string) {
+	// Julian Day Number of the civil date; 2440588 is 1970-01-01.
+	date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	jdn := int(date.Unix()/86400) + 2440588
+
+	l := jdn - 1948440 + 10632
+	n := (l - 1) / 10631
+	l = l - 10631*n + 354
+	j := ((10985-l)/5316)*((50*l)/17719) + (l/5670)*((43*l)/15238)
+	l = l - ((30-j)/15)*((17719*j)/50) - (j/16)*((15238*j)/43) + 29
+	month = (24 * l) / 709
+	day = l - (709*month)/24
+	year = 30*n + j - 30
+	return year, month, day, hijriMonthNames[langEN][month-1]
+}
+
+// formatHijriDate renders t as e.g. "15 Ramadan 1447" in the given language.
+func formatHijriDate(lang string, t time.Time) string {
+	year, month, day, _ := gregorianToHijri(t)
+	return formatHijriParts(lang, year, month, day)
+}
+
+func formatHijriParts(lang string, year, month, day int) string {
+	name := hijriMonthNames[langEN][month-1]
+	if names, ok := hijriMonthNames[normalizeLang(lang)]; ok {
+		name = names[month-1]
+	}
+	return fmt.Sprintf("%d %s %d", day, name, year)
+}
+
+// hijriRamadan is the Ramadan month number in the Hijri calendar.
+const hijriRamadan = 9
+
+// dayHijriDate formats the Hijri date of a calendar row, or "" if its date cannot be parsed.
+// Within Ramadan the day comes from the row's day number, since RAMADAN_START follows the
+// sighting while the arithmetic calendar can be a day off.
+func dayHijriDate(lang string, day DayTimes) string {
+	date, err := time.Parse("02.01.2006", day.Data)
+	if err != nil {
+		return ""
+	}
+	if day.Day >= 1 && day.Day <= ramadanDays {
+		year, _, _, _ := gregorianToHijri(date)
+		return formatHijriParts(lang, year, hijriRamadan, day.Day)
+	}
+	return formatHijriDate(lang, date)
+}
+
 var translations = map[string]map[string]string{
 	langTG: {
 		"choose_language":         "Лутфан забони худро интихоб кунед:\n\nТоҷикӣ / Русский / English / O'zbek",
 		"language_saved":          "Забон интихоб шуд.",
+		"mylang_usage":            "/mylang en — забони шахсии шумо барои ҷавобҳои inline (@бот дар ҳар чат) ва огоҳиҳое, ки танҳо ба шумо дахл доранд (tg, ru, en, uz). Паёмҳои гурӯҳ бо забони гурӯҳ мемонанд. /mylang off — бекор кардан.",
+		"mylang_set":              "Ҷавобҳои inline барои шумо бо забони тоҷикӣ хоҳанд буд. Паёмҳои ин гурӯҳ бо забони гурӯҳ мемонанд.",
+		"mylang_off":              "Забони шахсии шумо барои ин гурӯҳ бекор карда шуд.",
 		"choose_region":           "Минтақаи худро интихоб кунед:",
 		"welcome":                 "Ассалому алайкум! Ман барои тақвими Рамазон, ёдовариҳо ва ниятҳо кӯмак мекунам.\n\nФармонҳо:\n/lang — ивази забон\n/region — интихоби минтақа\n/calendar — тақвими Рамазон (саҳар ва ифтор)\n/today — вақтҳои имрӯз (саҳар ва ифтор)\n/hadiths — ҳадиси тасодуфӣ аз API\n/notifyoff — хомӯш кардани ёдовариҳо\n/notifyon — фаъол кардани ёдовариҳо\n/testnotify — ирсоли ёдоварии санҷишӣ\n/menu ё /help — меню ва клавиатура",
-		"help":                    "Фармонҳо:\n/lang — ивази забон\n/region — интихоби минтақа\n/calendar — тақвими Рамазон (саҳар ва ифтор)\n/today — вақтҳои имрӯз (саҳар ва ифтор)\n/hadiths — ҳадиси тасодуфӣ аз API\n/notifyoff — хомӯш кардани ёдовариҳо\n/notifyon — фаъол кардани ёдовариҳо\n/testnotify — ирсоли ёдоварии санҷишӣ\n/menu ё /help — меню ва клавиатура",
+		"help":                    "Фармонҳо:\n/lang [tg/ru/en/uz] — ивази забон\n/mylang en — забони шахсии шумо барои ҷавобҳои inline (@бот)\n/region [ном] — интихоби минтақа\n/settings — танзимоти ман\n/theme — мавзӯи тасвирҳо\n/calendar — тақвими Рамазон (саҳар ва ифтор)\n/calendartext — тақвим ҳамчун матн\n/calendarpdf — тақвим ҳамчун PDF\n/ics — вақтҳо барои барномаи тақвим (.ics)\n/subscribe [reset] — обуна ба тақвим бо навсозии худкор (reset — пайванди нав)\n/today — вақтҳои имрӯз (саҳар ва ифтор)\n/day N — вақтҳои рӯзи N-и Рамазон\n/prayers — ҳамаи вақтҳои намози имрӯз\n/qibla — самти қибла\n/dua — нияти саҳару ифтор ва дуоҳои Рамазон\n/tasbih — ҳисобкунаки тасбеҳ\n/progress — пешрафти рӯзадорӣ\n/countdown — то Рамазон чанд рӯз монд\n/pintoday — вақтҳои имрӯзро дар гурӯҳ сабт (pin) кардан\n/hadiths [мавзӯъ] — ҳадиси тасодуфӣ (масалан, рӯза, дуо, илм)\n/ayah — ояти рӯз бо тарҷума\n/tahajjud — ёдоварии таҳаҷҷуд (фаъол/хомӯш)\n/madhab — усули ҳисоби аср (стандартӣ/ҳанафӣ)\n/hadithcard — ҳадиси рӯз дар тасвир (фаъол/хомӯш)\n/ayahcard — ояти рӯз дар тасвир (фаъол/хомӯш)\n/digest [дақиқа] — хулосаи рӯзона пеш аз саҳар\n/quiet 22:00 05:00 — соатҳои ором барои ёдовариҳо\n/zakatfitr [нафар] — ҳисоби закоти фитр\n/notifyoff — хомӯш кардани ёдовариҳо\n/notifyon — фаъол кардани ёдовариҳо\n/mute 3h — қатъи муваққатии ёдовариҳо\n/testnotify [рӯйдод] — ирсоли ёдоварии санҷишӣ\n/preview — ҳамаи ёдовариҳои имрӯз\n/catchup — ёдовариҳои гузаштаи имрӯз\n/compare A B — муқоисаи саҳар ва ифтори ду минтақа\n/about — версия ва маълумоти сохт\n/textmode — ҳолати бе тасвир (фаъол/хомӯш)\n/hidemenu, /showmenu — пинҳон/нишон додани клавиатура\n/menu ё /help — меню ва клавиатура",
 		"region_selected":         "Минтақа интихоб шуд: %s\nЁдовариҳо ба таври худкор фаъол шуданд (30 дақиқа пеш аз ҳар намоз, саҳар ва ифтор).",
 		"need_region_first":       "Лутфан аввал минтақаро бо /region интихоб кунед.",
 		"calendar_not_found":      "Тақвим барои минтақаи интихобшуда ёфт нашуд. Минтақаро бо /region аз нав интихоб кунед.",
-		"out_of_range":            "Ҳоло берун аз доираи тақвими Рамазон аст. Санаи оғозро дар RAMADAN_START санҷед.",
+		"card_failed":             "Тасвирро фиристода натавонистам. Лутфан пас аз як дақиқа боз кӯшиш кунед.",
+		"out_of_range":            "Ҳоло берун аз доираи тақвими Рамазон аст (%s – %s). Санаи оғозро дар RAMADAN_START санҷед.",
 		"calendar_caption":        "Тақвими Рамазон (%s)\n\n%s",
-		"today_caption":           "%s • %s • Рӯзи %d\n\n%s",
+		"today_caption":           "%s • %s (%s) • %s\n\n%s",
+		"day_caption":             "%s • %s (%s) • %s",
+		"day_out_of_range":        "Рақами рӯзро аз 1 то %d нависед, масалан: /day 12",
 		"test_region_default":     "Минтақа интихоб нашудааст, санҷиш барои минтақаи %s фиристода мешавад.",
 		"test_notification_title": "Ёдоварии санҷишӣ",
 		"need_region_notify":      "Барои идоракунии ёдовариҳо минтақаро интихоб кунед:",
@@ -259,13 +688,112 @@ var translations = map[string]map[string]string{
 		"notify_disabled":         "Ёдовариҳо хомӯш шуданд.",
 		"rem_no_calendar_region":  "Тақвим барои минтақаи %s ёфт нашуд.",
 		"rem_before_start":        "То оғози Рамазон %.0f соат монд. Ёдовариҳо худкор фаъол мешаванд.",
-		"rem_out_of_range":        "Тақвими Рамазон анҷом ёфтааст ё ҳанӯз оғоз нашудааст. Лутфан RAMADAN_START-ро санҷед.",
-		"rem_headline":            "Минтақа: %s\nРӯзи %d Рамазон\nБаъд аз 30 дақиқа: %s соати %s",
+		"rem_out_of_range":        "Тақвими Рамазон (%s – %s) анҷом ёфтааст ё ҳанӯз оғоз нашудааст. Лутфан RAMADAN_START-ро санҷед.",
+		"rem_headline":            "Минтақа: %s\n%s\nБаъд аз 30 дақиқа: %s соати %s",
 		"niyat_suhoor_label":      "Нияти саҳар:\n",
 		"niyat_iftar_label":       "Нияти ифтор:\n",
+		"dua_prompt":              "Дуоро интихоб кунед:",
+		"btn_dua_suhoor":          "🌙 Нияти саҳар",
+		"btn_dua_iftar":           "🌅 Нияти ифтор",
+		"tasbih_count":            "📿 Тасбеҳ: %d",
+		"tasbih_target":           "Ҳадаф: %d",
+		"tasbih_done":             "✅ Ҳадаф иҷро шуд!",
+		"btn_tasbih_reset":        "↺ Аз нав",
+		"btn_page_more":           "Боз ▶",
+		"btn_page_back":           "◀ Бозгашт",
+		"region_candidates":       "Якчанд минтақа ёфт шуд, интихоб кунед:",
+		"preview_intro":           "Ёдовариҳои имрӯз (%d):",
+		"catchup_intro":           "Ёдовариҳои гузаштаи имрӯз (%d):",
+		"catchup_none":            "Имрӯз ягон ёдоварӣ гум нашудааст.",
+		"compare_usage":           "Муқоисаи ду минтақа: /compare Душанбе Хуҷанд (ё танҳо /compare Хуҷанд — бо минтақаи шумо).",
+		"compare_title":           "Муқоиса • %s • %s",
+		"compare_diff":            "%s: саҳар %s дақ., ифтор %s дақ.",
+		"testnotify_invalid":      "Рӯйдоди номаълум. Инҳоро истифода баред: %s",
+		"rate_limited":            "Лутфан каме сабр кунед ва баъд боз кӯшиш кунед.",
+		"menu_hidden":             "Клавиатураи меню пинҳон шуд. Барои баргардонидан /showmenu нависед.",
+		"menu_shown":              "Клавиатураи меню баргардонида шуд.",
+		"calendar_text_title":     "Тақвими Рамазон (%s)",
+		"calendar_pdf_caption":    "Тақвими Рамазон барои чоп (%s)",
+		"ics_caption":             "Вақтҳои намози Рамазон барои тақвими шумо (%s). Файлро кушоед, то ба тақвим илова шавад.",
+		"subscribe_link":          "Ба ин тақвим обуна шавед, то вақтҳо худкор нав шаванд:\n%s",
+		"subscribe_unavailable":   "Обуна ба тақвим дар ин бот фаъол нест. Ба ҷояш /ics-ро истифода баред.",
+		"subscribe_reset":         "Суроғаи пешина дигар кор намекунад. Ба суроғаи нав обуна шавед:\n%s",
+		"textmode_on":             "Ҳолати матнӣ фаъол шуд: тақвим, имрӯз ва ёдовариҳо бе тасвир фиристода мешаванд.",
+		"textmode_off":            "Ҳолати матнӣ хомӯш шуд, тасвирҳо баргаштанд.",
+		"asr_prompt":              "Усули ҳисоби вақти аср-ро интихоб кунед:",
+		"asr_standard":            "Стандартӣ (Шофеӣ, Моликӣ, Ҳанбалӣ)",
+		"asr_hanafi":              "Ҳанафӣ",
+		"asr_saved_standard":      "Вақти аср бо усули стандартӣ ҳисоб карда мешавад.",
+		"asr_saved_hanafi":        "Вақти аср бо усули ҳанафӣ ҳисоб карда мешавад.",
+		"btn_fasted":              "✅ Имрӯз рӯза доштам",
+		"fast_confirmed":          "Қабул бошад! Рӯзаи имрӯз ҳисоб шуд.",
+		"fast_already":            "Ин рӯз аллакай ҳисоб шудааст.",
+		"progress_text":           "Рӯзи %d/%d, %d рӯз тасдиқ шуд, пайдарпай: %d",
+		"progress_outside":        "Ҳоло Рамазон нест. Тасдиқ шуд: %d аз %d рӯз.",
+		"day_label":               "Рӯзи %s",
+		"day_ordinal":             "%d-ум",
+		"rem_day_label":           "Рӯзи %sи Рамазон",
+		"day_label_eve":           "Арафаи Рамазон",
+		"countdown_before":        "То Рамазон %d рӯз ва %d соат монд.",
+		"countdown_ongoing":       "Рамазон идома дорад: рӯзи %d аз %d.",
+		"countdown_ended":         "Рамазони имсола анҷом ёфт. Иди шумо муборак!",
+		"about_text":              "Боти Рамазон %s\nКоммит: %s\nСанаи сохт: %s\nGo: %s",
+		"about_support":           "Дастгирӣ: %s",
 		"hadith_day_title":        "Ҳадиси рӯз",
+		"ayah_day_title":          "Ояти рӯз",
+		"ayah_reference":          "Қуръон, %s",
 		"hadith_title_default":    "Ҳадис",
 		"hadith_source":           "Манбаъ",
+		"hadith_tag_usage":        "Мавзӯъро нависед, масалан: /hadiths рӯза\nМавзӯъҳо: %s",
+		"hadith_tag_fasting":      "рӯза",
+		"hadith_tag_dua":          "дуо",
+		"hadith_tag_knowledge":    "илм",
+		"hadith_tag_quran":        "қуръон",
+		"hadith_tag_intention":    "ният",
+		"hadith_tag_purity":       "покизагӣ",
+		"hadith_tag_brotherhood":  "бародарӣ",
+		"hadith_tag_forgiveness":  "омурзиш",
+		"cmd_start":               "Оғоз ва интихоби забон",
+		"cmd_lang":                "Ивази забон",
+		"cmd_mylang":              "Забони шахсӣ барои ҷавобҳои inline",
+		"cmd_menu":                "Меню ва кӯмак",
+		"cmd_region":              "Интихоби минтақа",
+		"cmd_settings":            "Танзимоти ман",
+		"cmd_theme":               "Мавзӯи тасвирҳо",
+		"cmd_calendar":            "Тақвими Рамазон",
+		"cmd_today":               "Вақтҳои имрӯз",
+		"cmd_day":                 "Вақтҳои як рӯзи Рамазон",
+		"cmd_qibla":               "Самти қибла",
+		"cmd_dua":                 "Ният ва дуоҳои Рамазон",
+		"cmd_tasbih":              "Ҳисобкунаки тасбеҳ",
+		"cmd_hadiths":             "Ҳадиси тасодуфӣ",
+		"cmd_zakatfitr":           "Ҳисоби закоти фитр",
+		"cmd_digest":              "Хулосаи рӯзона",
+		"cmd_quiet":               "Соатҳои ором",
+		"cmd_compare":             "Муқоисаи ду минтақа",
+		"cmd_prayers":             "Ҳамаи вақтҳои намози имрӯз",
+		"cmd_tahajjud":            "Ёдоварии таҳаҷҷуд",
+		"cmd_hadithcard":          "Ҳадис дар тасвирҳо",
+		"cmd_ayah":                "Ояти рӯз",
+		"cmd_ayahcard":            "Оят дар тасвирҳо",
+		"cmd_calendartext":        "Тақвим ҳамчун матн",
+		"cmd_calendarpdf":         "Тақвим ҳамчун PDF",
+		"cmd_ics":                 "Вақтҳо барои барномаи тақвим",
+		"cmd_subscribe":           "Обуна ба тақвим",
+		"cmd_madhab":              "Усули ҳисоби аср",
+		"cmd_progress":            "Пешрафти рӯзадорӣ",
+		"cmd_countdown":           "То Рамазон чанд рӯз",
+		"cmd_pintoday":            "Сабти вақтҳои имрӯз дар гурӯҳ",
+		"cmd_textmode":            "Ҳолати бе тасвир",
+		"cmd_hidemenu":            "Пинҳон кардани клавиатура",
+		"cmd_showmenu":            "Нишон додани клавиатура",
+		"cmd_notifyon":            "Фаъол кардани ёдовариҳо",
+		"cmd_notifyoff":           "Хомӯш кардани ёдовариҳо",
+		"cmd_mute":                "Қатъи муваққатии ёдовариҳо",
+		"cmd_preview":             "Ёдовариҳои имрӯз",
+		"cmd_catchup":             "Ёдовариҳои гузаштаи имрӯз",
+		"cmd_testnotify":          "Ёдоварии санҷишӣ",
+		"cmd_about":               "Версия ва маълумоти сохт",
 		"hadith_fallback":         "Аллоҳ рӯза ва ибодатҳои шуморо қабул фармояд.",
 		"img_calendar_title":      "Тақвими моҳи шарифи Рамазон",
 		"img_start_prefix":        "Оғоз ",
@@ -279,12 +807,20 @@ var translations = map[string]map[string]string{
 		"img_calendar_footer":     "«Рӯза сипар аст» — ҳадис аз Паёмбар ﷺ (Бухорӣ).",
 		"img_today_title":         "Имрӯз дар Рамазон",
 		"img_region_prefix":       "Минтақа: ",
-		"img_date_day":            "Сана: %s    Рӯз: %d",
+		"img_date_day":            "Сана: %s    %s",
 		"img_today_suhoor_label":  "Саҳар то",
 		"img_today_iftar_label":   "Ифтор",
 		"img_today_footer":        "Саҳар бо даромадани намози бомдод анҷом мешавад.",
+		"img_compare_title":       "Муқоисаи минтақаҳо",
+		"img_prayers_title":       "Вақтҳои намози имрӯз",
+		"img_prayer_suhoor":       "Саҳар то",
+		"img_prayer_fajr":         "Бомдод",
+		"img_prayer_dhuhr":        "Пешин",
+		"img_prayer_asr":          "Аср",
+		"img_prayer_maghrib":      "Шом (ифтор)",
+		"img_prayer_isha":         "Хуфтан",
 		"img_rem_title":           "Ёдоварии намоз",
-		"img_rem_day_date":        "Рӯзи %d • %s",
+		"img_rem_day_date":        "%s • %s",
 		"img_rem_footer":          "Баъд аз 30 дақиқа. Пешакӣ омода шавед.",
 		"event_suhoor":            "Саҳар (охири вақт)",
 		"event_fajr":              "Бомдод",
@@ -292,6 +828,31 @@ var translations = map[string]map[string]string{
 		"event_asr":               "Аср",
 		"event_maghrib":           "Шом (ифтор)",
 		"event_isha":              "Хуфтан",
+		"event_tahajjud":          "Таҳаҷҷуд (сеяки охири шаб)",
+		"img_tahajjud_footer":     "Баъд аз 30 дақиқа сеяки охири шаб оғоз мешавад.",
+		"tahajjud_enabled":        "Ёдоварии таҳаҷҷуд фаъол шуд.",
+		"tahajjud_disabled":       "Ёдоварии таҳаҷҷуд хомӯш шуд.",
+		"hadithcard_enabled":      "Ҳадиси рӯз акнун дар тасвирҳои тақвим ва имрӯз нишон дода мешавад.",
+		"hadithcard_disabled":     "Ҳадиси рӯз дигар дар тасвирҳо нишон дода намешавад.",
+		"ayahcard_enabled":        "Ояти рӯз акнун дар тасвири имрӯз нишон дода мешавад.",
+		"ayahcard_disabled":       "Ояти рӯз дигар дар тасвирҳо нишон дода намешавад.",
+		"digest_title":            "🗓 %s • %s • %s",
+		"digest_enabled":          "Хулосаи рӯзона фаъол шуд: %d дақиқа пеш аз саҳар.",
+		"digest_disabled":         "Хулосаи рӯзона хомӯш шуд.",
+		"digest_usage":            "Истифода: /digest ё /digest <дақиқа> (1–%d).",
+		"quiet_usage":             "Соатҳои ором: /quiet 22:00 05:00 — дар ин фосила ёдовариҳо фиристода намешаванд (ба ғайр аз саҳар). /quiet off — хомӯш кардан.",
+		"quiet_set":               "Соатҳои ором: %s–%s. Ёдоварии саҳар ҳамеша фиристода мешавад.",
+		"quiet_off":               "Соатҳои ором хомӯш шуданд.",
+		"mute_usage":              "Истифода: /mute 3h ё /mute 90m — ёдовариҳо муваққатан (то %d соат) қатъ мешаванд. /mute off — аз нав фаъол кардан.",
+		"mute_set":                "Ёдовариҳо то %s қатъ шуданд, баъд худкор аз нав оғоз мешаванд.",
+		"mute_off":                "Ёдовариҳо аз нав фаъол шуданд.",
+		"mute_notify_off":         "Ёдовариҳо хомӯш ҳастанд. Барои фаъол кардан /notifyon.",
+		"pin_no_rights":           "Барои сабт (pin) кардани паём ба бот ҳуқуқи администратор лозим аст.",
+		"group_admin_only":        "Дар гурӯҳ танҳо администраторон метавонанд танзимотро иваз кунанд.",
+		"zakat_info":              "Закоти фитр садақаи воҷибест, ки пеш аз намози иди Рамазон барои ҳар як аъзои хонавода, аз ҷумла кӯдакон, дода мешавад.",
+		"zakat_amount":            "Барои %[1]d нафар: %[1]d × %[2]s %[3]s = %[4]s %[3]s",
+		"zakat_unit_default":      "кг ғалла",
+		"zakat_usage":             "Истифода: /zakatfitr ё /zakatfitr <шумораи нафарон> (1–%d).",
 		"btn_hadiths":             "☪️ Ҳадиси тасодуфӣ",
 		"btn_calendar":            "🗓 Тақвим",
 		"btn_today":               "🌙 Имрӯз",
@@ -301,19 +862,51 @@ var translations = map[string]map[string]string{
 		"btn_notify_off":          "🔕 Ёдоварӣ OFF",
 		"btn_help":                "ℹ️ Ёрӣ",
 		"restart_update_notice":   "🔄 Бот нав шуд.\nЛутфан /start-ро дубора пахш кунед, то меню ва танзимот нав шаванд.",
+		"settings_title":          "⚙️ Танзимоти шумо:",
+		"settings_language":       "🌐 Забон: %s",
+		"settings_region":         "📍 Минтақа: %s",
+		"settings_notifications":  "🔔 Ёдовариҳо: %s",
+		"settings_region_none":    "интихоб нашудааст",
+		"settings_on":             "фаъол",
+		"settings_off":            "хомӯш",
+		"settings_theme":          "🎨 Мавзӯи тасвирҳо: %s",
+		"theme_prompt":            "Мавзӯи тасвирҳоро интихоб кунед:",
+		"theme_saved":             "Мавзӯъ нигоҳ дошта шуд: %s",
+		"theme_dark":              "🌙 Торик",
+		"theme_light":             "☀️ Равшан",
+		"inline_description":      "Саҳар то %s • Ифтор %s",
+		"inline_text":             "%s • %s • %s\nСаҳар то %s\nИфтор %s",
+		"qibla_caption":           "Қибла аз %s: %d° (%s)\nАз шимол бо самти ақрабаки соат ҳисоб карда мешавад.",
+		"qibla_title":             "Самти қибла",
+		"dir_n":                   "Шимол",
+		"dir_ne":                  "Шимолу шарқ",
+		"dir_e":                   "Шарқ",
+		"dir_se":                  "Ҷанубу шарқ",
+		"dir_s":                   "Ҷануб",
+		"dir_sw":                  "Ҷанубу ғарб",
+		"dir_w":                   "Ғарб",
+		"dir_nw":                  "Шимолу ғарб",
+		"location_region":         "📍 Минтақаи наздиктарин ба ҷойгиршавии шумо: %s",
+		"location_too_far":        "📍 Ҷойгиршавии шумо аз ҳамаи минтақаҳои ҷадвал дур аст. Лутфан минтақаро дастӣ интихоб кунед.",
 	},
 	langRU: {
 		"choose_language":         "Выберите язык:\n\nТоҷикӣ / Русский / English / O'zbek",
 		"language_saved":          "Язык выбран.",
+		"mylang_usage":            "/mylang en — ваш личный язык для inline-ответов (@бот в любом чате) и уведомлений лично вам (tg, ru, en, uz). Сообщения в группе остаются на языке группы. /mylang off — убрать.",
+		"mylang_set":              "Inline-ответы для вас будут на русском. Сообщения в этой группе остаются на языке группы.",
+		"mylang_off":              "Ваш личный язык для этой группы удалён.",
 		"choose_region":           "Выберите свой регион:",
 		"welcome":                 "Ассалому алейкум! Я помогу с календарём Рамадана, напоминаниями и ниётами.\n\nКоманды:\n/lang — сменить язык\n/region — выбрать регион\n/calendar — календарь Рамадана (сухур и ифтар)\n/today — времена на сегодня (сухур и ифтар)\n/hadiths — случайный хадис из API\n/notifyoff — выключить напоминания\n/notifyon — включить напоминания\n/testnotify — отправить тест уведомления\n/menu или /help — меню и клавиатура",
-		"help":                    "Команды:\n/lang — сменить язык\n/region — выбор региона\n/calendar — календарь Рамадана (сухур и ифтар)\n/today — времена на сегодня (сухур и ифтар)\n/hadiths — случайный хадис из API\n/notifyoff — выключить напоминания\n/notifyon — включить напоминания\n/testnotify — отправить тест уведомления\n/menu или /help — меню и клавиатура",
+		"help":                    "Команды:\n/lang [tg/ru/en/uz] — сменить язык\n/mylang en — ваш личный язык для inline-ответов (@бот)\n/region [название] — выбор региона\n/settings — мои настройки\n/theme — тема изображений\n/calendar — календарь Рамадана (сухур и ифтар)\n/calendartext — календарь текстом\n/calendarpdf — календарь в PDF\n/ics — времена для приложения-календаря (.ics)\n/subscribe [reset] — подписка на календарь с автообновлением (reset — новая ссылка)\n/today — времена на сегодня (сухур и ифтар)\n/day N — времена на N-й день Рамадана\n/prayers — все времена намаза на сегодня\n/qibla — направление киблы\n/dua — ният сухура и ифтара, дуа Рамадана\n/tasbih — счётчик тасбиха\n/progress — прогресс поста\n/countdown — сколько дней до Рамадана\n/pintoday — закрепить расписание на сегодня в группе\n/hadiths [тема] — случайный хадис (например, пост, дуа, знание)\n/ayah — аят дня с переводом\n/tahajjud — напоминание о тахаджуде (вкл/выкл)\n/madhab — расчёт аср (стандартный/ханафитский)\n/hadithcard — хадис дня на картинке (вкл/выкл)\n/ayahcard — аят дня на картинке (вкл/выкл)\n/digest [минуты] — ежедневная сводка до сухура\n/quiet 22:00 05:00 — тихие часы для напоминаний\n/zakatfitr [люди] — расчёт закят аль-фитр\n/notifyoff — выключить напоминания\n/notifyon — включить напоминания\n/mute 3h — приостановить напоминания на время\n/testnotify [событие] — отправить тест уведомления\n/preview — все напоминания на сегодня\n/catchup — пропущенные сегодня напоминания\n/compare A B — сравнить сухур и ифтар двух регионов\n/about — версия и сведения о сборке\n/textmode — режим без картинок (вкл/выкл)\n/hidemenu, /showmenu — скрыть/показать клавиатуру\n/menu или /help — меню и клавиатура",
 		"region_selected":         "Регион выбран: %s\nНапоминания включены автоматически (за 30 минут до каждого намаза, сухура и ифтара).",
 		"need_region_first":       "Сначала выберите регион через /region.",
 		"calendar_not_found":      "Календарь для выбранного региона не найден. Переустановите регион командой /region.",
-		"out_of_range":            "Сейчас вне диапазона календаря Рамадана. Проверьте дату RAMADAN_START.",
+		"card_failed":             "Не удалось отправить изображение. Попробуйте ещё раз через минуту.",
+		"out_of_range":            "Сейчас вне диапазона календаря Рамадана (%s – %s). Проверьте дату RAMADAN_START.",
 		"calendar_caption":        "Календарь Рамадана (%s)\n\n%s",
-		"today_caption":           "%s • %s • День %d\n\n%s",
+		"today_caption":           "%s • %s (%s) • %s\n\n%s",
+		"day_caption":             "%s • %s (%s) • %s",
+		"day_out_of_range":        "Укажите номер дня от 1 до %d, например: /day 12",
 		"test_region_default":     "Регион не выбран, тест отправляется для региона: %s",
 		"test_notification_title": "Тестовое уведомление",
 		"need_region_notify":      "Выберите регион для управления напоминаниями:",
@@ -321,13 +914,112 @@ var translations = map[string]map[string]string{
 		"notify_disabled":         "Напоминания выключены.",
 		"rem_no_calendar_region":  "Не найден календарь для региона %s.",
 		"rem_before_start":        "До начала Рамадана осталось %.0f часов. Напоминания включатся автоматически.",
-		"rem_out_of_range":        "Календарь Рамадана завершён или ещё не начался. Проверьте RAMADAN_START.",
-		"rem_headline":            "Регион: %s\nДень %d Рамадана\nЧерез 30 минут: %s в %s",
+		"rem_out_of_range":        "Календарь Рамадана (%s – %s) завершён или ещё не начался. Проверьте RAMADAN_START.",
+		"rem_headline":            "Регион: %s\n%s\nЧерез 30 минут: %s в %s",
 		"niyat_suhoor_label":      "Ният сухур:\n",
 		"niyat_iftar_label":       "Ният ифтар:\n",
+		"dua_prompt":              "Выберите дуа:",
+		"btn_dua_suhoor":          "🌙 Ният сухур",
+		"btn_dua_iftar":           "🌅 Ният ифтар",
+		"tasbih_count":            "📿 Тасбих: %d",
+		"tasbih_target":           "Цель: %d",
+		"tasbih_done":             "✅ Цель достигнута!",
+		"btn_tasbih_reset":        "↺ Сброс",
+		"btn_page_more":           "Ещё ▶",
+		"btn_page_back":           "◀ Назад",
+		"region_candidates":       "Найдено несколько регионов, выберите:",
+		"preview_intro":           "Напоминания на сегодня (%d):",
+		"catchup_intro":           "Пропущенные сегодня напоминания (%d):",
+		"catchup_none":            "Сегодня пропущенных напоминаний нет.",
+		"compare_usage":           "Сравнение двух регионов: /compare Душанбе Худжанд (или /compare Худжанд — с вашим регионом).",
+		"compare_title":           "Сравнение • %s • %s",
+		"compare_diff":            "%s: сухур %s мин., ифтар %s мин.",
+		"testnotify_invalid":      "Неизвестное событие. Доступные: %s",
+		"rate_limited":            "Пожалуйста, подождите немного и попробуйте снова.",
+		"menu_hidden":             "Клавиатура меню скрыта. Чтобы вернуть её, отправьте /showmenu.",
+		"menu_shown":              "Клавиатура меню снова включена.",
+		"calendar_text_title":     "Календарь Рамадана (%s)",
+		"calendar_pdf_caption":    "Календарь Рамадана для печати (%s)",
+		"ics_caption":             "Времена намаза в Рамадан для вашего календаря (%s). Откройте файл, чтобы добавить их в календарь.",
+		"subscribe_link":          "Подпишитесь на этот календарь, чтобы времена обновлялись сами:\n%s",
+		"subscribe_unavailable":   "Подписка на календарь в этом боте не настроена. Используйте /ics.",
+		"subscribe_reset":         "Старая ссылка больше не работает. Подпишитесь на новую:\n%s",
+		"textmode_on":             "Текстовый режим включён: календарь, день и напоминания приходят без картинок.",
+		"textmode_off":            "Текстовый режим выключен, картинки снова включены.",
+		"asr_prompt":              "Выберите способ расчёта времени аср:",
+		"asr_standard":            "Стандартный (шафиитский, маликитский, ханбалитский)",
+		"asr_hanafi":              "Ханафитский",
+		"asr_saved_standard":      "Время аср рассчитывается по стандартному методу.",
+		"asr_saved_hanafi":        "Время аср рассчитывается по ханафитскому методу.",
+		"btn_fasted":              "✅ Я постился сегодня",
+		"fast_confirmed":          "Да примет Аллах! Сегодняшний пост засчитан.",
+		"fast_already":            "Этот день уже засчитан.",
+		"progress_text":           "День %d/%d, подтверждено дней: %d, подряд: %d",
+		"progress_outside":        "Сейчас не Рамадан. Подтверждено: %d из %d дней.",
+		"day_label":               "%s день",
+		"day_ordinal":             "%d-й",
+		"rem_day_label":           "%s день Рамадана",
+		"day_label_eve":           "Канун Рамадана",
+		"countdown_before":        "До Рамадана осталось %d дн. и %d ч.",
+		"countdown_ongoing":       "Рамадан идёт: день %d из %d.",
+		"countdown_ended":         "Рамадан в этом году завершился. С праздником!",
+		"about_text":              "Бот Рамадана %s\nКоммит: %s\nДата сборки: %s\nGo: %s",
+		"about_support":           "Поддержка: %s",
 		"hadith_day_title":        "Хадис дня",
+		"ayah_day_title":          "Аят дня",
+		"ayah_reference":          "Коран, %s",
 		"hadith_title_default":    "Хадис",
 		"hadith_source":           "Источник",
+		"hadith_tag_usage":        "Укажите тему, например: /hadiths пост\nТемы: %s",
+		"hadith_tag_fasting":      "пост",
+		"hadith_tag_dua":          "дуа",
+		"hadith_tag_knowledge":    "знание",
+		"hadith_tag_quran":        "коран",
+		"hadith_tag_intention":    "намерение",
+		"hadith_tag_purity":       "чистота",
+		"hadith_tag_brotherhood":  "братство",
+		"hadith_tag_forgiveness":  "прощение",
+		"cmd_start":               "Начало и выбор языка",
+		"cmd_lang":                "Сменить язык",
+		"cmd_mylang":              "Ваш личный язык для inline-ответов",
+		"cmd_menu":                "Меню и помощь",
+		"cmd_region":              "Выбор региона",
+		"cmd_settings":            "Мои настройки",
+		"cmd_theme":               "Тема изображений",
+		"cmd_calendar":            "Календарь Рамадана",
+		"cmd_today":               "Времена на сегодня",
+		"cmd_day":                 "Времена на день Рамадана",
+		"cmd_qibla":               "Направление киблы",
+		"cmd_dua":                 "Ният и дуа Рамадана",
+		"cmd_tasbih":              "Счётчик тасбиха",
+		"cmd_hadiths":             "Случайный хадис",
+		"cmd_zakatfitr":           "Расчёт закят аль-фитр",
+		"cmd_digest":              "Ежедневная сводка",
+		"cmd_quiet":               "Тихие часы",
+		"cmd_compare":             "Сравнить два региона",
+		"cmd_prayers":             "Все времена намаза на сегодня",
+		"cmd_tahajjud":            "Напоминание о тахаджуде",
+		"cmd_hadithcard":          "Хадис на картинках",
+		"cmd_ayah":                "Аят дня",
+		"cmd_ayahcard":            "Аят на картинках",
+		"cmd_calendartext":        "Календарь текстом",
+		"cmd_calendarpdf":         "Календарь в PDF",
+		"cmd_ics":                 "Времена для приложения-календаря",
+		"cmd_subscribe":           "Подписка на календарь",
+		"cmd_madhab":              "Метод расчёта аср",
+		"cmd_progress":            "Прогресс поста",
+		"cmd_countdown":           "Дней до Рамадана",
+		"cmd_pintoday":            "Закрепить расписание на сегодня",
+		"cmd_textmode":            "Режим без картинок",
+		"cmd_hidemenu":            "Скрыть клавиатуру",
+		"cmd_showmenu":            "Показать клавиатуру",
+		"cmd_notifyon":            "Включить напоминания",
+		"cmd_notifyoff":           "Выключить напоминания",
+		"cmd_mute":                "Приостановить напоминания",
+		"cmd_preview":             "Напоминания на сегодня",
+		"cmd_catchup":             "Пропущенные сегодня напоминания",
+		"cmd_testnotify":          "Тестовое напоминание",
+		"cmd_about":               "Версия и сведения о сборке",
 		"hadith_fallback":         "Пусть Аллах примет ваш пост и молитвы.",
 		"img_calendar_title":      "Календарь Рамадана",
 		"img_start_prefix":        "Старт ",
@@ -341,12 +1033,20 @@ var translations = map[string]map[string]string{
 		"img_calendar_footer":     "«Пост — это щит» — хадис Пророка ﷺ (Бухари).",
 		"img_today_title":         "Сегодня в Рамадан",
 		"img_region_prefix":       "Регион: ",
-		"img_date_day":            "Дата: %s    День: %d",
+		"img_date_day":            "Дата: %s    %s",
 		"img_today_suhoor_label":  "Сухур до",
 		"img_today_iftar_label":   "Ифтар",
 		"img_today_footer":        "Сухур завершается с наступлением Фаджра.",
+		"img_compare_title":       "Сравнение регионов",
+		"img_prayers_title":       "Время намазов сегодня",
+		"img_prayer_suhoor":       "Сухур до",
+		"img_prayer_fajr":         "Фаджр",
+		"img_prayer_dhuhr":        "Зухр",
+		"img_prayer_asr":          "Аср",
+		"img_prayer_maghrib":      "Магриб (ифтар)",
+		"img_prayer_isha":         "Иша",
 		"img_rem_title":           "Напоминание о намазе",
-		"img_rem_day_date":        "День %d • %s",
+		"img_rem_day_date":        "%s • %s",
 		"img_rem_footer":          "Через 30 минут. Подготовьтесь заранее.",
 		"event_suhoor":            "Сухур (конец времени)",
 		"event_fajr":              "Фаджр",
@@ -354,6 +1054,31 @@ var translations = map[string]map[string]string{
 		"event_asr":               "Аср",
 		"event_maghrib":           "Магриб (ифтар)",
 		"event_isha":              "Иша",
+		"event_tahajjud":          "Тахаджуд (последняя треть ночи)",
+		"img_tahajjud_footer":     "Через 30 минут начинается последняя треть ночи.",
+		"tahajjud_enabled":        "Напоминание о тахаджуде включено.",
+		"tahajjud_disabled":       "Напоминание о тахаджуде выключено.",
+		"hadithcard_enabled":      "Хадис дня теперь выводится на картинках календаря и дня.",
+		"hadithcard_disabled":     "Хадис дня больше не выводится на картинках.",
+		"ayahcard_enabled":        "Аят дня теперь выводится на картинке дня.",
+		"ayahcard_disabled":       "Аят дня больше не выводится на картинках.",
+		"digest_title":            "🗓 %s • %s • %s",
+		"digest_enabled":          "Ежедневная сводка включена: за %d минут до сухура.",
+		"digest_disabled":         "Ежедневная сводка выключена.",
+		"digest_usage":            "Использование: /digest или /digest <минуты> (1–%d).",
+		"quiet_usage":             "Тихие часы: /quiet 22:00 05:00 — в этот промежуток напоминания не приходят (кроме сухура). /quiet off — отключить.",
+		"quiet_set":               "Тихие часы: %s–%s. Напоминание о сухуре приходит всегда.",
+		"quiet_off":               "Тихие часы отключены.",
+		"mute_usage":              "Использование: /mute 3h или /mute 90m — приостановить напоминания (до %d ч). /mute off — возобновить.",
+		"mute_set":                "Напоминания приостановлены до %s, затем возобновятся автоматически.",
+		"mute_off":                "Напоминания снова включены.",
+		"mute_notify_off":         "Напоминания выключены. Включить: /notifyon.",
+		"pin_no_rights":           "Чтобы закрепить сообщение, боту нужны права администратора на закрепление.",
+		"group_admin_only":        "В группе менять настройки могут только администраторы.",
+		"zakat_info":              "Закят аль-фитр — обязательная милостыня, которую выплачивают до праздничной молитвы Ураза-байрам за каждого члена семьи, включая детей.",
+		"zakat_amount":            "На %[1]d чел.: %[1]d × %[2]s %[3]s = %[4]s %[3]s",
+		"zakat_unit_default":      "кг зерна",
+		"zakat_usage":             "Использование: /zakatfitr или /zakatfitr <число людей> (1–%d).",
 		"btn_hadiths":             "☪️ Cлучайный хадис",
 		"btn_calendar":            "🗓 Календарь",
 		"btn_today":               "🌙 Сегодня",
@@ -363,19 +1088,51 @@ var translations = map[string]map[string]string{
 		"btn_notify_off":          "🔕 Напоминания OFF",
 		"btn_help":                "ℹ️ Помощь",
 		"restart_update_notice":   "🔄 Бот обновлён.\nПожалуйста, нажмите /start заново, чтобы обновить меню и настройки.",
+		"settings_title":          "⚙️ Ваши настройки:",
+		"settings_language":       "🌐 Язык: %s",
+		"settings_region":         "📍 Регион: %s",
+		"settings_notifications":  "🔔 Напоминания: %s",
+		"settings_region_none":    "не выбран",
+		"settings_on":             "включены",
+		"settings_off":            "выключены",
+		"settings_theme":          "🎨 Тема изображений: %s",
+		"theme_prompt":            "Выберите тему изображений:",
+		"theme_saved":             "Тема сохранена: %s",
+		"theme_dark":              "🌙 Тёмная",
+		"theme_light":             "☀️ Светлая",
+		"inline_description":      "Сухур до %s • Ифтар %s",
+		"inline_text":             "%s • %s • %s\nСухур до %s\nИфтар %s",
+		"qibla_caption":           "Кибла из %s: %d° (%s)\nОтсчёт от севера по часовой стрелке.",
+		"qibla_title":             "Направление киблы",
+		"dir_n":                   "Север",
+		"dir_ne":                  "Северо-восток",
+		"dir_e":                   "Восток",
+		"dir_se":                  "Юго-восток",
+		"dir_s":                   "Юг",
+		"dir_sw":                  "Юго-запад",
+		"dir_w":                   "Запад",
+		"dir_nw":                  "Северо-запад",
+		"location_region":         "📍 Ближайший к вашей геопозиции регион: %s",
+		"location_too_far":        "📍 Ваша геопозиция слишком далеко от регионов расписания. Пожалуйста, выберите регион вручную.",
 	},
 	langEN: {
 		"choose_language":         "Choose language:\n\nТоҷикӣ / Русский / English / O'zbek",
 		"language_saved":          "Language selected.",
+		"mylang_usage":            "/mylang en — your own language for inline results (typing @bot in any chat) and notices meant only for you (tg, ru, en, uz). Messages in the group stay in the group's language. /mylang off — remove it.",
+		"mylang_set":              "Inline results for you will be in English. Messages in this group stay in the group's language.",
+		"mylang_off":              "Your own language for this group was removed.",
 		"choose_region":           "Select your region:",
 		"welcome":                 "Assalamu alaikum! I can help with Ramadan calendar, reminders, and niyat texts.\n\nCommands:\n/lang — change language\n/region — select region\n/calendar — Ramadan calendar (suhoor and iftar)\n/today — today timings (suhoor and iftar)\n/hadiths — random hadith from API\n/notifyoff — disable reminders\n/notifyon — enable reminders\n/testnotify — send test reminder\n/menu or /help — menu and keyboard",
-		"help":                    "Commands:\n/lang — change language\n/region — select region\n/calendar — Ramadan calendar (suhoor and iftar)\n/today — today timings (suhoor and iftar)\n/hadiths — random hadith from API\n/notifyoff — disable reminders\n/notifyon — enable reminders\n/testnotify — send test reminder\n/menu or /help — menu and keyboard",
+		"help":                    "Commands:\n/lang [tg/ru/en/uz] — change language\n/mylang en — your own language for inline results (@bot queries)\n/region [name] — select region\n/settings — my settings\n/theme — image theme\n/calendar — Ramadan calendar (suhoor and iftar)\n/calendartext — calendar as text\n/calendarpdf — calendar as PDF\n/ics — times for your calendar app (.ics)\n/subscribe [reset] — calendar subscription that updates itself (reset — new link)\n/today — today timings (suhoor and iftar)\n/day N — timings for Ramadan day N\n/prayers — all of today's prayer times\n/qibla — qibla direction\n/dua — suhoor and iftar niyat, Ramadan duas\n/tasbih — tasbih counter\n/progress — fasting progress\n/countdown — days until Ramadan\n/pintoday — pin today's timetable in a group\n/hadiths [topic] — random hadith (e.g. fasting, dua, knowledge)\n/ayah — ayah of the day with translation\n/tahajjud — tahajjud reminder on/off\n/madhab — asr method (standard/Hanafi)\n/hadithcard — hadith of the day on images on/off\n/ayahcard — ayah of the day on images on/off\n/digest [minutes] — daily digest before suhoor\n/quiet 22:00 05:00 — quiet hours for reminders\n/zakatfitr [people] — zakat al-fitr calculator\n/notifyoff — disable reminders\n/notifyon — enable reminders\n/mute 3h — pause reminders for a while\n/testnotify [event] — send test reminder\n/preview — all of today's reminders\n/catchup — today's reminders you missed\n/compare A B — compare suhoor and iftar of two regions\n/about — version and build info\n/textmode — text-only mode on/off\n/hidemenu, /showmenu — hide/show the keyboard\n/menu or /help — menu and keyboard",
 		"region_selected":         "Region selected: %s\nReminders enabled automatically (30 minutes before each prayer, suhoor and iftar).",
 		"need_region_first":       "Please select a region first with /region.",
 		"calendar_not_found":      "Calendar for selected region not found. Re-select region with /region.",
-		"out_of_range":            "Current date is outside Ramadan calendar range. Check RAMADAN_START.",
+		"card_failed":             "Couldn't send the image. Please try again in a minute.",
+		"out_of_range":            "Current date is outside the Ramadan calendar (%s – %s). Check RAMADAN_START.",
 		"calendar_caption":        "Ramadan Calendar (%s)\n\n%s",
-		"today_caption":           "%s • %s • Day %d\n\n%s",
+		"today_caption":           "%s • %s (%s) • %s\n\n%s",
+		"day_caption":             "%s • %s (%s) • %s",
+		"day_out_of_range":        "Enter a day number from 1 to %d, e.g. /day 12",
 		"test_region_default":     "Region is not selected, test is sent for region: %s",
 		"test_notification_title": "Test reminder",
 		"need_region_notify":      "Select region to manage reminders:",
@@ -383,13 +1140,112 @@ var translations = map[string]map[string]string{
 		"notify_disabled":         "Reminders disabled.",
 		"rem_no_calendar_region":  "Calendar for region %s not found.",
 		"rem_before_start":        "Ramadan starts in %.0f hours. Reminders will start automatically.",
-		"rem_out_of_range":        "Ramadan calendar ended or has not started yet. Check RAMADAN_START.",
-		"rem_headline":            "Region: %s\nRamadan day %d\nIn 30 minutes: %s at %s",
+		"rem_out_of_range":        "Ramadan calendar (%s – %s) ended or has not started yet. Check RAMADAN_START.",
+		"rem_headline":            "Region: %s\n%s\nIn 30 minutes: %s at %s",
 		"niyat_suhoor_label":      "Suhoor niyat:\n",
 		"niyat_iftar_label":       "Iftar niyat:\n",
+		"dua_prompt":              "Choose a dua:",
+		"btn_dua_suhoor":          "🌙 Suhoor niyat",
+		"btn_dua_iftar":           "🌅 Iftar niyat",
+		"tasbih_count":            "📿 Tasbih: %d",
+		"tasbih_target":           "Target: %d",
+		"tasbih_done":             "✅ Target reached!",
+		"btn_tasbih_reset":        "↺ Reset",
+		"btn_page_more":           "More ▶",
+		"btn_page_back":           "◀ Back",
+		"region_candidates":       "Several regions match, pick one:",
+		"preview_intro":           "Today's reminders (%d):",
+		"catchup_intro":           "Today's reminders you missed (%d):",
+		"catchup_none":            "No reminders have been missed today.",
+		"compare_usage":           "Compare two regions: /compare Dushanbe Khujand (or just /compare Khujand to compare with your region).",
+		"compare_title":           "Comparison • %s • %s",
+		"compare_diff":            "%s: suhoor %s min, iftar %s min",
+		"testnotify_invalid":      "Unknown event. Use one of: %s",
+		"rate_limited":            "Please wait a moment before trying again.",
+		"menu_hidden":             "Menu keyboard hidden. Send /showmenu to bring it back.",
+		"menu_shown":              "Menu keyboard is back.",
+		"calendar_text_title":     "Ramadan calendar (%s)",
+		"calendar_pdf_caption":    "Printable Ramadan calendar (%s)",
+		"ics_caption":             "Ramadan prayer times for your calendar (%s). Open the file to add them to your calendar app.",
+		"subscribe_link":          "Subscribe to this calendar and the times will stay up to date:\n%s",
+		"subscribe_unavailable":   "Calendar subscriptions are not set up for this bot. Use /ics instead.",
+		"subscribe_reset":         "The old link no longer works. Subscribe to the new one:\n%s",
+		"textmode_on":             "Text mode on: calendar, today and reminders are sent without images.",
+		"textmode_off":            "Text mode off, images are back.",
+		"asr_prompt":              "Choose how asr time is calculated:",
+		"asr_standard":            "Standard (Shafi‘i, Maliki, Hanbali)",
+		"asr_hanafi":              "Hanafi",
+		"asr_saved_standard":      "Asr is now calculated with the standard method.",
+		"asr_saved_hanafi":        "Asr is now calculated with the Hanafi method.",
+		"btn_fasted":              "✅ I fasted today",
+		"fast_confirmed":          "May it be accepted! Today's fast is counted.",
+		"fast_already":            "This day is already counted.",
+		"progress_text":           "Day %d/%d, fasts confirmed: %d, streak: %d",
+		"progress_outside":        "It is not Ramadan right now. Confirmed: %d of %d days.",
+		"day_label":               "Day %s",
+		"day_ordinal":             "%d",
+		"rem_day_label":           "Ramadan day %s",
+		"day_label_eve":           "Ramadan eve",
+		"countdown_before":        "%d days and %d hours until Ramadan.",
+		"countdown_ongoing":       "Ramadan is ongoing: day %d of %d.",
+		"countdown_ended":         "This year’s Ramadan has ended. Eid Mubarak!",
+		"about_text":              "Ramadan bot %s\nCommit: %s\nBuilt: %s\nGo: %s",
+		"about_support":           "Support: %s",
 		"hadith_day_title":        "Hadith of the day",
+		"ayah_day_title":          "Ayah of the day",
+		"ayah_reference":          "Quran %s",
 		"hadith_title_default":    "Hadith",
 		"hadith_source":           "Source",
+		"hadith_tag_usage":        "Name a topic, for example: /hadiths fasting\nTopics: %s",
+		"hadith_tag_fasting":      "fasting",
+		"hadith_tag_dua":          "dua",
+		"hadith_tag_knowledge":    "knowledge",
+		"hadith_tag_quran":        "quran",
+		"hadith_tag_intention":    "intention",
+		"hadith_tag_purity":       "purity",
+		"hadith_tag_brotherhood":  "brotherhood",
+		"hadith_tag_forgiveness":  "forgiveness",
+		"cmd_start":               "Start and choose a language",
+		"cmd_lang":                "Change language",
+		"cmd_mylang":              "Your own language for inline results",
+		"cmd_menu":                "Menu and help",
+		"cmd_region":              "Choose your region",
+		"cmd_settings":            "My settings",
+		"cmd_theme":               "Image theme",
+		"cmd_calendar":            "Ramadan calendar",
+		"cmd_today":               "Today's timings",
+		"cmd_day":                 "Timings for a Ramadan day",
+		"cmd_qibla":               "Qibla direction",
+		"cmd_dua":                 "Niyat and Ramadan duas",
+		"cmd_tasbih":              "Tasbih counter",
+		"cmd_hadiths":             "Random hadith",
+		"cmd_zakatfitr":           "Zakat al-fitr calculator",
+		"cmd_digest":              "Daily digest on/off",
+		"cmd_quiet":               "Quiet hours for reminders",
+		"cmd_compare":             "Compare two regions",
+		"cmd_prayers":             "All of today's prayer times",
+		"cmd_tahajjud":            "Tahajjud reminder on/off",
+		"cmd_hadithcard":          "Hadith in images on/off",
+		"cmd_ayah":                "Ayah of the day",
+		"cmd_ayahcard":            "Ayah in images on/off",
+		"cmd_calendartext":        "Calendar as text",
+		"cmd_calendarpdf":         "Calendar as PDF",
+		"cmd_ics":                 "Prayer times for your calendar app",
+		"cmd_subscribe":           "Calendar subscription that stays up to date",
+		"cmd_madhab":              "Asr method (standard/Hanafi)",
+		"cmd_progress":            "Fasting progress",
+		"cmd_countdown":           "Days until Ramadan",
+		"cmd_pintoday":            "Pin today's timetable",
+		"cmd_textmode":            "Text-only mode on/off",
+		"cmd_hidemenu":            "Hide the menu keyboard",
+		"cmd_showmenu":            "Show the menu keyboard",
+		"cmd_notifyon":            "Enable reminders",
+		"cmd_notifyoff":           "Disable reminders",
+		"cmd_mute":                "Pause reminders for a while",
+		"cmd_preview":             "Preview today's reminders",
+		"cmd_catchup":             "Replay today's missed reminders",
+		"cmd_testnotify":          "Test reminder",
+		"cmd_about":               "Version and build info",
 		"hadith_fallback":         "May Allah accept your fasting and prayers.",
 		"img_calendar_title":      "Ramadan Calendar",
 		"img_start_prefix":        "Start ",
@@ -403,12 +1259,20 @@ var translations = map[string]map[string]string{
 		"img_calendar_footer":     "\"Fasting is a shield\" — Hadith of the Prophet ﷺ (Bukhari).",
 		"img_today_title":         "Today in Ramadan",
 		"img_region_prefix":       "Region: ",
-		"img_date_day":            "Date: %s    Day: %d",
+		"img_date_day":            "Date: %s    %s",
 		"img_today_suhoor_label":  "Suhoor until",
 		"img_today_iftar_label":   "Iftar",
 		"img_today_footer":        "Suhoor ends with the time of Fajr.",
+		"img_compare_title":       "Region comparison",
+		"img_prayers_title":       "Today's prayer times",
+		"img_prayer_suhoor":       "Suhoor until",
+		"img_prayer_fajr":         "Fajr",
+		"img_prayer_dhuhr":        "Dhuhr",
+		"img_prayer_asr":          "Asr",
+		"img_prayer_maghrib":      "Maghrib (iftar)",
+		"img_prayer_isha":         "Isha",
 		"img_rem_title":           "Prayer reminder",
-		"img_rem_day_date":        "Day %d • %s",
+		"img_rem_day_date":        "%s • %s",
 		"img_rem_footer":          "In 30 minutes. Prepare in advance.",
 		"event_suhoor":            "Suhoor (end time)",
 		"event_fajr":              "Fajr",
@@ -416,6 +1280,31 @@ var translations = map[string]map[string]string{
 		"event_asr":               "Asr",
 		"event_maghrib":           "Maghrib (iftar)",
 		"event_isha":              "Isha",
+		"event_tahajjud":          "Tahajjud (last third of the night)",
+		"img_tahajjud_footer":     "The last third of the night begins in 30 minutes.",
+		"tahajjud_enabled":        "Tahajjud reminder enabled.",
+		"tahajjud_disabled":       "Tahajjud reminder disabled.",
+		"hadithcard_enabled":      "The hadith of the day is now shown on the calendar and today images.",
+		"hadithcard_disabled":     "The hadith of the day is no longer shown on images.",
+		"ayahcard_enabled":        "The ayah of the day is now shown on the today image.",
+		"ayahcard_disabled":       "The ayah of the day is no longer shown on images.",
+		"digest_title":            "🗓 %s • %s • %s",
+		"digest_enabled":          "Daily digest enabled: %d minutes before suhoor.",
+		"digest_disabled":         "Daily digest disabled.",
+		"digest_usage":            "Usage: /digest or /digest <minutes> (1–%d).",
+		"quiet_usage":             "Quiet hours: /quiet 22:00 05:00 — no reminders in that window (suhoor is still sent). /quiet off — turn off.",
+		"quiet_set":               "Quiet hours: %s–%s. The suhoor reminder is always sent.",
+		"quiet_off":               "Quiet hours turned off.",
+		"mute_usage":              "Usage: /mute 3h or /mute 90m — pause reminders (up to %d hours). /mute off — resume now.",
+		"mute_set":                "Reminders paused until %s; they will resume automatically.",
+		"mute_off":                "Reminders resumed.",
+		"mute_notify_off":         "Reminders are off. Turn them on with /notifyon.",
+		"pin_no_rights":           "To pin the timetable, give the bot admin rights to pin messages.",
+		"group_admin_only":        "In groups only admins can change the bot's settings.",
+		"zakat_info":              "Zakat al-fitr is an obligatory charity paid before the Eid al-Fitr prayer for every member of the household, including children.",
+		"zakat_amount":            "For %[1]d person(s): %[1]d × %[2]s %[3]s = %[4]s %[3]s",
+		"zakat_unit_default":      "kg of staple food",
+		"zakat_usage":             "Usage: /zakatfitr or /zakatfitr <people> (1–%d).",
 		"btn_hadiths":             "☪️ Random hadith",
 		"btn_calendar":            "🗓 Calendar",
 		"btn_today":               "🌙 Today",
@@ -425,19 +1314,51 @@ var translations = map[string]map[string]string{
 		"btn_notify_off":          "🔕 Reminders OFF",
 		"btn_help":                "ℹ️ Help",
 		"restart_update_notice":   "🔄 Bot has been updated.\nPlease press /start again to refresh menu and settings.",
+		"settings_title":          "⚙️ Your settings:",
+		"settings_language":       "🌐 Language: %s",
+		"settings_region":         "📍 Region: %s",
+		"settings_notifications":  "🔔 Reminders: %s",
+		"settings_region_none":    "not selected",
+		"settings_on":             "on",
+		"settings_off":            "off",
+		"settings_theme":          "🎨 Image theme: %s",
+		"theme_prompt":            "Choose the image theme:",
+		"theme_saved":             "Theme saved: %s",
+		"theme_dark":              "🌙 Dark",
+		"theme_light":             "☀️ Light",
+		"inline_description":      "Suhoor until %s • Iftar %s",
+		"inline_text":             "%s • %s • %s\nSuhoor until %s\nIftar %s",
+		"qibla_caption":           "Qibla from %s: %d° (%s)\nMeasured clockwise from north.",
+		"qibla_title":             "Qibla direction",
+		"dir_n":                   "North",
+		"dir_ne":                  "Northeast",
+		"dir_e":                   "East",
+		"dir_se":                  "Southeast",
+		"dir_s":                   "South",
+		"dir_sw":                  "Southwest",
+		"dir_w":                   "West",
+		"dir_nw":                  "Northwest",
+		"location_region":         "📍 Nearest region to your location: %s",
+		"location_too_far":        "📍 Your location is too far from every region in the timetable. Please choose a region manually.",
 	},
 	langUZ: {
 		"choose_language":         "Tilni tanlang:\n\nТоҷикӣ / Русский / English / O'zbek",
 		"language_saved":          "Til tanlandi.",
+		"mylang_usage":            "/mylang en — inline javoblar (istalgan chatda @bot) va faqat sizga yuboriladigan xabarlar uchun shaxsiy tilingiz (tg, ru, en, uz). Guruhdagi xabarlar guruh tilida qoladi. /mylang off — olib tashlash.",
+		"mylang_set":              "Siz uchun inline javoblar o‘zbek tilida bo‘ladi. Bu guruhdagi xabarlar guruh tilida qoladi.",
+		"mylang_off":              "Bu guruh uchun shaxsiy tilingiz olib tashlandi.",
 		"choose_region":           "Mintaqangizni tanlang:",
 		"welcome":                 "Assalomu alaykum! Men Ramazon taqvimi, eslatmalar va niyatlarda yordam beraman.\n\nBuyruqlar:\n/lang — tilni almashtirish\n/region — mintaqani tanlash\n/calendar — Ramazon taqvimi (saharlik va iftor)\n/today — bugungi vaqtlar (saharlik va iftor)\n/hadiths — API dan tasodifiy hadis\n/notifyoff — eslatmalarni o‘chirish\n/notifyon — eslatmalarni yoqish\n/testnotify — test eslatma yuborish\n/menu yoki /help — menyu va klaviatura",
-		"help":                    "Buyruqlar:\n/lang — tilni almashtirish\n/region — mintaqani tanlash\n/calendar — Ramazon taqvimi (saharlik va iftor)\n/today — bugungi vaqtlar (saharlik va iftor)\n/hadiths — API dan tasodifiy hadis\n/notifyoff — eslatmalarni o‘chirish\n/notifyon — eslatmalarni yoqish\n/testnotify — test eslatma yuborish\n/menu yoki /help — menyu va klaviatura",
+		"help":                    "Buyruqlar:\n/lang [tg/ru/en/uz] — tilni almashtirish\n/mylang en — inline javoblar (@bot) uchun shaxsiy tilingiz\n/region [nomi] — mintaqani tanlash\n/settings — sozlamalarim\n/theme — rasm mavzusi\n/calendar — Ramazon taqvimi (saharlik va iftor)\n/calendartext — taqvim matn ko‘rinishida\n/calendarpdf — taqvim PDF ko‘rinishida\n/ics — taqvim ilovasi uchun vaqtlar (.ics)\n/subscribe [reset] — avtomatik yangilanadigan taqvim obunasi (reset — yangi havola)\n/today — bugungi vaqtlar (saharlik va iftor)\n/day N — Ramazonning N-kuni vaqtlari\n/prayers — bugungi barcha namoz vaqtlari\n/qibla — qibla yo‘nalishi\n/dua — saharlik va iftor niyati, Ramazon duolari\n/tasbih — tasbeh hisoblagichi\n/progress — ro‘za taraqqiyoti\n/countdown — Ramazongacha necha kun qoldi\n/pintoday — bugungi jadvalni guruhda qadash\n/hadiths [mavzu] — tasodifiy hadis (masalan, ro‘za, duo, ilm)\n/ayah — tarjimasi bilan kun oyati\n/tahajjud — tahajjud eslatmasi (yoqish/o‘chirish)\n/madhab — asr hisoblash usuli (standart/hanafiy)\n/hadithcard — rasmda kun hadisi (yoqish/o‘chirish)\n/ayahcard — rasmda kun oyati (yoqish/o‘chirish)\n/digest [daqiqa] — saharlikdan oldin kunlik xulosa\n/quiet 22:00 05:00 — eslatmalar uchun sokin soatlar\n/zakatfitr [kishi] — fitr zakoti hisobi\n/notifyoff — eslatmalarni o‘chirish\n/notifyon — eslatmalarni yoqish\n/mute 3h — eslatmalarni vaqtincha to‘xtatish\n/testnotify [hodisa] — test eslatma yuborish\n/preview — bugungi barcha eslatmalar\n/catchup — bugun o‘tkazib yuborilgan eslatmalar\n/compare A B — ikki mintaqaning saharlik va iftorini solishtirish\n/about — versiya va yig‘ish ma’lumoti\n/textmode — rasmsiz rejim (yoqish/o‘chirish)\n/hidemenu, /showmenu — klaviaturani yashirish/ko‘rsatish\n/menu yoki /help — menyu va klaviatura",
 		"region_selected":         "Mintaqa tanlandi: %s\nEslatmalar avtomatik yoqildi (har namoz, saharlik va iftordan 30 daqiqa oldin).",
 		"need_region_first":       "Avval /region orqali mintaqani tanlang.",
 		"calendar_not_found":      "Tanlangan mintaqa uchun taqvim topilmadi. /region bilan qayta tanlang.",
-		"out_of_range":            "Hozir sana Ramazon taqvimi oralig‘idan tashqarida. RAMADAN_START ni tekshiring.",
+		"card_failed":             "Rasmni yuborib bo‘lmadi. Bir daqiqadan so‘ng qayta urinib ko‘ring.",
+		"out_of_range":            "Hozir sana Ramazon taqvimi oralig‘idan tashqarida (%s – %s). RAMADAN_START ni tekshiring.",
 		"calendar_caption":        "Ramazon taqvimi (%s)\n\n%s",
-		"today_caption":           "%s • %s • Kun %d\n\n%s",
+		"today_caption":           "%s • %s (%s) • %s\n\n%s",
+		"day_caption":             "%s • %s (%s) • %s",
+		"day_out_of_range":        "Kun raqamini 1 dan %d gacha kiriting, masalan: /day 12",
 		"test_region_default":     "Mintaqa tanlanmagan, test ushbu mintaqa uchun yuboriladi: %s",
 		"test_notification_title": "Test eslatma",
 		"need_region_notify":      "Eslatmalarni boshqarish uchun mintaqani tanlang:",
@@ -445,13 +1366,112 @@ var translations = map[string]map[string]string{
 		"notify_disabled":         "Eslatmalar o‘chirildi.",
 		"rem_no_calendar_region":  "%s mintaqasi uchun taqvim topilmadi.",
 		"rem_before_start":        "Ramazon boshlanishiga %.0f soat qoldi. Eslatmalar avtomatik yoqiladi.",
-		"rem_out_of_range":        "Ramazon taqvimi tugagan yoki hali boshlanmagan. RAMADAN_START ni tekshiring.",
-		"rem_headline":            "Mintaqa: %s\nRamazon kuni %d\n30 daqiqadan so‘ng: %s soat %s da",
+		"rem_out_of_range":        "Ramazon taqvimi (%s – %s) tugagan yoki hali boshlanmagan. RAMADAN_START ni tekshiring.",
+		"rem_headline":            "Mintaqa: %s\n%s\n30 daqiqadan so‘ng: %s soat %s da",
 		"niyat_suhoor_label":      "Saharlik niyati:\n",
 		"niyat_iftar_label":       "Iftor niyati:\n",
+		"dua_prompt":              "Duoni tanlang:",
+		"btn_dua_suhoor":          "🌙 Saharlik niyati",
+		"btn_dua_iftar":           "🌅 Iftor niyati",
+		"tasbih_count":            "📿 Tasbeh: %d",
+		"tasbih_target":           "Maqsad: %d",
+		"tasbih_done":             "✅ Maqsadga yetildi!",
+		"btn_tasbih_reset":        "↺ Qayta",
+		"btn_page_more":           "Yana ▶",
+		"btn_page_back":           "◀ Orqaga",
+		"region_candidates":       "Bir nechta mintaqa topildi, tanlang:",
+		"preview_intro":           "Bugungi eslatmalar (%d):",
+		"catchup_intro":           "Bugun o‘tkazib yuborilgan eslatmalar (%d):",
+		"catchup_none":            "Bugun o‘tkazib yuborilgan eslatma yo‘q.",
+		"compare_usage":           "Ikki mintaqani solishtirish: /compare Dushanbe Xo‘jand (yoki /compare Xo‘jand — o‘z mintaqangiz bilan).",
+		"compare_title":           "Solishtirish • %s • %s",
+		"compare_diff":            "%s: saharlik %s daq., iftor %s daq.",
+		"testnotify_invalid":      "Noma'lum hodisa. Quyidagilardan foydalaning: %s",
+		"rate_limited":            "Iltimos, biroz kuting va qayta urinib ko‘ring.",
+		"menu_hidden":             "Menyu klaviaturasi yashirildi. Qaytarish uchun /showmenu yuboring.",
+		"menu_shown":              "Menyu klaviaturasi qaytarildi.",
+		"calendar_text_title":     "Ramazon taqvimi (%s)",
+		"calendar_pdf_caption":    "Chop etish uchun Ramazon taqvimi (%s)",
+		"ics_caption":             "Kalendaringiz uchun Ramazon namoz vaqtlari (%s). Taqvimga qo‘shish uchun faylni oching.",
+		"subscribe_link":          "Vaqtlar o‘zi yangilanib turishi uchun ushbu taqvimga obuna bo‘ling:\n%s",
+		"subscribe_unavailable":   "Bu botda taqvimga obuna sozlanmagan. Uning o‘rniga /ics dan foydalaning.",
+		"subscribe_reset":         "Eski havola endi ishlamaydi. Yangisiga obuna bo‘ling:\n%s",
+		"textmode_on":             "Matn rejimi yoqildi: taqvim, bugun va eslatmalar rasmsiz yuboriladi.",
+		"textmode_off":            "Matn rejimi o‘chirildi, rasmlar qaytdi.",
+		"asr_prompt":              "Asr vaqtini hisoblash usulini tanlang:",
+		"asr_standard":            "Standart (Shofe’iy, Molikiy, Hanbaliy)",
+		"asr_hanafi":              "Hanafiy",
+		"asr_saved_standard":      "Asr vaqti standart usulda hisoblanadi.",
+		"asr_saved_hanafi":        "Asr vaqti hanafiy usulda hisoblanadi.",
+		"btn_fasted":              "✅ Bugun ro‘za tutdim",
+		"fast_confirmed":          "Alloh qabul qilsin! Bugungi ro‘za hisobga olindi.",
+		"fast_already":            "Bu kun allaqachon hisobga olingan.",
+		"progress_text":           "%d/%d-kun, %d kun tasdiqlangan, ketma-ket: %d",
+		"progress_outside":        "Hozir Ramazon emas. Tasdiqlangan: %d / %d kun.",
+		"day_label":               "%skun",
+		"day_ordinal":             "%d-",
+		"rem_day_label":           "Ramazonning %skuni",
+		"day_label_eve":           "Ramazon arafasi",
+		"countdown_before":        "Ramazongacha %d kun va %d soat qoldi.",
+		"countdown_ongoing":       "Ramazon davom etmoqda: %d-kun, jami %d.",
+		"countdown_ended":         "Bu yilgi Ramazon tugadi. Hayitingiz muborak!",
+		"about_text":              "Ramazon boti %s\nKommit: %s\nYig‘ilgan sana: %s\nGo: %s",
+		"about_support":           "Yordam: %s",
 		"hadith_day_title":        "Kun hadisi",
+		"ayah_day_title":          "Kun oyati",
+		"ayah_reference":          "Qur’on, %s",
 		"hadith_title_default":    "Hadis",
 		"hadith_source":           "Manba",
+		"hadith_tag_usage":        "Mavzuni yozing, masalan: /hadiths ro‘za\nMavzular: %s",
+		"hadith_tag_fasting":      "ro‘za",
+		"hadith_tag_dua":          "duo",
+		"hadith_tag_knowledge":    "ilm",
+		"hadith_tag_quran":        "qur’on",
+		"hadith_tag_intention":    "niyat",
+		"hadith_tag_purity":       "poklik",
+		"hadith_tag_brotherhood":  "birodarlik",
+		"hadith_tag_forgiveness":  "mag‘firat",
+		"cmd_start":               "Boshlash va tilni tanlash",
+		"cmd_lang":                "Tilni almashtirish",
+		"cmd_mylang":              "Inline javoblar uchun shaxsiy tilingiz",
+		"cmd_menu":                "Menyu va yordam",
+		"cmd_region":              "Mintaqani tanlash",
+		"cmd_settings":            "Sozlamalarim",
+		"cmd_theme":               "Rasm mavzusi",
+		"cmd_calendar":            "Ramazon taqvimi",
+		"cmd_today":               "Bugungi vaqtlar",
+		"cmd_day":                 "Ramazon kuni vaqtlari",
+		"cmd_qibla":               "Qibla yo‘nalishi",
+		"cmd_dua":                 "Niyat va Ramazon duolari",
+		"cmd_tasbih":              "Tasbeh hisoblagichi",
+		"cmd_hadiths":             "Tasodifiy hadis",
+		"cmd_zakatfitr":           "Fitr zakoti hisobi",
+		"cmd_digest":              "Kunlik xulosa",
+		"cmd_quiet":               "Sokin soatlar",
+		"cmd_compare":             "Ikki mintaqani solishtirish",
+		"cmd_prayers":             "Bugungi barcha namoz vaqtlari",
+		"cmd_tahajjud":            "Tahajjud eslatmasi",
+		"cmd_hadithcard":          "Rasmlarda hadis",
+		"cmd_ayah":                "Kun oyati",
+		"cmd_ayahcard":            "Rasmlarda oyat",
+		"cmd_calendartext":        "Taqvim matn ko‘rinishida",
+		"cmd_calendarpdf":         "Taqvim PDF ko‘rinishida",
+		"cmd_ics":                 "Taqvim ilovasi uchun vaqtlar",
+		"cmd_subscribe":           "Taqvimga obuna",
+		"cmd_madhab":              "Asr hisoblash usuli",
+		"cmd_progress":            "Ro‘za taraqqiyoti",
+		"cmd_countdown":           "Ramazongacha kunlar",
+		"cmd_pintoday":            "Bugungi jadvalni qadash",
+		"cmd_textmode":            "Rasmsiz rejim",
+		"cmd_hidemenu":            "Klaviaturani yashirish",
+		"cmd_showmenu":            "Klaviaturani ko‘rsatish",
+		"cmd_notifyon":            "Eslatmalarni yoqish",
+		"cmd_notifyoff":           "Eslatmalarni o‘chirish",
+		"cmd_mute":                "Eslatmalarni vaqtincha to‘xtatish",
+		"cmd_preview":             "Bugungi eslatmalar",
+		"cmd_catchup":             "O‘tkazib yuborilgan eslatmalar",
+		"cmd_testnotify":          "Test eslatma",
+		"cmd_about":               "Versiya va yig‘ish ma’lumoti",
 		"hadith_fallback":         "Alloh ro‘za va ibodatlaringizni qabul qilsin.",
 		"img_calendar_title":      "Ramazon taqvimi",
 		"img_start_prefix":        "Boshlanish ",
@@ -465,12 +1485,20 @@ var translations = map[string]map[string]string{
 		"img_calendar_footer":     "\"Ro‘za qalqondir\" — Payg‘ambar ﷺ hadisi (Buxoriy).",
 		"img_today_title":         "Bugun Ramazonda",
 		"img_region_prefix":       "Mintaqa: ",
-		"img_date_day":            "Sana: %s    Kun: %d",
+		"img_date_day":            "Sana: %s    %s",
 		"img_today_suhoor_label":  "Saharlik gacha",
 		"img_today_iftar_label":   "Iftor",
 		"img_today_footer":        "Saharlik Fajr kirishi bilan tugaydi.",
+		"img_compare_title":       "Mintaqalarni solishtirish",
+		"img_prayers_title":       "Bugungi namoz vaqtlari",
+		"img_prayer_suhoor":       "Saharlik gacha",
+		"img_prayer_fajr":         "Bomdod",
+		"img_prayer_dhuhr":        "Peshin",
+		"img_prayer_asr":          "Asr",
+		"img_prayer_maghrib":      "Shom (iftor)",
+		"img_prayer_isha":         "Xufton",
 		"img_rem_title":           "Namoz eslatmasi",
-		"img_rem_day_date":        "Kun %d • %s",
+		"img_rem_day_date":        "%s • %s",
 		"img_rem_footer":          "30 daqiqadan so‘ng. Oldindan tayyor bo‘ling.",
 		"event_suhoor":            "Saharlik (yakun vaqti)",
 		"event_fajr":              "Bomdod",
@@ -478,6 +1506,31 @@ var translations = map[string]map[string]string{
 		"event_asr":               "Asr",
 		"event_maghrib":           "Shom (iftor)",
 		"event_isha":              "Xufton",
+		"event_tahajjud":          "Tahajjud (tunning oxirgi uchdan biri)",
+		"img_tahajjud_footer":     "30 daqiqadan so‘ng tunning oxirgi uchdan biri boshlanadi.",
+		"tahajjud_enabled":        "Tahajjud eslatmasi yoqildi.",
+		"tahajjud_disabled":       "Tahajjud eslatmasi o‘chirildi.",
+		"hadithcard_enabled":      "Kun hadisi endi taqvim va bugungi rasmlarda ko‘rsatiladi.",
+		"hadithcard_disabled":     "Kun hadisi endi rasmlarda ko‘rsatilmaydi.",
+		"ayahcard_enabled":        "Kun oyati endi bugungi rasmda ko‘rsatiladi.",
+		"ayahcard_disabled":       "Kun oyati endi rasmlarda ko‘rsatilmaydi.",
+		"digest_title":            "🗓 %s • %s • %s",
+		"digest_enabled":          "Kunlik xulosa yoqildi: saharlikdan %d daqiqa oldin.",
+		"digest_disabled":         "Kunlik xulosa o‘chirildi.",
+		"digest_usage":            "Foydalanish: /digest yoki /digest <daqiqa> (1–%d).",
+		"quiet_usage":             "Sokin soatlar: /quiet 22:00 05:00 — bu oraliqda eslatmalar yuborilmaydi (saharlikdan tashqari). /quiet off — o‘chirish.",
+		"quiet_set":               "Sokin soatlar: %s–%s. Saharlik eslatmasi doim yuboriladi.",
+		"quiet_off":               "Sokin soatlar o‘chirildi.",
+		"mute_usage":              "Foydalanish: /mute 3h yoki /mute 90m — eslatmalarni to‘xtatish (%d soatgacha). /mute off — qayta yoqish.",
+		"mute_set":                "Eslatmalar %s gacha to‘xtatildi, keyin avtomatik qayta boshlanadi.",
+		"mute_off":                "Eslatmalar qayta yoqildi.",
+		"mute_notify_off":         "Eslatmalar o‘chirilgan. Yoqish uchun /notifyon.",
+		"pin_no_rights":           "Jadvalni qadash uchun botga xabarlarni qadash huquqi (admin) kerak.",
+		"group_admin_only":        "Guruhda sozlamalarni faqat adminlar o‘zgartira oladi.",
+		"zakat_info":              "Fitr zakoti — Ramazon hayiti namozidan oldin oilaning har bir a’zosi, shu jumladan bolalar uchun beriladigan majburiy sadaqa.",
+		"zakat_amount":            "%[1]d kishi uchun: %[1]d × %[2]s %[3]s = %[4]s %[3]s",
+		"zakat_unit_default":      "kg don",
+		"zakat_usage":             "Foydalanish: /zakatfitr yoki /zakatfitr <kishilar soni> (1–%d).",
 		"btn_hadiths":             "☪️ Tasodifiy hadis",
 		"btn_calendar":            "🗓 Taqvim",
 		"btn_today":               "🌙 Bugun",
@@ -487,6 +1540,32 @@ var translations = map[string]map[string]string{
 		"btn_notify_off":          "🔕 Eslatma OFF",
 		"btn_help":                "ℹ️ Yordam",
 		"restart_update_notice":   "🔄 Bot yangilandi.\nMenyu va sozlamalarni yangilash uchun /start ni qayta bosing.",
+		"settings_title":          "⚙️ Sozlamalaringiz:",
+		"settings_language":       "🌐 Til: %s",
+		"settings_region":         "📍 Mintaqa: %s",
+		"settings_notifications":  "🔔 Eslatmalar: %s",
+		"settings_region_none":    "tanlanmagan",
+		"settings_on":             "yoqilgan",
+		"settings_off":            "o‘chirilgan",
+		"settings_theme":          "🎨 Rasm mavzusi: %s",
+		"theme_prompt":            "Rasm mavzusini tanlang:",
+		"theme_saved":             "Mavzu saqlandi: %s",
+		"theme_dark":              "🌙 Qorong‘i",
+		"theme_light":             "☀️ Yorug‘",
+		"inline_description":      "Saharlik %s gacha • Iftor %s",
+		"inline_text":             "%s • %s • %s\nSaharlik %s gacha\nIftor %s",
+		"qibla_caption":           "%s dan qibla: %d° (%s)\nShimoldan soat mili yo‘nalishida hisoblanadi.",
+		"qibla_title":             "Qibla yo‘nalishi",
+		"dir_n":                   "Shimol",
+		"dir_ne":                  "Shimoli-sharq",
+		"dir_e":                   "Sharq",
+		"dir_se":                  "Janubi-sharq",
+		"dir_s":                   "Janub",
+		"dir_sw":                  "Janubi-g‘arb",
+		"dir_w":                   "G‘arb",
+		"dir_nw":                  "Shimoli-g‘arb",
+		"location_region":         "📍 Joylashuvingizga eng yaqin mintaqa: %s",
+		"location_too_far":        "📍 Joylashuvingiz jadvaldagi barcha mintaqalardan juda uzoqda. Iltimos, mintaqani qo‘lda tanlang.",
 	},
 }
 
@@ -510,19 +1589,28 @@ func normalizeLang(raw string) string {
 	}
 }
 
+// defaultLang is the operator's DEFAULT_LANG, already normalized; empty when unset.
+var defaultLang string
+
+// fallbackLang is the language for chats that have not chosen one and for missing
+// translations: DEFAULT_LANG when configured, otherwise Tajik.
+func fallbackLang() string {
+	if defaultLang != "" {
+		return defaultLang
+	}
+	return langTG
+}
+
 func tr(lang, key string) string {
 	lang = normalizeLang(lang)
 	if lang == "" {
-		lang = langTG
+		lang = fallbackLang()
 	}
-	if dict, ok := translations[lang]; ok {
-		if text, ok := dict[key]; ok && strings.TrimSpace(text) != "" {
-			return text
-		}
-	}
-	if dict, ok := translations[langTG]; ok {
-		if text, ok := dict[key]; ok && strings.TrimSpace(text) != "" {
-			return text
+	for _, candidate := range []string{lang, fallbackLang(), langTG} {
+		if dict, ok := translations[candidate]; ok {
+			if text, ok := dict[key]; ok && strings.TrimSpace(text) != "" {
+				return text
+			}
 		}
 	}
 	return key
@@ -532,6 +1620,33 @@ func trf(lang, key string, args ...any) string {
 	return fmt.Sprintf(tr(lang, key), args...)
 }
 
+// missingTranslationKeys compares every language against the union of keys across all
+// of translations and returns the sorted missing keys per language. Complete languages
+// are left out, so an empty map means nothing is missing.
+func missingTranslationKeys() map[string][]string {
+	union := make(map[string]bool)
+	for _, dict := range translations {
+		for key := range dict {
+			union[key] = true
+		}
+	}
+	missing := make(map[string][]string)
+	for lang, dict := range translations {
+		for key := range union {
+			if _, ok := dict[key]; !ok {
+				missing[lang] = append(missing[lang], key)
+			}
+		}
+		sort.Strings(missing[lang])
+	}
+	for lang, keys := range missing {
+		if len(keys) == 0 {
+			delete(missing, lang)
+		}
+	}
+	return missing
+}
+
 func eventTitle(lang string, ev eventSpec) string {
 	if title := strings.TrimSpace(ev.Title); title != "" {
 		return title
@@ -546,16 +1661,54 @@ func main() {
 	}
 	rand.Seed(time.Now().UnixNano())
 	log.Printf("Go version: %s", runtime.Version())
+	for lang, keys := range missingTranslationKeys() {
+		log.Printf("WARN: %s translations are missing %d keys: %s", lang, len(keys), strings.Join(keys, ", "))
+	}
+
+	if raw := strings.TrimSpace(os.Getenv("DEFAULT_LANG")); raw != "" {
+		if defaultLang = normalizeLang(raw); defaultLang == "" {
+			log.Printf("WARN: unsupported DEFAULT_LANG %q, falling back to %s", raw, langTG)
+		}
+	}
+
+	imageBrand.Text = strings.TrimSpace(os.Getenv("BRAND_TEXT"))
+	if path := strings.TrimSpace(os.Getenv("BRAND_LOGO")); path != "" {
+		if err := imageBrand.loadLogo(path); err != nil {
+			log.Printf("WARN: brand logo disabled: %v", err)
+		}
+	}
 
 	loc, err := time.LoadLocation("Asia/Dushanbe")
 	if err != nil {
 		log.Fatalf("failed to load Asia/Dushanbe timezone: %v", err)
 	}
 
-	calendars := buildCalendars()
+	if path := strings.TrimSpace(os.Getenv("REGIONS_FILE")); path != "" {
+		specs, err := loadRegionsFile(path)
+		if err != nil {
+			log.Fatalf("failed to load regions from %s: %v", path, err)
+		}
+		applyRegions(specs)
+		log.Printf("Loaded %d regions from %s", len(specs), path)
+	}
+
+	start := resolveRamadanStart(loc)
+	year, err := timetableYear(start)
+	if err != nil {
+		log.Fatalf("refusing to schedule reminders: %v", err)
+	}
+	calendars := buildCalendars(year)
 	hadiths := sampleHadithsByLang()
+	if path := strings.TrimSpace(os.Getenv("HADITHS_FILE")); path != "" {
+		custom, err := loadHadithsFile(path)
+		if err != nil {
+			log.Printf("WARN: keeping the built-in hadiths, %s is invalid: %v", path, err)
+		} else {
+			hadiths = mergeHadiths(hadiths, custom)
+			log.Printf("Loaded hadiths for %d languages from %s", len(custom), path)
+		}
+	}
 	niyatSuhoor, niyatIftar := niyatTextsByLang()
-	start := resolveRamadanStart(loc)
 
 	statePath := strings.TrimSpace(os.Getenv("STATE_FILE"))
 	if statePath == "" {
@@ -567,10 +1720,37 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to initialize state store: %v", err)
 	}
-	bot := newBot(token, state, calendars, loc, hadiths, niyatSuhoor, niyatIftar, start)
+	var opts []botOption
+	if debug, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("TELEGRAM_DEBUG"))); debug {
+		log.Printf("TELEGRAM_DEBUG enabled: logging API requests and responses")
+		opts = append(opts, withHTTPClient(&http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &debugTransport{next: http.DefaultTransport, secret: token},
+		}))
+	}
+	bot := newBot(token, state, calendars, loc, hadiths, niyatSuhoor, niyatIftar, start, opts...)
+	bot.zakatRate, bot.zakatUnit = resolveZakatFitrRate()
+	bot.adminIDs = parseChatIDList(os.Getenv("ADMIN_CHAT_IDS"))
+	bot.supportURL = strings.TrimSpace(os.Getenv("SUPPORT_URL"))
+	bot.publicURL = strings.TrimRight(strings.TrimSpace(os.Getenv("PUBLIC_URL")), "/")
+	bot.limiter = resolveChatLimiter()
+	if rich, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("USE_RICH_TEXT"))); rich {
+		bot.useRichText = true
+		bot.scheduler.useRichText = true
+	}
+	bot.scheduler.reaction = strings.TrimSpace(os.Getenv("REMINDER_REACTION"))
+	bot.duaAudioPaths = map[string]string{
+		duaSuhoor: strings.TrimSpace(os.Getenv("DUA_SUHOOR_AUDIO")),
+		duaIftar:  strings.TrimSpace(os.Getenv("DUA_IFTAR_AUDIO")),
+	}
+	if addr := strings.TrimSpace(os.Getenv("HEALTH_ADDR")); addr != "" {
+		go bot.serveHealth(addr)
+	}
 	if err := bot.setCommands(); err != nil {
 		log.Printf("setMyCommands error: %v", err)
 	}
+	// A failed setMyCommands only affects the client menu, so it does not block readiness.
+	bot.ready.Store(true)
 	restored := state.ActiveNotificationRegions()
 	for chatID, region := range restored {
 		bot.scheduler.Start(chatID, region)
@@ -581,33 +1761,136 @@ func main() {
 		go bot.sendRestartUpdateNotice(allChats)
 	}
 
-	log.Printf("Ramadan bot is running. Ramadan start: %s", start.Format("2006-01-02"))
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			bot.reloadSchedule()
+		}
+	}()
+
+	bot.startWorkers(resolveUpdateWorkers())
+	log.Printf("Ramadan bot %s (%s, built %s) is running. Ramadan start: %s", version, commit, buildDate, start.Format("2006-01-02"))
 	ctx := context.Background()
+	if warmup, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("IMAGE_WARMUP"))); warmup {
+		go bot.runCalendarWarmup(ctx)
+	}
 	bot.Run(ctx)
 }
 
-func newBot(token string, state *StateStore, calendars map[string][]DayTimes, tz *time.Location, hadiths map[string][]string, niyatSuhoor, niyatIftar map[string]string, start time.Time) *Bot {
-	cache := newImageCache()
+// debugLogLimit caps how many bytes of each request and response body are logged.
+const debugLogLimit = 2048
+
+// debugTransport logs every API call with the bot token masked. It is enabled with
+// TELEGRAM_DEBUG=1 and is meant for chasing down unexplained API errors.
+type debugTransport struct {
+	next   http.RoundTripper
+	secret string
+}
+
+func (t *debugTransport) redact(s string) string {
+	if t.secret == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, t.secret, "<redacted>")
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody := ""
+	if req.Body != nil {
+		raw, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(raw))
+		reqBody = debugBody(req.Header.Get("Content-Type"), raw)
+	}
+	log.Printf("telegram debug: -> %s %s %s", req.Method, t.redact(req.URL.String()), t.redact(reqBody))
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		log.Printf("telegram debug: <- error: %s", t.redact(err.Error()))
+		return nil, err
+	}
+	raw, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	log.Printf("telegram debug: <- %s %s", resp.Status, t.redact(debugBody(resp.Header.Get("Content-Type"), raw)))
+	return resp, nil
+}
+
+// debugBody summarises binary uploads and truncates long payloads for the debug log.
+func debugBody(contentType string, raw []byte) string {
+	if strings.HasPrefix(contentType, "multipart/") {
+		return fmt.Sprintf("<multipart body, %d bytes>", len(raw))
+	}
+	if len(raw) > debugLogLimit {
+		// Cut at a rune start so a Cyrillic payload is not split mid-character.
+		cut := debugLogLimit
+		for cut > 0 && !utf8.RuneStart(raw[cut]) {
+			cut--
+		}
+		return fmt.Sprintf("%s… (%d bytes total)", raw[:cut], len(raw))
+	}
+	return string(raw)
+}
+
+// botOption customises a Bot built by newBot. Tests use it to point the client at a fake
+// Bot API server.
+type botOption func(*Bot)
+
+// withAPIURL overrides the Bot API base URL (normally https://api.telegram.org/bot<token>).
+func withAPIURL(apiURL string) botOption {
+	return func(b *Bot) {
+		b.apiURL = strings.TrimRight(apiURL, "/")
+	}
+}
+
+// withSender routes outgoing messages through sender instead of the Bot API client.
+func withSender(sender Sender) botOption {
+	return func(b *Bot) {
+		b.sender = sender
+	}
+}
+
+func withHTTPClient(client *http.Client) botOption {
+	return func(b *Bot) {
+		b.client = client
+	}
+}
+
+func newBot(token string, state *StateStore, calendars map[string][]DayTimes, tz *time.Location, hadiths map[string][]Hadith, niyatSuhoor, niyatIftar map[string]string, start time.Time, opts ...botOption) *Bot {
+	cache := newImageCache(resolveImageCacheLimits())
+	store := newCalendarStore(calendars)
 	b := &Bot{
 		token:         token,
 		apiURL:        fmt.Sprintf("https://api.telegram.org/bot%s", token),
 		client:        &http.Client{Timeout: 30 * time.Second},
 		state:         state,
-		calendars:     calendars,
+		calendars:     store,
 		tz:            tz,
 		hadithsByLang: hadiths,
 		niyatSuhoor:   niyatSuhoor,
 		niyatIftar:    niyatIftar,
 		ramadanStart:  start,
-		defaultRegion: "Душанбе",
+		defaultRegion: baseRegion,
 		imageCache:    cache,
 		hadithAPIURL:  "https://hadeethenc.com/api/v1",
 		hadithCats:    make(map[string]cachedHadithCategories),
+		zakatRate:     defaultZakatFitrRate,
+	}
+	b.sender = b
+	for _, opt := range opts {
+		opt(b)
 	}
 
 	manager := &ReminderManager{
 		active:        make(map[int64]*reminderState),
-		calendar:      calendars,
+		calendar:      store,
 		loc:           tz,
 		ramadanStart:  start,
 		hadithsByLang: hadiths,
@@ -615,197 +1898,666 @@ func newBot(token string, state *StateStore, calendars map[string][]DayTimes, tz
 		niyatIftar:    niyatIftar,
 		imageCache:    cache,
 	}
-	manager.sendFn = func(chatID int64, text string) error {
-		return b.SendMessage(chatID, text, nil)
-	}
-	manager.sendPhotoFn = func(chatID int64, photo []byte, caption string) error {
-		return b.SendPhoto(chatID, photo, caption)
-	}
+	manager.sender = b.sender
 	manager.getLangFn = func(chatID int64) string {
 		return b.userLang(chatID)
 	}
+	manager.renderOptsFn = b.renderOptionsFor
+	manager.settingsFn = func(chatID int64) UserSettings {
+		settings, _ := b.state.Lookup(chatID)
+		return settings
+	}
+	manager.blockedFn = func(chatID int64) {
+		b.state.SetBlocked(chatID, true)
+	}
+	manager.sentFn = b.state.SentReminders
+	manager.markSentFn = b.state.MarkReminderSent
 	b.scheduler = manager
 
 	return b
 }
 
-// setCommands configures the Telegram bot menu (client-side command list).
-func (b *Bot) setCommands() error {
-	commands := []BotCommand{
-		{Command: "start", Description: "Start / Язык / Til"},
-		{Command: "lang", Description: "Change language"},
-		{Command: "menu", Description: "Menu / Help"},
-		{Command: "region", Description: "Region / Регион / Минтақа"},
-		{Command: "calendar", Description: "Ramadan calendar"},
-		{Command: "today", Description: "Today timings"},
-		{Command: "hadiths", Description: "Random hadith"},
-		{Command: "notifyon", Description: "Enable reminders"},
-		{Command: "notifyoff", Description: "Disable reminders"},
-		{Command: "testnotify", Description: "Test reminder"},
-	}
-
-	body := struct {
-		Commands []BotCommand `json:"commands"`
-	}{Commands: commands}
+// menuCommands are the commands listed in Telegram's command menu, in order. Each is
+// described by its cmd_ translation.
+var menuCommands = []string{"start", "lang", "mylang", "menu", "region", "settings", "theme", "calendar", "today", "day", "qibla", "dua", "tasbih", "hadiths", "ayah", "zakatfitr", "digest", "quiet", "compare", "prayers", "tahajjud", "hadithcard", "ayahcard", "calendartext", "calendarpdf", "ics", "subscribe", "madhab", "progress", "countdown", "pintoday", "textmode", "hidemenu", "showmenu", "notifyon", "notifyoff", "mute", "preview", "catchup", "testnotify", "about"}
 
-	raw, err := json.Marshal(body)
-	if err != nil {
-		return err
-	}
+// privateOnlyCommands are personal commands left out of the group chat menu.
+var privateOnlyCommands = map[string]bool{"tasbih": true, "progress": true, "testnotify": true, "catchup": true, "hidemenu": true, "showmenu": true}
 
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/setMyCommands", b.apiURL), bytes.NewReader(raw))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
+// groupOnlyCommands are left out of the private chat menu.
+var groupOnlyCommands = map[string]bool{"mylang": true, "pintoday": true}
 
-	resp, err := b.client.Do(req)
-	if err != nil {
-		return err
+// Bot command scopes (BotCommandScope types) setCommands publishes a menu for.
+const (
+	commandScopeDefault = "default"
+	commandScopePrivate = "all_private_chats"
+	commandScopeGroups  = "all_group_chats"
+)
+
+// commandMenu returns the menu commands for scope with descriptions in lang. The default
+// scope lists everything.
+func commandMenu(lang, scope string) []BotCommand {
+	commands := make([]BotCommand, 0, len(menuCommands))
+	for _, name := range menuCommands {
+		if (scope == commandScopePrivate && groupOnlyCommands[name]) || (scope == commandScopeGroups && privateOnlyCommands[name]) {
+			continue
+		}
+		commands = append(commands, BotCommand{Command: name, Description: tr(lang, "cmd_"+name)})
 	}
-	defer resp.Body.Close()
+	return commands
+}
 
-	var result struct {
-		OK          bool   `json:"ok"`
-		Description string `json:"description"`
-		ErrorCode   int    `json:"error_code"`
+// setCommands publishes the command menu for private chats, group chats and the default
+// scope, each once per supported language so users see the descriptions in their
+// Telegram language, plus an English fallback for other languages. It also points the
+// chat menu button at the command list. Every call is attempted; the failures are
+// returned together.
+func (b *Bot) setCommands() error {
+	var errs []error
+	publish := func(scope, lang, code string) {
+		payload := map[string]interface{}{
+			"commands": commandMenu(lang, scope),
+			"scope":    map[string]string{"type": scope},
+		}
+		if code != "" {
+			payload["language_code"] = code
+		}
+		if err := b.postJSON("setMyCommands", payload, nil); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s commands: %w", scope, lang, err))
+		}
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return err
+	for _, scope := range []string{commandScopeDefault, commandScopePrivate, commandScopeGroups} {
+		publish(scope, langEN, "")
+		for _, lang := range supportedLangs {
+			publish(scope, lang, lang)
+		}
 	}
-	if !result.OK {
-		return fmt.Errorf("telegram setMyCommands error %d: %s", result.ErrorCode, result.Description)
+	menuButton := map[string]interface{}{"menu_button": map[string]string{"type": "commands"}}
+	if err := b.postJSON("setChatMenuButton", menuButton, nil); err != nil {
+		errs = append(errs, fmt.Errorf("menu button: %w", err))
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 // Run starts long polling loop and dispatches updates.
+var (
+	// errPollConflict means another process is polling getUpdates with the same token.
+	errPollConflict = errors.New("getUpdates conflict")
+	// errPollDecode means Telegram's response could not be decoded.
+	errPollDecode = errors.New("getUpdates decode failure")
+)
+
+const maxPollBackoff = 5 * time.Minute
+
+// pollBackoff returns how long to wait after the given number of consecutive getUpdates
+// failures. Conflicts and decode failures will not clear up within seconds, so they start
+// from a longer base; every repeat doubles the wait up to maxPollBackoff.
+func pollBackoff(failures int, err error) time.Duration {
+	wait := 2 * time.Second
+	switch {
+	case errors.Is(err, errPollConflict):
+		wait = 30 * time.Second
+	case errors.Is(err, errPollDecode):
+		wait = 5 * time.Second
+	}
+	for i := 1; i < failures && wait < maxPollBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxPollBackoff {
+		wait = maxPollBackoff
+	}
+	return wait
+}
+
 func (b *Bot) Run(ctx context.Context) {
+	failures := 0
 	for {
 		updates, err := b.getUpdates(ctx)
 		if err != nil {
-			log.Printf("getUpdates error: %v", err)
-			time.Sleep(2 * time.Second)
-			continue
-		}
-
-		for _, u := range updates {
-			if u.UpdateID >= b.offset {
-				b.offset = u.UpdateID + 1
-			}
+			failures++
+			wait := pollBackoff(failures, err)
 			switch {
-			case u.CallbackQuery != nil:
-				b.handleCallback(u.CallbackQuery)
-			case u.Message != nil:
-				b.handleMessage(u.Message)
+			case errors.Is(err, errPollConflict):
+				log.Printf("getUpdates conflict: another bot instance is polling with this token; retrying in %s (%v)", wait, err)
+			case errors.Is(err, errPollDecode) && failures > 1:
+				log.Printf("getUpdates failed to decode %d times in a row; retrying in %s (%v)", failures, wait, err)
+			default:
+				log.Printf("getUpdates error: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
 			}
+			continue
 		}
+		failures = 0
+		b.lastPoll.Store(time.Now().UnixNano())
+		b.processUpdates(updates)
 	}
 }
 
-func (b *Bot) getUpdates(ctx context.Context) ([]Update, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/getUpdates", b.apiURL), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	q := req.URL.Query()
-	q.Set("timeout", "25")
-	if b.offset > 0 {
-		q.Set("offset", strconv.Itoa(b.offset))
+// processUpdates advances the offset and hands each update to its chat's worker queue.
+// Without workers (see startWorkers) updates are handled inline, in order.
+func (b *Bot) processUpdates(updates []Update) {
+	for _, u := range updates {
+		if u.UpdateID >= b.offset {
+			b.offset = u.UpdateID + 1
+		}
+		if len(b.workers) == 0 {
+			b.dispatchUpdate(u)
+			continue
+		}
+		b.workers[workerIndex(updateChatID(u), len(b.workers))] <- u
 	}
-	req.URL.RawQuery = q.Encode()
+}
 
-	resp, err := b.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+const defaultUpdateWorkers = 4
 
-	var envelope struct {
-		OK          bool     `json:"ok"`
-		Result      []Update `json:"result"`
-		Description string   `json:"description"`
-		ErrorCode   int      `json:"error_code"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
-		return nil, err
+// startWorkers starts n ordered queues. Every update of a chat lands on the same queue, so
+// a chat's messages stay serialized while slow renders for one chat do not block others.
+func (b *Bot) startWorkers(n int) {
+	if n <= 0 {
+		n = defaultUpdateWorkers
 	}
-	if !envelope.OK {
-		return nil, fmt.Errorf("telegram getUpdates error %d: %s", envelope.ErrorCode, envelope.Description)
+	b.workers = make([]chan Update, n)
+	for i := range b.workers {
+		queue := make(chan Update, 64)
+		b.workers[i] = queue
+		go func() {
+			for u := range queue {
+				b.dispatchUpdate(u)
+			}
+		}()
 	}
-	return envelope.Result, nil
 }
 
-func (b *Bot) SendMessage(chatID int64, text string, markup interface{}) error {
-	return b.SendMessageWithMode(chatID, text, markup, "")
+func workerIndex(chatID int64, n int) int {
+	return int(uint64(chatID) % uint64(n))
 }
 
-func (b *Bot) SendMessageWithMode(chatID int64, text string, markup interface{}, parseMode string) error {
-	body := sendMessageRequest{
-		ChatID:                chatID,
-		Text:                  text,
-		ReplyMarkup:           markup,
-		ParseMode:             parseMode,
-		DisableWebPagePreview: true,
+// resolveUpdateWorkers reads the worker pool size from UPDATE_WORKERS.
+func resolveUpdateWorkers() int {
+	env := strings.TrimSpace(os.Getenv("UPDATE_WORKERS"))
+	if env == "" {
+		return defaultUpdateWorkers
 	}
-	raw, err := json.Marshal(body)
-	if err != nil {
-		return err
+	n, err := strconv.Atoi(env)
+	if err != nil || n < 1 {
+		log.Printf("Could not parse UPDATE_WORKERS (%s), using %d", env, defaultUpdateWorkers)
+		return defaultUpdateWorkers
 	}
+	return n
+}
 
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/sendMessage", b.apiURL), bytes.NewReader(raw))
-	if err != nil {
-		return err
+const (
+	defaultChatRatePerMinute = 30
+	defaultChatRateBurst     = 10
+	chatLimiterIdle          = 10 * time.Minute
+)
+
+// chatLimiter is a per-chat token bucket that keeps one chat from monopolising
+// renders and the bot's Telegram send budget. A nil limiter allows everything.
+type chatLimiter struct {
+	mu          sync.Mutex
+	rate        float64 // tokens per second
+	burst       float64
+	buckets     map[int64]*chatBucket
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+type chatBucket struct {
+	tokens float64
+	last   time.Time
+	warned bool
+}
+
+func newChatLimiter(perMinute, burst int) *chatLimiter {
+	return &chatLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[int64]*chatBucket),
+		now:     time.Now,
+	}
+}
+
+// allow takes a token for chatID. When the bucket is empty it reports ok=false, and
+// warn=true only for the first rejected update so the chat is told once, not on every tap.
+func (l *chatLimiter) allow(chatID int64) (ok, warn bool) {
+	if l == nil {
+		return true, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastCleanup) > chatLimiterIdle {
+		for id, bucket := range l.buckets {
+			if now.Sub(bucket.last) > chatLimiterIdle {
+				delete(l.buckets, id)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	bucket, found := l.buckets[chatID]
+	if !found {
+		bucket = &chatBucket{tokens: l.burst, last: now}
+		l.buckets[chatID] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		warn = !bucket.warned
+		bucket.warned = true
+		return false, warn
+	}
+	bucket.tokens--
+	bucket.warned = false
+	return true, false
+}
+
+// resolveChatLimiter reads CHAT_RATE_LIMIT (updates per minute per chat) and
+// CHAT_RATE_BURST. CHAT_RATE_LIMIT=0 turns rate limiting off.
+func resolveChatLimiter() *chatLimiter {
+	perMinute := defaultChatRatePerMinute
+	if env := strings.TrimSpace(os.Getenv("CHAT_RATE_LIMIT")); env != "" {
+		n, err := strconv.Atoi(env)
+		if err != nil || n < 0 {
+			log.Printf("Could not parse CHAT_RATE_LIMIT (%s), using %d", env, perMinute)
+		} else {
+			perMinute = n
+		}
+	}
+	if perMinute == 0 {
+		return nil
+	}
+	burst := defaultChatRateBurst
+	if env := strings.TrimSpace(os.Getenv("CHAT_RATE_BURST")); env != "" {
+		n, err := strconv.Atoi(env)
+		if err != nil || n < 1 {
+			log.Printf("Could not parse CHAT_RATE_BURST (%s), using %d", env, burst)
+		} else {
+			burst = n
+		}
+	}
+	return newChatLimiter(perMinute, burst)
+}
+
+// rateLimited reports whether an update from chatID should be dropped, telling the
+// chat to slow down the first time it hits the limit.
+func (b *Bot) rateLimited(chatID int64) bool {
+	ok, warn := b.limiter.allow(chatID)
+	if ok {
+		return false
+	}
+	if warn {
+		if _, err := b.sender.SendMessage(chatID, tr(b.userLang(chatID), "rate_limited"), nil); err != nil {
+			log.Printf("rate limit notice error: %v", err)
+		}
+	}
+	return true
+}
+
+// dispatchUpdate routes one update to its handler. A panic while handling it is logged
+// with its stack and swallowed so a single malformed update cannot stop the poll loop.
+func (b *Bot) dispatchUpdate(u Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic while handling update %d for chat %d: %v\n%s", u.UpdateID, updateChatID(u), r, debug.Stack())
+		}
+	}()
+	switch {
+	case u.CallbackQuery != nil:
+		if u.CallbackQuery.Message != nil {
+			b.reactivate(u.CallbackQuery.Message.Chat.ID)
+		}
+		b.handleCallback(u.CallbackQuery)
+	case u.InlineQuery != nil:
+		b.handleInlineQuery(u.InlineQuery)
+	case u.MyChatMember != nil:
+		b.handleMyChatMember(u.MyChatMember)
+	case u.Message != nil:
+		b.reactivate(u.Message.Chat.ID)
+		b.handleMessage(u.Message)
+	}
+}
+
+// healthPollThreshold is how long /healthz tolerates no successful getUpdates. Long polls
+// return at least every 25 seconds, so several missed polls mean the loop is wedged.
+const healthPollThreshold = 2 * time.Minute
+
+func (b *Bot) healthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		last := b.lastPoll.Load()
+		if last == 0 || time.Since(time.Unix(0, last)) > healthPollThreshold {
+			http.Error(w, "polling stalled", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		var drift driftSnapshot
+		if b.scheduler != nil {
+			drift = b.scheduler.drift.snapshot()
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintf(w, "# HELP ramadan_reminders_sent_total Reminders sent by the reminder loops.\n# TYPE ramadan_reminders_sent_total counter\nramadan_reminders_sent_total %d\n", drift.Sent)
+		fmt.Fprintf(w, "# HELP ramadan_reminders_late_total Reminders sent more than %s after their scheduled moment.\n# TYPE ramadan_reminders_late_total counter\nramadan_reminders_late_total %d\n", reminderDriftWarn, drift.Late)
+		fmt.Fprintf(w, "# HELP ramadan_reminder_drift_max_seconds Largest reminder delay today.\n# TYPE ramadan_reminder_drift_max_seconds gauge\nramadan_reminder_drift_max_seconds %.3f\n", drift.Max.Seconds())
+		fmt.Fprintf(w, "# HELP ramadan_reminder_drift_last_seconds Delay of the most recent reminder.\n# TYPE ramadan_reminder_drift_last_seconds gauge\nramadan_reminder_drift_last_seconds %.3f\n", drift.Last.Seconds())
+	})
+	mux.HandleFunc("/calendar/", b.serveCalendarFeed)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !b.ready.Load() {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// serveCalendarFeed answers /calendar/<token>.ics with the current ICS feed of the chat
+// that owns the token, so subscribed calendar apps pick up schedule changes.
+func (b *Bot) serveCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/calendar/"), ".ics")
+	if !ok || token == "" || b.state == nil {
+		http.NotFound(w, r)
+		return
+	}
+	settings, ok := b.state.ChatByCalendarToken(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	lang := normalizeLang(settings.Language)
+	if lang == "" {
+		lang = fallbackLang()
+	}
+	doc, ok := b.calendarFeed(settings, lang)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.Write(doc)
+}
+
+func (b *Bot) serveHealth(addr string) {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           b.healthHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	log.Printf("Health endpoints listening on %s", addr)
+	if err := server.ListenAndServe(); err != nil {
+		log.Printf("health server error: %v", err)
+	}
+}
+
+// updateChatID returns the chat an update belongs to, or 0 when it has none.
+func updateChatID(u Update) int64 {
+	switch {
+	case u.Message != nil:
+		return u.Message.Chat.ID
+	case u.CallbackQuery != nil:
+		if u.CallbackQuery.Message != nil {
+			return u.CallbackQuery.Message.Chat.ID
+		}
+		return u.CallbackQuery.From.ID
+	case u.InlineQuery != nil:
+		return u.InlineQuery.From.ID
+	case u.MyChatMember != nil:
+		return u.MyChatMember.Chat.ID
+	}
+	return 0
+}
+
+func (b *Bot) getUpdates(ctx context.Context) ([]Update, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/getUpdates", b.apiURL), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	q := req.URL.Query()
+	q.Set("timeout", "25")
+	if b.offset > 0 {
+		q.Set("offset", strconv.Itoa(b.offset))
+	}
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		OK          bool               `json:"ok"`
+		Result      []Update           `json:"result"`
+		Description string             `json:"description"`
+		ErrorCode   int                `json:"error_code"`
+		Parameters  responseParameters `json:"parameters"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("%w: %v", errPollDecode, err)
+	}
+	if !envelope.OK {
+		apiErr := newTelegramError("getUpdates", envelope.ErrorCode, envelope.Description, envelope.Parameters)
+		if envelope.ErrorCode == http.StatusConflict {
+			return nil, fmt.Errorf("%w: %w", errPollConflict, apiErr)
+		}
+		return nil, apiErr
+	}
+	return envelope.Result, nil
+}
+
+// ErrBotBlocked matches a TelegramError reporting that the chat can no longer be reached:
+// the user blocked the bot, deleted their account, or removed the bot from the group.
+var ErrBotBlocked = errors.New("telegram: bot was blocked by the user")
+
+type responseParameters struct {
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// TelegramError is an unsuccessful Bot API response.
+type TelegramError struct {
+	Method      string
+	Code        int
+	Description string
+	// RetryAfter is the number of seconds to wait before retrying a rate-limited request.
+	RetryAfter int
+}
+
+func newTelegramError(method string, code int, description string, params responseParameters) *TelegramError {
+	return &TelegramError{Method: method, Code: code, Description: description, RetryAfter: params.RetryAfter}
+}
+
+func (e *TelegramError) Error() string {
+	return fmt.Sprintf("telegram %s error %d: %s", e.Method, e.Code, e.Description)
+}
+
+func (e *TelegramError) IsRateLimited() bool {
+	return e.Code == http.StatusTooManyRequests
+}
+
+func (e *TelegramError) IsBlocked() bool {
+	if e.Code != http.StatusForbidden {
+		return false
+	}
+	lower := strings.ToLower(e.Description)
+	return strings.Contains(lower, "blocked") || strings.Contains(lower, "deactivated") || strings.Contains(lower, "kicked")
+}
+
+// IsMissingRights reports that the bot lacks the admin rights the call needs, such as
+// pinning messages in a group.
+func (e *TelegramError) IsMissingRights() bool {
+	lower := strings.ToLower(e.Description)
+	return strings.Contains(lower, "not enough rights") || strings.Contains(lower, "chat_admin_required")
+}
+
+// Is lets errors.Is(err, ErrBotBlocked) match blocked-chat responses.
+func (e *TelegramError) Is(target error) bool {
+	return target == ErrBotBlocked && e.IsBlocked()
+}
+
+// SendMessage sends plain text and returns the message Telegram created.
+func (b *Bot) SendMessage(chatID int64, text string, markup interface{}) (*Message, error) {
+	return b.sendMessage(chatID, text, markup, "", false)
+}
+
+func (b *Bot) SendMessageWithMode(chatID int64, text string, markup interface{}, parseMode string) (*Message, error) {
+	return b.sendMessage(chatID, text, markup, parseMode, false)
+}
+
+// SendMessageWithPreview sends plain text and lets Telegram show a link preview, which
+// every other send suppresses.
+func (b *Bot) SendMessageWithPreview(chatID int64, text string, markup interface{}) (*Message, error) {
+	return b.sendMessage(chatID, text, markup, "", true)
+}
+
+func (b *Bot) sendMessage(chatID int64, text string, markup interface{}, parseMode string, linkPreview bool) (*Message, error) {
+	body := sendMessageRequest{
+		ChatID:                chatID,
+		Text:                  text,
+		ReplyMarkup:           markup,
+		ParseMode:             parseMode,
+		DisableWebPagePreview: !linkPreview,
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/sendMessage", b.apiURL), bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := b.client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	var result struct {
-		OK          bool     `json:"ok"`
-		Description string   `json:"description"`
-		ErrorCode   int      `json:"error_code"`
-		Result      *Message `json:"result"`
+		OK          bool               `json:"ok"`
+		Description string             `json:"description"`
+		ErrorCode   int                `json:"error_code"`
+		Parameters  responseParameters `json:"parameters"`
+		Result      *Message           `json:"result"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return err
+		return nil, err
 	}
 	if !result.OK {
-		return fmt.Errorf("telegram sendMessage error %d: %s", result.ErrorCode, result.Description)
+		return nil, newTelegramError("sendMessage", result.ErrorCode, result.Description, result.Parameters)
 	}
-	return nil
+	return result.Result, nil
 }
 
-func (b *Bot) SendPhoto(chatID int64, photo []byte, caption string) error {
-	var body bytes.Buffer
-	writer := multipart.NewWriter(&body)
+// SendPhoto uploads a PNG and returns the message Telegram created.
+func (b *Bot) SendPhoto(chatID int64, photo []byte, caption string) (*Message, error) {
+	return b.SendPhotoWithMarkup(chatID, photo, caption, nil)
+}
+
+// SendPhotoWithMarkup is SendPhoto with an inline keyboard attached.
+func (b *Bot) SendPhotoWithMarkup(chatID int64, photo []byte, caption string, markup interface{}) (*Message, error) {
+	fields := map[string]string{"chat_id": strconv.FormatInt(chatID, 10)}
+	if caption != "" {
+		fields["caption"] = caption
+	}
+	if markup != nil {
+		raw, err := json.Marshal(markup)
+		if err != nil {
+			return nil, err
+		}
+		fields["reply_markup"] = string(raw)
+	}
+	return b.postMessageMultipart("sendPhoto", fields, "photo", "calendar.png", photo)
+}
 
-	if err := writer.WriteField("chat_id", strconv.FormatInt(chatID, 10)); err != nil {
+// EditMessagePhoto swaps the picture, caption and keyboard of a photo message in place.
+func (b *Bot) EditMessagePhoto(chatID int64, messageID int, photo []byte, caption string, markup interface{}) error {
+	media, err := json.Marshal(map[string]string{
+		"type":    "photo",
+		"media":   "attach://photo",
+		"caption": caption,
+	})
+	if err != nil {
 		return err
 	}
+	fields := map[string]string{
+		"chat_id":    strconv.FormatInt(chatID, 10),
+		"message_id": strconv.Itoa(messageID),
+		"media":      string(media),
+	}
+	if markup != nil {
+		raw, err := json.Marshal(markup)
+		if err != nil {
+			return err
+		}
+		fields["reply_markup"] = string(raw)
+	}
+	return b.postMultipart("editMessageMedia", fields, "photo", "calendar.png", photo, nil)
+}
+
+// SendVoice sends an OGG/Opus recording as a voice message.
+func (b *Bot) SendVoice(chatID int64, audio []byte, caption string) (*Message, error) {
+	fields := map[string]string{"chat_id": strconv.FormatInt(chatID, 10)}
+	if caption != "" {
+		fields["caption"] = caption
+	}
+	return b.postMessageMultipart("sendVoice", fields, "voice", "dua.ogg", audio)
+}
+
+// SendDocument uploads data as a file attachment named filename.
+func (b *Bot) SendDocument(chatID int64, data []byte, filename, caption string) (*Message, error) {
+	fields := map[string]string{"chat_id": strconv.FormatInt(chatID, 10)}
 	if caption != "" {
-		if err := writer.WriteField("caption", caption); err != nil {
+		fields["caption"] = caption
+	}
+	return b.postMessageMultipart("sendDocument", fields, "document", filename, data)
+}
+
+// postMessageMultipart is postMultipart for the send* methods, which answer with the
+// message they created.
+func (b *Bot) postMessageMultipart(method string, fields map[string]string, fileField, fileName string, data []byte) (*Message, error) {
+	var sent Message
+	if err := b.postMultipart(method, fields, fileField, fileName, data, &sent); err != nil {
+		return nil, err
+	}
+	return &sent, nil
+}
+
+// postMultipart uploads data as fileField together with plain form fields and decodes
+// the result into out when out is non-nil.
+func (b *Bot) postMultipart(method string, fields map[string]string, fileField, fileName string, data []byte, out interface{}) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
 			return err
 		}
 	}
 
-	part, err := writer.CreateFormFile("photo", "calendar.png")
+	part, err := writer.CreateFormFile(fileField, fileName)
 	if err != nil {
 		return err
 	}
-	if _, err := part.Write(photo); err != nil {
+	if _, err := part.Write(data); err != nil {
 		return err
 	}
 	if err := writer.Close(); err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/sendPhoto", b.apiURL), &body)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/%s", b.apiURL, method), &body)
 	if err != nil {
 		return err
 	}
@@ -818,1005 +2570,4186 @@ func (b *Bot) SendPhoto(chatID int64, photo []byte, caption string) error {
 	defer resp.Body.Close()
 
 	var result struct {
-		OK          bool   `json:"ok"`
-		Description string `json:"description"`
-		ErrorCode   int    `json:"error_code"`
+		OK          bool               `json:"ok"`
+		Description string             `json:"description"`
+		ErrorCode   int                `json:"error_code"`
+		Parameters  responseParameters `json:"parameters"`
+		Result      json.RawMessage    `json:"result"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return err
 	}
 	if !result.OK {
-		return fmt.Errorf("telegram sendPhoto error %d: %s", result.ErrorCode, result.Description)
+		return newTelegramError(method, result.ErrorCode, result.Description, result.Parameters)
+	}
+	if out != nil && len(result.Result) > 0 {
+		return json.Unmarshal(result.Result, out)
 	}
 	return nil
 }
 
-func (b *Bot) sendRestartUpdateNotice(chatIDs []int64) {
-	if len(chatIDs) == 0 {
-		return
+// GetChatMember looks up userID's membership, including their status, in chatID.
+func (b *Bot) GetChatMember(chatID, userID int64) (*ChatMember, error) {
+	body := map[string]interface{}{
+		"chat_id": chatID,
+		"user_id": userID,
 	}
-	interval := 50 * time.Millisecond
-
-	for i, chatID := range chatIDs {
-		if i > 0 {
-			time.Sleep(interval)
-		}
-		lang := b.userLang(chatID)
-		if err := b.SendMessage(chatID, tr(lang, "restart_update_notice"), nil); err != nil {
-			log.Printf("restart notice send error for chat %d: %v", chatID, err)
-		}
+	var member ChatMember
+	if err := b.postJSON("getChatMember", body, &member); err != nil {
+		return nil, err
 	}
-	log.Printf("Restart notice sent to %d chats", len(chatIDs))
+	return &member, nil
 }
 
-func (b *Bot) answerCallback(id string) {
-	data := url.Values{}
-	data.Set("callback_query_id", id)
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/answerCallbackQuery", b.apiURL), strings.NewReader(data.Encode()))
+// isChatAdmin reports whether userID owns or administers the group chatID. Errors count
+// as "no", so a failing API call never opens up admin-only commands.
+func (b *Bot) isChatAdmin(chatID, userID int64) bool {
+	member, err := b.sender.GetChatMember(chatID, userID)
 	if err != nil {
-		log.Printf("answerCallback build error: %v", err)
-		return
+		log.Printf("getChatMember %d in chat %d error: %v", userID, chatID, err)
+		return false
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := b.client.Do(req)
-	if err != nil {
-		log.Printf("answerCallback error: %v", err)
-		return
+	return member.Status == "creator" || member.Status == "administrator"
+}
+
+// groupAdminCommands change settings the whole group shares, so in groups only admins
+// may run them. Their keyboard callbacks are listed in groupAdminCallbacks.
+var groupAdminCommands = map[string]bool{
+	"/lang": true, "/language": true, "/region": true, "/theme": true, "/notifyon": true,
+	"/notifyoff": true, "/mute": true, "/quiet": true, "/digest": true, "/tahajjud": true,
+	"/hadithcard": true, "/ayahcard": true, "/madhab": true, "/textmode": true, "/hidemenu": true,
+	"/showmenu": true, "/pintoday": true,
+}
+
+var groupAdminCallbacks = []string{"lang:", "region:", "theme:", "madhab:", "settings:"}
+
+// mayChangeChat reports whether userID may change chatID's settings: always in private
+// chats (positive IDs), only as a group admin in groups.
+func (b *Bot) mayChangeChat(chatID, userID int64) bool {
+	if chatID > 0 {
+		return true
 	}
-	resp.Body.Close()
+	return userID != 0 && b.isChatAdmin(chatID, userID)
 }
 
-func normalizeButtonText(text string) string {
-	return strings.Join(strings.Fields(strings.ToLower(strings.TrimSpace(text))), " ")
+// messageMayChangeChat is mayChangeChat for a message; anonymous admins write as the
+// group itself.
+func (b *Bot) messageMayChangeChat(msg *Message) bool {
+	if msg.SenderChat != nil && msg.SenderChat.ID == msg.Chat.ID {
+		return true
+	}
+	return b.mayChangeChat(msg.Chat.ID, messageUserID(msg))
 }
 
-func (b *Bot) resolveCommand(chatID int64, text string) string {
-	normalized := normalizeButtonText(text)
-	if normalized == "" {
-		return ""
+func messageUserID(msg *Message) int64 {
+	if msg.From == nil {
+		return 0
 	}
-	switch normalized {
-	case "/start", "/menu", "/help", "/lang", "/language", "/region", "/calendar", "/today", "/hadiths", "/notifyon", "/notifyoff", "/testnotify":
-		return normalized
+	return msg.From.ID
+}
+
+// sendGroupAdminOnly tells userID, in their own language, that only group admins may
+// do what they tried.
+func (b *Bot) sendGroupAdminOnly(chatID, userID int64) {
+	if _, err := b.sender.SendMessage(chatID, tr(b.memberLang(chatID, userID), "group_admin_only"), nil); err != nil {
+		log.Printf("admin-only notice send error: %v", err)
 	}
+}
 
-	buttonToCommand := map[string]string{
-		"btn_calendar":   "/calendar",
-		"btn_today":      "/today",
-		"btn_region":     "/region",
-		"btn_lang":       "/lang",
-		"btn_notify_on":  "/notifyon",
-		"btn_notify_off": "/notifyoff",
-		"btn_help":       "/help",
-		"btn_hadiths":    "/hadiths",
+// PinChatMessage pins a message without notifying the chat's members.
+func (b *Bot) PinChatMessage(chatID int64, messageID int) error {
+	body := map[string]interface{}{
+		"chat_id":              chatID,
+		"message_id":           messageID,
+		"disable_notification": true,
 	}
-	checkLangs := []string{langTG, langRU, langEN, langUZ, b.userLang(chatID)}
-	for _, l := range checkLangs {
-		for key, command := range buttonToCommand {
-			if normalized == normalizeButtonText(tr(l, key)) {
-				return command
-			}
-		}
+	return b.postJSON("pinChatMessage", body, nil)
+}
+
+// SetMessageReaction puts a single emoji reaction on a message. Telegram only accepts
+// emoji from its reaction list; an empty emoji removes the bot's reaction.
+func (b *Bot) SetMessageReaction(chatID int64, messageID int, emoji string) error {
+	reaction := []map[string]string{}
+	if emoji != "" {
+		reaction = append(reaction, map[string]string{"type": "emoji", "emoji": emoji})
 	}
+	body := map[string]interface{}{
+		"chat_id":    chatID,
+		"message_id": messageID,
+		"reaction":   reaction,
+	}
+	return b.postJSON("setMessageReaction", body, nil)
+}
 
-	return normalized
+// EditMessageText replaces the text (and inline keyboard) of a message the bot sent earlier.
+func (b *Bot) EditMessageText(chatID int64, messageID int, text string, markup interface{}) error {
+	body := editMessageTextRequest{
+		ChatID:                chatID,
+		MessageID:             messageID,
+		Text:                  text,
+		ReplyMarkup:           markup,
+		DisableWebPagePreview: true,
+	}
+	err := b.postJSON("editMessageText", body, nil)
+	if err != nil && strings.Contains(err.Error(), "message is not modified") {
+		return nil
+	}
+	return err
 }
 
-func (b *Bot) handleMessage(msg *Message) {
-	lower := b.resolveCommand(msg.Chat.ID, msg.Text)
-	switch {
-	case lower == "/start":
-		b.handleStart(msg.Chat.ID)
-	case lower == "/lang" || lower == "/language":
-		b.promptLanguage(msg.Chat.ID)
-	case lower == "/menu":
-		b.handleStart(msg.Chat.ID)
-	case lower == "/help":
-		if _, ok := b.requireLanguage(msg.Chat.ID); !ok {
-			return
-		}
-		b.sendHelp(msg.Chat.ID)
-	case lower == "/region":
-		if lang, ok := b.requireLanguage(msg.Chat.ID); ok {
-			b.promptRegion(msg.Chat.ID, tr(lang, "choose_region"))
-		}
-	case lower == "/calendar":
-		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
-			b.sendCalendar(msg.Chat.ID)
-		}
-	case lower == "/today":
-		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
-			b.sendToday(msg.Chat.ID)
-		}
-	case lower == "/hadiths":
-		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
-			b.sendHadith(msg.Chat.ID)
-		}
-	case lower == "/notifyoff":
-		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
-			b.setNotifications(msg.Chat.ID, false)
-		}
-	case lower == "/notifyon":
-		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
-			b.setNotifications(msg.Chat.ID, true)
-		}
-	case lower == "/testnotify":
-		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
-			b.sendTestNotification(msg.Chat.ID)
-		}
-	default:
-		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
-			b.sendHelp(msg.Chat.ID)
-		}
+// EditMessageReplyMarkup swaps only the inline keyboard of an earlier message.
+func (b *Bot) EditMessageReplyMarkup(chatID int64, messageID int, markup interface{}) error {
+	body := map[string]interface{}{
+		"chat_id":      chatID,
+		"message_id":   messageID,
+		"reply_markup": markup,
+	}
+	err := b.postJSON("editMessageReplyMarkup", body, nil)
+	if err != nil && strings.Contains(err.Error(), "message is not modified") {
+		return nil
 	}
+	return err
 }
 
-func (b *Bot) userLang(chatID int64) string {
-	lang := normalizeLang(b.state.Get(chatID).Language)
-	if lang == "" {
-		return langTG
+// editOrSend updates msg in place and falls back to a new message when editing fails
+// (for example when the original message is too old to be edited).
+func (b *Bot) editOrSend(chatID int64, msg *Message, text string, markup interface{}) error {
+	if msg != nil && msg.MessageID != 0 {
+		err := b.sender.EditMessageText(chatID, msg.MessageID, text, markup)
+		if err == nil {
+			return nil
+		}
+		log.Printf("edit message %d in chat %d failed, sending new one: %v", msg.MessageID, chatID, err)
 	}
-	return lang
+	_, err := b.sender.SendMessage(chatID, text, markup)
+	return err
 }
 
-func (b *Bot) requireLanguage(chatID int64) (string, bool) {
-	settings := b.state.Get(chatID)
-	lang := normalizeLang(settings.Language)
-	if lang == "" {
-		b.promptLanguage(chatID)
-		return "", false
-	}
-	if lang != settings.Language {
-		b.state.SetLanguage(chatID, lang)
+// postJSON calls a Telegram API method with a JSON body and decodes the result into out
+// when out is non-nil.
+func (b *Bot) postJSON(method string, payload interface{}, out interface{}) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
 	}
-	return lang, true
-}
 
-func (b *Bot) promptLanguage(chatID int64) {
-	if err := b.SendMessage(chatID, tr(b.userLang(chatID), "choose_language"), b.languageKeyboard()); err != nil {
-		log.Printf("prompt language error: %v", err)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/%s", b.apiURL, method), bytes.NewReader(raw))
+	if err != nil {
+		return err
 	}
-}
+	req.Header.Set("Content-Type", "application/json")
 
-func (b *Bot) handleStart(chatID int64) {
-	settings := b.state.Get(chatID)
-	lang := normalizeLang(settings.Language)
-	if lang == "" {
-		b.promptLanguage(chatID)
-		return
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
 	}
-	if err := b.SendMessage(chatID, tr(lang, "welcome"), b.menuKeyboard(lang)); err != nil {
-		log.Printf("send welcome error: %v", err)
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool               `json:"ok"`
+		Description string             `json:"description"`
+		ErrorCode   int                `json:"error_code"`
+		Parameters  responseParameters `json:"parameters"`
+		Result      json.RawMessage    `json:"result"`
 	}
-	if strings.TrimSpace(settings.Region) == "" {
-		b.promptRegion(chatID, tr(lang, "choose_region"))
-		return
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
 	}
-}
-
-func (b *Bot) promptRegion(chatID int64, message string) {
-	if strings.TrimSpace(message) == "" {
-		message = tr(b.userLang(chatID), "choose_region")
+	if !result.OK {
+		return newTelegramError(method, result.ErrorCode, result.Description, result.Parameters)
 	}
-	if err := b.SendMessage(chatID, message, b.regionKeyboard()); err != nil {
-		log.Printf("prompt region error: %v", err)
+	if out != nil && len(result.Result) > 0 {
+		return json.Unmarshal(result.Result, out)
 	}
+	return nil
 }
 
-func (b *Bot) handleCallback(cb *CallbackQuery) {
-	if cb.Data == "" {
-		return
-	}
-	b.answerCallback(cb.ID)
-
-	chatID := cb.From.ID
-	if cb.Message != nil {
-		chatID = cb.Message.Chat.ID
-	}
-	if strings.HasPrefix(cb.Data, "lang:") {
-		lang := normalizeLang(strings.TrimPrefix(cb.Data, "lang:"))
-		if lang == "" {
-			lang = langTG
-		}
-		b.state.SetLanguage(chatID, lang)
-		if err := b.SendMessage(chatID, tr(lang, "language_saved"), nil); err != nil {
-			log.Printf("confirm language error: %v", err)
-		}
-		if strings.TrimSpace(b.state.Get(chatID).Region) == "" {
-			b.promptRegion(chatID, tr(lang, "choose_region"))
-		} else {
-			b.sendHelp(chatID)
-		}
+func (b *Bot) sendRestartUpdateNotice(chatIDs []int64) {
+	if len(chatIDs) == 0 {
 		return
 	}
+	interval := 50 * time.Millisecond
 
-	if strings.HasPrefix(cb.Data, "region:") {
-		lang, ok := b.requireLanguage(chatID)
-		if !ok {
-			return
+	for i, chatID := range chatIDs {
+		if i > 0 {
+			time.Sleep(interval)
 		}
-		region := strings.TrimPrefix(cb.Data, "region:")
-		b.state.SetRegion(chatID, region)
-		if err := b.SendMessage(chatID, trf(lang, "region_selected", region), nil); err != nil {
-			log.Printf("confirm region error: %v", err)
+		lang := b.userLang(chatID)
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "restart_update_notice"), nil); err != nil {
+			log.Printf("restart notice send error for chat %d: %v", chatID, err)
 		}
-		b.scheduler.Start(chatID, region)
-		return
 	}
+	log.Printf("Restart notice sent to %d chats", len(chatIDs))
 }
 
-func (b *Bot) sendHelp(chatID int64) {
-	lang := b.userLang(chatID)
-	if err := b.SendMessage(chatID, tr(lang, "help"), b.menuKeyboard(lang)); err != nil {
-		log.Printf("help send error: %v", err)
+func (b *Bot) AnswerCallback(id string) error {
+	data := url.Values{}
+	data.Set("callback_query_id", id)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/answerCallbackQuery", b.apiURL), strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
 	}
-}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-func (b *Bot) sendCalendar(chatID int64) {
-	settings := b.state.Get(chatID)
-	lang := b.userLang(chatID)
-	region := settings.Region
-	if region == "" {
-		region = b.defaultRegion
+	var result struct {
+		OK          bool               `json:"ok"`
+		Description string             `json:"description"`
+		ErrorCode   int                `json:"error_code"`
+		Parameters  responseParameters `json:"parameters"`
 	}
-	schedule, ok := b.calendars[region]
-	if !ok {
-		b.SendMessage(chatID, tr(lang, "need_region_first"), nil)
-		return
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
 	}
-
-	photo, err := b.cachedCalendarImage(lang, region, schedule)
-	if err != nil {
-		log.Printf("calendar image build error: %v", err)
-	} else {
-		caption := trf(
-			lang,
-			"calendar_caption",
-			region,
-			formatHadithBlock(lang, tr(lang, "hadith_day_title"), b.randomHadith(lang)),
-		)
-		if err := b.SendPhoto(chatID, photo, caption); err != nil {
-			log.Printf("calendar photo send error: %v", err)
-		}
+	if !result.OK {
+		return newTelegramError("answerCallbackQuery", result.ErrorCode, result.Description, result.Parameters)
 	}
+	return nil
+}
 
-	//if err := b.SendMessage(chatID, text, nil); err != nil {
-	//	log.Printf("calendar text send error: %v", err)
-	//}
+func normalizeButtonText(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(strings.TrimSpace(text))), " ")
 }
 
-func (b *Bot) sendToday(chatID int64) {
-	settings := b.state.Get(chatID)
-	lang := b.userLang(chatID)
-	if settings.Region == "" {
-		b.promptRegion(chatID, tr(lang, "need_region_first"))
-		return
-	}
-	cal, ok := b.calendars[settings.Region]
-	if !ok || len(cal) == 0 {
-		b.SendMessage(chatID, tr(lang, "calendar_not_found"), nil)
-		return
-	}
-	day := currentDaySchedule(cal, b.ramadanStart, b.tz)
-	if day == nil {
-		b.SendMessage(chatID, tr(lang, "out_of_range"), nil)
-		return
+// knownCommands are the slash commands handleMessage understands.
+var knownCommands = []string{"/start", "/menu", "/help", "/lang", "/language", "/region", "/settings", "/theme", "/calendar", "/today", "/day", "/dua", "/tasbih", "/qibla", "/hadiths", "/ayah", "/zakatfitr", "/digest", "/quiet", "/tahajjud", "/hadithcard", "/ayahcard", "/hidemenu", "/showmenu", "/calendartext", "/calendarpdf", "/ics", "/subscribe", "/textmode", "/madhab", "/progress", "/countdown", "/pintoday", "/notifyon", "/notifyoff", "/mute", "/testnotify", "/preview", "/catchup", "/compare", "/prayers", "/mylang", "/about", "/cachestats", "/broadcast", "/export"}
+
+// commandAliases maps variants users commonly type to a command. A value may carry
+// arguments, used when the user gave none ("/tomorrow" is "/day +1").
+var commandAliases = map[string]string{
+	"/calender":    "/calendar",
+	"/calandar":    "/calendar",
+	"/kalendar":    "/calendar",
+	"/schedule":    "/calendar",
+	"/taqvim":      "/calendar",
+	"/календарь":   "/calendar",
+	"/тақвим":      "/calendar",
+	"/timings":     "/today",
+	"/times":       "/today",
+	"/time":        "/today",
+	"/prayertimes": "/today",
+	"/iftar":       "/today",
+	"/suhoor":      "/today",
+	"/сегодня":     "/today",
+	"/имрӯз":       "/today",
+	"/bugun":       "/today",
+	"/tomorrow":    "/day +1",
+	"/завтра":      "/day +1",
+	"/фардо":       "/day +1",
+	"/ertaga":      "/day +1",
+	"/languages":   "/lang",
+	"/язык":        "/lang",
+	"/забон":       "/lang",
+	"/til":         "/lang",
+	"/city":        "/region",
+	"/location":    "/region",
+	"/регион":      "/region",
+	"/минтақа":     "/region",
+	"/hadith":      "/hadiths",
+	"/hadis":       "/hadiths",
+	"/qiblah":      "/qibla",
+	"/kibla":       "/qibla",
+	"/setting":     "/settings",
+	"/zakat":       "/zakatfitr",
+	"/fitr":        "/zakatfitr",
+	"/duas":        "/dua",
+	"/niyat":       "/dua",
+	"/stop":        "/notifyoff",
+	"/помощь":      "/help",
+	"/version":     "/about",
+}
+
+// expandCommandAlias rewrites an aliased command to its target, filling in the alias's
+// arguments when the user gave none.
+func expandCommandAlias(cmd, args string) (string, string) {
+	target, ok := commandAliases[normalizeButtonText(cmd)]
+	if !ok {
+		return cmd, args
 	}
-
-	photo, err := b.cachedTodayImage(lang, settings.Region, *day)
-	if err != nil {
-		log.Printf("today image build error: %v", err)
-	} else {
-		caption := trf(
-			lang,
-			"today_caption",
-			settings.Region,
-			day.Data,
-			day.Day,
-			formatHadithBlock(lang, tr(lang, "hadith_day_title"), b.randomHadith(lang)),
-		)
-		if err := b.SendPhoto(chatID, photo, caption); err != nil {
-			log.Printf("today photo send error: %v", err)
-		}
+	target, aliasArgs, _ := strings.Cut(target, " ")
+	if args == "" {
+		args = aliasArgs
 	}
+	return target, args
 }
 
-func (b *Bot) sendHadith(chatID int64) {
-	lang := b.userLang(chatID)
-	text, err := b.randomHadithFromAPI(lang)
-	if err != nil {
-		log.Printf("hadith api error for chat %d: %v", chatID, err)
-		text = formatHadithBlock(lang, tr(lang, "hadith_day_title"), b.randomHadith(lang))
+// closestCommand corrects a one-letter slip in a slash command ("/todya", "/calender").
+// Short commands and ties are left alone so the typo fallback stays conservative.
+func closestCommand(text string) (string, bool) {
+	if !strings.HasPrefix(text, "/") || utf8.RuneCountInString(text) < 5 {
+		return "", false
 	}
-	if err := b.SendMessage(chatID, text, nil); err != nil {
-		log.Printf("hadith send error: %v", err)
+	best, ties := "", 0
+	for _, cmd := range knownCommands {
+		if len(cmd) < 5 || editDistance(text, cmd) > 1 {
+			continue
+		}
+		best = cmd
+		ties++
+	}
+	return best, ties == 1
+}
+
+// editDistance is the optimal string alignment distance between a and b: insertions,
+// deletions, substitutions and swaps of neighbouring letters each count one.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
 	}
+	return prev[len(rb)]
 }
 
-func (b *Bot) randomHadithFromAPI(lang string) (string, error) {
-	apiLang := hadithAPILanguageForUser(lang)
-	return b.randomHadithFromAPIByLanguage(lang, apiLang)
+// buttonWords strips the emoji and punctuation around a button label, so typing
+// "календарь" finds the "🗓 Календарь" button.
+func buttonWords(label string) string {
+	return strings.TrimFunc(label, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
 }
 
-func hadithAPILanguageForUser(lang string) string {
-	normalized := normalizeLang(lang)
+func (b *Bot) resolveCommand(chatID int64, text string) string {
+	normalized := normalizeButtonText(text)
 	if normalized == "" {
-		return langEN
-	}
-	return normalized
-}
-
-func (b *Bot) randomHadithFromAPIByLanguage(userLang, apiLang string) (string, error) {
-	categories, err := b.fetchHadithCategories(apiLang)
-	if err != nil {
-		return "", err
+		return ""
 	}
-	if len(categories) == 0 {
-		return "", fmt.Errorf("no categories for api language %q", apiLang)
+	for _, cmd := range knownCommands {
+		if normalized == cmd {
+			return normalized
+		}
 	}
 
-	var lastErr error
-	maxAttempts := len(categories)
-	if maxAttempts > 3 {
-		maxAttempts = 3
+	buttonToCommand := map[string]string{
+		"btn_calendar":   "/calendar",
+		"btn_today":      "/today",
+		"btn_region":     "/region",
+		"btn_lang":       "/lang",
+		"btn_notify_on":  "/notifyon",
+		"btn_notify_off": "/notifyoff",
+		"btn_help":       "/help",
+		"btn_hadiths":    "/hadiths",
 	}
-	for i, idx := range rand.Perm(len(categories)) {
-		if i >= maxAttempts {
-			break
-		}
-		categoryID := strings.TrimSpace(categories[idx].ID)
-		if categoryID == "" {
-			continue
-		}
-		items, err := b.fetchHadithList(apiLang, categoryID)
-		if err != nil {
-			lastErr = err
-			continue
-		}
-		if len(items) == 0 {
-			continue
-		}
-
-		item := items[rand.Intn(len(items))]
-		detail, err := b.fetchHadithDetail(apiLang, strings.TrimSpace(item.ID))
-		if err != nil {
-			lastErr = err
-			continue
+	checkLangs := append(append([]string(nil), supportedLangs...), b.userLang(chatID))
+	for _, l := range checkLangs {
+		for key, command := range buttonToCommand {
+			label := normalizeButtonText(tr(l, key))
+			if normalized == label || normalized == buttonWords(label) {
+				return command
+			}
 		}
-		return formatHadithFromAPI(userLang, detail), nil
 	}
-
-	if lastErr != nil {
-		return "", lastErr
+	if cmd, ok := closestCommand(normalized); ok {
+		return cmd
 	}
-	return "", fmt.Errorf("no hadiths found for api language %q", apiLang)
+
+	return normalized
 }
 
-func formatHadithFromAPI(lang string, hadith hadithAPIDetail) string {
-	text := strings.TrimSpace(hadith.Hadeeth)
-	if text == "" {
-		text = tr(lang, "hadith_fallback")
+// splitCommand separates a slash command from its arguments. The command is returned
+// without a trailing @botname suffix; non-command text is returned unchanged.
+func splitCommand(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return text, ""
 	}
-	source := firstNonEmptyTrimmed(hadith.Attribution, hadith.Reference, hadith.Grade)
-	if source != "" {
-		text += " — " + source
+	cmd, args := text, ""
+	if idx := strings.IndexFunc(text, unicode.IsSpace); idx >= 0 {
+		cmd, args = text[:idx], strings.TrimSpace(text[idx:])
 	}
-	return formatHadithBlock(lang, tr(lang, "hadith_day_title"), text)
-}
-
-func firstNonEmptyTrimmed(values ...string) string {
-	for _, value := range values {
-		value = strings.TrimSpace(value)
-		if value != "" {
-			return value
-		}
+	if at := strings.Index(cmd, "@"); at > 0 {
+		cmd = cmd[:at]
 	}
-	return ""
+	return cmd, args
 }
 
-func (b *Bot) fetchHadithCategories(apiLang string) ([]hadithAPICategory, error) {
-	apiLang = strings.ToLower(strings.TrimSpace(apiLang))
-	if apiLang == "" {
-		return nil, fmt.Errorf("hadith API language is empty")
+func (b *Bot) handleMessage(msg *Message) {
+	if b.rateLimited(msg.Chat.ID) {
+		return
 	}
-	if cached, ok := b.getCachedHadithCategories(apiLang); ok {
-		return cached, nil
+	if msg.Location != nil {
+		// A shared location picks the region for the whole group.
+		if !b.messageMayChangeChat(msg) {
+			b.sendGroupAdminOnly(msg.Chat.ID, messageUserID(msg))
+			return
+		}
+		b.handleLocation(msg.Chat.ID, *msg.Location)
+		return
+	}
+	cmd, args := splitCommand(msg.Text)
+	cmd, args = expandCommandAlias(cmd, args)
+	lower := b.resolveCommand(msg.Chat.ID, cmd)
+	// A /start payload sets the group's language and region like /lang and /region do.
+	gated := groupAdminCommands[lower] || (lower == "/start" && args != "")
+	if gated && !b.messageMayChangeChat(msg) {
+		b.sendGroupAdminOnly(msg.Chat.ID, messageUserID(msg))
+		return
+	}
+	switch {
+	case lower == "/start":
+		b.handleStart(msg.Chat.ID, args)
+	case lower == "/mylang":
+		b.handleMyLang(msg, args)
+	case lower == "/lang" || lower == "/language":
+		// "/lang en" switches straight away; anything else opens the keyboard.
+		if lang := normalizeLang(args); lang != "" {
+			b.applyLanguage(msg.Chat.ID, lang, nil)
+		} else {
+			b.promptLanguage(msg.Chat.ID)
+		}
+	case lower == "/menu":
+		b.handleStart(msg.Chat.ID, "")
+	case lower == "/help":
+		if _, ok := b.requireLanguage(msg.Chat.ID); !ok {
+			return
+		}
+		b.sendHelp(msg.Chat.ID)
+	case lower == "/region":
+		if lang, ok := b.requireLanguage(msg.Chat.ID); ok {
+			// "/region Khorug" picks the region directly; no or unknown name opens the keyboard.
+			var matches []string
+			if args != "" {
+				matches = matchRegions(args)
+			}
+			if len(matches) > 0 {
+				b.offerRegions(msg.Chat.ID, lang, matches)
+			} else {
+				b.promptRegion(msg.Chat.ID, tr(lang, "choose_region"))
+			}
+		}
+	case lower == "/theme":
+		if lang, ok := b.requireLanguage(msg.Chat.ID); ok {
+			if _, err := b.sender.SendMessage(msg.Chat.ID, tr(lang, "theme_prompt"), themeKeyboard(lang)); err != nil {
+				log.Printf("theme prompt error: %v", err)
+			}
+		}
+	case lower == "/settings":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.sendSettings(msg.Chat.ID)
+		}
+	case lower == "/calendar":
+		if lang, ok := b.requireLanguage(msg.Chat.ID); ok {
+			if err := b.sendCalendar(msg.Chat.ID); err != nil {
+				b.recoverCard(msg.Chat.ID, lang, err)
+			}
+		}
+	case lower == "/day":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.handleDay(msg.Chat.ID, args)
+		}
+	case lower == "/dua":
+		if lang, ok := b.requireLanguage(msg.Chat.ID); ok {
+			if _, err := b.sender.SendMessage(msg.Chat.ID, tr(lang, "dua_prompt"), duaKeyboard(lang)); err != nil {
+				log.Printf("dua prompt error: %v", err)
+			}
+		}
+	case lower == "/tasbih":
+		if lang, ok := b.requireLanguage(msg.Chat.ID); ok {
+			settings := b.state.Get(msg.Chat.ID)
+			if _, err := b.sender.SendMessage(msg.Chat.ID, tasbihText(lang, *settings), tasbihKeyboard(lang)); err != nil {
+				log.Printf("tasbih send error: %v", err)
+			}
+		}
+	case lower == "/qibla":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.sendQibla(msg.Chat.ID)
+		}
+	case lower == "/today":
+		if lang, ok := b.requireLanguage(msg.Chat.ID); ok {
+			if err := b.sendToday(msg.Chat.ID); err != nil {
+				b.recoverCard(msg.Chat.ID, lang, err)
+			}
+		}
+	case lower == "/hadiths":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.sendHadith(msg.Chat.ID, args)
+		}
+	case lower == "/about":
+		lang := b.userLang(msg.Chat.ID)
+		// Preview the support link, if one is configured.
+		if _, err := b.sender.SendMessageWithPreview(msg.Chat.ID, aboutText(lang, b.supportURL), nil); err != nil {
+			log.Printf("about send error: %v", err)
+		}
+	case lower == "/cachestats" && b.isAdmin(msg.Chat.ID):
+		b.sendCacheStats(msg.Chat.ID)
+	case lower == "/broadcast" && b.isAdmin(msg.Chat.ID):
+		b.handleBroadcast(msg.Chat.ID, args)
+	case lower == "/export" && b.isAdmin(msg.Chat.ID):
+		b.sendStateExport(msg.Chat.ID)
+	case lower == "/zakatfitr":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.sendZakatFitr(msg.Chat.ID, args)
+		}
+	case lower == "/quiet":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.handleQuiet(msg.Chat.ID, args)
+		}
+	case lower == "/digest":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.handleDigest(msg.Chat.ID, args)
+		}
+	case lower == "/countdown":
+		if lang, ok := b.requireLanguage(msg.Chat.ID); ok {
+			if _, err := b.sender.SendMessage(msg.Chat.ID, countdownText(lang, b.startDate(), time.Now().In(b.tz)), nil); err != nil {
+				log.Printf("countdown send error: %v", err)
+			}
+		}
+	case lower == "/progress":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.sendProgress(msg.Chat.ID)
+		}
+	case lower == "/madhab":
+		if lang, ok := b.requireLanguage(msg.Chat.ID); ok {
+			method := b.state.Get(msg.Chat.ID).AsrMethod
+			if _, err := b.sender.SendMessage(msg.Chat.ID, tr(lang, "asr_prompt"), asrKeyboard(lang, method)); err != nil {
+				log.Printf("madhab prompt error: %v", err)
+			}
+		}
+	case lower == "/calendartext":
+		if lang, ok := b.requireLanguage(msg.Chat.ID); ok {
+			region := b.state.Get(msg.Chat.ID).Region
+			if region == "" {
+				region = b.defaultRegion
+			}
+			if schedule, found := b.regionCalendar(region); found {
+				b.sendCalendarText(msg.Chat.ID, lang, region, schedule)
+			} else if _, err := b.sender.SendMessage(msg.Chat.ID, tr(lang, "need_region_first"), nil); err != nil {
+				log.Printf("calendar text need region send error: %v", err)
+			}
+		}
+	case lower == "/pintoday":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.pinToday(msg.Chat.ID)
+		}
+	case lower == "/calendarpdf":
+		if lang, ok := b.requireLanguage(msg.Chat.ID); ok {
+			region := b.state.Get(msg.Chat.ID).Region
+			if region == "" {
+				region = b.defaultRegion
+			}
+			if schedule, found := b.regionCalendar(region); found {
+				b.sendCalendarPDF(msg.Chat.ID, lang, region, schedule)
+			} else if _, err := b.sender.SendMessage(msg.Chat.ID, tr(lang, "need_region_first"), nil); err != nil {
+				log.Printf("calendar pdf need region send error: %v", err)
+			}
+		}
+	case lower == "/textmode":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.toggleTextMode(msg.Chat.ID)
+		}
+	case lower == "/hidemenu" || lower == "/showmenu":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.setMenuHidden(msg.Chat.ID, lower == "/hidemenu")
+		}
+	case lower == "/hadithcard":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.toggleHadithCard(msg.Chat.ID)
+		}
+	case lower == "/ayah":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.sendAyah(msg.Chat.ID)
+		}
+	case lower == "/ayahcard":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.toggleAyahCard(msg.Chat.ID)
+		}
+	case lower == "/tahajjud":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.toggleTahajjud(msg.Chat.ID)
+		}
+	case lower == "/notifyoff":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.setNotifications(msg.Chat.ID, false)
+		}
+	case lower == "/notifyon":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.setNotifications(msg.Chat.ID, true)
+		}
+	case lower == "/mute":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.handleMute(msg.Chat.ID, args)
+		}
+	case lower == "/ics":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.sendICS(msg.Chat.ID)
+		}
+	case lower == "/subscribe":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			reset := strings.EqualFold(args, "reset")
+			// The group's feed is shared, so only admins may revoke it.
+			if reset && !b.messageMayChangeChat(msg) {
+				b.sendGroupAdminOnly(msg.Chat.ID, messageUserID(msg))
+				return
+			}
+			b.handleSubscribe(msg.Chat.ID, reset)
+		}
+	case lower == "/prayers":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.sendPrayers(msg.Chat.ID)
+		}
+	case lower == "/compare":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.handleCompare(msg.Chat.ID, args)
+		}
+	case lower == "/catchup":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.sendCatchup(msg.Chat.ID)
+		}
+	case lower == "/preview":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.sendPreview(msg.Chat.ID)
+		}
+	case lower == "/testnotify":
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.sendTestNotification(msg.Chat.ID, strings.ToLower(args))
+		}
+	default:
+		if b.handleRegionSearch(msg.Chat.ID, msg.Text) {
+			return
+		}
+		if _, ok := b.requireLanguage(msg.Chat.ID); ok {
+			b.sendHelp(msg.Chat.ID)
+		}
 	}
+}
 
-	endpoint := b.hadithAPIEndpoint("/categories/list/", url.Values{
-		"language": {apiLang},
-	})
-	var categories []hadithAPICategory
-	if err := b.getJSON(endpoint, &categories); err != nil {
-		return nil, err
+func (b *Bot) regionCalendar(region string) ([]DayTimes, bool) {
+	return b.calendars.Get(region)
+}
+
+func (b *Bot) startDate() time.Time {
+	b.scheduleMu.RLock()
+	defer b.scheduleMu.RUnlock()
+	return b.ramadanStart
+}
+
+// reloadSchedule re-reads RAMADAN_START, rebuilds the calendars and restarts running
+// reminder loops so a corrected start date applies without a redeploy. A start year
+// without a published timetable is refused and the current schedule kept.
+func (b *Bot) reloadSchedule() {
+	start := resolveRamadanStart(b.tz)
+	year, err := timetableYear(start)
+	if err != nil {
+		log.Printf("Schedule reload refused, keeping Ramadan start %s: %v", b.startDate().Format("2006-01-02"), err)
+		return
 	}
-	b.setCachedHadithCategories(apiLang, categories, 12*time.Hour)
-	return categories, nil
+	calendars := buildCalendars(year)
+
+	b.calendars.Replace(calendars)
+	b.scheduleMu.Lock()
+	old := b.ramadanStart
+	b.ramadanStart = start
+	b.scheduleMu.Unlock()
+
+	b.scheduler.SetStart(start)
+	restarted := b.scheduler.RestartAll()
+	log.Printf("Schedule reloaded: Ramadan start %s -> %s, restarted %d reminder loops", old.Format("2006-01-02"), start.Format("2006-01-02"), restarted)
 }
 
-func (b *Bot) getCachedHadithCategories(apiLang string) ([]hadithAPICategory, bool) {
-	if b == nil {
-		return nil, false
+func (b *Bot) isAdmin(chatID int64) bool {
+	return b.adminIDs[chatID]
+}
+
+func (b *Bot) sendCacheStats(chatID int64) {
+	stats := b.imageCache.Stats()
+	ratio := 0.0
+	if total := stats.Hits + stats.Misses; total > 0 {
+		ratio = float64(stats.Hits) / float64(total) * 100
 	}
-	b.hadithCatsMu.RLock()
-	cached, ok := b.hadithCats[apiLang]
-	b.hadithCatsMu.RUnlock()
-	if !ok || time.Now().After(cached.expiresAt) || len(cached.items) == 0 {
-		return nil, false
+	text := fmt.Sprintf(
+		"Image cache\nhits: %d\nmisses: %d\nhit ratio: %.1f%%\nentries: %d\nsize: %.1f KiB",
+		stats.Hits, stats.Misses, ratio, stats.Entries, float64(stats.Bytes)/1024,
+	)
+	if !stats.SuspendedUntil.IsZero() {
+		text += "\nrendering suspended until " + stats.SuspendedUntil.In(b.tz).Format("15:04:05")
+	}
+	if _, err := b.sender.SendMessage(chatID, text, nil); err != nil {
+		log.Printf("cache stats send error: %v", err)
 	}
-	return append([]hadithAPICategory(nil), cached.items...), true
 }
 
-func (b *Bot) setCachedHadithCategories(apiLang string, categories []hadithAPICategory, ttl time.Duration) {
-	if b == nil || len(categories) == 0 || ttl <= 0 {
+// sendStateExport sends the user state as a state.json document for backups.
+func (b *Bot) sendStateExport(chatID int64) {
+	raw, err := b.state.Export()
+	if err != nil {
+		log.Printf("state export error: %v", err)
+		if _, err := b.sender.SendMessage(chatID, "State export failed: "+err.Error(), nil); err != nil {
+			log.Printf("state export failure send error: %v", err)
+		}
 		return
 	}
-	b.hadithCatsMu.Lock()
-	if b.hadithCats == nil {
-		b.hadithCats = make(map[string]cachedHadithCategories)
+	now := time.Now().In(b.tz)
+	caption := fmt.Sprintf("State backup: %d users, %s", len(b.state.AllChatIDs()), now.Format("2006-01-02 15:04"))
+	if _, err := b.sender.SendDocument(chatID, raw, "state.json", caption); err != nil {
+		log.Printf("state export send error: %v", err)
 	}
-	b.hadithCats[apiLang] = cachedHadithCategories{
-		items:     append([]hadithAPICategory(nil), categories...),
-		expiresAt: time.Now().Add(ttl),
+}
+
+// reachableChatIDs lists known chats except those that blocked the bot.
+func (b *Bot) reachableChatIDs() []int64 {
+	all := b.state.AllChatIDs()
+	ids := all[:0]
+	for _, chatID := range all {
+		if settings, ok := b.state.Lookup(chatID); ok && settings.Blocked {
+			continue
+		}
+		ids = append(ids, chatID)
 	}
-	b.hadithCatsMu.Unlock()
+	return ids
 }
 
-func (b *Bot) fetchHadithList(apiLang, categoryID string) ([]hadithAPIListItem, error) {
-	endpoint := b.hadithAPIEndpoint("/hadeeths/list/", url.Values{
-		"language":    {apiLang},
-		"category_id": {categoryID},
-		"page":        {"1"},
-		"per_page":    {"20"},
-	})
-	var resp hadithAPIListResp
-	if err := b.getJSON(endpoint, &resp); err != nil {
-		return nil, err
+func (b *Bot) markBlocked(chatID int64) {
+	b.scheduler.Stop(chatID)
+	b.state.SetBlocked(chatID, true)
+}
+
+// reactivate clears the blocked flag once a chat talks to the bot again and resumes its
+// reminders if they were on.
+func (b *Bot) reactivate(chatID int64) {
+	settings, ok := b.state.Lookup(chatID)
+	if !ok || !settings.Blocked {
+		return
+	}
+	b.state.SetBlocked(chatID, false)
+	if settings.Notifications && strings.TrimSpace(settings.Region) != "" {
+		b.scheduler.Start(chatID, settings.Region)
 	}
-	return resp.Data, nil
 }
 
-func (b *Bot) fetchHadithDetail(apiLang, hadithID string) (hadithAPIDetail, error) {
-	endpoint := b.hadithAPIEndpoint("/hadeeths/one/", url.Values{
-		"language": {apiLang},
-		"id":       {hadithID},
-	})
-	var detail hadithAPIDetail
-	if err := b.getJSON(endpoint, &detail); err != nil {
-		return hadithAPIDetail{}, err
+// broadcastRate keeps broadcasts below Telegram's ~30 messages per second bot limit.
+const broadcastRate = 25
+
+type broadcastResult struct {
+	Sent    int
+	Failed  int
+	Blocked int
+}
+
+func (b *Bot) handleBroadcast(chatID int64, text string) {
+	if text == "" {
+		if _, err := b.sender.SendMessage(chatID, "Usage: /broadcast <text>", nil); err != nil {
+			log.Printf("broadcast usage send error: %v", err)
+		}
+		return
 	}
-	return detail, nil
+	go func() {
+		result := b.broadcast(b.reachableChatIDs(), text)
+		report := fmt.Sprintf("Broadcast finished\nsent: %d\nfailed: %d\nblocked: %d", result.Sent, result.Failed, result.Blocked)
+		if _, err := b.sender.SendMessage(chatID, report, nil); err != nil {
+			log.Printf("broadcast report send error: %v", err)
+		}
+	}()
 }
 
-func (b *Bot) hadithAPIEndpoint(path string, query url.Values) string {
-	base := strings.TrimRight(strings.TrimSpace(b.hadithAPIURL), "/")
-	if base == "" {
-		base = "https://api.hadeethenc.com/api/v1"
+// broadcast sends text to every chat, pacing requests with a ticker.
+func (b *Bot) broadcast(chatIDs []int64, text string) broadcastResult {
+	var result broadcastResult
+	ticker := time.NewTicker(time.Second / broadcastRate)
+	defer ticker.Stop()
+
+	for i, chatID := range chatIDs {
+		if i > 0 {
+			<-ticker.C
+		}
+		_, err := b.sender.SendMessage(chatID, text, nil)
+		switch {
+		case err == nil:
+			result.Sent++
+		case errors.Is(err, ErrBotBlocked):
+			result.Blocked++
+			b.markBlocked(chatID)
+		default:
+			result.Failed++
+			log.Printf("broadcast send error for chat %d: %v", chatID, err)
+		}
 	}
-	path = "/" + strings.TrimLeft(strings.TrimSpace(path), "/")
-	if len(query) == 0 {
-		return base + path
+	log.Printf("Broadcast sent to %d chats (%d failed, %d blocked)", result.Sent, result.Failed, result.Blocked)
+	return result
+}
+
+func (b *Bot) userLang(chatID int64) string {
+	lang := normalizeLang(b.state.Get(chatID).Language)
+	if lang == "" {
+		return fallbackLang()
 	}
-	return base + path + "?" + query.Encode()
+	return lang
 }
 
-func (b *Bot) getJSON(rawURL string, target interface{}) error {
-	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
-	if err != nil {
-		return err
+// memberLang is the language for a notice addressed only to userID, such as the
+// admin-only refusal: their own choice in a group (/mylang) when they made one,
+// otherwise the chat's language. Ordinary group replies stay in the group's language.
+func (b *Bot) memberLang(chatID, userID int64) string {
+	if chatID < 0 && userID != 0 {
+		if member, ok := b.state.Member(chatID, userID); ok && member.Language != "" {
+			return member.Language
+		}
 	}
-	resp, err := b.client.Do(req)
-	if err != nil {
-		return err
+	return b.userLang(chatID)
+}
+
+// handleMyLang sets the caller's own language. In groups it only affects inline results
+// and notices meant for them, leaving the group's language alone; in private chats it
+// is /lang.
+func (b *Bot) handleMyLang(msg *Message, args string) {
+	chatID, userID := msg.Chat.ID, messageUserID(msg)
+	if chatID > 0 || userID == 0 {
+		if lang := normalizeLang(args); lang != "" {
+			b.applyLanguage(chatID, lang, nil)
+		} else {
+			b.promptLanguage(chatID)
+		}
+		return
 	}
-	defer resp.Body.Close()
+	var text string
+	switch lang := normalizeLang(args); {
+	case lang != "":
+		b.state.SetMemberLanguage(chatID, userID, lang)
+		text = tr(lang, "mylang_set")
+	case strings.EqualFold(strings.TrimSpace(args), "off"):
+		b.state.SetMemberLanguage(chatID, userID, "")
+		text = tr(b.userLang(chatID), "mylang_off")
+	default:
+		text = tr(b.memberLang(chatID, userID), "mylang_usage")
+	}
+	if _, err := b.sender.SendMessage(chatID, text, nil); err != nil {
+		log.Printf("member language send error: %v", err)
+	}
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
-		return fmt.Errorf("request %s failed with %d: %s", rawURL, resp.StatusCode, strings.TrimSpace(string(raw)))
+func (b *Bot) renderOptionsFor(chatID int64) renderOptions {
+	return renderOptions{Theme: themeByName(b.state.Get(chatID).Theme).Name}
+}
+
+func (b *Bot) requireLanguage(chatID int64) (string, bool) {
+	settings := b.state.Get(chatID)
+	lang := normalizeLang(settings.Language)
+	if lang == "" && defaultLang != "" {
+		// With DEFAULT_LANG configured, new chats start in it; /lang still switches.
+		return defaultLang, true
+	}
+	if lang == "" {
+		b.promptLanguage(chatID)
+		return "", false
+	}
+	if lang != settings.Language {
+		b.state.SetLanguage(chatID, lang)
+	}
+	return lang, true
+}
+
+// applyLanguage saves lang for the chat, confirms it (editing msg when set) and moves
+// on to the region prompt or the help text.
+func (b *Bot) applyLanguage(chatID int64, lang string, msg *Message) {
+	b.state.SetLanguage(chatID, lang)
+	if err := b.editOrSend(chatID, msg, tr(lang, "language_saved"), nil); err != nil {
+		log.Printf("confirm language error: %v", err)
+	}
+	if strings.TrimSpace(b.state.Get(chatID).Region) == "" {
+		b.promptRegion(chatID, tr(lang, "choose_region"))
+	} else {
+		b.sendHelp(chatID)
+	}
+}
+
+func (b *Bot) promptLanguage(chatID int64) {
+	if _, err := b.sender.SendMessage(chatID, tr(b.userLang(chatID), "choose_language"), b.languageKeyboard()); err != nil {
+		log.Printf("prompt language error: %v", err)
+	}
+}
+
+// parseStartPayload decodes deep-link payloads such as "region_khorug_lang_en".
+// Unknown keys and values are ignored so a malformed link still opens the bot normally.
+func parseStartPayload(payload string) (region string, lang string) {
+	parts := strings.Split(strings.TrimSpace(payload), "_")
+	for i := 0; i+1 < len(parts); i += 2 {
+		value := strings.ToLower(parts[i+1])
+		switch strings.ToLower(parts[i]) {
+		case "region":
+			if name, ok := regionSlugs[value]; ok {
+				region = name
+			}
+		case "lang":
+			lang = normalizeLang(value)
+		}
+	}
+	return region, lang
+}
+
+func (b *Bot) handleStart(chatID int64, payload string) {
+	if region, lang := parseStartPayload(payload); region != "" || lang != "" {
+		if lang != "" {
+			b.state.SetLanguage(chatID, lang)
+		}
+		if region != "" {
+			b.state.SetRegion(chatID, region)
+			b.scheduler.Start(chatID, region)
+		}
+	}
+
+	settings := b.state.Get(chatID)
+	lang := normalizeLang(settings.Language)
+	if lang == "" {
+		b.promptLanguage(chatID)
+		return
+	}
+	if _, err := b.sender.SendMessage(chatID, tr(lang, "welcome"), b.replyMenu(chatID, lang)); err != nil {
+		log.Printf("send welcome error: %v", err)
+	}
+	if strings.TrimSpace(settings.Region) == "" {
+		b.promptRegion(chatID, tr(lang, "choose_region"))
+		return
+	}
+}
+
+func (b *Bot) handleLocation(chatID int64, loc Location) {
+	lang, ok := b.requireLanguage(chatID)
+	if !ok {
+		return
+	}
+	region, distKm, ok := nearestRegion(loc.Latitude, loc.Longitude)
+	if !ok {
+		b.promptRegion(chatID, tr(lang, "choose_region"))
+		return
+	}
+	if distKm > maxRegionDistanceKm {
+		b.promptRegion(chatID, tr(lang, "location_too_far"))
+		return
+	}
+	b.state.SetRegion(chatID, region)
+	name := regionDisplayName(region, lang)
+	text := trf(lang, "location_region", name) + "\n" + trf(lang, "region_selected", name)
+	if _, err := b.sender.SendMessage(chatID, text, b.replyMenu(chatID, lang)); err != nil {
+		log.Printf("confirm location region error: %v", err)
+	}
+	b.scheduler.Start(chatID, region)
+}
+
+func (b *Bot) promptRegion(chatID int64, message string) {
+	lang := b.userLang(chatID)
+	if strings.TrimSpace(message) == "" {
+		message = tr(lang, "choose_region")
+	}
+	if _, err := b.sender.SendMessage(chatID, message, b.regionKeyboard(lang)); err != nil {
+		log.Printf("prompt region error: %v", err)
+	}
+}
+
+func (b *Bot) handleCallback(cb *CallbackQuery) {
+	if cb.Data == "" {
+		return
+	}
+	if err := b.sender.AnswerCallback(cb.ID); err != nil {
+		log.Printf("answerCallback error: %v", err)
+	}
+
+	chatID := cb.From.ID
+	if cb.Message != nil {
+		chatID = cb.Message.Chat.ID
+	}
+	// Tasbih taps only edit the counter message in place; counting them against the
+	// chat's budget would cut a dhikr short after a handful of taps.
+	if !strings.HasPrefix(cb.Data, "tasbih:") && b.rateLimited(chatID) {
+		return
+	}
+	for _, prefix := range groupAdminCallbacks {
+		if strings.HasPrefix(cb.Data, prefix) && !b.mayChangeChat(chatID, cb.From.ID) {
+			b.sendGroupAdminOnly(chatID, cb.From.ID)
+			return
+		}
+	}
+	if strings.HasPrefix(cb.Data, "lang:") {
+		lang := normalizeLang(strings.TrimPrefix(cb.Data, "lang:"))
+		if lang == "" {
+			lang = fallbackLang()
+		}
+		b.applyLanguage(chatID, lang, cb.Message)
+		return
+	}
+
+	if strings.HasPrefix(cb.Data, "regionpage:") {
+		lang, ok := b.requireLanguage(chatID)
+		if !ok || cb.Message == nil {
+			return
+		}
+		page, err := strconv.Atoi(strings.TrimPrefix(cb.Data, "regionpage:"))
+		if err != nil {
+			return
+		}
+		markup := regionKeyboardPage(b.orderedRegions(lang), lang, page, regionsPerPage, regionKeyboardColumns)
+		if err := b.sender.EditMessageReplyMarkup(chatID, cb.Message.MessageID, markup); err != nil {
+			log.Printf("region page edit error: %v", err)
+		}
+		return
+	}
+
+	if strings.HasPrefix(cb.Data, "region:") {
+		lang, ok := b.requireLanguage(chatID)
+		if !ok {
+			return
+		}
+		b.selectRegion(chatID, lang, cb.Message, strings.TrimPrefix(cb.Data, "region:"))
+		return
+	}
+
+	if strings.HasPrefix(cb.Data, "day:") {
+		if _, ok := b.requireLanguage(chatID); !ok {
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(cb.Data, "day:"))
+		if err != nil || n < 1 || n > ramadanDays {
+			return
+		}
+		b.sendDay(chatID, cb.Message, n)
+		return
+	}
+
+	if strings.HasPrefix(cb.Data, "tasbih:") {
+		if _, ok := b.requireLanguage(chatID); ok {
+			b.handleTasbih(chatID, cb.Message, strings.TrimPrefix(cb.Data, "tasbih:"))
+		}
+		return
+	}
+
+	if strings.HasPrefix(cb.Data, "dua:") {
+		if _, ok := b.requireLanguage(chatID); ok {
+			b.sendDua(chatID, strings.TrimPrefix(cb.Data, "dua:"))
+		}
+		return
+	}
+
+	if strings.HasPrefix(cb.Data, "theme:") {
+		lang, ok := b.requireLanguage(chatID)
+		if !ok {
+			return
+		}
+		theme := themeByName(strings.TrimPrefix(cb.Data, "theme:"))
+		b.state.SetTheme(chatID, theme.Name)
+		if err := b.editOrSend(chatID, cb.Message, trf(lang, "theme_saved", themeDisplayName(lang, theme.Name)), nil); err != nil {
+			log.Printf("confirm theme error: %v", err)
+		}
+		return
+	}
+
+	if strings.HasPrefix(cb.Data, "fasted:") {
+		if _, ok := b.requireLanguage(chatID); !ok {
+			return
+		}
+		if day, err := strconv.Atoi(strings.TrimPrefix(cb.Data, "fasted:")); err == nil {
+			b.confirmFast(chatID, day)
+		}
+		return
+	}
+
+	if strings.HasPrefix(cb.Data, "madhab:") {
+		lang, ok := b.requireLanguage(chatID)
+		if !ok {
+			return
+		}
+		method := asrHanafi
+		if strings.TrimPrefix(cb.Data, "madhab:") == asrStandard {
+			method = asrStandard
+		}
+		b.state.SetAsrMethod(chatID, method)
+		if err := b.editOrSend(chatID, cb.Message, tr(lang, "asr_saved_"+method), nil); err != nil {
+			log.Printf("confirm madhab error: %v", err)
+		}
+		// Restart the loop so today's asr reminder moves to the new time.
+		if settings := b.state.Get(chatID); settings.Notifications && settings.Region != "" {
+			b.scheduler.Start(chatID, settings.Region)
+		}
+		return
+	}
+
+	if strings.HasPrefix(cb.Data, "settings:") {
+		lang, ok := b.requireLanguage(chatID)
+		if !ok {
+			return
+		}
+		switch strings.TrimPrefix(cb.Data, "settings:") {
+		case "lang":
+			b.promptLanguage(chatID)
+		case "region":
+			b.promptRegion(chatID, tr(lang, "choose_region"))
+		case "notify:on":
+			b.setNotifications(chatID, true)
+		case "notify:off":
+			b.setNotifications(chatID, false)
+		}
+		return
+	}
+}
+
+func (b *Bot) sendHelp(chatID int64) {
+	lang := b.userLang(chatID)
+	if _, err := b.sender.SendMessage(chatID, tr(lang, "help"), b.replyMenu(chatID, lang)); err != nil {
+		log.Printf("help send error: %v", err)
+	}
+}
+
+func (b *Bot) sendSettings(chatID int64) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+
+	region := regionDisplayName(strings.TrimSpace(settings.Region), lang)
+	if region == "" {
+		region = tr(lang, "settings_region_none")
+	}
+	// The summary and the toggle button both follow settings.Notifications so they
+	// never disagree about the current state.
+	notifications := tr(lang, "settings_off")
+	if settings.Notifications {
+		notifications = tr(lang, "settings_on")
+	}
+
+	lines := []string{
+		tr(lang, "settings_title"),
+		"",
+		trf(lang, "settings_language", languageDisplayName(lang)),
+		trf(lang, "settings_region", region),
+		trf(lang, "settings_notifications", notifications),
+		trf(lang, "settings_theme", themeDisplayName(lang, settings.Theme)),
+	}
+	if _, err := b.sender.SendMessage(chatID, strings.Join(lines, "\n"), b.settingsKeyboard(lang, settings.Notifications)); err != nil {
+		log.Printf("settings send error: %v", err)
+	}
+}
+
+// cardError reports that a card image could not be built or sent. Handlers pass it to
+// recoverCard so the user still gets an answer instead of silence.
+type cardError struct {
+	what string
+	err  error
+	// text sends the same content without the image.
+	text func() (*Message, error)
+}
+
+func (e *cardError) Error() string {
+	return e.what + ": " + e.err.Error()
+}
+
+func (e *cardError) Unwrap() error {
+	return e.err
+}
+
+// recoverCard answers a failed card with its text version, or with a localized "try
+// again" note when there is none or it cannot be sent either. It returns the message
+// that went out in place of the card. Chats that blocked the bot are left alone.
+func (b *Bot) recoverCard(chatID int64, lang string, err error) *Message {
+	if !errors.Is(err, errRenderingSuspended) {
+		log.Printf("card error for chat %d: %v", chatID, err)
+	}
+	if errors.Is(err, ErrBotBlocked) {
+		return nil
+	}
+	var ce *cardError
+	if errors.As(err, &ce) && ce.text != nil {
+		sent, textErr := ce.text()
+		if textErr == nil {
+			return sent
+		}
+		log.Printf("card text fallback error for chat %d: %v", chatID, textErr)
+	}
+	if _, err := b.sender.SendMessage(chatID, tr(lang, "card_failed"), nil); err != nil {
+		log.Printf("card failure notice error: %v", err)
+	}
+	return nil
+}
+
+// sendCalendar sends the Ramadan calendar card. It returns a *cardError when the card
+// could not be built or sent; everything else is answered here.
+func (b *Bot) sendCalendar(chatID int64) error {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	region := settings.Region
+	if region == "" {
+		region = b.defaultRegion
+	}
+	schedule, ok := b.regionCalendar(region)
+	if !ok {
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "need_region_first"), nil); err != nil {
+			log.Printf("calendar need region send error: %v", err)
+		}
+		return nil
+	}
+	if settings.ImagesDisabled {
+		b.sendCalendarText(chatID, lang, region, schedule)
+		return nil
+	}
+	text := func() (*Message, error) {
+		return b.sender.SendMessageWithMode(chatID, formatCalendarText(lang, region, schedule), nil, "HTML")
+	}
+
+	opts := b.hadithCardOptions(chatID, lang)
+	photo, err := b.cachedCalendarImage(lang, opts, region, schedule)
+	if err != nil {
+		return &cardError{what: "calendar image build", err: err, text: text}
+	}
+	hadith := opts.Hadith
+	if hadith.Text == "" {
+		hadith = b.randomHadith(lang)
+	}
+	caption := trf(
+		lang,
+		"calendar_caption",
+		regionDisplayName(region, lang),
+		formatHadithBlock(lang, tr(lang, "hadith_day_title"), hadith),
+	)
+	if _, err := b.sender.SendPhoto(chatID, photo, caption); err != nil {
+		return &cardError{what: "calendar photo send", err: err, text: text}
+	}
+	return nil
+}
+
+// sendCalendarText sends the schedule as a monospace table instead of an image.
+func (b *Bot) sendCalendarText(chatID int64, lang, region string, schedule []DayTimes) {
+	text := formatCalendarText(lang, region, schedule)
+	if _, err := b.sender.SendMessageWithMode(chatID, text, nil, "HTML"); err != nil {
+		log.Printf("calendar text send error: %v", err)
+	}
+}
+
+// sendCalendarPDF sends the schedule as a printable PDF document.
+func (b *Bot) sendCalendarPDF(chatID int64, lang, region string, schedule []DayTimes) {
+	doc, err := renderCalendarPDF(schedule, b.startDate(), region, lang)
+	if err != nil {
+		log.Printf("calendar pdf render error: %v", err)
+		b.sendCalendarText(chatID, lang, region, schedule)
+		return
+	}
+	filename := fmt.Sprintf("ramadan-%s-%d.pdf", region, b.startDate().Year())
+	caption := trf(lang, "calendar_pdf_caption", regionDisplayName(region, lang))
+	if _, err := b.sender.SendDocument(chatID, doc, filename, caption); err != nil {
+		log.Printf("calendar pdf send error: %v", err)
+	}
+}
+
+func (b *Bot) sendICS(chatID int64) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	if settings.Region == "" {
+		b.promptRegion(chatID, tr(lang, "need_region_first"))
+		return
+	}
+	doc, ok := b.calendarFeed(*settings, lang)
+	if !ok {
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "calendar_not_found"), nil); err != nil {
+			log.Printf("ics calendar missing send error: %v", err)
+		}
+		return
+	}
+	filename := fmt.Sprintf("ramadan-%s-%d.ics", settings.Region, b.startDate().Year())
+	caption := trf(lang, "ics_caption", regionDisplayName(settings.Region, lang))
+	if _, err := b.sender.SendDocument(chatID, doc, filename, caption); err != nil {
+		log.Printf("ics send error: %v", err)
+	}
+}
+
+// calendarFeed renders the ICS file for a chat's region and asr method; ok is false when
+// the region has no calendar.
+func (b *Bot) calendarFeed(settings UserSettings, lang string) ([]byte, bool) {
+	cal, ok := b.regionCalendar(settings.Region)
+	if !ok || len(cal) == 0 {
+		return nil, false
+	}
+	schedule := make([]DayTimes, len(cal))
+	for i, day := range cal {
+		schedule[i] = day.withAsrMethod(settings.AsrMethod)
+	}
+	return renderCalendarICS(schedule, b.startDate(), settings.Region, lang, b.tz, time.Now()), true
+}
+
+// handleSubscribe replies with the chat's webcal:// feed URL; with reset it first
+// replaces the token, revoking the old address. The feed is served by the HEALTH_ADDR
+// server, so both it and PUBLIC_URL must be configured.
+func (b *Bot) handleSubscribe(chatID int64, reset bool) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	if b.publicURL == "" {
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "subscribe_unavailable"), nil); err != nil {
+			log.Printf("subscribe unavailable send error: %v", err)
+		}
+		return
+	}
+	if settings.Region == "" {
+		b.promptRegion(chatID, tr(lang, "need_region_first"))
+		return
+	}
+	token, key := b.state.EnsureCalendarToken(chatID), "subscribe_link"
+	if reset {
+		token, key = b.state.ResetCalendarToken(chatID), "subscribe_reset"
+	}
+	if token == "" {
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "subscribe_unavailable"), nil); err != nil {
+			log.Printf("subscribe unavailable send error: %v", err)
+		}
+		return
+	}
+	feed := calendarFeedURL(b.publicURL, token)
+	if _, err := b.sender.SendMessageWithPreview(chatID, trf(lang, key, feed), nil); err != nil {
+		log.Printf("subscribe send error: %v", err)
+	}
+}
+
+// calendarFeedURL turns the public base URL into the webcal:// address calendar apps
+// subscribe to.
+func calendarFeedURL(base, token string) string {
+	feed := base + "/calendar/" + token + ".ics"
+	if rest, ok := strings.CutPrefix(feed, "https://"); ok {
+		return "webcal://" + rest
+	}
+	if rest, ok := strings.CutPrefix(feed, "http://"); ok {
+		return "webcal://" + rest
+	}
+	return feed
+}
+
+// formatCalendarText lays out the Ramadan days as an HTML <pre> table for text mode.
+func formatCalendarText(lang, region string, schedule []DayTimes) string {
+	headers := []string{tr(lang, "img_col_date"), tr(lang, "img_col_day"), tr(lang, "img_col_suhoor"), tr(lang, "img_col_iftar")}
+	rows := [][]string{headers}
+	for _, day := range schedule {
+		if day.Day < 1 {
+			continue
+		}
+		rows = append(rows, []string{day.Data, fmt.Sprintf("%02d", day.Day), minutesToClock(day.SuhoorEnd), minutesToClock(day.Maghrib)})
+	}
+	widths := make([]int, len(headers))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("<b>" + html.EscapeString(trf(lang, "calendar_text_title", regionDisplayName(region, lang))) + "</b>\n<pre>")
+	for _, row := range rows {
+		for i, cell := range row {
+			b.WriteString(html.EscapeString(cell))
+			if i < len(row)-1 {
+				b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+2))
+			}
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("</pre>")
+	return b.String()
+}
+
+func (b *Bot) toggleTextMode(chatID int64) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	disabled := !settings.ImagesDisabled
+	b.state.SetImagesDisabled(chatID, disabled)
+	key := "textmode_off"
+	if disabled {
+		key = "textmode_on"
+	}
+	if _, err := b.sender.SendMessage(chatID, tr(lang, key), nil); err != nil {
+		log.Printf("text mode toggle send error: %v", err)
+	}
+}
+
+// sendToday sends today's card; like sendCalendar it returns a *cardError when the
+// card could not be built or sent.
+func (b *Bot) sendToday(chatID int64) error {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	if settings.Region == "" {
+		b.promptRegion(chatID, tr(lang, "need_region_first"))
+		return nil
+	}
+	_, err := b.postToday(chatID, lang, settings.Region)
+	return err
+}
+
+// postToday sends today's timetable for region and returns the message. The message is
+// nil when there is no timetable to show (it then explains why) or sending failed; a
+// failed card is returned as a *cardError.
+func (b *Bot) postToday(chatID int64, lang, region string) (*Message, error) {
+	settings := b.state.Get(chatID)
+	cal, ok := b.regionCalendar(region)
+	if !ok || len(cal) == 0 {
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "calendar_not_found"), nil); err != nil {
+			log.Printf("today calendar missing send error: %v", err)
+		}
+		return nil, nil
+	}
+	day := currentDaySchedule(cal, b.startDate(), b.tz)
+	if day == nil {
+		if _, err := b.sender.SendMessage(chatID, outOfRangeText(lang, "out_of_range", b.startDate()), nil); err != nil {
+			log.Printf("today range send error: %v", err)
+		}
+		return nil, nil
+	}
+	text := func() (*Message, error) {
+		text := formatDayTimetable(lang, region, day.withAsrMethod(settings.AsrMethod)) + "\n\n" +
+			formatHadithBlock(lang, tr(lang, "hadith_day_title"), b.randomHadith(lang))
+		return b.sender.SendMessage(chatID, text, fastedMarkup(lang, day.Day))
+	}
+	if settings.ImagesDisabled {
+		sent, err := text()
+		if err != nil {
+			log.Printf("today text send error: %v", err)
+		}
+		return sent, nil
+	}
+
+	opts := b.hadithCardOptions(chatID, lang)
+	photo, err := b.cachedTodayImage(lang, opts, region, *day)
+	if err != nil {
+		return nil, &cardError{what: "today image build", err: err, text: text}
+	}
+	hadith := opts.Hadith
+	if hadith.Text == "" {
+		hadith = b.randomHadith(lang)
+	}
+	caption := trf(
+		lang,
+		"today_caption",
+		regionDisplayName(region, lang),
+		day.Data,
+		dayHijriDate(lang, *day),
+		dayLabel(lang, day.Day),
+		formatHadithBlock(lang, tr(lang, "hadith_day_title"), hadith),
+	)
+	sent, err := b.sender.SendPhotoWithMarkup(chatID, photo, caption, fastedMarkup(lang, day.Day))
+	if err != nil {
+		return nil, &cardError{what: "today photo send", err: err, text: text}
+	}
+	return sent, nil
+}
+
+// pinToday posts today's timetable and pins it so members of a group find it at the top
+// of the chat. Until the chat picks a region the default region is used.
+func (b *Bot) pinToday(chatID int64) {
+	lang := b.userLang(chatID)
+	region := b.state.Get(chatID).Region
+	if region == "" {
+		region = b.defaultRegion
+	}
+	sent, err := b.postToday(chatID, lang, region)
+	if err != nil {
+		sent = b.recoverCard(chatID, lang, err)
+	}
+	if sent == nil {
+		return
+	}
+	err = b.sender.PinChatMessage(chatID, sent.MessageID)
+	var apiErr *TelegramError
+	switch {
+	case err == nil:
+	case errors.As(err, &apiErr) && apiErr.IsMissingRights():
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "pin_no_rights"), nil); err != nil {
+			log.Printf("pin rights send error: %v", err)
+		}
+	default:
+		log.Printf("pin timetable error for chat %d: %v", chatID, err)
+	}
+}
+
+// handleMyChatMember reacts to the bot's own membership changes. When it is added to a
+// group it posts and pins today's timetable; when removed it stops the group's reminders.
+func (b *Bot) handleMyChatMember(upd *ChatMemberUpdated) {
+	chatID := upd.Chat.ID
+	was, now := upd.OldChatMember.Status, upd.NewChatMember.Status
+	present := func(status string) bool {
+		return status == "member" || status == "administrator" || status == "creator"
+	}
+	switch {
+	case present(now) && !present(was):
+		if upd.Chat.Type != "group" && upd.Chat.Type != "supergroup" {
+			return
+		}
+		log.Printf("added to group %d", chatID)
+		b.reactivate(chatID)
+		if normalizeLang(b.state.Get(chatID).Language) == "" {
+			if lang := normalizeLang(upd.From.LanguageCode); lang != "" {
+				b.state.SetLanguage(chatID, lang)
+			}
+		}
+		b.pinToday(chatID)
+	case present(was) && !present(now):
+		log.Printf("removed from chat %d (%s)", chatID, now)
+		b.scheduler.Stop(chatID)
+		b.state.SetBlocked(chatID, true)
+	}
+}
+
+// countdownText describes where now falls relative to the Ramadan starting at start:
+// the days and hours left, the current day, or that it has ended.
+func countdownText(lang string, start, now time.Time) string {
+	now = now.In(start.Location())
+	if now.Before(start) {
+		left := start.Sub(now)
+		hours := int(math.Ceil(left.Hours()))
+		return trf(lang, "countdown_before", hours/24, hours%24)
+	}
+	day := int(now.Sub(start).Hours()/24) + 1
+	if day <= ramadanDays {
+		return trf(lang, "countdown_ongoing", day, ramadanDays)
+	}
+	return tr(lang, "countdown_ended")
+}
+
+// fastedMarkup is the "I fasted today" button for messages about day, or nil on the
+// eve of Ramadan when there is no fast to confirm yet.
+func fastedMarkup(lang string, day int) interface{} {
+	if day < 1 {
+		return nil
+	}
+	return fastedKeyboard(lang, day)
+}
+
+// fastedKeyboard carries the "I fasted today" button for Ramadan day.
+func fastedKeyboard(lang string, day int) InlineKeyboardMarkup {
+	return InlineKeyboardMarkup{
+		InlineKeyboard: [][]InlineKeyboardButton{
+			{{Text: tr(lang, "btn_fasted"), CallbackData: fmt.Sprintf("fasted:%d", day)}},
+		},
+	}
+}
+
+// mutedUntil is MutedUntil with nil as the zero time.
+func (u UserSettings) mutedUntil() time.Time {
+	if u.MutedUntil == nil {
+		return time.Time{}
+	}
+	return *u.MutedUntil
+}
+
+// fastConfirmed reports whether day is already counted.
+func (u UserSettings) fastConfirmed(day int) bool {
+	return slices.Contains(u.FastDays, day)
+}
+
+// progressText summarises the chat's fasting for /progress. today is the current
+// Ramadan day, or 0 outside Ramadan.
+func progressText(lang string, today int, settings UserSettings, season string) string {
+	count, streak := settings.FastCount, settings.FastStreak
+	if settings.FastSeason != season {
+		count, streak = 0, 0
+	}
+	if today > 0 {
+		return trf(lang, "progress_text", today, ramadanDays, count, streak)
+	}
+	return trf(lang, "progress_outside", count, ramadanDays)
+}
+
+func (b *Bot) sendProgress(chatID int64) {
+	lang := b.userLang(chatID)
+	settings := b.state.Get(chatID)
+	today := 0
+	if cal, ok := b.regionCalendar(settings.Region); ok {
+		if day := currentDaySchedule(cal, b.startDate(), b.tz); day != nil {
+			today = day.Day
+		}
+	}
+	season := b.startDate().Format("2006-01-02")
+	var markup interface{}
+	if confirmed := settings.FastSeason == season && settings.fastConfirmed(today); today > 0 && !confirmed {
+		markup = fastedKeyboard(lang, today)
+	}
+	if _, err := b.sender.SendMessage(chatID, progressText(lang, today, *settings, season), markup); err != nil {
+		log.Printf("progress send error: %v", err)
+	}
+}
+
+// confirmFast handles the "I fasted today" button. Buttons for days that have not
+// started yet are ignored; older ones still count if they were never confirmed.
+func (b *Bot) confirmFast(chatID int64, day int) {
+	lang := b.userLang(chatID)
+	region := b.state.Get(chatID).Region
+	if region == "" {
+		region = b.defaultRegion
+	}
+	cal, _ := b.regionCalendar(region)
+	today := currentDaySchedule(cal, b.startDate(), b.tz)
+	if today == nil || day < 1 || day > today.Day {
+		return
+	}
+	season := b.startDate().Format("2006-01-02")
+	settings, counted := b.state.ConfirmFast(chatID, season, day)
+	text := tr(lang, "fast_already")
+	if counted {
+		text = tr(lang, "fast_confirmed") + "\n" + progressText(lang, today.Day, settings, season)
+	}
+	if _, err := b.sender.SendMessage(chatID, text, nil); err != nil {
+		log.Printf("fast confirm send error: %v", err)
+	}
+}
+
+// tasbihPresets are the targets offered on the tasbih keyboard.
+var tasbihPresets = []int{33, 99}
+
+func tasbihText(lang string, settings UserSettings) string {
+	text := trf(lang, "tasbih_count", settings.TasbihCount)
+	if settings.TasbihTarget > 0 {
+		text += "\n" + trf(lang, "tasbih_target", settings.TasbihTarget)
+		if settings.TasbihCount >= settings.TasbihTarget {
+			text += "\n" + tr(lang, "tasbih_done")
+		}
+	}
+	return text
+}
+
+func tasbihKeyboard(lang string) InlineKeyboardMarkup {
+	presets := make([]InlineKeyboardButton, 0, len(tasbihPresets)+1)
+	for _, preset := range tasbihPresets {
+		presets = append(presets, InlineKeyboardButton{Text: strconv.Itoa(preset), CallbackData: fmt.Sprintf("tasbih:%d", preset)})
+	}
+	presets = append(presets, InlineKeyboardButton{Text: tr(lang, "btn_tasbih_reset"), CallbackData: "tasbih:reset"})
+	return InlineKeyboardMarkup{
+		InlineKeyboard: [][]InlineKeyboardButton{
+			{{Text: "+1", CallbackData: "tasbih:inc"}},
+			presets,
+		},
+	}
+}
+
+// handleTasbih applies a tasbih button press: "inc", "reset", or a preset target, which
+// also restarts the count.
+func (b *Bot) handleTasbih(chatID int64, msg *Message, action string) {
+	lang := b.userLang(chatID)
+	before := *b.state.Get(chatID)
+	var after UserSettings
+	switch action {
+	case "inc":
+		after = b.state.IncrementTasbih(chatID)
+	case "reset":
+		b.state.SetTasbih(chatID, 0, before.TasbihTarget)
+		after = *b.state.Get(chatID)
+	default:
+		target, err := strconv.Atoi(action)
+		if err != nil || target <= 0 {
+			return
+		}
+		b.state.SetTasbih(chatID, 0, target)
+		after = *b.state.Get(chatID)
+	}
+	// Telegram rejects edits that change nothing, e.g. resetting a counter that is already 0.
+	if after.TasbihCount == before.TasbihCount && after.TasbihTarget == before.TasbihTarget && msg != nil {
+		return
+	}
+	if err := b.editOrSend(chatID, msg, tasbihText(lang, after), tasbihKeyboard(lang)); err != nil {
+		log.Printf("tasbih update error: %v", err)
+	}
+}
+
+const (
+	duaSuhoor = "suhoor"
+	duaIftar  = "iftar"
+)
+
+func duaKeyboard(lang string) InlineKeyboardMarkup {
+	rows := [][]InlineKeyboardButton{
+		{
+			{Text: tr(lang, "btn_dua_suhoor"), CallbackData: "dua:" + duaSuhoor},
+			{Text: tr(lang, "btn_dua_iftar"), CallbackData: "dua:" + duaIftar},
+		},
+	}
+	for _, dua := range duaLibrary {
+		rows = append(rows, []InlineKeyboardButton{{Text: localizedNiyatText(dua.Title, lang), CallbackData: "dua:" + dua.Key}})
+	}
+	return InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// Recordings dropped into audio/ as <kind>.ogg are compiled in. None ship with the
+// repository yet (see audio/README.md), so a stock build sends the niyat as text.
+//
+//go:embed audio
+var embeddedDuaAudio embed.FS
+
+// duaRecording returns the audio for kind, preferring a file configured through the
+// environment over the embedded one and caching it on first use. It returns nil
+// when neither exists.
+func (b *Bot) duaRecording(kind string) ([]byte, error) {
+	b.duaAudioMu.Lock()
+	defer b.duaAudioMu.Unlock()
+	if audio, ok := b.duaAudio[kind]; ok {
+		return audio, nil
+	}
+	var audio []byte
+	var err error
+	if path := b.duaAudioPaths[kind]; path != "" {
+		audio, err = os.ReadFile(path)
+	} else {
+		audio, err = embeddedDuaAudio.ReadFile("audio/" + kind + ".ogg")
+		if errors.Is(err, fs.ErrNotExist) {
+			audio, err = nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b.duaAudio == nil {
+		b.duaAudio = make(map[string][]byte)
+	}
+	b.duaAudio[kind] = audio
+	return audio, nil
+}
+
+// sendDua plays the recording for kind when one is configured and always follows with
+// the written niyat, which is too long for a voice caption.
+func (b *Bot) sendDua(chatID int64, kind string) {
+	lang := b.userLang(chatID)
+	var label, text string
+	switch kind {
+	case duaSuhoor:
+		label, text = tr(lang, "niyat_suhoor_label"), localizedNiyatText(b.niyatSuhoor, lang)
+	case duaIftar:
+		label, text = tr(lang, "niyat_iftar_label"), localizedNiyatText(b.niyatIftar, lang)
+	default:
+		if dua, ok := findDua(kind); ok {
+			if _, err := b.sender.SendMessage(chatID, formatDua(lang, dua), nil); err != nil {
+				log.Printf("dua text send error: %v", err)
+			}
+		}
+		return
+	}
+
+	audio, err := b.duaRecording(kind)
+	if err != nil {
+		log.Printf("dua audio load error (%s): %v", kind, err)
+	}
+	if len(audio) > 0 {
+		if _, err := b.sender.SendVoice(chatID, audio, strings.TrimSpace(label)); err != nil {
+			log.Printf("dua voice send error: %v", err)
+		}
+	}
+	if _, err := b.sender.SendMessage(chatID, label+text, nil); err != nil {
+		log.Printf("dua text send error: %v", err)
+	}
+}
+
+// ramadanDays is the highest day number /day accepts.
+const ramadanDays = 30
+
+// handleDay answers /day <n>; without an argument it shows the current day.
+func (b *Bot) handleDay(chatID int64, args string) {
+	lang := b.userLang(chatID)
+	settings := b.state.Get(chatID)
+	today := 0
+	if cal, ok := b.regionCalendar(settings.Region); ok {
+		if day := currentDaySchedule(cal, b.startDate(), b.tz); day != nil {
+			today = day.Day
+		}
+	}
+	n := max(today, 1)
+	if args != "" {
+		parsed, err := strconv.Atoi(args)
+		if strings.HasPrefix(args, "+") {
+			// "+N" counts from today, so "/day +1" is tomorrow.
+			parsed += today
+		}
+		if err != nil || parsed < 1 || parsed > ramadanDays {
+			if _, err := b.sender.SendMessage(chatID, trf(lang, "day_out_of_range", ramadanDays), nil); err != nil {
+				log.Printf("day range send error: %v", err)
+			}
+			return
+		}
+		n = parsed
+	}
+	b.sendDay(chatID, nil, n)
+}
+
+// sendDay shows day n for the user's region. With msg set (a navigation button press)
+// the existing photo is edited in place instead of sending a new one.
+func (b *Bot) sendDay(chatID int64, msg *Message, n int) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	if settings.Region == "" {
+		b.promptRegion(chatID, tr(lang, "need_region_first"))
+		return
+	}
+	cal, ok := b.regionCalendar(settings.Region)
+	if !ok || len(cal) == 0 {
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "calendar_not_found"), nil); err != nil {
+			log.Printf("day calendar missing send error: %v", err)
+		}
+		return
+	}
+	day := findDaySchedule(cal, n)
+	if day == nil {
+		if _, err := b.sender.SendMessage(chatID, trf(lang, "day_out_of_range", ramadanDays), nil); err != nil {
+			log.Printf("day range send error: %v", err)
+		}
+		return
+	}
+
+	markup := dayNavKeyboard(n)
+	photo, err := b.cachedTodayImage(lang, b.renderOptionsFor(chatID), settings.Region, *day)
+	if err != nil {
+		b.recoverCard(chatID, lang, &cardError{what: "day image build", err: err, text: func() (*Message, error) {
+			return b.sender.SendMessage(chatID, formatDayTimetable(lang, settings.Region, day.withAsrMethod(settings.AsrMethod)), markup)
+		}})
+		return
+	}
+	caption := trf(lang, "day_caption", regionDisplayName(settings.Region, lang), day.Data, dayHijriDate(lang, *day), dayLabel(lang, day.Day))
+	if msg != nil {
+		err := b.sender.EditMessagePhoto(chatID, msg.MessageID, photo, caption, markup)
+		if err == nil {
+			return
+		}
+		log.Printf("day photo edit error: %v", err)
+	}
+	if _, err := b.sender.SendPhotoWithMarkup(chatID, photo, caption, markup); err != nil {
+		log.Printf("day photo send error: %v", err)
+	}
+}
+
+func dayNavKeyboard(n int) InlineKeyboardMarkup {
+	var row []InlineKeyboardButton
+	if n > 1 {
+		row = append(row, InlineKeyboardButton{Text: fmt.Sprintf("◀ %d", n-1), CallbackData: fmt.Sprintf("day:%d", n-1)})
+	}
+	if n < ramadanDays {
+		row = append(row, InlineKeyboardButton{Text: fmt.Sprintf("%d ▶", n+1), CallbackData: fmt.Sprintf("day:%d", n+1)})
+	}
+	return InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{row}}
+}
+
+func (b *Bot) handleInlineQuery(q *InlineQuery) {
+	lang := normalizeLang(q.From.LanguageCode)
+	query := q.Query
+	if member := b.state.MemberLanguage(q.From.ID); member != "" {
+		lang = member
+	}
+	if settings, ok := b.state.Lookup(q.From.ID); ok {
+		if saved := normalizeLang(settings.Language); saved != "" {
+			lang = saved
+		}
+		if strings.TrimSpace(query) == "" {
+			query = settings.Region
+		}
+	}
+	if lang == "" {
+		lang = fallbackLang()
+	}
+
+	results := []InlineQueryResultArticle{}
+	for i, region := range matchRegions(query) {
+		cal, ok := b.regionCalendar(region)
+		if !ok || len(cal) == 0 {
+			continue
+		}
+		day := currentDaySchedule(cal, b.startDate(), b.tz)
+		if day == nil {
+			continue
+		}
+		suhoor := minutesToClock(day.SuhoorEnd)
+		iftar := minutesToClock(day.Maghrib)
+		results = append(results, InlineQueryResultArticle{
+			Type:        "article",
+			ID:          strconv.Itoa(i),
+			Title:       regionDisplayName(region, lang),
+			Description: trf(lang, "inline_description", suhoor, iftar),
+			InputMessageContent: InputTextMessageContent{
+				MessageText: trf(lang, "inline_text", regionDisplayName(region, lang), day.Data, dayLabel(lang, day.Day), suhoor, iftar),
+			},
+		})
+	}
+
+	body := answerInlineQueryRequest{
+		InlineQueryID: q.ID,
+		Results:       results,
+		CacheTime:     300,
+		IsPersonal:    true,
+	}
+	if err := b.postJSON("answerInlineQuery", body, nil); err != nil {
+		log.Printf("answerInlineQuery error: %v", err)
+	}
+}
+
+// selectRegion saves region for the chat, confirms it (editing msg when possible) and
+// starts reminders.
+func (b *Bot) selectRegion(chatID int64, lang string, msg *Message, region string) {
+	b.state.SetRegion(chatID, region)
+	if err := b.editOrSend(chatID, msg, trf(lang, "region_selected", regionDisplayName(region, lang)), nil); err != nil {
+		log.Printf("confirm region error: %v", err)
+	}
+	b.scheduler.Start(chatID, region)
+}
+
+// regionFold strips case, Tajik-specific letters, apostrophes and dots so "Хуҷанд",
+// "худжанд" and "Xo‘jand" compare the way people type them.
+var regionFold = strings.NewReplacer(
+	"ҳ", "х", "ҷ", "ч", "ӣ", "и", "ӯ", "у", "қ", "к", "ғ", "г", "ё", "е", "дж", "ч",
+	"ъ", "", "'", "", "‘", "", "’", "", "ʻ", "", "`", "", ".", "",
+)
+
+func foldRegionName(s string) string {
+	return strings.Join(strings.Fields(regionFold.Replace(strings.ToLower(s))), " ")
+}
+
+// matchRegions returns the regions whose canonical key, localized name or slug starts
+// with query. An exact match on any of them wins outright.
+func matchRegions(query string) []string {
+	query = foldRegionName(query)
+	var matches []string
+	for _, region := range regionNames {
+		names := []string{region}
+		for _, name := range regionDisplayNames[region] {
+			names = append(names, name)
+		}
+		for slug, key := range regionSlugs {
+			if key == region {
+				names = append(names, slug)
+			}
+		}
+		prefix := false
+		for _, name := range names {
+			name = foldRegionName(name)
+			if name == query {
+				return []string{region}
+			}
+			if strings.HasPrefix(name, query) {
+				prefix = true
+			}
+		}
+		if prefix {
+			matches = append(matches, region)
+		}
+	}
+	return matches
+}
+
+// minRegionSearchLen keeps single stray letters from popping up the region picker.
+const minRegionSearchLen = 2
+
+// handleRegionSearch treats free text as a region name in private chats; in groups
+// ordinary chatter would keep matching regions. It returns false when nothing matches
+// so the caller can fall back to help.
+func (b *Bot) handleRegionSearch(chatID int64, text string) bool {
+	if chatID < 0 || strings.HasPrefix(text, "/") || len([]rune(foldRegionName(text))) < minRegionSearchLen {
+		return false
+	}
+	matches := matchRegions(text)
+	if len(matches) == 0 {
+		return false
+	}
+	if lang, ok := b.requireLanguage(chatID); ok {
+		b.offerRegions(chatID, lang, matches)
+	}
+	return true
+}
+
+// offerRegions selects the region outright when there is one match and otherwise lets
+// the user pick among the candidates.
+func (b *Bot) offerRegions(chatID int64, lang string, matches []string) {
+	if len(matches) == 1 {
+		b.selectRegion(chatID, lang, nil, matches[0])
+		return
+	}
+	if _, err := b.sender.SendMessage(chatID, tr(lang, "region_candidates"), regionKeyboardPage(matches, lang, 1, regionsPerPage, regionKeyboardColumns)); err != nil {
+		log.Printf("region candidates send error: %v", err)
+	}
+}
+
+func (b *Bot) sendQibla(chatID int64) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	if settings.Region == "" {
+		b.promptRegion(chatID, tr(lang, "need_region_first"))
+		return
+	}
+	coords, ok := regionCoordinates[settings.Region]
+	if !ok {
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "calendar_not_found"), nil); err != nil {
+			log.Printf("qibla calendar missing send error: %v", err)
+		}
+		return
+	}
+
+	bearing := qiblaBearing(coords.Lat, coords.Lng)
+	caption := trf(lang, "qibla_caption", regionDisplayName(settings.Region, lang), int(math.Round(bearing))%360, tr(lang, compassDirectionKey(bearing)))
+	photo, err := b.cachedQiblaImage(lang, b.renderOptionsFor(chatID), settings.Region, bearing)
+	if err != nil {
+		log.Printf("qibla image build error: %v", err)
+		if _, err := b.sender.SendMessage(chatID, caption, nil); err != nil {
+			log.Printf("qibla send error: %v", err)
+		}
+		return
+	}
+	if _, err := b.sender.SendPhoto(chatID, photo, caption); err != nil {
+		log.Printf("qibla photo send error: %v", err)
+	}
+}
+
+// sendPrayers shows all of today's prayer times for the chat's region, with the next
+// one highlighted on the card.
+func (b *Bot) sendPrayers(chatID int64) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	if settings.Region == "" {
+		b.promptRegion(chatID, tr(lang, "need_region_first"))
+		return
+	}
+	cal, ok := b.regionCalendar(settings.Region)
+	if !ok || len(cal) == 0 {
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "calendar_not_found"), nil); err != nil {
+			log.Printf("prayers calendar missing send error: %v", err)
+		}
+		return
+	}
+	today := currentDaySchedule(cal, b.startDate(), b.tz)
+	if today == nil {
+		if _, err := b.sender.SendMessage(chatID, outOfRangeText(lang, "out_of_range", b.startDate()), nil); err != nil {
+			log.Printf("prayers range send error: %v", err)
+		}
+		return
+	}
+	day := today.withAsrMethod(settings.AsrMethod)
+	text := formatDayTimetable(lang, settings.Region, day)
+	if settings.ImagesDisabled {
+		if _, err := b.sender.SendMessage(chatID, text, nil); err != nil {
+			log.Printf("prayers text send error: %v", err)
+		}
+		return
+	}
+	now := time.Now().In(b.tz)
+	photo, err := b.cachedPrayersImage(lang, b.renderOptionsFor(chatID), settings.Region, day, nextPrayer(day, now.Hour()*60+now.Minute()))
+	if err != nil {
+		log.Printf("prayers image build error: %v", err)
+		if _, err := b.sender.SendMessage(chatID, text, nil); err != nil {
+			log.Printf("prayers send error: %v", err)
+		}
+		return
+	}
+	if _, err := b.sender.SendPhoto(chatID, photo, text); err != nil {
+		log.Printf("prayers photo send error: %v", err)
+	}
+}
+
+// prayerKeys lists the day's times in order, as used by the event_ and img_prayer_ keys.
+var prayerKeys = []string{"suhoor", "fajr", "dhuhr", "asr", "maghrib", "isha"}
+
+func prayerMinutes(day DayTimes, key string) int {
+	switch key {
+	case "suhoor":
+		return day.SuhoorEnd
+	case "fajr":
+		return day.Fajr
+	case "dhuhr":
+		return day.Dhuhr
+	case "asr":
+		return day.Asr
+	case "maghrib":
+		return day.Maghrib
+	}
+	return day.Isha
+}
+
+// nextPrayer returns the key of the first time after minute of the day, or "" once
+// isha has passed.
+func nextPrayer(day DayTimes, minute int) string {
+	for _, key := range prayerKeys {
+		if prayerMinutes(day, key) > minute {
+			return key
+		}
+	}
+	return ""
+}
+
+// handleCompare answers "/compare <regionA> <regionB>" with today's suhoor and iftar for
+// both regions side by side. With a single region it is compared against the chat's own.
+func (b *Bot) handleCompare(chatID int64, args string) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	regionA, regionB, ok := parseCompareRegions(args, settings.Region)
+	if !ok {
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "compare_usage"), nil); err != nil {
+			log.Printf("compare usage send error: %v", err)
+		}
+		return
+	}
+	calA, okA := b.regionCalendar(regionA)
+	calB, okB := b.regionCalendar(regionB)
+	if !okA || !okB || len(calA) == 0 || len(calB) == 0 {
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "calendar_not_found"), nil); err != nil {
+			log.Printf("compare calendar missing send error: %v", err)
+		}
+		return
+	}
+	dayA := currentDaySchedule(calA, b.startDate(), b.tz)
+	dayB := currentDaySchedule(calB, b.startDate(), b.tz)
+	if dayA == nil || dayB == nil {
+		if _, err := b.sender.SendMessage(chatID, outOfRangeText(lang, "out_of_range", b.startDate()), nil); err != nil {
+			log.Printf("compare range send error: %v", err)
+		}
+		return
+	}
+
+	text := formatCompareText(lang, regionA, *dayA, regionB, *dayB)
+	if settings.ImagesDisabled {
+		if _, err := b.sender.SendMessage(chatID, text, nil); err != nil {
+			log.Printf("compare text send error: %v", err)
+		}
+		return
+	}
+	photo, err := b.cachedCompareImage(lang, b.renderOptionsFor(chatID), regionA, *dayA, regionB, *dayB)
+	if err != nil {
+		log.Printf("compare image build error: %v", err)
+		if _, err := b.sender.SendMessage(chatID, text, nil); err != nil {
+			log.Printf("compare send error: %v", err)
+		}
+		return
+	}
+	if _, err := b.sender.SendPhoto(chatID, photo, text); err != nil {
+		log.Printf("compare photo send error: %v", err)
+	}
+}
+
+// parseCompareRegions splits the /compare arguments into two regions. Region names may
+// contain spaces ("Ш. Шохин"), so every split point is tried and the one where both
+// halves resolve to exactly one region wins. A single argument is paired with own.
+func parseCompareRegions(args, own string) (string, string, bool) {
+	fields := strings.Fields(args)
+	resolve := func(words []string) (string, bool) {
+		matches := matchRegions(strings.Join(words, " "))
+		if len(matches) != 1 {
+			return "", false
+		}
+		return matches[0], true
+	}
+	for i := 1; i < len(fields); i++ {
+		a, okA := resolve(fields[:i])
+		b, okB := resolve(fields[i:])
+		if okA && okB && a != b {
+			return a, b, true
+		}
+	}
+	if own != "" && len(fields) > 0 {
+		if other, ok := resolve(fields); ok && other != own {
+			return own, other, true
+		}
+	}
+	return "", "", false
+}
+
+// formatCompareText lists both regions' suhoor and iftar and how far the second region
+// is from the first.
+func formatCompareText(lang, regionA string, dayA DayTimes, regionB string, dayB DayTimes) string {
+	lines := []string{trf(lang, "compare_title", dayA.Data, dayLabel(lang, dayA.Day)), ""}
+	for _, r := range []struct {
+		region string
+		day    DayTimes
+	}{{regionA, dayA}, {regionB, dayB}} {
+		lines = append(lines, fmt.Sprintf("%s: %s %s • %s %s",
+			regionDisplayName(r.region, lang),
+			tr(lang, "img_today_suhoor_label"), minutesToClock(r.day.SuhoorEnd),
+			tr(lang, "img_today_iftar_label"), minutesToClock(r.day.Maghrib),
+		))
+	}
+	lines = append(lines, "", compareDiffLine(lang, regionB, dayA, dayB))
+	return strings.Join(lines, "\n")
+}
+
+func compareDiffLine(lang, regionB string, dayA, dayB DayTimes) string {
+	return trf(lang, "compare_diff", regionDisplayName(regionB, lang), signedMinutes(dayB.SuhoorEnd-dayA.SuhoorEnd), signedMinutes(dayB.Maghrib-dayA.Maghrib))
+}
+
+// signedMinutes formats a minute difference with an explicit sign, e.g. "+7" or "−3".
+func signedMinutes(d int) string {
+	switch {
+	case d > 0:
+		return fmt.Sprintf("+%d", d)
+	case d < 0:
+		return fmt.Sprintf("−%d", -d)
+	}
+	return "0"
+}
+
+// sendHadith sends a random hadith from the API, or with a topic (/hadiths fasting) a
+// built-in hadith tagged with it.
+func (b *Bot) sendHadith(chatID int64, args string) {
+	lang := b.userLang(chatID)
+	var hadith Hadith
+	var err error
+	if args = strings.TrimSpace(args); args != "" {
+		tag := matchHadithTag(args)
+		tagged, ok := b.randomHadithByTag(lang, tag)
+		if tag == "" || !ok {
+			if _, err := b.sender.SendMessage(chatID, trf(lang, "hadith_tag_usage", hadithTagList(lang)), nil); err != nil {
+				log.Printf("hadith tag usage send error: %v", err)
+			}
+			return
+		}
+		hadith = tagged
+	} else if hadith, err = b.randomHadithFromAPI(lang); err != nil {
+		log.Printf("hadith api error for chat %d: %v", chatID, err)
+		hadith = b.randomHadith(lang)
+	}
+	title := tr(lang, "hadith_day_title")
+	plain := formatHadithBlock(lang, title, hadith)
+	if b.useRichText {
+		_, err = sendRichText(b.sender, chatID, formatHadithBlockHTML(lang, title, hadith), plain, nil)
+	} else {
+		_, err = b.sender.SendMessage(chatID, plain, nil)
+	}
+	if err != nil {
+		log.Printf("hadith send error: %v", err)
+	}
+}
+
+func (b *Bot) randomHadithFromAPI(lang string) (Hadith, error) {
+	apiLang := hadithAPILanguageForUser(lang)
+	return b.randomHadithFromAPIByLanguage(lang, apiLang)
+}
+
+func hadithAPILanguageForUser(lang string) string {
+	normalized := normalizeLang(lang)
+	if normalized == "" {
+		return langEN
+	}
+	return normalized
+}
+
+func (b *Bot) randomHadithFromAPIByLanguage(userLang, apiLang string) (Hadith, error) {
+	categories, err := b.fetchHadithCategories(apiLang)
+	if err != nil {
+		return Hadith{}, err
+	}
+	if len(categories) == 0 {
+		return Hadith{}, fmt.Errorf("no categories for api language %q", apiLang)
+	}
+
+	var lastErr error
+	maxAttempts := len(categories)
+	if maxAttempts > 3 {
+		maxAttempts = 3
+	}
+	for i, idx := range rand.Perm(len(categories)) {
+		if i >= maxAttempts {
+			break
+		}
+		categoryID := strings.TrimSpace(categories[idx].ID)
+		if categoryID == "" {
+			continue
+		}
+		items, err := b.fetchHadithList(apiLang, categoryID)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(items) == 0 {
+			continue
+		}
+
+		item := items[rand.Intn(len(items))]
+		detail, err := b.fetchHadithDetail(apiLang, strings.TrimSpace(item.ID))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return hadithFromAPI(userLang, detail), nil
+	}
+
+	if lastErr != nil {
+		return Hadith{}, lastErr
+	}
+	return Hadith{}, fmt.Errorf("no hadiths found for api language %q", apiLang)
+}
+
+// hadithFromAPI converts an API hadith, using the first of its attribution, reference
+// and grade as the source.
+func hadithFromAPI(lang string, hadith hadithAPIDetail) Hadith {
+	text := strings.TrimSpace(hadith.Hadeeth)
+	if text == "" {
+		text = tr(lang, "hadith_fallback")
+	}
+	return Hadith{Text: text, Source: firstNonEmptyTrimmed(hadith.Attribution, hadith.Reference, hadith.Grade)}
+}
+
+func firstNonEmptyTrimmed(values ...string) string {
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (b *Bot) fetchHadithCategories(apiLang string) ([]hadithAPICategory, error) {
+	apiLang = strings.ToLower(strings.TrimSpace(apiLang))
+	if apiLang == "" {
+		return nil, fmt.Errorf("hadith API language is empty")
+	}
+	if cached, ok := b.getCachedHadithCategories(apiLang); ok {
+		return cached, nil
+	}
+
+	endpoint := b.hadithAPIEndpoint("/categories/list/", url.Values{
+		"language": {apiLang},
+	})
+	var categories []hadithAPICategory
+	if err := b.getJSON(endpoint, &categories); err != nil {
+		return nil, err
+	}
+	b.setCachedHadithCategories(apiLang, categories, 12*time.Hour)
+	return categories, nil
+}
+
+func (b *Bot) getCachedHadithCategories(apiLang string) ([]hadithAPICategory, bool) {
+	if b == nil {
+		return nil, false
+	}
+	b.hadithCatsMu.RLock()
+	cached, ok := b.hadithCats[apiLang]
+	b.hadithCatsMu.RUnlock()
+	if !ok || time.Now().After(cached.expiresAt) || len(cached.items) == 0 {
+		return nil, false
+	}
+	return append([]hadithAPICategory(nil), cached.items...), true
+}
+
+func (b *Bot) setCachedHadithCategories(apiLang string, categories []hadithAPICategory, ttl time.Duration) {
+	if b == nil || len(categories) == 0 || ttl <= 0 {
+		return
+	}
+	b.hadithCatsMu.Lock()
+	if b.hadithCats == nil {
+		b.hadithCats = make(map[string]cachedHadithCategories)
+	}
+	b.hadithCats[apiLang] = cachedHadithCategories{
+		items:     append([]hadithAPICategory(nil), categories...),
+		expiresAt: time.Now().Add(ttl),
+	}
+	b.hadithCatsMu.Unlock()
+}
+
+func (b *Bot) fetchHadithList(apiLang, categoryID string) ([]hadithAPIListItem, error) {
+	endpoint := b.hadithAPIEndpoint("/hadeeths/list/", url.Values{
+		"language":    {apiLang},
+		"category_id": {categoryID},
+		"page":        {"1"},
+		"per_page":    {"20"},
+	})
+	var resp hadithAPIListResp
+	if err := b.getJSON(endpoint, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+func (b *Bot) fetchHadithDetail(apiLang, hadithID string) (hadithAPIDetail, error) {
+	endpoint := b.hadithAPIEndpoint("/hadeeths/one/", url.Values{
+		"language": {apiLang},
+		"id":       {hadithID},
+	})
+	var detail hadithAPIDetail
+	if err := b.getJSON(endpoint, &detail); err != nil {
+		return hadithAPIDetail{}, err
+	}
+	return detail, nil
+}
+
+func (b *Bot) hadithAPIEndpoint(path string, query url.Values) string {
+	base := strings.TrimRight(strings.TrimSpace(b.hadithAPIURL), "/")
+	if base == "" {
+		base = "https://api.hadeethenc.com/api/v1"
+	}
+	path = "/" + strings.TrimLeft(strings.TrimSpace(path), "/")
+	if len(query) == 0 {
+		return base + path
+	}
+	return base + path + "?" + query.Encode()
+}
+
+func (b *Bot) getJSON(rawURL string, target interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("request %s failed with %d: %s", rawURL, resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return fmt.Errorf("decode %s: %w", rawURL, err)
+	}
+	return nil
+}
+
+// sendTestNotification sends a synthetic reminder 30 minutes out, or with eventKey set,
+// today's real reminder for that event.
+func (b *Bot) sendTestNotification(chatID int64, eventKey string) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	if eventKey != "" && !isReminderEventKey(eventKey) {
+		if _, err := b.sender.SendMessage(chatID, trf(lang, "testnotify_invalid", strings.Join(reminderEventKeys, ", ")), nil); err != nil {
+			log.Printf("test notify usage send error: %v", err)
+		}
+		return
+	}
+	region := strings.TrimSpace(settings.Region)
+	if region == "" {
+		region = b.defaultRegion
+		if _, err := b.sender.SendMessage(chatID, trf(lang, "test_region_default", regionDisplayName(region, lang)), nil); err != nil {
+			log.Printf("test notify region info send error: %v", err)
+		}
+	}
+
+	schedule, _ := b.regionCalendar(region)
+	day := currentDaySchedule(schedule, b.startDate(), b.tz)
+	if eventKey != "" {
+		if day == nil {
+			if _, err := b.sender.SendMessage(chatID, outOfRangeText(lang, "out_of_range", b.startDate()), nil); err != nil {
+				log.Printf("testnotify range send error: %v", err)
+			}
+			return
+		}
+		base := reminderDayBaseTime(b.startDate(), day.Day, b.tz)
+		events := reminderEventsForDay(base, day.withAsrMethod(settings.AsrMethod), reminderOptions{
+			Tahajjud: true,
+			Next:     findDaySchedule(schedule, day.Day+1),
+		})
+		for _, ev := range events {
+			if ev.Key == eventKey {
+				b.scheduler.sendReminder(chatID, region, day.Day, ev)
+				return
+			}
+		}
+		return
+	}
+
+	dayNumber := 1
+	if day != nil && day.Day > 0 {
+		dayNumber = day.Day
+	}
+	ev := eventSpec{
+		Key:   "test",
+		Title: tr(lang, "test_notification_title"),
+		Time:  time.Now().In(b.tz).Add(30 * time.Minute),
+	}
+	b.scheduler.sendReminder(chatID, region, dayNumber, ev)
+}
+
+// sendPreview sends today's reminder cards right away, one photo per event, so users can
+// see what they will receive.
+func (b *Bot) sendPreview(chatID int64) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	day, events, ok := b.todayReminderEvents(chatID, lang, *settings)
+	if !ok {
+		return
+	}
+	intro := trf(lang, "preview_intro", len(events))
+	// Text mode gets the headlines in one message instead of a card per event.
+	if settings.ImagesDisabled {
+		lines := []string{intro}
+		for _, ev := range events {
+			lines = append(lines, reminderHeadline(lang, settings.Region, day.Day, ev, b.tz))
+		}
+		if _, err := b.sender.SendMessage(chatID, strings.Join(lines, "\n\n"), nil); err != nil {
+			log.Printf("preview send error: %v", err)
+		}
+		return
+	}
+	if _, err := b.sender.SendMessage(chatID, intro, nil); err != nil {
+		log.Printf("preview intro send error: %v", err)
+	}
+	opts := b.renderOptionsFor(chatID)
+	for _, ev := range events {
+		headline := reminderHeadline(lang, settings.Region, day.Day, ev, b.tz)
+		photo, err := b.scheduler.cachedReminderImage(lang, opts, settings.Region, day.Day, ev)
+		if err != nil {
+			log.Printf("preview image build error: %v", err)
+			if _, err := b.sender.SendMessage(chatID, headline, nil); err != nil {
+				log.Printf("preview send error: %v", err)
+			}
+			continue
+		}
+		if _, err := b.sender.SendPhoto(chatID, photo, headline); err != nil {
+			log.Printf("preview photo send error: %v", err)
+		}
+	}
+}
+
+// todayReminderEvents returns today's Ramadan day and the reminders the chat gets for it.
+// When there are none (no region, no calendar, outside Ramadan) it tells the chat why
+// and reports false.
+func (b *Bot) todayReminderEvents(chatID int64, lang string, settings UserSettings) (*DayTimes, []eventSpec, bool) {
+	if settings.Region == "" {
+		b.promptRegion(chatID, tr(lang, "need_region_first"))
+		return nil, nil, false
+	}
+	cal, ok := b.regionCalendar(settings.Region)
+	if !ok || len(cal) == 0 {
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "calendar_not_found"), nil); err != nil {
+			log.Printf("reminder events calendar missing send error: %v", err)
+		}
+		return nil, nil, false
+	}
+	day := currentDaySchedule(cal, b.startDate(), b.tz)
+	if day == nil {
+		if _, err := b.sender.SendMessage(chatID, outOfRangeText(lang, "out_of_range", b.startDate()), nil); err != nil {
+			log.Printf("reminder events range send error: %v", err)
+		}
+		return nil, nil, false
+	}
+
+	base := reminderDayBaseTime(b.startDate(), day.Day, b.tz)
+	events := reminderEventsForDay(base, day.withAsrMethod(settings.AsrMethod), reminderOptions{
+		Tahajjud: settings.Tahajjud,
+		Next:     findDaySchedule(cal, day.Day+1),
+	})
+	return day, events, true
+}
+
+// sendCatchup replays the reminder cards for today's events whose reminder moment has
+// already passed without them going out, for example because the bot was down at
+// suhoor. Unlike /preview it skips upcoming and already delivered events.
+func (b *Bot) sendCatchup(chatID int64) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	day, events, ok := b.todayReminderEvents(chatID, lang, *settings)
+	if !ok {
+		return
+	}
+	dayKey := reminderDayBaseTime(b.startDate(), day.Day, b.tz).Format("2006-01-02")
+	missed := missedReminders(events, *settings, b.state.SentReminders(chatID, dayKey), time.Now().In(b.tz))
+	if len(missed) == 0 {
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "catchup_none"), nil); err != nil {
+			log.Printf("catchup none send error: %v", err)
+		}
+		return
+	}
+	if _, err := b.sender.SendMessage(chatID, trf(lang, "catchup_intro", len(missed)), nil); err != nil {
+		log.Printf("catchup intro send error: %v", err)
+	}
+	for _, ev := range missed {
+		err := b.scheduler.sendReminder(chatID, settings.Region, day.Day, ev)
+		if errors.Is(err, ErrBotBlocked) {
+			return
+		}
+		// Only a delivered reminder is marked, so a failed one is offered again by the
+		// next /catchup.
+		if err == nil {
+			b.state.MarkReminderSent(chatID, dayKey, ev.Key)
+		}
+	}
+}
+
+// missedReminders picks the events whose reminder moment is not after now and that are
+// not in sent, in time order. Events the chat silenced with quiet hours or /mute stay
+// silenced, and nothing is missed while reminders are off.
+func missedReminders(events []eventSpec, settings UserSettings, sent map[string]bool, now time.Time) []eventSpec {
+	if !settings.Notifications {
+		return nil
+	}
+	var missed []eventSpec
+	for _, ev := range events {
+		remindAt := ev.Time.Add(-reminderLead)
+		if remindAt.After(now) || sent[ev.Key] {
+			continue
+		}
+		if remindAt.Before(settings.mutedUntil()) {
+			continue
+		}
+		if !quietExempt(ev) && quietHoursActive(settings, remindAt.In(now.Location())) {
+			continue
+		}
+		missed = append(missed, ev)
+	}
+	sort.SliceStable(missed, func(i, j int) bool { return missed[i].Time.Before(missed[j].Time) })
+	return missed
+}
+
+func (b *Bot) setNotifications(chatID int64, enabled bool) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	if settings.Region == "" {
+		b.promptRegion(chatID, tr(lang, "need_region_notify"))
+		return
+	}
+	b.state.SetNotifications(chatID, enabled)
+	// Turning reminders on or off explicitly also ends any /mute.
+	if settings.MutedUntil != nil {
+		b.state.SetMutedUntil(chatID, time.Time{})
+	}
+	if enabled {
+		b.scheduler.Start(chatID, settings.Region)
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "notify_enabled"), nil); err != nil {
+			log.Printf("notify enabled send error: %v", err)
+		}
+	} else {
+		b.scheduler.Stop(chatID)
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "notify_disabled"), nil); err != nil {
+			log.Printf("notify disabled send error: %v", err)
+		}
+	}
+}
+
+func (b *Bot) toggleHadithCard(chatID int64) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	enabled := !settings.HadithCard
+	b.state.SetHadithCard(chatID, enabled)
+	key := "hadithcard_disabled"
+	if enabled {
+		key = "hadithcard_enabled"
+	}
+	if _, err := b.sender.SendMessage(chatID, tr(lang, key), nil); err != nil {
+		log.Printf("hadith card toggle send error: %v", err)
+	}
+}
+
+func (b *Bot) toggleAyahCard(chatID int64) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	enabled := !settings.AyahCard
+	b.state.SetAyahCard(chatID, enabled)
+	key := "ayahcard_disabled"
+	if enabled {
+		key = "ayahcard_enabled"
+	}
+	if _, err := b.sender.SendMessage(chatID, tr(lang, key), nil); err != nil {
+		log.Printf("ayah card toggle send error: %v", err)
+	}
+}
+
+func (b *Bot) toggleTahajjud(chatID int64) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	enabled := !settings.Tahajjud
+	b.state.SetTahajjud(chatID, enabled)
+	key := "tahajjud_disabled"
+	if enabled {
+		key = "tahajjud_enabled"
+	}
+	if _, err := b.sender.SendMessage(chatID, tr(lang, key), nil); err != nil {
+		log.Printf("tahajjud toggle send error: %v", err)
+	}
+	// Restart the loop so today's events pick up the change.
+	if settings.Notifications && settings.Region != "" {
+		b.scheduler.Start(chatID, settings.Region)
+	}
+}
+
+// handleDigest toggles the daily digest, or enables it with a new lead time when the
+// command carries a number of minutes ("/digest 90").
+func (b *Bot) handleDigest(chatID int64, args string) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+
+	enabled := !settings.DailyDigest
+	lead := 0
+	if args = strings.TrimSpace(args); args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 1 || n > maxDigestLeadMinutes {
+			if _, err := b.sender.SendMessage(chatID, trf(lang, "digest_usage", maxDigestLeadMinutes), nil); err != nil {
+				log.Printf("digest usage send error: %v", err)
+			}
+			return
+		}
+		enabled, lead = true, n
+	}
+	b.state.SetDigest(chatID, enabled, lead)
+
+	text := tr(lang, "digest_disabled")
+	if enabled {
+		text = trf(lang, "digest_enabled", digestLeadMinutes(*b.state.Get(chatID)))
+	}
+	if _, err := b.sender.SendMessage(chatID, text, nil); err != nil {
+		log.Printf("digest toggle send error: %v", err)
+	}
+	if settings.Notifications && settings.Region != "" {
+		b.scheduler.Start(chatID, settings.Region)
+	}
+}
+
+// handleQuiet sets the quiet hours from "/quiet 22:00 05:00", clears them with
+// "/quiet off" and shows the current window without arguments.
+func (b *Bot) handleQuiet(chatID int64, args string) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+
+	fields := strings.Fields(args)
+	var text string
+	switch {
+	case len(fields) == 0:
+		if settings.QuietFrom == settings.QuietTo {
+			text = tr(lang, "quiet_usage")
+		} else {
+			text = trf(lang, "quiet_set", minutesToClock(settings.QuietFrom), minutesToClock(settings.QuietTo))
+		}
+		if _, err := b.sender.SendMessage(chatID, text, nil); err != nil {
+			log.Printf("quiet hours send error: %v", err)
+		}
+		return
+	case len(fields) == 1 && strings.EqualFold(fields[0], "off"):
+		b.state.SetQuietHours(chatID, 0, 0)
+		text = tr(lang, "quiet_off")
+	default:
+		from, to, err := parseQuietHours(fields)
+		if err != nil {
+			if _, err := b.sender.SendMessage(chatID, tr(lang, "quiet_usage"), nil); err != nil {
+				log.Printf("quiet usage send error: %v", err)
+			}
+			return
+		}
+		b.state.SetQuietHours(chatID, from, to)
+		text = trf(lang, "quiet_set", minutesToClock(from), minutesToClock(to))
+	}
+	if _, err := b.sender.SendMessage(chatID, text, nil); err != nil {
+		log.Printf("quiet hours send error: %v", err)
+	}
+	if settings.Notifications && settings.Region != "" {
+		b.scheduler.Start(chatID, settings.Region)
+	}
+}
+
+// parseQuietHours reads a "HH:MM HH:MM" window into minutes of the day.
+func parseQuietHours(fields []string) (from, to int, err error) {
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("expected two times, got %d", len(fields))
+	}
+	var minutes [2]int
+	for i, field := range fields {
+		t, err := time.Parse("15:04", field)
+		if err != nil {
+			return 0, 0, err
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+	if minutes[0] == minutes[1] {
+		return 0, 0, fmt.Errorf("empty quiet window")
+	}
+	return minutes[0], minutes[1], nil
+}
+
+// maxMute caps /mute so a typo cannot silence reminders for the rest of Ramadan.
+const maxMute = 72 * time.Hour
+
+// handleMute pauses reminders for a while ("/mute 3h", "/mute 90m"; a bare number is
+// hours) or lifts the pause with "/mute off". The reminder manager resumes the chat on
+// its own when the time is up, including after a restart.
+func (b *Bot) handleMute(chatID int64, args string) {
+	settings := b.state.Get(chatID)
+	lang := b.userLang(chatID)
+	if settings.Region == "" {
+		b.promptRegion(chatID, tr(lang, "need_region_notify"))
+		return
+	}
+	if !settings.Notifications {
+		if _, err := b.sender.SendMessage(chatID, tr(lang, "mute_notify_off"), nil); err != nil {
+			log.Printf("mute notify off send error: %v", err)
+		}
+		return
+	}
+
+	args = strings.ToLower(strings.TrimSpace(args))
+	var text string
+	if args == "off" {
+		b.state.SetMutedUntil(chatID, time.Time{})
+		text = tr(lang, "mute_off")
+	} else {
+		d, err := parseMuteDuration(args)
+		if err != nil {
+			if _, err := b.sender.SendMessage(chatID, trf(lang, "mute_usage", int(maxMute.Hours())), nil); err != nil {
+				log.Printf("mute usage send error: %v", err)
+			}
+			return
+		}
+		now := time.Now().In(b.tz)
+		until := now.Add(d).Truncate(time.Minute)
+		b.state.SetMutedUntil(chatID, until)
+		layout := "15:04"
+		if until.YearDay() != now.YearDay() || until.Year() != now.Year() {
+			layout = "2006-01-02 15:04"
+		}
+		text = trf(lang, "mute_set", until.Format(layout))
+	}
+	if _, err := b.sender.SendMessage(chatID, text, nil); err != nil {
+		log.Printf("mute send error: %v", err)
+	}
+	b.scheduler.Start(chatID, settings.Region)
+}
+
+// parseMuteDuration reads "3h", "90m", "1h30m" or a bare number of hours.
+func parseMuteDuration(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, fmt.Errorf("missing duration")
+	}
+	if hours, err := strconv.Atoi(raw); err == nil {
+		raw = strconv.Itoa(hours) + "h"
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, err
+	}
+	if d < time.Minute || d > maxMute {
+		return 0, fmt.Errorf("duration %s out of range", d)
+	}
+	return d, nil
+}
+
+const maxZakatHousehold = 100
+
+func (b *Bot) sendZakatFitr(chatID int64, args string) {
+	lang := b.userLang(chatID)
+	people := 1
+	if args = strings.TrimSpace(args); args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 1 || n > maxZakatHousehold {
+			if _, err := b.sender.SendMessage(chatID, trf(lang, "zakat_usage", maxZakatHousehold), nil); err != nil {
+				log.Printf("zakat usage send error: %v", err)
+			}
+			return
+		}
+		people = n
+	}
+
+	unit := b.zakatUnit
+	if unit == "" {
+		unit = tr(lang, "zakat_unit_default")
+	}
+	rate := formatAmount(b.zakatRate)
+	total := formatAmount(b.zakatRate * float64(people))
+	text := tr(lang, "zakat_info") + "\n\n" + trf(lang, "zakat_amount", people, rate, unit, total)
+	if _, err := b.sender.SendMessage(chatID, text, nil); err != nil {
+		log.Printf("zakat send error: %v", err)
+	}
+}
+
+// formatAmount prints a quantity with at most two decimals and no trailing zeros.
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+// replyMenu is the reply markup to attach to welcome and help messages: the menu
+// keyboard, or none when the chat has hidden it with /hidemenu.
+func (b *Bot) replyMenu(chatID int64, lang string) interface{} {
+	if b.state.Get(chatID).MenuHidden {
+		return nil
+	}
+	return b.menuKeyboard(lang)
+}
+
+func (b *Bot) setMenuHidden(chatID int64, hidden bool) {
+	lang := b.userLang(chatID)
+	b.state.SetMenuHidden(chatID, hidden)
+	var (
+		text   = tr(lang, "menu_shown")
+		markup interface{}
+	)
+	if hidden {
+		text = tr(lang, "menu_hidden")
+		markup = ReplyKeyboardRemove{RemoveKeyboard: true}
+	} else {
+		markup = b.menuKeyboard(lang)
+	}
+	if _, err := b.sender.SendMessage(chatID, text, markup); err != nil {
+		log.Printf("menu toggle send error: %v", err)
+	}
+}
+
+func (b *Bot) menuKeyboard(lang string) ReplyKeyboardMarkup {
+	return ReplyKeyboardMarkup{
+		Keyboard: [][]KeyboardButton{
+			{
+				{Text: tr(lang, "btn_hadiths")},
+				{Text: tr(lang, "btn_calendar")},
+				{Text: tr(lang, "btn_today")},
+			},
+			{
+				{Text: tr(lang, "btn_region")},
+				{Text: tr(lang, "btn_lang")},
+			},
+			{
+				{Text: tr(lang, "btn_notify_on")},
+				{Text: tr(lang, "btn_notify_off")},
+			},
+			{
+				{Text: tr(lang, "btn_help")},
+			},
+		},
+		ResizeKeyboard: true,
+		IsPersistent:   true,
+	}
+}
+
+func (b *Bot) settingsKeyboard(lang string, notificationsOn bool) InlineKeyboardMarkup {
+	notifyButton := InlineKeyboardButton{Text: tr(lang, "btn_notify_on"), CallbackData: "settings:notify:on"}
+	if notificationsOn {
+		notifyButton = InlineKeyboardButton{Text: tr(lang, "btn_notify_off"), CallbackData: "settings:notify:off"}
+	}
+	return InlineKeyboardMarkup{
+		InlineKeyboard: [][]InlineKeyboardButton{
+			{
+				{Text: tr(lang, "btn_lang"), CallbackData: "settings:lang"},
+				{Text: tr(lang, "btn_region"), CallbackData: "settings:region"},
+			},
+			{notifyButton},
+		},
+	}
+}
+
+func themeKeyboard(lang string) InlineKeyboardMarkup {
+	return InlineKeyboardMarkup{
+		InlineKeyboard: [][]InlineKeyboardButton{
+			{
+				{Text: tr(lang, "theme_dark"), CallbackData: "theme:" + themeDark},
+				{Text: tr(lang, "theme_light"), CallbackData: "theme:" + themeLight},
+			},
+		},
+	}
+}
+
+func themeDisplayName(lang, theme string) string {
+	if themeByName(theme).Name == themeLight {
+		return tr(lang, "theme_light")
+	}
+	return tr(lang, "theme_dark")
+}
+
+func languageDisplayName(lang string) string {
+	switch normalizeLang(lang) {
+	case langRU:
+		return "Русский"
+	case langEN:
+		return "English"
+	case langUZ:
+		return "O'zbek"
+	default:
+		return "Тоҷикӣ"
+	}
+}
+
+func (b *Bot) languageKeyboard() InlineKeyboardMarkup {
+	return InlineKeyboardMarkup{
+		InlineKeyboard: [][]InlineKeyboardButton{
+			{
+				{Text: "Тоҷикӣ", CallbackData: "lang:" + langTG},
+				{Text: "Русский", CallbackData: "lang:" + langRU},
+			},
+			{
+				{Text: "English", CallbackData: "lang:" + langEN},
+				{Text: "O'zbek", CallbackData: "lang:" + langUZ},
+			},
+		},
+	}
+}
+
+// regionNames lists the supported regions in the order they are offered to users.
+var regionNames = []string{
+	"Душанбе",
+	"Ашт",
+	"Айни",
+	"Кулоб",
+	"Рашт",
+	"Хамадони",
+	"Худжанд",
+	"Истаравшан",
+	"Исфара",
+	"Конибодом",
+	"Хоруг",
+	"Мургоб",
+	"Ш. Шохин",
+	"Муъминобод",
+	"Панчакент",
+	"Шахритус",
+	"Н. Хусрав",
+	"Турсунзода",
+}
+
+// regionDisplayNames gives the spelling of each region per interface language. Region
+// keys (the Cyrillic names in regionNames) stay canonical in state and callback data.
+var regionDisplayNames = map[string]map[string]string{
+	"Душанбе":    {langTG: "Душанбе", langRU: "Душанбе", langEN: "Dushanbe", langUZ: "Dushanbe"},
+	"Ашт":        {langTG: "Ашт", langRU: "Ашт", langEN: "Asht", langUZ: "Asht"},
+	"Айни":       {langTG: "Айнӣ", langRU: "Айни", langEN: "Ayni", langUZ: "Ayni"},
+	"Кулоб":      {langTG: "Кӯлоб", langRU: "Куляб", langEN: "Kulob", langUZ: "Ko‘lob"},
+	"Рашт":       {langTG: "Рашт", langRU: "Рашт", langEN: "Rasht", langUZ: "Rasht"},
+	"Хамадони":   {langTG: "Ҳамадонӣ", langRU: "Хамадони", langEN: "Hamadoni", langUZ: "Hamadoniy"},
+	"Худжанд":    {langTG: "Хуҷанд", langRU: "Худжанд", langEN: "Khujand", langUZ: "Xo‘jand"},
+	"Истаравшан": {langTG: "Истаравшан", langRU: "Истаравшан", langEN: "Istaravshan", langUZ: "Istaravshan"},
+	"Исфара":     {langTG: "Исфара", langRU: "Исфара", langEN: "Isfara", langUZ: "Isfara"},
+	"Конибодом":  {langTG: "Конибодом", langRU: "Канибадам", langEN: "Konibodom", langUZ: "Konibodom"},
+	"Хоруг":      {langTG: "Хоруғ", langRU: "Хорог", langEN: "Khorog", langUZ: "Xorug‘"},
+	"Мургоб":     {langTG: "Мурғоб", langRU: "Мургаб", langEN: "Murghob", langUZ: "Murg‘ob"},
+	"Ш. Шохин":   {langTG: "Ш. Шоҳин", langRU: "Ш. Шохин", langEN: "Sh. Shohin", langUZ: "Sh. Shohin"},
+	"Муъминобод": {langTG: "Муъминобод", langRU: "Муминабад", langEN: "Muminobod", langUZ: "Mo‘minobod"},
+	"Панчакент":  {langTG: "Панҷакент", langRU: "Пенджикент", langEN: "Panjakent", langUZ: "Panjikent"},
+	"Шахритус":   {langTG: "Шаҳритус", langRU: "Шаартуз", langEN: "Shahritus", langUZ: "Shahrituz"},
+	"Н. Хусрав":  {langTG: "Н. Хусрав", langRU: "Н. Хусрав", langEN: "N. Khusrav", langUZ: "N. Xusrav"},
+	"Турсунзода": {langTG: "Турсунзода", langRU: "Турсунзаде", langEN: "Tursunzoda", langUZ: "Tursunzoda"},
+}
+
+// regionDisplayName returns the localized name of a region key, or the key itself when
+// there is no translation.
+func regionDisplayName(key, lang string) string {
+	if names, ok := regionDisplayNames[key]; ok {
+		if name := names[normalizeLang(lang)]; name != "" {
+			return name
+		}
+	}
+	return key
+}
+
+// regionSlugs maps the Latin names used in deep links to region names.
+var regionSlugs = map[string]string{
+	"dushanbe":    "Душанбе",
+	"asht":        "Ашт",
+	"ayni":        "Айни",
+	"kulob":       "Кулоб",
+	"rasht":       "Рашт",
+	"hamadoni":    "Хамадони",
+	"khujand":     "Худжанд",
+	"istaravshan": "Истаравшан",
+	"isfara":      "Исфара",
+	"konibodom":   "Конибодом",
+	"khorug":      "Хоруг",
+	"murghob":     "Мургоб",
+	"shohin":      "Ш. Шохин",
+	"muminobod":   "Муъминобод",
+	"panjakent":   "Панчакент",
+	"shahritus":   "Шахритус",
+	"khusrav":     "Н. Хусрав",
+	"tursunzoda":  "Турсунзода",
+}
+
+const (
+	regionKeyboardColumns = 2
+	// regionsPerPage is how many regions fit on one page of the picker before it paginates.
+	regionsPerPage = 20
+)
+
+// regionKeyboard offers the regions the calendar store has schedules for, so a region
+// without data can never be picked.
+func (b *Bot) regionKeyboard(lang string) InlineKeyboardMarkup {
+	return regionKeyboardPage(b.orderedRegions(lang), lang, 1, regionsPerPage, regionKeyboardColumns)
+}
+
+// orderedRegions lists the regions with calendars the way the picker shows them: the
+// default region (the capital) first, then the rest alphabetically by their name in lang.
+func (b *Bot) orderedRegions(lang string) []string {
+	regions := b.calendars.Regions()
+	sort.SliceStable(regions, func(i, j int) bool {
+		if (regions[i] == b.defaultRegion) != (regions[j] == b.defaultRegion) {
+			return regions[i] == b.defaultRegion
+		}
+		return regionNameLess(regionDisplayName(regions[i], lang), regionDisplayName(regions[j], lang))
+	})
+	return regions
+}
+
+// regionCollation ranks letters in alphabet order. Plain code point order would put the
+// Tajik letters (ғ, ӣ, қ, ӯ, ҳ, ҷ) after я; here each follows its base letter.
+var regionCollation = func() map[rune]int {
+	rank := make(map[rune]int)
+	for i, r := range []rune("abcdefghijklmnopqrstuvwxyzабвгғдеёжзиӣйкқлмноөпрстуӯүфхҳцчҷшщъыьэюя") {
+		rank[r] = i + 1
+	}
+	return rank
+}()
+
+// regionNameLess compares region names letter by letter using regionCollation, ignoring
+// case and punctuation such as the dots in "Ш. Шохин" or the ‘ in "Ko‘lob".
+func regionNameLess(a, b string) bool {
+	key := func(s string) []int {
+		var out []int
+		for _, r := range strings.ToLower(s) {
+			if rank, ok := regionCollation[r]; ok {
+				out = append(out, rank)
+			} else if unicode.IsLetter(r) {
+				out = append(out, len(regionCollation)+int(r))
+			}
+		}
+		return out
+	}
+	ka, kb := key(a), key(b)
+	for i := 0; i < len(ka) && i < len(kb); i++ {
+		if ka[i] != kb[i] {
+			return ka[i] < kb[i]
+		}
+	}
+	if len(ka) != len(kb) {
+		return len(ka) < len(kb)
+	}
+	return a < b
+}
+
+// regionKeyboardPage lays out one page (1-based) of regions in a grid and adds
+// "◀ Back"/"More ▶" buttons that switch pages via regionpage:N callbacks.
+func regionKeyboardPage(regions []string, lang string, page, perPage, columns int) InlineKeyboardMarkup {
+	pages := (len(regions) + perPage - 1) / perPage
+	if pages < 1 {
+		pages = 1
+	}
+	if page < 1 {
+		page = 1
+	}
+	if page > pages {
+		page = pages
+	}
+	from := (page - 1) * perPage
+	to := from + perPage
+	if to > len(regions) {
+		to = len(regions)
+	}
+
+	var rows [][]InlineKeyboardButton
+	for i := from; i < to; i += columns {
+		var row []InlineKeyboardButton
+		for j := i; j < i+columns && j < to; j++ {
+			row = append(row, InlineKeyboardButton{Text: regionDisplayName(regions[j], lang), CallbackData: "region:" + regions[j]})
+		}
+		rows = append(rows, row)
+	}
+
+	var nav []InlineKeyboardButton
+	if page > 1 {
+		nav = append(nav, InlineKeyboardButton{Text: tr(lang, "btn_page_back"), CallbackData: fmt.Sprintf("regionpage:%d", page-1)})
+	}
+	if page < pages {
+		nav = append(nav, InlineKeyboardButton{Text: tr(lang, "btn_page_more"), CallbackData: fmt.Sprintf("regionpage:%d", page+1)})
+	}
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+	return InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+// StateStore helpers.
+type persistedStateData struct {
+	Users map[string]UserSettings `json:"users"`
+	// Members is keyed by "chatID:userID".
+	Members map[string]MemberSettings `json:"members,omitempty"`
+}
+
+func newRedisStore(rawURL string) (*redisStore, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return nil, fmt.Errorf("empty REDIS_URL")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported redis scheme %q", u.Scheme)
+	}
+
+	host := strings.TrimSpace(u.Hostname())
+	if host == "" {
+		return nil, fmt.Errorf("redis host is empty")
+	}
+	port := strings.TrimSpace(u.Port())
+	if port == "" {
+		port = "6379"
+	}
+
+	db := 0
+	if dbText := strings.TrimSpace(strings.TrimPrefix(u.Path, "/")); dbText != "" {
+		parsed, err := strconv.Atoi(dbText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis db from path %q: %w", dbText, err)
+		}
+		db = parsed
+	}
+	if dbText := strings.TrimSpace(u.Query().Get("db")); dbText != "" {
+		parsed, err := strconv.Atoi(dbText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis db from query %q: %w", dbText, err)
+		}
+		db = parsed
+	}
+
+	password := ""
+	username := ""
+	if u.User != nil {
+		username = strings.TrimSpace(u.User.Username())
+		password, _ = u.User.Password()
+	}
+
+	keyPrefix := strings.TrimSpace(os.Getenv("REDIS_KEY_PREFIX"))
+	if keyPrefix == "" {
+		keyPrefix = "ramadan-bot"
+	}
+
+	return &redisStore{
+		addr:       net.JoinHostPort(host, port),
+		useTLS:     u.Scheme == "rediss",
+		serverName: host,
+		username:   username,
+		password:   password,
+		db:         db,
+		usersKey:   keyPrefix + ":users",
+		membersKey: keyPrefix + ":members",
+		timeout:    7 * time.Second,
+	}, nil
+}
+
+func (r *redisStore) ping() error {
+	resp, err := r.do("PING")
+	if err != nil {
+		return err
+	}
+	pong, err := redisRespString(resp)
+	if err != nil {
+		return err
+	}
+	if strings.ToUpper(strings.TrimSpace(pong)) != "PONG" {
+		return fmt.Errorf("unexpected ping response: %v", pong)
+	}
+	return nil
+}
+
+func (r *redisStore) loadUsers() (map[int64]*UserSettings, error) {
+	resp, err := r.do("HGETALL", r.usersKey)
+	if err != nil {
+		return nil, err
+	}
+	items, err := redisRespArray(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[int64]*UserSettings)
+	for i := 0; i+1 < len(items); i += 2 {
+		chatIDRaw, err := redisRespString(items[i])
+		if err != nil {
+			return nil, err
+		}
+		payload, err := redisRespString(items[i+1])
+		if err != nil {
+			return nil, err
+		}
+		chatID, err := strconv.ParseInt(strings.TrimSpace(chatIDRaw), 10, 64)
+		if err != nil {
+			log.Printf("skip invalid chat id in redis state: %q", chatIDRaw)
+			continue
+		}
+		var settings UserSettings
+		if err := json.Unmarshal([]byte(payload), &settings); err != nil {
+			log.Printf("skip invalid redis payload for chat %d: %v", chatID, err)
+			continue
+		}
+		settings.Language = normalizeLang(settings.Language)
+		copySettings := settings
+		users[chatID] = &copySettings
+	}
+	return users, nil
+}
+
+func (r *redisStore) saveUser(chatID int64, settings *UserSettings) error {
+	if settings == nil {
+		return nil
+	}
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	_, err = r.do("HSET", r.usersKey, strconv.FormatInt(chatID, 10), string(raw))
+	return err
+}
+
+func (r *redisStore) loadMembers() (map[memberKey]*MemberSettings, error) {
+	resp, err := r.do("HGETALL", r.membersKey)
+	if err != nil {
+		return nil, err
+	}
+	items, err := redisRespArray(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[memberKey]*MemberSettings)
+	for i := 0; i+1 < len(items); i += 2 {
+		keyRaw, err := redisRespString(items[i])
+		if err != nil {
+			return nil, err
+		}
+		payload, err := redisRespString(items[i+1])
+		if err != nil {
+			return nil, err
+		}
+		key, ok := parseMemberKey(keyRaw)
+		if !ok {
+			log.Printf("skip invalid member key in redis state: %q", keyRaw)
+			continue
+		}
+		var settings MemberSettings
+		if err := json.Unmarshal([]byte(payload), &settings); err != nil {
+			log.Printf("skip invalid redis payload for member %s: %v", key, err)
+			continue
+		}
+		members[key] = &settings
+	}
+	return members, nil
+}
+
+// saveMember stores a member's settings; nil removes them.
+func (r *redisStore) saveMember(key memberKey, settings *MemberSettings) error {
+	if settings == nil {
+		_, err := r.do("HDEL", r.membersKey, key.String())
+		return err
+	}
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	_, err = r.do("HSET", r.membersKey, key.String(), string(raw))
+	return err
+}
+
+func (r *redisStore) do(args ...string) (interface{}, error) {
+	conn, reader, err := r.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := r.sessionSetup(conn, reader); err != nil {
+		return nil, err
+	}
+	if err := redisWriteCommand(conn, args...); err != nil {
+		return nil, err
+	}
+	resp, err := redisReadResponse(reader)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (r *redisStore) dial() (net.Conn, *bufio.Reader, error) {
+	dialer := &net.Dialer{Timeout: r.timeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if r.useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", r.addr, &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ServerName: r.serverName,
+		})
+	} else {
+		conn, err = dialer.Dial("tcp", r.addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(r.timeout)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, bufio.NewReader(conn), nil
+}
+
+func (r *redisStore) sessionSetup(conn net.Conn, reader *bufio.Reader) error {
+	if r.password != "" || r.username != "" {
+		if r.username != "" {
+			if err := redisExecuteSimple(conn, reader, "AUTH", r.username, r.password); err != nil {
+				return err
+			}
+		} else {
+			if err := redisExecuteSimple(conn, reader, "AUTH", r.password); err != nil {
+				return err
+			}
+		}
+	}
+	if r.db > 0 {
+		if err := redisExecuteSimple(conn, reader, "SELECT", strconv.Itoa(r.db)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func redisExecuteSimple(conn net.Conn, reader *bufio.Reader, args ...string) error {
+	if err := redisWriteCommand(conn, args...); err != nil {
+		return err
+	}
+	_, err := redisReadResponse(reader)
+	return err
+}
+
+func redisWriteCommand(conn net.Conn, args ...string) error {
+	var b strings.Builder
+	b.WriteString("*")
+	b.WriteString(strconv.Itoa(len(args)))
+	b.WriteString("\r\n")
+	for _, arg := range args {
+		b.WriteString("$")
+		b.WriteString(strconv.Itoa(len(arg)))
+		b.WriteString("\r\n")
+		b.WriteString(arg)
+		b.WriteString("\r\n")
+	}
+	_, err := conn.Write([]byte(b.String()))
+	return err
+}
+
+func redisReadResponse(reader *bufio.Reader) (interface{}, error) {
+	prefix, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch prefix {
+	case '+':
+		return redisReadLine(reader)
+	case '-':
+		line, err := redisReadLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("redis error: %s", line)
+	case ':':
+		line, err := redisReadLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		num, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return num, nil
+	case '$':
+		line, err := redisReadLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		if size == -1 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		line, err := redisReadLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		count, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		if count == -1 {
+			return nil, nil
+		}
+		out := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			item, err := redisReadResponse(reader)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, item)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported redis response prefix: %q", prefix)
+	}
+}
+
+func redisReadLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+func redisRespArray(v interface{}) ([]interface{}, error) {
+	if v == nil {
+		return []interface{}{}, nil
+	}
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected redis response type %T", v)
+	}
+	return arr, nil
+}
+
+func redisRespString(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	switch typed := v.(type) {
+	case string:
+		return typed, nil
+	case int64:
+		return strconv.FormatInt(typed, 10), nil
+	default:
+		return "", fmt.Errorf("unexpected redis string type %T", v)
+	}
+}
+
+func newStateStore(path string) (*StateStore, error) {
+	store := &StateStore{
+		users:          make(map[int64]*UserSettings),
+		members:        make(map[memberKey]*MemberSettings),
+		calendarTokens: make(map[string]int64),
+		latestMember:   make(map[int64]memberKey),
+		persistPath:    strings.TrimSpace(path),
+	}
+
+	redisURL := strings.TrimSpace(os.Getenv("REDIS_URL"))
+	if redisURL != "" {
+		rs, err := newRedisStore(redisURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		if err := rs.ping(); err != nil {
+			return nil, fmt.Errorf("cannot connect to redis: %w", err)
+		}
+		store.redis = rs
+		if err := store.loadFromRedis(); err != nil {
+			return nil, err
+		}
+		if len(store.users) == 0 && strings.TrimSpace(store.persistPath) != "" {
+			if err := store.loadFromDisk(); err != nil {
+				log.Printf("state migration load from file failed: %v", err)
+			} else if len(store.users) > 0 {
+				if err := store.syncAllUsersToRedis(); err != nil {
+					log.Printf("state migration file->redis failed: %v", err)
+				} else {
+					log.Printf("Migrated %d users from file state to redis", len(store.users))
+				}
+			}
+		}
+		log.Printf("State backend: redis (%s db=%d key=%s)", rs.addr, rs.db, rs.usersKey)
+		return store, nil
+	}
+
+	if err := store.loadFromDisk(); err != nil {
+		return nil, err
+	}
+	log.Printf("State backend: file (%s)", store.persistPath)
+	return store, nil
+}
+
+// Get returns a copy of the chat's settings, creating an empty entry for new chats.
+// Updates are handled concurrently, so callers must change settings through the setters.
+func (s *StateStore) Get(chatID int64) *UserSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
+	}
+	copied := *settings
+	return &copied
+}
+
+// Member returns the member's own settings in a group chat, if they saved any.
+func (s *StateStore) Member(chatID, userID int64) (MemberSettings, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings, ok := s.members[memberKey{ChatID: chatID, UserID: userID}]
+	if !ok {
+		return MemberSettings{}, false
+	}
+	return *settings, true
+}
+
+// MemberLanguage returns the language userID chose most recently in any group, for
+// places without a chat such as inline queries.
+func (s *StateStore) MemberLanguage(userID int64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.latestMember[userID]
+	if !ok || s.members[key] == nil {
+		return ""
+	}
+	return s.members[key].Language
+}
+
+// indexMemberLocked makes key the member's latest language if it was set after the
+// current one; equal times fall back to the lower chat ID so reloads agree.
+func (s *StateStore) indexMemberLocked(key memberKey) {
+	settings := s.members[key]
+	if settings == nil || settings.Language == "" {
+		return
+	}
+	if current, ok := s.latestMember[key.UserID]; ok && current != key {
+		if other := s.members[current]; other != nil {
+			if other.SetAt.After(settings.SetAt) || (other.SetAt.Equal(settings.SetAt) && current.ChatID < key.ChatID) {
+				return
+			}
+		}
+	}
+	s.latestMember[key.UserID] = key
+}
+
+// reindexMemberLocked picks the user's latest language again after key was dropped.
+func (s *StateStore) reindexMemberLocked(key memberKey) {
+	if s.latestMember[key.UserID] != key {
+		return
+	}
+	delete(s.latestMember, key.UserID)
+	for other := range s.members {
+		if other.UserID == key.UserID {
+			s.indexMemberLocked(other)
+		}
+	}
+}
+
+// SetMemberLanguage sets the member's own language in a group chat; an empty lang
+// drops it so the group's language applies again.
+func (s *StateStore) SetMemberLanguage(chatID, userID int64, lang string) {
+	key := memberKey{ChatID: chatID, UserID: userID}
+	s.mu.Lock()
+	var copySettings *MemberSettings
+	if lang = normalizeLang(lang); lang == "" {
+		delete(s.members, key)
+		s.reindexMemberLocked(key)
+	} else {
+		settings, ok := s.members[key]
+		if !ok {
+			settings = &MemberSettings{}
+			s.members[key] = settings
+		}
+		settings.Language = lang
+		settings.SetAt = time.Now().UTC()
+		s.latestMember[userID] = key
+		copied := *settings
+		copySettings = &copied
+	}
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
+
+	if rs != nil {
+		if err := rs.saveMember(key, copySettings); err != nil {
+			log.Printf("state persist error (SetMemberLanguage redis): %v", err)
+		}
+		return
+	}
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetMemberLanguage): %v", err)
+	}
+}
+
+// Lookup returns a copy of the stored settings without creating an entry for unknown chats.
+func (s *StateStore) Lookup(chatID int64) (UserSettings, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		return UserSettings{}, false
+	}
+	return *settings, true
+}
+
+func (s *StateStore) SetRegion(chatID int64, region string) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
+	}
+	settings.Region = region
+	settings.Notifications = true
+	settings.RegionSelected = true
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
+
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetRegion redis): %v", err)
+		}
+		return
 	}
-	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
-		return fmt.Errorf("decode %s: %w", rawURL, err)
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetRegion): %v", err)
 	}
-	return nil
 }
 
-func (b *Bot) sendTestNotification(chatID int64) {
-	settings := b.state.Get(chatID)
-	lang := b.userLang(chatID)
-	region := strings.TrimSpace(settings.Region)
-	if region == "" {
-		region = b.defaultRegion
-		if err := b.SendMessage(chatID, trf(lang, "test_region_default", region), nil); err != nil {
-			log.Printf("test notify region info send error: %v", err)
-		}
+func (s *StateStore) SetLanguage(chatID int64, lang string) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
 	}
+	settings.Language = normalizeLang(lang)
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
 
-	dayNumber := 1
-	if schedule, ok := b.calendars[region]; ok {
-		if day := currentDaySchedule(schedule, b.ramadanStart, b.tz); day != nil && day.Day > 0 {
-			dayNumber = day.Day
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetLanguage redis): %v", err)
 		}
+		return
 	}
-
-	ev := eventSpec{
-		Key:   "test",
-		Title: tr(lang, "test_notification_title"),
-		Time:  time.Now().In(b.tz).Add(30 * time.Minute),
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetLanguage): %v", err)
 	}
-	b.scheduler.sendReminder(chatID, region, dayNumber, ev)
 }
 
-func (b *Bot) setNotifications(chatID int64, enabled bool) {
-	settings := b.state.Get(chatID)
-	lang := b.userLang(chatID)
-	if settings.Region == "" {
-		b.promptRegion(chatID, tr(lang, "need_region_notify"))
+func (s *StateStore) SetNotifications(chatID int64, enabled bool) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
+	}
+	settings.Notifications = enabled
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
+
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetNotifications redis): %v", err)
+		}
 		return
 	}
-	b.state.SetNotifications(chatID, enabled)
-	if enabled {
-		b.scheduler.Start(chatID, settings.Region)
-		b.SendMessage(chatID, tr(lang, "notify_enabled"), nil)
-	} else {
-		b.scheduler.Stop(chatID)
-		b.SendMessage(chatID, tr(lang, "notify_disabled"), nil)
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetNotifications): %v", err)
 	}
 }
 
-func (b *Bot) menuKeyboard(lang string) ReplyKeyboardMarkup {
-	return ReplyKeyboardMarkup{
-		Keyboard: [][]KeyboardButton{
-			{
-				{Text: tr(lang, "btn_hadiths")},
-				{Text: tr(lang, "btn_calendar")},
-				{Text: tr(lang, "btn_today")},
-			},
-			{
-				{Text: tr(lang, "btn_region")},
-				{Text: tr(lang, "btn_lang")},
-			},
-			{
-				{Text: tr(lang, "btn_notify_on")},
-				{Text: tr(lang, "btn_notify_off")},
-			},
-			{
-				{Text: tr(lang, "btn_help")},
-			},
-		},
-		ResizeKeyboard: true,
-		IsPersistent:   true,
+func (s *StateStore) SetTheme(chatID int64, theme string) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
 	}
-}
+	settings.Theme = theme
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
 
-func (b *Bot) languageKeyboard() InlineKeyboardMarkup {
-	return InlineKeyboardMarkup{
-		InlineKeyboard: [][]InlineKeyboardButton{
-			{
-				{Text: "Тоҷикӣ", CallbackData: "lang:" + langTG},
-				{Text: "Русский", CallbackData: "lang:" + langRU},
-			},
-			{
-				{Text: "English", CallbackData: "lang:" + langEN},
-				{Text: "O'zbek", CallbackData: "lang:" + langUZ},
-			},
-		},
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetTheme redis): %v", err)
+		}
+		return
+	}
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetTheme): %v", err)
 	}
 }
 
-func (b *Bot) regionKeyboard() InlineKeyboardMarkup {
-	regions := []string{
-		"Душанбе",
-		"Ашт",
-		"Айни",
-		"Кулоб",
-		"Рашт",
-		"Хамадони",
-		"Худжанд",
-		"Истаравшан",
-		"Исфара",
-		"Конибодом",
-		"Хоруг",
-		"Мургоб",
-		"Ш. Шохин",
-		"Муъминобод",
-		"Панчакент",
-		"Шахритус",
-		"Н. Хусрав",
-		"Турсунзода",
+func (s *StateStore) SetTahajjud(chatID int64, enabled bool) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
 	}
+	settings.Tahajjud = enabled
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
 
-	var rows [][]InlineKeyboardButton
-	for _, r := range regions {
-		rows = append(rows, []InlineKeyboardButton{
-			{Text: r, CallbackData: "region:" + r},
-		})
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetTahajjud redis): %v", err)
+		}
+		return
+	}
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetTahajjud): %v", err)
 	}
-	return InlineKeyboardMarkup{InlineKeyboard: rows}
-}
-
-// StateStore helpers.
-type persistedStateData struct {
-	Users map[string]UserSettings `json:"users"`
 }
 
-func newRedisStore(rawURL string) (*redisStore, error) {
-	rawURL = strings.TrimSpace(rawURL)
-	if rawURL == "" {
-		return nil, fmt.Errorf("empty REDIS_URL")
+func (s *StateStore) SetHadithCard(chatID int64, enabled bool) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
 	}
-	u, err := url.Parse(rawURL)
-	if err != nil {
-		return nil, err
+	settings.HadithCard = enabled
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
+
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetHadithCard redis): %v", err)
+		}
+		return
 	}
-	if u.Scheme != "redis" && u.Scheme != "rediss" {
-		return nil, fmt.Errorf("unsupported redis scheme %q", u.Scheme)
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetHadithCard): %v", err)
 	}
+}
 
-	host := strings.TrimSpace(u.Hostname())
-	if host == "" {
-		return nil, fmt.Errorf("redis host is empty")
-	}
-	port := strings.TrimSpace(u.Port())
-	if port == "" {
-		port = "6379"
+func (s *StateStore) SetAyahCard(chatID int64, enabled bool) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
 	}
+	settings.AyahCard = enabled
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
 
-	db := 0
-	if dbText := strings.TrimSpace(strings.TrimPrefix(u.Path, "/")); dbText != "" {
-		parsed, err := strconv.Atoi(dbText)
-		if err != nil {
-			return nil, fmt.Errorf("invalid redis db from path %q: %w", dbText, err)
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetAyahCard redis): %v", err)
 		}
-		db = parsed
+		return
 	}
-	if dbText := strings.TrimSpace(u.Query().Get("db")); dbText != "" {
-		parsed, err := strconv.Atoi(dbText)
-		if err != nil {
-			return nil, fmt.Errorf("invalid redis db from query %q: %w", dbText, err)
-		}
-		db = parsed
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetAyahCard): %v", err)
 	}
+}
 
-	password := ""
-	username := ""
-	if u.User != nil {
-		username = strings.TrimSpace(u.User.Username())
-		password, _ = u.User.Password()
+func (s *StateStore) SetMenuHidden(chatID int64, hidden bool) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
 	}
+	settings.MenuHidden = hidden
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
 
-	keyPrefix := strings.TrimSpace(os.Getenv("REDIS_KEY_PREFIX"))
-	if keyPrefix == "" {
-		keyPrefix = "ramadan-bot"
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetMenuHidden redis): %v", err)
+		}
+		return
+	}
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetMenuHidden): %v", err)
 	}
-
-	return &redisStore{
-		addr:       net.JoinHostPort(host, port),
-		useTLS:     u.Scheme == "rediss",
-		serverName: host,
-		username:   username,
-		password:   password,
-		db:         db,
-		usersKey:   keyPrefix + ":users",
-		timeout:    7 * time.Second,
-	}, nil
 }
 
-func (r *redisStore) ping() error {
-	resp, err := r.do("PING")
-	if err != nil {
-		return err
+func (s *StateStore) SetImagesDisabled(chatID int64, disabled bool) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
 	}
-	pong, err := redisRespString(resp)
-	if err != nil {
-		return err
+	settings.ImagesDisabled = disabled
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
+
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetImagesDisabled redis): %v", err)
+		}
+		return
 	}
-	if strings.ToUpper(strings.TrimSpace(pong)) != "PONG" {
-		return fmt.Errorf("unexpected ping response: %v", pong)
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetImagesDisabled): %v", err)
 	}
-	return nil
 }
 
-func (r *redisStore) loadUsers() (map[int64]*UserSettings, error) {
-	resp, err := r.do("HGETALL", r.usersKey)
-	if err != nil {
-		return nil, err
-	}
-	items, err := redisRespArray(resp)
-	if err != nil {
-		return nil, err
+func (s *StateStore) SetAsrMethod(chatID int64, method string) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
 	}
+	settings.AsrMethod = method
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
 
-	users := make(map[int64]*UserSettings)
-	for i := 0; i+1 < len(items); i += 2 {
-		chatIDRaw, err := redisRespString(items[i])
-		if err != nil {
-			return nil, err
-		}
-		payload, err := redisRespString(items[i+1])
-		if err != nil {
-			return nil, err
-		}
-		chatID, err := strconv.ParseInt(strings.TrimSpace(chatIDRaw), 10, 64)
-		if err != nil {
-			log.Printf("skip invalid chat id in redis state: %q", chatIDRaw)
-			continue
-		}
-		var settings UserSettings
-		if err := json.Unmarshal([]byte(payload), &settings); err != nil {
-			log.Printf("skip invalid redis payload for chat %d: %v", chatID, err)
-			continue
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetAsrMethod redis): %v", err)
 		}
-		settings.Language = normalizeLang(settings.Language)
-		copySettings := settings
-		users[chatID] = &copySettings
-	}
-	return users, nil
-}
-
-func (r *redisStore) saveUser(chatID int64, settings *UserSettings) error {
-	if settings == nil {
-		return nil
+		return
 	}
-	raw, err := json.Marshal(settings)
-	if err != nil {
-		return err
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetAsrMethod): %v", err)
 	}
-	_, err = r.do("HSET", r.usersKey, strconv.FormatInt(chatID, 10), string(raw))
-	return err
 }
 
-func (r *redisStore) do(args ...string) (interface{}, error) {
-	conn, reader, err := r.dial()
-	if err != nil {
-		return nil, err
+func (s *StateStore) SetQuietHours(chatID int64, from, to int) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
 	}
-	defer conn.Close()
+	settings.QuietFrom = from
+	settings.QuietTo = to
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
 
-	if err := r.sessionSetup(conn, reader); err != nil {
-		return nil, err
-	}
-	if err := redisWriteCommand(conn, args...); err != nil {
-		return nil, err
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetQuietHours redis): %v", err)
+		}
+		return
 	}
-	resp, err := redisReadResponse(reader)
-	if err != nil {
-		return nil, err
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetQuietHours): %v", err)
 	}
-	return resp, nil
 }
 
-func (r *redisStore) dial() (net.Conn, *bufio.Reader, error) {
-	dialer := &net.Dialer{Timeout: r.timeout}
-	var (
-		conn net.Conn
-		err  error
-	)
-	if r.useTLS {
-		conn, err = tls.DialWithDialer(dialer, "tcp", r.addr, &tls.Config{
-			MinVersion: tls.VersionTLS12,
-			ServerName: r.serverName,
-		})
-	} else {
-		conn, err = dialer.Dial("tcp", r.addr)
-	}
-	if err != nil {
-		return nil, nil, err
+// SetMutedUntil mutes the chat's reminders until until; the zero time unmutes it.
+func (s *StateStore) SetMutedUntil(chatID int64, until time.Time) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
 	}
-	if err := conn.SetDeadline(time.Now().Add(r.timeout)); err != nil {
-		conn.Close()
-		return nil, nil, err
+	settings.MutedUntil = nil
+	if !until.IsZero() {
+		settings.MutedUntil = &until
 	}
-	return conn, bufio.NewReader(conn), nil
-}
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
 
-func (r *redisStore) sessionSetup(conn net.Conn, reader *bufio.Reader) error {
-	if r.password != "" || r.username != "" {
-		if r.username != "" {
-			if err := redisExecuteSimple(conn, reader, "AUTH", r.username, r.password); err != nil {
-				return err
-			}
-		} else {
-			if err := redisExecuteSimple(conn, reader, "AUTH", r.password); err != nil {
-				return err
-			}
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetMutedUntil redis): %v", err)
 		}
+		return
 	}
-	if r.db > 0 {
-		if err := redisExecuteSimple(conn, reader, "SELECT", strconv.Itoa(r.db)); err != nil {
-			return err
-		}
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetMutedUntil): %v", err)
 	}
-	return nil
 }
 
-func redisExecuteSimple(conn net.Conn, reader *bufio.Reader, args ...string) error {
-	if err := redisWriteCommand(conn, args...); err != nil {
-		return err
-	}
-	_, err := redisReadResponse(reader)
-	return err
+// EnsureCalendarToken returns the chat's calendar feed token, creating a random one on
+// first use. It returns "" if no token could be generated.
+func (s *StateStore) EnsureCalendarToken(chatID int64) string {
+	return s.calendarToken(chatID, false)
 }
 
-func redisWriteCommand(conn net.Conn, args ...string) error {
-	var b strings.Builder
-	b.WriteString("*")
-	b.WriteString(strconv.Itoa(len(args)))
-	b.WriteString("\r\n")
-	for _, arg := range args {
-		b.WriteString("$")
-		b.WriteString(strconv.Itoa(len(arg)))
-		b.WriteString("\r\n")
-		b.WriteString(arg)
-		b.WriteString("\r\n")
-	}
-	_, err := conn.Write([]byte(b.String()))
-	return err
+// ResetCalendarToken replaces the chat's calendar feed token, so the old feed address
+// stops working. It returns "" if no token could be generated.
+func (s *StateStore) ResetCalendarToken(chatID int64) string {
+	return s.calendarToken(chatID, true)
 }
 
-func redisReadResponse(reader *bufio.Reader) (interface{}, error) {
-	prefix, err := reader.ReadByte()
-	if err != nil {
-		return nil, err
+func (s *StateStore) calendarToken(chatID int64, rotate bool) string {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
+	}
+	if settings.CalendarToken != "" && !rotate {
+		token := settings.CalendarToken
+		s.mu.Unlock()
+		return token
 	}
+	raw := make([]byte, 16)
+	if _, err := crand.Read(raw); err != nil {
+		s.mu.Unlock()
+		log.Printf("calendar token error: %v", err)
+		return ""
+	}
+	delete(s.calendarTokens, settings.CalendarToken)
+	settings.CalendarToken = hex.EncodeToString(raw)
+	s.calendarTokens[settings.CalendarToken] = chatID
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
 
-	switch prefix {
-	case '+':
-		return redisReadLine(reader)
-	case '-':
-		line, err := redisReadLine(reader)
-		if err != nil {
-			return nil, err
-		}
-		return nil, fmt.Errorf("redis error: %s", line)
-	case ':':
-		line, err := redisReadLine(reader)
-		if err != nil {
-			return nil, err
-		}
-		num, err := strconv.ParseInt(line, 10, 64)
-		if err != nil {
-			return nil, err
-		}
-		return num, nil
-	case '$':
-		line, err := redisReadLine(reader)
-		if err != nil {
-			return nil, err
-		}
-		size, err := strconv.Atoi(line)
-		if err != nil {
-			return nil, err
-		}
-		if size == -1 {
-			return nil, nil
-		}
-		data := make([]byte, size+2)
-		if _, err := io.ReadFull(reader, data); err != nil {
-			return nil, err
-		}
-		return string(data[:size]), nil
-	case '*':
-		line, err := redisReadLine(reader)
-		if err != nil {
-			return nil, err
-		}
-		count, err := strconv.Atoi(line)
-		if err != nil {
-			return nil, err
-		}
-		if count == -1 {
-			return nil, nil
-		}
-		out := make([]interface{}, 0, count)
-		for i := 0; i < count; i++ {
-			item, err := redisReadResponse(reader)
-			if err != nil {
-				return nil, err
-			}
-			out = append(out, item)
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (calendarToken redis): %v", err)
 		}
-		return out, nil
-	default:
-		return nil, fmt.Errorf("unsupported redis response prefix: %q", prefix)
+		return copySettings.CalendarToken
+	}
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (calendarToken): %v", err)
 	}
+	return copySettings.CalendarToken
 }
 
-func redisReadLine(reader *bufio.Reader) (string, error) {
-	line, err := reader.ReadString('\n')
-	if err != nil {
-		return "", err
+// ChatByCalendarToken returns the settings of the chat that owns a calendar feed token.
+func (s *StateStore) ChatByCalendarToken(token string) (UserSettings, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chatID, ok := s.calendarTokens[token]
+	if !ok || s.users[chatID] == nil {
+		return UserSettings{}, false
 	}
-	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+	return *s.users[chatID], true
 }
 
-func redisRespArray(v interface{}) ([]interface{}, error) {
-	if v == nil {
-		return []interface{}{}, nil
+func (s *StateStore) SetDigest(chatID int64, enabled bool, leadMinutes int) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
 	}
-	arr, ok := v.([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("unexpected redis response type %T", v)
+	settings.DailyDigest = enabled
+	if leadMinutes > 0 {
+		settings.DigestLead = leadMinutes
 	}
-	return arr, nil
-}
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
 
-func redisRespString(v interface{}) (string, error) {
-	if v == nil {
-		return "", nil
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetDigest redis): %v", err)
+		}
+		return
 	}
-	switch typed := v.(type) {
-	case string:
-		return typed, nil
-	case int64:
-		return strconv.FormatInt(typed, 10), nil
-	default:
-		return "", fmt.Errorf("unexpected redis string type %T", v)
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetDigest): %v", err)
 	}
 }
 
-func newStateStore(path string) (*StateStore, error) {
-	store := &StateStore{
-		users:       make(map[int64]*UserSettings),
-		persistPath: strings.TrimSpace(path),
+// SetTasbih stores the tasbih counter and its target (0 for no target).
+func (s *StateStore) SetTasbih(chatID int64, count, target int) {
+	s.mu.Lock()
+	settings, ok := s.users[chatID]
+	if !ok {
+		settings = &UserSettings{}
+		s.users[chatID] = settings
 	}
+	settings.TasbihCount = count
+	settings.TasbihTarget = target
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
 
-	redisURL := strings.TrimSpace(os.Getenv("REDIS_URL"))
-	if redisURL != "" {
-		rs, err := newRedisStore(redisURL)
-		if err != nil {
-			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
-		}
-		if err := rs.ping(); err != nil {
-			return nil, fmt.Errorf("cannot connect to redis: %w", err)
-		}
-		store.redis = rs
-		if err := store.loadFromRedis(); err != nil {
-			return nil, err
-		}
-		if len(store.users) == 0 && strings.TrimSpace(store.persistPath) != "" {
-			if err := store.loadFromDisk(); err != nil {
-				log.Printf("state migration load from file failed: %v", err)
-			} else if len(store.users) > 0 {
-				if err := store.syncAllUsersToRedis(); err != nil {
-					log.Printf("state migration file->redis failed: %v", err)
-				} else {
-					log.Printf("Migrated %d users from file state to redis", len(store.users))
-				}
-			}
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (SetTasbih redis): %v", err)
 		}
-		log.Printf("State backend: redis (%s db=%d key=%s)", rs.addr, rs.db, rs.usersKey)
-		return store, nil
+		return
 	}
-
-	if err := store.loadFromDisk(); err != nil {
-		return nil, err
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (SetTasbih): %v", err)
 	}
-	log.Printf("State backend: file (%s)", store.persistPath)
-	return store, nil
 }
 
-func (s *StateStore) Get(chatID int64) *UserSettings {
+// IncrementTasbih adds one to the tasbih counter under the lock so fast taps are not lost,
+// and returns the updated settings.
+func (s *StateStore) IncrementTasbih(chatID int64) UserSettings {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	settings, ok := s.users[chatID]
 	if !ok {
 		settings = &UserSettings{}
 		s.users[chatID] = settings
 	}
-	return settings
+	settings.TasbihCount++
+	copySettings := *settings
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	rs := s.redis
+	s.mu.Unlock()
+
+	if rs != nil {
+		if err := rs.saveUser(chatID, &copySettings); err != nil {
+			log.Printf("state persist error (IncrementTasbih redis): %v", err)
+		}
+		return copySettings
+	}
+	if err := writeStateSnapshot(path, snapshot); err != nil {
+		log.Printf("state persist error (IncrementTasbih): %v", err)
+	}
+	return copySettings
 }
 
-func (s *StateStore) SetRegion(chatID int64, region string) {
+// ConfirmFast records that the chat fasted on Ramadan day of season. It reports false,
+// and changes nothing, when that day was already confirmed. Counters from an earlier
+// season are reset first.
+func (s *StateStore) ConfirmFast(chatID int64, season string, day int) (UserSettings, bool) {
 	s.mu.Lock()
 	settings, ok := s.users[chatID]
 	if !ok {
 		settings = &UserSettings{}
 		s.users[chatID] = settings
 	}
-	settings.Region = region
-	settings.Notifications = true
-	settings.RegionSelected = true
+	if settings.FastSeason != season {
+		settings.FastSeason = season
+		settings.FastCount, settings.FastStreak, settings.LastFastDay = 0, 0, 0
+		settings.FastDays = nil
+	}
+	if settings.fastConfirmed(day) {
+		copySettings := *settings
+		s.mu.Unlock()
+		return copySettings, false
+	}
+	settings.FastDays = append(slices.Clone(settings.FastDays), day)
+	slices.Sort(settings.FastDays)
+	settings.FastCount++
+	settings.LastFastDay = max(settings.LastFastDay, day)
+	// The streak is the run of consecutive confirmed days ending at the latest one.
+	settings.FastStreak = 1
+	for i := len(settings.FastDays) - 1; i > 0 && settings.FastDays[i-1] == settings.FastDays[i]-1; i-- {
+		settings.FastStreak++
+	}
 	copySettings := *settings
 	snapshot := s.snapshotLocked()
 	path := s.persistPath
@@ -1825,48 +6758,86 @@ func (s *StateStore) SetRegion(chatID int64, region string) {
 
 	if rs != nil {
 		if err := rs.saveUser(chatID, &copySettings); err != nil {
-			log.Printf("state persist error (SetRegion redis): %v", err)
+			log.Printf("state persist error (ConfirmFast redis): %v", err)
 		}
-		return
+		return copySettings, true
 	}
 	if err := writeStateSnapshot(path, snapshot); err != nil {
-		log.Printf("state persist error (SetRegion): %v", err)
+		log.Printf("state persist error (ConfirmFast): %v", err)
 	}
+	return copySettings, true
 }
 
-func (s *StateStore) SetLanguage(chatID int64, lang string) {
+// SentReminders returns the reminder keys already sent to the chat on date.
+func (s *StateStore) SentReminders(chatID int64, date string) map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sent := make(map[string]bool)
+	if settings, ok := s.users[chatID]; ok && settings.SentDate == date {
+		for _, key := range settings.SentReminders {
+			sent[key] = true
+		}
+	}
+	return sent
+}
+
+// sentFlushDelay batches sent-reminder writes to the state file: every chat's reminder
+// fires at nearly the same moment, and rewriting the whole file for each would cost
+// O(chats) per reminder. A crash loses at most this window, which the restart covers
+// by skipping reminders whose moment has passed.
+const sentFlushDelay = 30 * time.Second
+
+// MarkReminderSent records that key went out on date. A new date starts a fresh list.
+// With the file backend the write is batched; see sentFlushDelay.
+func (s *StateStore) MarkReminderSent(chatID int64, date, key string) {
 	s.mu.Lock()
 	settings, ok := s.users[chatID]
 	if !ok {
 		settings = &UserSettings{}
 		s.users[chatID] = settings
 	}
-	settings.Language = normalizeLang(lang)
+	if settings.SentDate != date {
+		settings.SentDate = date
+		settings.SentReminders = nil
+	}
+	settings.SentReminders = append(settings.SentReminders, key)
 	copySettings := *settings
-	snapshot := s.snapshotLocked()
-	path := s.persistPath
 	rs := s.redis
+	if rs == nil && s.sentFlush == nil && s.persistPath != "" {
+		s.sentFlush = time.AfterFunc(sentFlushDelay, s.flushSentReminders)
+	}
 	s.mu.Unlock()
 
 	if rs != nil {
 		if err := rs.saveUser(chatID, &copySettings); err != nil {
-			log.Printf("state persist error (SetLanguage redis): %v", err)
+			log.Printf("state persist error (MarkReminderSent redis): %v", err)
 		}
-		return
 	}
+}
+
+// flushSentReminders writes the reminders marked since the last flush to the state file.
+func (s *StateStore) flushSentReminders() {
+	s.mu.Lock()
+	s.sentFlush = nil
+	snapshot := s.snapshotLocked()
+	path := s.persistPath
+	s.mu.Unlock()
+
 	if err := writeStateSnapshot(path, snapshot); err != nil {
-		log.Printf("state persist error (SetLanguage): %v", err)
+		log.Printf("state persist error (MarkReminderSent): %v", err)
 	}
 }
 
-func (s *StateStore) SetNotifications(chatID int64, enabled bool) {
+// SetBlocked records whether the chat has blocked the bot. Blocked chats are left out of
+// reminder scheduling and broadcasts until they talk to the bot again.
+func (s *StateStore) SetBlocked(chatID int64, blocked bool) {
 	s.mu.Lock()
 	settings, ok := s.users[chatID]
 	if !ok {
 		settings = &UserSettings{}
 		s.users[chatID] = settings
 	}
-	settings.Notifications = enabled
+	settings.Blocked = blocked
 	copySettings := *settings
 	snapshot := s.snapshotLocked()
 	path := s.persistPath
@@ -1875,12 +6846,12 @@ func (s *StateStore) SetNotifications(chatID int64, enabled bool) {
 
 	if rs != nil {
 		if err := rs.saveUser(chatID, &copySettings); err != nil {
-			log.Printf("state persist error (SetNotifications redis): %v", err)
+			log.Printf("state persist error (SetBlocked redis): %v", err)
 		}
 		return
 	}
 	if err := writeStateSnapshot(path, snapshot); err != nil {
-		log.Printf("state persist error (SetNotifications): %v", err)
+		log.Printf("state persist error (SetBlocked): %v", err)
 	}
 }
 
@@ -1894,7 +6865,7 @@ func (s *StateStore) ActiveNotificationRegions() map[int64]string {
 			continue
 		}
 		region := strings.TrimSpace(settings.Region)
-		if !settings.Notifications || region == "" {
+		if !settings.Notifications || settings.Blocked || region == "" {
 			continue
 		}
 		result[chatID] = region
@@ -1948,6 +6919,19 @@ func (s *StateStore) loadFromDisk() error {
 		}
 		copySettings := settings
 		s.users[chatID] = &copySettings
+		if copySettings.CalendarToken != "" {
+			s.calendarTokens[copySettings.CalendarToken] = chatID
+		}
+	}
+	for raw, settings := range data.Members {
+		key, ok := parseMemberKey(raw)
+		if !ok {
+			log.Printf("skip invalid member key in persisted state: %q", raw)
+			continue
+		}
+		copySettings := settings
+		s.members[key] = &copySettings
+		s.indexMemberLocked(key)
 	}
 	return nil
 }
@@ -1960,6 +6944,10 @@ func (s *StateStore) loadFromRedis() error {
 	if err != nil {
 		return err
 	}
+	members, err := s.redis.loadMembers()
+	if err != nil {
+		return err
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -1969,6 +6957,13 @@ func (s *StateStore) loadFromRedis() error {
 		}
 		copySettings := *settings
 		s.users[chatID] = &copySettings
+		if copySettings.CalendarToken != "" {
+			s.calendarTokens[copySettings.CalendarToken] = chatID
+		}
+	}
+	for key, settings := range members {
+		s.members[key] = settings
+		s.indexMemberLocked(key)
 	}
 	return nil
 }
@@ -1985,6 +6980,10 @@ func (s *StateStore) syncAllUsersToRedis() error {
 		}
 		local[chatID] = *settings
 	}
+	localMembers := make(map[memberKey]MemberSettings, len(s.members))
+	for key, settings := range s.members {
+		localMembers[key] = *settings
+	}
 	s.mu.Unlock()
 
 	for chatID, settings := range local {
@@ -1993,28 +6992,53 @@ func (s *StateStore) syncAllUsersToRedis() error {
 			return err
 		}
 	}
+	for key, settings := range localMembers {
+		copySettings := settings
+		if err := s.redis.saveMember(key, &copySettings); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (s *StateStore) snapshotLocked() map[string]UserSettings {
-	out := make(map[string]UserSettings, len(s.users))
+func (s *StateStore) snapshotLocked() persistedStateData {
+	out := persistedStateData{Users: make(map[string]UserSettings, len(s.users))}
 	for chatID, settings := range s.users {
 		if settings == nil {
 			continue
 		}
-		out[strconv.FormatInt(chatID, 10)] = *settings
+		out.Users[strconv.FormatInt(chatID, 10)] = *settings
+	}
+	if len(s.members) > 0 {
+		out.Members = make(map[string]MemberSettings, len(s.members))
+		for key, settings := range s.members {
+			out.Members[key.String()] = *settings
+		}
 	}
 	return out
 }
 
-func writeStateSnapshot(path string, snapshot map[string]UserSettings) error {
+// encodeStateSnapshot renders the state in the state.json file format.
+func encodeStateSnapshot(snapshot persistedStateData) ([]byte, error) {
+	return json.MarshalIndent(snapshot, "", "  ")
+}
+
+// Export returns the current users in the state.json format, whichever backend
+// persists them, so a backup can be taken without access to the server.
+func (s *StateStore) Export() ([]byte, error) {
+	s.mu.Lock()
+	snapshot := s.snapshotLocked()
+	s.mu.Unlock()
+	return encodeStateSnapshot(snapshot)
+}
+
+func writeStateSnapshot(path string, snapshot persistedStateData) error {
 	path = strings.TrimSpace(path)
 	if path == "" {
 		return nil
 	}
 
-	data := persistedStateData{Users: snapshot}
-	raw, err := json.MarshalIndent(data, "", "  ")
+	raw, err := encodeStateSnapshot(snapshot)
 	if err != nil {
 		return err
 	}
@@ -2030,57 +7054,338 @@ func writeStateSnapshot(path string, snapshot map[string]UserSettings) error {
 	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
 		return err
 	}
-	return os.Rename(tmp, path)
+	return os.Rename(tmp, path)
+}
+
+// ReminderManager handles per-chat reminder goroutines.
+func (rm *ReminderManager) Start(chatID int64, region string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if existing, ok := rm.active[chatID]; ok {
+		existing.cancel()
+	}
+	rm.startLocked(chatID, region)
+}
+
+// startLocked launches the chat's loop, or, while the chat is muted, a timer that starts
+// it when the mute ends. Callers hold rm.mu and have cancelled any previous state.
+func (rm *ReminderManager) startLocked(chatID int64, region string) {
+	var mutedUntil time.Time
+	if rm.settingsFn != nil {
+		mutedUntil = rm.settingsFn(chatID).mutedUntil()
+	}
+	if wait := mutedUntil.Sub(rm.clock()); wait > 0 {
+		state := &reminderState{region: region}
+		stop := rm.startTimer(wait, func() { rm.resume(chatID, state) })
+		state.cancel = func() { stop() }
+		rm.active[chatID] = state
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rm.active[chatID] = &reminderState{cancel: cancel, region: region}
+	go rm.loop(ctx, chatID, region)
+}
+
+// resume starts a muted chat's loop once the mute is over, unless the chat has been
+// stopped or restarted since the timer was armed.
+func (rm *ReminderManager) resume(chatID int64, state *reminderState) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.active[chatID] != state {
+		return
+	}
+	rm.startLocked(chatID, state.region)
+}
+
+// CalendarStore holds the per-region schedules shared by handlers and reminder loops.
+// Slices handed out by Get are never modified in place; Set and Replace swap in new
+// ones, so readers may keep using what they got without holding the lock.
+type CalendarStore struct {
+	mu   sync.RWMutex
+	days map[string][]DayTimes
+}
+
+func newCalendarStore(calendars map[string][]DayTimes) *CalendarStore {
+	s := &CalendarStore{}
+	s.Replace(calendars)
+	return s
+}
+
+// Get returns the schedule for region. A nil store has no regions.
+func (s *CalendarStore) Get(region string) ([]DayTimes, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	days, ok := s.days[region]
+	return days, ok
+}
+
+// Set stores a copy of days as region's schedule.
+func (s *CalendarStore) Set(region string, days []DayTimes) {
+	copied := append([]DayTimes(nil), days...)
+	s.mu.Lock()
+	s.days[region] = copied
+	s.mu.Unlock()
+}
+
+// Replace swaps in a whole new set of schedules, e.g. after the start date changes.
+func (s *CalendarStore) Replace(calendars map[string][]DayTimes) {
+	days := make(map[string][]DayTimes, len(calendars))
+	for region, schedule := range calendars {
+		days[region] = schedule
+	}
+	s.mu.Lock()
+	s.days = days
+	s.mu.Unlock()
+}
+
+// Regions returns the stored region keys in sorted order.
+func (s *CalendarStore) Regions() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	regions := make([]string, 0, len(s.days))
+	for region := range s.days {
+		regions = append(regions, region)
+	}
+	s.mu.RUnlock()
+	sort.Strings(regions)
+	return regions
+}
+
+func (rm *ReminderManager) Stop(chatID int64) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if existing, ok := rm.active[chatID]; ok {
+		existing.cancel()
+		delete(rm.active, chatID)
+	}
+}
+
+// regionSchedule returns the current schedule for region. Running loops call it once per
+// day so a calendar swapped in the shared CalendarStore takes effect without re-subscribing.
+func (rm *ReminderManager) regionSchedule(region string) ([]DayTimes, bool) {
+	days, ok := rm.calendar.Get(region)
+	if !ok || len(days) == 0 {
+		return nil, false
+	}
+	return days, true
+}
+
+func (rm *ReminderManager) startDate() time.Time {
+	rm.startMu.RLock()
+	defer rm.startMu.RUnlock()
+	return rm.ramadanStart
+}
+
+// SetStart replaces the Ramadan start date used by all reminder loops.
+func (rm *ReminderManager) SetStart(start time.Time) {
+	rm.startMu.Lock()
+	rm.ramadanStart = start
+	rm.startMu.Unlock()
+}
+
+// RestartAll restarts every active loop so it recomputes the current day from scratch.
+func (rm *ReminderManager) RestartAll() int {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	for chatID, existing := range rm.active {
+		existing.cancel()
+		rm.startLocked(chatID, existing.region)
+	}
+	return len(rm.active)
+}
+
+// stopRegion removes the chat's loop if it is still the one running for region. Loops use
+// it to retire themselves without cancelling a newer subscription.
+func (rm *ReminderManager) stopRegion(chatID int64, region string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if existing, ok := rm.active[chatID]; ok && existing.region == region {
+		existing.cancel()
+		delete(rm.active, chatID)
+	}
+}
+
+func reminderDayBaseTime(ramadanStart time.Time, ramadanDay int, loc *time.Location) time.Time {
+	if loc == nil {
+		loc = time.Local
+	}
+	baseDate := ramadanStart.AddDate(0, 0, ramadanDay-1)
+	return time.Date(baseDate.Year(), baseDate.Month(), baseDate.Day(), 0, 0, 0, 0, loc)
+}
+
+// reminderEventKeys lists the event keys reminderEventsForDay can produce.
+var reminderEventKeys = []string{"suhoor", "fajr", "dhuhr", "asr", "maghrib", "isha", "tahajjud"}
+
+func isReminderEventKey(key string) bool {
+	for _, known := range reminderEventKeys {
+		if key == known {
+			return true
+		}
+	}
+	return false
+}
+
+// atDayMinute returns the wall-clock time minutes after base's midnight. Building it with
+// time.Date rather than adding a Duration keeps prayer times on the clock face across a
+// DST change, when the day is 23 or 25 hours long. Minutes past 1440 roll into the next day.
+func atDayMinute(base time.Time, minutes int) time.Time {
+	return time.Date(base.Year(), base.Month(), base.Day(), 0, minutes, 0, 0, base.Location())
+}
+
+// reminderOptions carries the per-chat choices that add optional events to a day.
+type reminderOptions struct {
+	Tahajjud bool
+	// Next is the following Ramadan day, used for the night that ends at its fajr.
+	Next *DayTimes
+}
+
+// reminderEventsForDay builds the day's events in chronological order. A time that is
+// smaller than the one before it has wrapped past midnight (see applyOffset), so it and
+// every later event are rolled into the next calendar day.
+func reminderEventsForDay(base time.Time, day DayTimes, opts reminderOptions) []eventSpec {
+	events := []eventSpec{
+		{Key: "suhoor", UseSuhoor: true},
+		{Key: "fajr"},
+		{Key: "dhuhr"},
+		{Key: "asr"},
+		{Key: "maghrib", UseIftar: true},
+		{Key: "isha"},
+	}
+	minutes := []int{day.SuhoorEnd, day.Fajr, day.Dhuhr, day.Asr, day.Maghrib, day.Isha}
+
+	rollover := 0
+	prev := -1
+	for i := range events {
+		at := minutes[i] + rollover
+		if at < prev {
+			rollover += minutesPerDay
+			at += minutesPerDay
+		}
+		prev = at
+		events[i].Time = atDayMinute(base, at)
+	}
+	if opts.Tahajjud {
+		events = append(events, eventSpec{
+			Key:  "tahajjud",
+			Time: atDayMinute(base, tahajjudMinutes(day, opts.Next)),
+		})
+	}
+	return events
+}
+
+// tahajjudMinutes returns the start of the last third of the night after the day's maghrib,
+// in minutes from the day's midnight. The night ends at the next day's fajr, so the result
+// is normally past 1440; without a next day, today's fajr stands in for it.
+func tahajjudMinutes(day DayTimes, next *DayTimes) int {
+	fajr := day.Fajr
+	if next != nil {
+		fajr = next.Fajr
+	}
+	nightEnd := fajr + minutesPerDay
+	return day.Maghrib + (nightEnd-day.Maghrib)*2/3
+}
+
+const (
+	defaultDigestLeadMinutes = 120
+	maxDigestLeadMinutes     = 360
+)
+
+func digestLeadMinutes(settings UserSettings) int {
+	if settings.DigestLead > 0 {
+		return settings.DigestLead
+	}
+	return defaultDigestLeadMinutes
 }
 
-// ReminderManager handles per-chat reminder goroutines.
-func (rm *ReminderManager) Start(chatID int64, region string) {
-	rm.mu.Lock()
-	defer rm.mu.Unlock()
-	if existing, ok := rm.active[chatID]; ok {
-		existing.cancel()
+// digestTime is when the daily digest goes out: leadMinutes before suhoor ends, but never
+// before the day's midnight so it cannot slip into the previous Ramadan day.
+func digestTime(base time.Time, day DayTimes, leadMinutes int) time.Time {
+	at := day.SuhoorEnd - leadMinutes
+	if at < 0 {
+		at = 0
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	rm.active[chatID] = &reminderState{cancel: cancel, region: region}
-	go rm.loop(ctx, chatID, region)
+	return atDayMinute(base, at)
 }
 
-func (rm *ReminderManager) Stop(chatID int64) {
-	rm.mu.Lock()
-	defer rm.mu.Unlock()
-	if existing, ok := rm.active[chatID]; ok {
-		existing.cancel()
-		delete(rm.active, chatID)
+// formatDayTimetable lists every prayer time of the day, one per line.
+func formatDayTimetable(lang, region string, day DayTimes) string {
+	lines := []string{trf(lang, "digest_title", regionDisplayName(region, lang), day.Data, dayLabel(lang, day.Day)), ""}
+	entries := []struct {
+		key     string
+		minutes int
+	}{
+		{"suhoor", day.SuhoorEnd},
+		{"fajr", day.Fajr},
+		{"dhuhr", day.Dhuhr},
+		{"asr", day.Asr},
+		{"maghrib", day.Maghrib},
+		{"isha", day.Isha},
 	}
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%s — %s", tr(lang, "event_"+e.key), minutesToClock(e.minutes)))
+	}
+	return strings.Join(lines, "\n")
 }
 
-func reminderDayBaseTime(ramadanStart time.Time, ramadanDay int, loc *time.Location) time.Time {
-	if loc == nil {
-		loc = time.Local
+func findDaySchedule(days []DayTimes, dayNumber int) *DayTimes {
+	for i := range days {
+		if days[i].Day == dayNumber {
+			return &days[i]
+		}
 	}
-	baseDate := ramadanStart.AddDate(0, 0, ramadanDay-1)
-	return time.Date(baseDate.Year(), baseDate.Month(), baseDate.Day(), 0, 0, 0, 0, loc)
+	return nil
 }
 
-func reminderEventsForDay(base time.Time, day DayTimes) []eventSpec {
-	return []eventSpec{
-		{Key: "suhoor", Time: base.Add(time.Duration(day.SuhoorEnd) * time.Minute), UseSuhoor: true},
-		{Key: "fajr", Time: base.Add(time.Duration(day.Fajr) * time.Minute)},
-		{Key: "dhuhr", Time: base.Add(time.Duration(day.Dhuhr) * time.Minute)},
-		{Key: "asr", Time: base.Add(time.Duration(day.Asr) * time.Minute)},
-		{Key: "maghrib", Time: base.Add(time.Duration(day.Maghrib) * time.Minute), UseIftar: true},
-		{Key: "isha", Time: base.Add(time.Duration(day.Isha) * time.Minute)},
+// reminderDayEnd returns the moment the reminder loop may move on to the next Ramadan day.
+// Normally that is the following midnight, but an event rolled past midnight keeps the
+// day open until its reminder has had a chance to fire.
+func reminderDayEnd(base time.Time, events []eventSpec) time.Time {
+	end := atDayMinute(base, minutesPerDay)
+	for _, ev := range events {
+		remindAt := ev.Time.Add(-reminderLead)
+		if !remindAt.Before(end) {
+			end = remindAt.Add(time.Minute)
+		}
 	}
+	return end
 }
 
 func shouldTriggerReminder(now time.Time, ev eventSpec, sent map[string]bool) bool {
 	if sent != nil && sent[ev.Key] {
 		return false
 	}
-	remindAt := ev.Time.Add(-30 * time.Minute)
+	remindAt := ev.Time.Add(-reminderLead)
 	return !now.Before(remindAt)
 }
 
+// quietHoursActive reports whether t's time of day falls inside the chat's quiet hours,
+// [QuietFrom, QuietTo). A window whose start is after its end wraps past midnight, so
+// 22:00–05:00 covers both late evening and early morning.
+func quietHoursActive(settings UserSettings, t time.Time) bool {
+	from, to := settings.QuietFrom, settings.QuietTo
+	if from == to {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if from < to {
+		return minute >= from && minute < to
+	}
+	return minute >= from || minute < to
+}
+
+// quietExempt reports whether ev goes out even during quiet hours. The suhoor reminder
+// is the one people wake up for, and a window like 22:00–05:00 would otherwise swallow
+// it, so it is always delivered. The daily digest has its own opt-in time and is not
+// affected either.
+func quietExempt(ev eventSpec) bool {
+	return ev.UseSuhoor
+}
+
 // markPastDayRemindersAsSent prevents "catch-up" sends after process restart.
 // If the reminder moment has already passed for today, treat it as already sent.
 func markPastDayRemindersAsSent(now time.Time, events []eventSpec, sent map[string]bool) {
@@ -2088,7 +7393,7 @@ func markPastDayRemindersAsSent(now time.Time, events []eventSpec, sent map[stri
 		return
 	}
 	for _, ev := range events {
-		remindAt := ev.Time.Add(-30 * time.Minute)
+		remindAt := ev.Time.Add(-reminderLead)
 		if now.After(remindAt) {
 			sent[ev.Key] = true
 		}
@@ -2096,18 +7401,7 @@ func markPastDayRemindersAsSent(now time.Time, events []eventSpec, sent map[stri
 }
 
 func (rm *ReminderManager) loop(ctx context.Context, chatID int64, region string) {
-	lang := langTG
-	if rm.getLangFn != nil {
-		if resolved := normalizeLang(rm.getLangFn(chatID)); resolved != "" {
-			lang = resolved
-		}
-	}
-	calendar, ok := rm.calendar[region]
-	if !ok {
-		rm.sendFn(chatID, trf(lang, "rem_no_calendar_region", region))
-		return
-	}
-
+	lang := fallbackLang()
 	loc := rm.loc
 	for {
 		if rm.getLangFn != nil {
@@ -2115,34 +7409,70 @@ func (rm *ReminderManager) loop(ctx context.Context, chatID int64, region string
 				lang = resolved
 			}
 		}
-		now := time.Now().In(loc)
-		if now.Before(rm.ramadanStart) {
-			wait := time.Until(rm.ramadanStart)
-			timer := time.NewTimer(wait)
+		calendar, ok := rm.regionSchedule(region)
+		if !ok {
+			if _, err := rm.sender.SendMessage(chatID, trf(lang, "rem_no_calendar_region", regionDisplayName(region, lang)), nil); err != nil {
+				log.Printf("reminder region missing send error: %v", err)
+			}
+			rm.stopRegion(chatID, region)
+			return
+		}
+		start := rm.startDate()
+		now := rm.clock()
+		if now.Before(start) {
+			timer := time.NewTimer(start.Sub(now))
 			select {
 			case <-ctx.Done():
 				timer.Stop()
 				return
 			case <-timer.C:
 			}
+			now = rm.clock()
 		}
 
-		day := currentDaySchedule(calendar, rm.ramadanStart, loc)
+		day := dayScheduleAt(calendar, start, now)
 		if day == nil {
 			// Out of range: Rely on start date to tell user.
-			rm.sendFn(chatID, tr(lang, "rem_out_of_range"))
-			time.Sleep(6 * time.Hour)
+			if _, err := rm.sender.SendMessage(chatID, outOfRangeText(lang, "rem_out_of_range", start), nil); err != nil {
+				log.Printf("reminder range send error: %v", err)
+			}
+			timer := time.NewTimer(rm.outOfRangeDelay())
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
 			continue
 		}
 
-		base := reminderDayBaseTime(rm.ramadanStart, day.Day, loc)
-		events := reminderEventsForDay(base, *day)
+		base := reminderDayBaseTime(start, day.Day, loc)
+		var settings UserSettings
+		if rm.settingsFn != nil {
+			settings = rm.settingsFn(chatID)
+		}
+		userDay := day.withAsrMethod(settings.AsrMethod)
+		events := reminderEventsForDay(base, userDay, reminderOptions{
+			Tahajjud: settings.Tahajjud,
+			Next:     findDaySchedule(calendar, day.Day+1),
+		})
 
+		dayKey := base.Format("2006-01-02")
 		sent := make(map[string]bool)
+		if rm.sentFn != nil {
+			sent = rm.sentFn(chatID, dayKey)
+		}
 		// On restart, skip reminders whose scheduled reminder moment already passed today.
 		markPastDayRemindersAsSent(now, events, sent)
-		nextDay := base.Add(24 * time.Hour)
-		ticker := time.NewTicker(30 * time.Second)
+		var digestAt time.Time
+		if settings.DailyDigest {
+			digestAt = digestTime(base, *day, digestLeadMinutes(settings))
+			if now.After(digestAt) {
+				sent["digest"] = true
+			}
+		}
+		nextDay := reminderDayEnd(base, events)
+		ticker := time.NewTicker(rm.tickInterval())
 
 	loopDay:
 		for {
@@ -2151,80 +7481,336 @@ func (rm *ReminderManager) loop(ctx context.Context, chatID int64, region string
 				ticker.Stop()
 				return
 			case <-ticker.C:
-				now = time.Now().In(loc)
+				now = rm.clock()
 				if !now.Before(nextDay) {
 					break loopDay
 				}
+				if settings.DailyDigest && !sent["digest"] && !now.Before(digestAt) {
+					sent["digest"] = true
+					err := rm.sendDigest(chatID, region, userDay)
+					if errors.Is(err, ErrBotBlocked) {
+						rm.dropBlocked(chatID)
+						return
+					}
+					if err == nil {
+						rm.recordSent(chatID, dayKey, "digest")
+					}
+				}
 				for _, ev := range events {
 					if shouldTriggerReminder(now, ev, sent) {
 						sent[ev.Key] = true
-						rm.sendReminder(chatID, region, day.Day, ev)
+						if !quietExempt(ev) && quietHoursActive(settings, ev.Time.Add(-reminderLead).In(loc)) {
+							// Swallowed, not postponed: marked as sent so it never fires late.
+							rm.recordSent(chatID, dayKey, ev.Key)
+							continue
+						}
+						err := rm.sendReminder(chatID, region, day.Day, ev)
+						if errors.Is(err, ErrBotBlocked) {
+							rm.dropBlocked(chatID)
+							return
+						}
+						// A failed send is left unrecorded so /catchup can still offer it.
+						if err == nil {
+							rm.recordSent(chatID, dayKey, ev.Key)
+							rm.recordDrift(chatID, ev)
+						}
 					}
 				}
 			}
 		}
+		ticker.Stop()
+	}
+}
+
+// recordDrift notes how far past its scheduled moment ev went out, rendering and the
+// Telegram round trip included, and warns when that exceeds reminderDriftWarn. Call it
+// once the send has returned.
+func (rm *ReminderManager) recordDrift(chatID int64, ev eventSpec) {
+	remindAt := ev.Time.Add(-reminderLead)
+	drift := rm.clock().Sub(remindAt)
+	rm.drift.record(remindAt.In(rm.loc).Format("2006-01-02"), drift)
+	if drift > reminderDriftWarn {
+		log.Printf("WARN: %s reminder for chat %d is %s late", ev.Key, chatID, drift.Round(time.Second))
+	}
+}
+
+// recordSent persists a delivered (or deliberately swallowed) reminder so a restart
+// later the same day and /catchup skip it.
+func (rm *ReminderManager) recordSent(chatID int64, dayKey, key string) {
+	if rm.markSentFn != nil {
+		rm.markSentFn(chatID, dayKey, key)
+	}
+}
+
+// dropBlocked stops reminders for a chat that blocked the bot and flags it in the state.
+func (rm *ReminderManager) dropBlocked(chatID int64) {
+	log.Printf("chat %d blocked the bot; stopping reminders", chatID)
+	rm.Stop(chatID)
+	if rm.blockedFn != nil {
+		rm.blockedFn(chatID)
+	}
+}
+
+// imagesDisabled reports whether chatID asked for text-only messages.
+func (rm *ReminderManager) imagesDisabled(chatID int64) bool {
+	return rm.settingsFn != nil && rm.settingsFn(chatID).ImagesDisabled
+}
+
+func (rm *ReminderManager) sendDigest(chatID int64, region string, day DayTimes) error {
+	lang := fallbackLang()
+	if rm.getLangFn != nil {
+		if resolved := normalizeLang(rm.getLangFn(chatID)); resolved != "" {
+			lang = resolved
+		}
+	}
+	timetable := formatDayTimetable(lang, region, day)
+	hadith := formatHadithBlock(lang, tr(lang, "hadith_day_title"), rm.randomHadith(lang))
+
+	opts := renderOptions{Theme: themeDark}
+	if rm.renderOptsFn != nil {
+		opts = rm.renderOptsFn(chatID)
+	}
+	// Text mode skips the card and goes straight to the text-only digest below.
+	if !rm.imagesDisabled(chatID) {
+		if photo, err := rm.cachedTodayImage(lang, opts, region, day); err != nil {
+			log.Printf("digest image build error: %v", err)
+		} else if _, err := rm.sender.SendPhoto(chatID, photo, timetable); err != nil {
+			if errors.Is(err, ErrBotBlocked) {
+				return err
+			}
+			log.Printf("digest photo send error: %v", err)
+		} else {
+			if _, err := rm.sender.SendMessage(chatID, hadith, fastedMarkup(lang, day.Day)); err != nil {
+				log.Printf("digest hadith send error: %v", err)
+				return err
+			}
+			return nil
+		}
+	}
+	if _, err := rm.sender.SendMessage(chatID, timetable+"\n\n"+hadith, fastedMarkup(lang, day.Day)); err != nil {
+		log.Printf("digest send error: %v", err)
+		return err
 	}
+	return nil
+}
+
+// dayLabel names a Ramadan day for captions. Day 0, the eve before the first fast, is
+// shown as such instead of as "Day 0".
+func dayLabel(lang string, day int) string {
+	if day < 1 {
+		return tr(lang, "day_label_eve")
+	}
+	return trf(lang, "day_label", dayOrdinal(lang, day))
+}
+
+// dayOrdinal writes day as the language's ordinal ("5-й", "5-ум", "5-"); the
+// surrounding phrase comes from day_label or rem_day_label. Tajik numerals ending in a
+// vowel take "-юм" instead of day_ordinal's "-ум".
+func dayOrdinal(lang string, day int) string {
+	if lang == langTG && tajikNumeralEndsInVowel(day) {
+		return fmt.Sprintf("%d-юм", day)
+	}
+	return trf(lang, "day_ordinal", day)
+}
+
+// tajikNumeralEndsInVowel reports whether the Tajik numeral for n ends in a vowel: 2 (ду),
+// 3 (се) and 30 (сӣ), alone or closing a compound such as 22 (бисту ду). The teens end
+// in -даҳ.
+func tajikNumeralEndsInVowel(n int) bool {
+	if rest := n % 100; rest >= 11 && rest <= 19 {
+		return false
+	}
+	switch n % 10 {
+	case 2, 3:
+		return true
+	case 0:
+		return n%100 == 30
+	}
+	return false
+}
+
+// ramadanDayLabel is dayLabel's longer form used in reminder headlines.
+func ramadanDayLabel(lang string, day int) string {
+	if day < 1 {
+		return tr(lang, "day_label_eve")
+	}
+	return trf(lang, "rem_day_label", dayOrdinal(lang, day))
 }
 
-func (rm *ReminderManager) sendReminder(chatID int64, region string, day int, ev eventSpec) {
-	lang := langTG
+func reminderHeadline(lang, region string, day int, ev eventSpec, loc *time.Location) string {
+	timeLabel := ev.Time.In(loc).Format("15:04")
+	return trf(lang, "rem_headline", regionDisplayName(region, lang), ramadanDayLabel(lang, day), eventTitle(lang, ev), timeLabel)
+}
+
+// react adds the configured reaction to a reminder the bot just sent. A failure only
+// costs the decoration, so it is logged and otherwise ignored.
+func (rm *ReminderManager) react(chatID int64, sent *Message) {
+	if rm.reaction == "" || sent == nil || sent.MessageID == 0 {
+		return
+	}
+	if err := rm.sender.SetMessageReaction(chatID, sent.MessageID, rm.reaction); err != nil {
+		log.Printf("reminder reaction error: %v", err)
+	}
+}
+
+func (rm *ReminderManager) sendReminder(chatID int64, region string, day int, ev eventSpec) error {
+	lang := fallbackLang()
 	if rm.getLangFn != nil {
 		if resolved := normalizeLang(rm.getLangFn(chatID)); resolved != "" {
 			lang = resolved
 		}
 	}
-	title := eventTitle(lang, ev)
-	timeLabel := ev.Time.In(rm.loc).Format("15:04")
-	headline := trf(lang, "rem_headline", region, day, title, timeLabel)
+	headline := reminderHeadline(lang, region, day, ev, rm.loc)
 	photoSent := false
-	if rm.sendPhotoFn != nil {
-		photo, err := rm.cachedReminderImage(lang, region, day, ev)
+	opts := renderOptions{Theme: themeDark}
+	if rm.renderOptsFn != nil {
+		opts = rm.renderOptsFn(chatID)
+	}
+	// In text mode the headline goes into the message below instead of a photo caption.
+	if !rm.imagesDisabled(chatID) {
+		photo, err := rm.cachedReminderImage(lang, opts, region, day, ev)
 		if err != nil {
 			log.Printf("reminder image build error: %v", err)
-		} else {
-			if err := rm.sendPhotoFn(chatID, photo, headline); err != nil {
-				log.Printf("reminder photo send error: %v", err)
-			} else {
-				photoSent = true
+		} else if sent, err := rm.sender.SendPhoto(chatID, photo, headline); err != nil {
+			if errors.Is(err, ErrBotBlocked) {
+				return err
 			}
+			log.Printf("reminder photo send error: %v", err)
+		} else {
+			photoSent = true
+			rm.react(chatID, sent)
 		}
 	}
 
-	var builder strings.Builder
+	// Build the plain message and, when rich text is on, its HTML twin side by side.
+	var builder, rich strings.Builder
 	if !photoSent {
 		builder.WriteString(headline)
 		builder.WriteString("\n\n")
+		rich.WriteString("<b>" + html.EscapeString(headline) + "</b>\n\n")
 	}
+	niyatLabel, niyat := "", ""
 	if ev.UseSuhoor {
-		builder.WriteString(tr(lang, "niyat_suhoor_label"))
-		builder.WriteString(localizedNiyatText(rm.niyatSuhoor, lang))
+		niyatLabel, niyat = tr(lang, "niyat_suhoor_label"), localizedNiyatText(rm.niyatSuhoor, lang)
 	} else if ev.UseIftar {
-		builder.WriteString(tr(lang, "niyat_iftar_label"))
-		builder.WriteString(localizedNiyatText(rm.niyatIftar, lang))
+		niyatLabel, niyat = tr(lang, "niyat_iftar_label"), localizedNiyatText(rm.niyatIftar, lang)
+	}
+	if niyatLabel != "" {
+		builder.WriteString(niyatLabel)
+		builder.WriteString(niyat)
+		rich.WriteString("<b>" + html.EscapeString(strings.TrimSpace(niyatLabel)) + "</b>\n")
+		rich.WriteString(html.EscapeString(niyat))
 	} else {
-		builder.WriteString(formatHadithBlock(lang, tr(lang, "hadith_day_title"), rm.randomHadith(lang)))
+		hadith := rm.randomHadith(lang)
+		builder.WriteString(formatHadithBlock(lang, tr(lang, "hadith_day_title"), hadith))
+		rich.WriteString(formatHadithBlockHTML(lang, tr(lang, "hadith_day_title"), hadith))
 	}
 
-	if err := rm.sendFn(chatID, builder.String()); err != nil {
+	var err error
+	if rm.useRichText {
+		_, err = sendRichText(rm.sender, chatID, rich.String(), builder.String(), nil)
+	} else {
+		_, err = rm.sender.SendMessage(chatID, builder.String(), nil)
+	}
+	if err != nil {
 		log.Printf("reminder send error: %v", err)
+		return err
 	}
+	return nil
 }
 
-func (rm *ReminderManager) randomHadith(lang string) string {
+func (rm *ReminderManager) randomHadith(lang string) Hadith {
 	return randomHadithForLang(rm.hadithsByLang, lang)
 }
 
-func (b *Bot) randomHadith(lang string) string {
+func (b *Bot) randomHadith(lang string) Hadith {
 	return randomHadithForLang(b.hadithsByLang, lang)
 }
 
-func randomHadithForLang(hadithsByLang map[string][]string, lang string) string {
+// randomHadithByTag picks a random hadith carrying tag; ok is false when there is none.
+func (b *Bot) randomHadithByTag(lang, tag string) (Hadith, bool) {
+	var tagged []Hadith
+	for _, hadith := range hadithListForLang(b.hadithsByLang, lang) {
+		if hadith.HasTag(tag) {
+			tagged = append(tagged, hadith)
+		}
+	}
+	if len(tagged) == 0 {
+		return Hadith{}, false
+	}
+	return tagged[rand.Intn(len(tagged))], true
+}
+
+// hadithTags are the topics hadiths are tagged with; each has a hadith_tag_ label.
+var hadithTags = []string{"fasting", "dua", "knowledge", "quran", "intention", "purity", "brotherhood", "forgiveness"}
+
+// matchHadithTag resolves a topic typed in any language to its tag, or "".
+func matchHadithTag(input string) string {
+	normalize := strings.NewReplacer("‘", "", "’", "", "'", "", "`", "", "ʻ", "")
+	input = normalize.Replace(strings.ToLower(strings.TrimSpace(input)))
+	for _, tag := range hadithTags {
+		if input == tag {
+			return tag
+		}
+		for _, lang := range supportedLangs {
+			if input == normalize.Replace(strings.ToLower(tr(lang, "hadith_tag_"+tag))) {
+				return tag
+			}
+		}
+	}
+	return ""
+}
+
+// hadithTagList lists the topic names in lang for the /hadiths usage hint.
+func hadithTagList(lang string) string {
+	names := make([]string, len(hadithTags))
+	for i, tag := range hadithTags {
+		names[i] = tr(lang, "hadith_tag_"+tag)
+	}
+	return strings.Join(names, ", ")
+}
+
+// hadithCardOptions returns the chat's render options, adding the hadith and the ayah
+// of the day when the chat has asked for them to be drawn on the card.
+func (b *Bot) hadithCardOptions(chatID int64, lang string) renderOptions {
+	opts := b.renderOptionsFor(chatID)
+	settings := b.state.Get(chatID)
+	now := time.Now().In(b.tz)
+	if settings.HadithCard {
+		opts.Hadith = dailyHadithForLang(b.hadithsByLang, lang, now)
+	}
+	if settings.AyahCard {
+		opts.Ayah = dailyAyah(b.startDate(), now).asHadith(lang, false)
+	}
+	return opts
+}
+
+// dailyHadithForLang picks one hadith per calendar day, so cards rendered with it
+// stay cacheable for the whole day.
+func dailyHadithForLang(hadithsByLang map[string][]Hadith, lang string, date time.Time) Hadith {
+	list := hadithListForLang(hadithsByLang, lang)
+	if len(list) == 0 {
+		return Hadith{}
+	}
+	days := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+	return list[int(days%int64(len(list)))]
+}
+
+func randomHadithForLang(hadithsByLang map[string][]Hadith, lang string) Hadith {
+	list := hadithListForLang(hadithsByLang, lang)
+	if len(list) == 0 {
+		return Hadith{}
+	}
+	return list[rand.Intn(len(list))]
+}
+
+func hadithListForLang(hadithsByLang map[string][]Hadith, lang string) []Hadith {
 	if len(hadithsByLang) == 0 {
-		return ""
+		return nil
 	}
 	lang = normalizeLang(lang)
 	if lang == "" {
-		lang = langTG
+		lang = fallbackLang()
 	}
 	list := hadithsByLang[lang]
 	if len(list) == 0 {
@@ -2238,10 +7824,7 @@ func randomHadithForLang(hadithsByLang map[string][]string, lang string) string
 			}
 		}
 	}
-	if len(list) == 0 {
-		return ""
-	}
-	return list[rand.Intn(len(list))]
+	return list
 }
 
 func localizedNiyatText(niyatByLang map[string]string, lang string) string {
@@ -2250,7 +7833,7 @@ func localizedNiyatText(niyatByLang map[string]string, lang string) string {
 	}
 	lang = normalizeLang(lang)
 	if lang == "" {
-		lang = langTG
+		lang = fallbackLang()
 	}
 	text := strings.TrimSpace(niyatByLang[lang])
 	if text == "" {
@@ -2268,22 +7851,115 @@ func localizedNiyatText(niyatByLang map[string]string, lang string) string {
 	return ""
 }
 
-func formatHadithBlock(lang, title, hadith string) string {
+// Hadith is a hadith's text together with where it is recorded.
+type Hadith struct {
+	Text   string `json:"text"`
+	Source string `json:"source,omitempty"`
+	// Tags are topics from hadithTags, used by /hadiths <topic>.
+	Tags []string `json:"tags,omitempty"`
+}
+
+func (h Hadith) HasTag(tag string) bool {
+	for _, t := range h.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// String is the one-line "text — source" form drawn on cards.
+func (h Hadith) String() string {
+	if h.Source == "" {
+		return h.Text
+	}
+	return h.Text + " — " + h.Source
+}
+
+// UnmarshalJSON accepts {"text", "source"} objects as well as the older single-string
+// form, which is read with parseLegacyHadith.
+func (h *Hadith) UnmarshalJSON(data []byte) error {
+	var legacy string
+	if err := json.Unmarshal(data, &legacy); err == nil {
+		*h = parseLegacyHadith(legacy)
+		return nil
+	}
+	type plain Hadith
+	var parsed plain
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return err
+	}
+	*h = Hadith{Text: strings.TrimSpace(parsed.Text), Source: strings.TrimSpace(parsed.Source), Tags: parsed.Tags}
+	return nil
+}
+
+// legacyHadithQuotes pairs the opening and closing marks a legacy hadith quote may use.
+var legacyHadithQuotes = map[rune]rune{'"': '"', '“': '”', '«': '»', '„': '“'}
+
+// parseLegacyHadith reads the old "\"quote\" — source" string form. The source is only
+// split off after a closing quote mark, so dashes inside the quote stay in the text;
+// unquoted strings are kept whole.
+func parseLegacyHadith(s string) Hadith {
+	s = strings.TrimSpace(s)
+	open, size := utf8.DecodeRuneInString(s)
+	closing, quoted := legacyHadithQuotes[open]
+	if !quoted {
+		return Hadith{Text: s}
+	}
+	end := strings.IndexRune(s[size:], closing)
+	if end < 0 {
+		return Hadith{Text: s}
+	}
+	end += size + utf8.RuneLen(closing)
+	source := strings.TrimSpace(s[end:])
+	source = strings.TrimSpace(strings.TrimLeft(source, "—–-"))
+	return Hadith{Text: s[:end], Source: source}
+}
+
+// hadithParts fills in the localized defaults for a missing title or text.
+func hadithParts(lang, title string, hadith Hadith) (string, string, string) {
 	title = strings.TrimSpace(title)
 	if title == "" {
 		title = tr(lang, "hadith_title_default")
 	}
-	text := strings.TrimSpace(hadith)
+	text := strings.TrimSpace(hadith.Text)
 	if text == "" {
 		text = tr(lang, "hadith_fallback")
 	}
+	return title, text, strings.TrimSpace(hadith.Source)
+}
+
+// formatHadithBlockHTML is the Telegram HTML variant of formatHadithBlock: a bold title
+// and an italic source line instead of the box frame.
+func formatHadithBlockHTML(lang, title string, hadith Hadith) string {
+	title, quote, source := hadithParts(lang, title, hadith)
+	var b strings.Builder
+	b.WriteString("<b>")
+	b.WriteString(html.EscapeString(title))
+	b.WriteString("</b>\n")
+	b.WriteString(html.EscapeString(quote))
+	if source != "" {
+		b.WriteString("\n\n<i>")
+		b.WriteString(html.EscapeString(tr(lang, "hadith_source") + ": " + source))
+		b.WriteString("</i>")
+	}
+	return b.String()
+}
 
-	quote := text
-	source := ""
-	if idx := strings.LastIndex(text, "—"); idx > 0 {
-		quote = strings.TrimSpace(text[:idx])
-		source = strings.TrimSpace(text[idx+len("—"):])
+// sendRichText sends richHTML with HTML parse mode and, if Telegram rejects the markup,
+// sends plain instead so the user still gets the message.
+func sendRichText(sender Sender, chatID int64, richHTML, plain string, markup interface{}) (*Message, error) {
+	sent, err := sender.SendMessageWithMode(chatID, richHTML, markup, "HTML")
+	var apiErr *TelegramError
+	if err == nil || !errors.As(err, &apiErr) || apiErr.Code != http.StatusBadRequest {
+		return sent, err
 	}
+	log.Printf("rich text rejected for chat %d, sending plain text: %v", chatID, err)
+	return sender.SendMessage(chatID, plain, markup)
+}
+
+func formatHadithBlock(lang, title string, hadith Hadith) string {
+	title, quote, source := hadithParts(lang, title, hadith)
 
 	var b strings.Builder
 	b.WriteString("╔══")
@@ -2298,14 +7974,54 @@ func formatHadithBlock(lang, title, hadith string) string {
 		b.WriteString(": ")
 		b.WriteString(source)
 	}
+	// The bottom border spans the same number of runes as the title line above it.
 	b.WriteString("\n╚")
-	b.WriteString(strings.Repeat("═", 10))
+	b.WriteString(strings.Repeat("═", utf8.RuneCountInString(title)+8))
 	b.WriteString("╝")
 	return b.String()
 }
 
-func newImageCache() *imageCache {
-	return &imageCache{items: make(map[string]cachedImage)}
+const defaultImageCacheEntries = 512
+
+const (
+	// renderBreakerThreshold consecutive failed builds suspend rendering for
+	// renderBreakerCooldown; callers fall back to text meanwhile.
+	renderBreakerThreshold = 3
+	renderBreakerCooldown  = 5 * time.Minute
+)
+
+// errRenderingSuspended is returned instead of building while the breaker is open.
+var errRenderingSuspended = errors.New("image rendering suspended after repeated failures")
+
+func newImageCache(maxEntries, maxBytes int) *imageCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultImageCacheEntries
+	}
+	return &imageCache{
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		now:        time.Now,
+	}
+}
+
+// resolveImageCacheLimits reads IMAGE_CACHE_MAX_ENTRIES and IMAGE_CACHE_MAX_BYTES.
+// Missing or invalid values fall back to the default entry count and no byte limit.
+func resolveImageCacheLimits() (int, int) {
+	read := func(name string, fallback int) int {
+		env := strings.TrimSpace(os.Getenv(name))
+		if env == "" {
+			return fallback
+		}
+		n, err := strconv.Atoi(env)
+		if err != nil || n < 0 {
+			log.Printf("Could not parse %s (%s), using %d", name, env, fallback)
+			return fallback
+		}
+		return n
+	}
+	return read("IMAGE_CACHE_MAX_ENTRIES", defaultImageCacheEntries), read("IMAGE_CACHE_MAX_BYTES", 0)
 }
 
 func (c *imageCache) getOrBuild(key string, ttl time.Duration, build func() ([]byte, error)) ([]byte, error) {
@@ -2313,56 +8029,180 @@ func (c *imageCache) getOrBuild(key string, ttl time.Duration, build func() ([]b
 		return build()
 	}
 
-	now := time.Now()
-	c.mu.RLock()
-	if cached, ok := c.items[key]; ok && now.Before(cached.expiresAt) {
-		out := append([]byte(nil), cached.data...)
-		c.mu.RUnlock()
-		return out, nil
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		cached := el.Value.(*cachedImage)
+		if c.now().Before(cached.expiresAt) {
+			c.hits.Add(1)
+			c.order.MoveToFront(el)
+			out := append([]byte(nil), cached.data...)
+			c.mu.Unlock()
+			return out, nil
+		}
+		c.removeLocked(el)
+	}
+	if c.now().Before(c.suspendedUntil) || c.probing {
+		c.mu.Unlock()
+		return nil, errRenderingSuspended
 	}
-	c.mu.RUnlock()
+	c.probing = c.failures >= renderBreakerThreshold
+	c.mu.Unlock()
+	c.misses.Add(1)
 
 	data, err := build()
 	if err != nil {
+		c.recordFailure(err)
 		return nil, err
 	}
 	copied := append([]byte(nil), data...)
 
 	c.mu.Lock()
-	c.items[key] = cachedImage{
+	if c.failures >= renderBreakerThreshold {
+		log.Printf("image rendering recovered after %d failures", c.failures)
+	}
+	c.failures = 0
+	c.suspendedUntil = time.Time{}
+	c.probing = false
+	if el, ok := c.items[key]; ok {
+		c.removeLocked(el)
+	}
+	c.items[key] = c.order.PushFront(&cachedImage{
+		key:       key,
 		data:      copied,
-		expiresAt: time.Now().Add(ttl),
+		expiresAt: c.now().Add(ttl),
+	})
+	c.totalBytes += len(copied)
+	c.evictLocked()
+	c.mu.Unlock()
+
+	return append([]byte(nil), copied...), nil
+}
+
+// recordFailure counts a failed build and opens the breaker at the threshold. After the
+// cooldown a single trial build is let through (see probing); if it fails too the
+// breaker opens again at once.
+func (c *imageCache) recordFailure(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+	c.failures++
+	if c.failures >= renderBreakerThreshold {
+		c.suspendedUntil = c.now().Add(renderBreakerCooldown)
+		log.Printf("image rendering suspended for %s after %d consecutive failures: %v", renderBreakerCooldown, c.failures, err)
+	}
+}
+
+// evictLocked drops least recently used entries until the cache fits its limits. The
+// newest entry is kept even if it alone exceeds maxBytes.
+func (c *imageCache) evictLocked() {
+	for c.order.Len() > 1 && (c.order.Len() > c.maxEntries || (c.maxBytes > 0 && c.totalBytes > c.maxBytes)) {
+		c.removeLocked(c.order.Back())
 	}
-	if len(c.items) > 512 {
-		now = time.Now()
-		for k, v := range c.items {
-			if now.After(v.expiresAt) {
-				delete(c.items, k)
+}
+
+func (c *imageCache) removeLocked(el *list.Element) {
+	cached := c.order.Remove(el).(*cachedImage)
+	delete(c.items, cached.key)
+	c.totalBytes -= len(cached.data)
+}
+
+// renderOptions collects the per-chat presentation choices that change a rendered card.
+// Cache keys hash the whole struct, so new fields are picked up without touching the key builders.
+type renderOptions struct {
+	Theme string
+	// Hadith, when set, is drawn in a panel on calendar and today cards.
+	Hadith Hadith
+	// Ayah, when set, is drawn in a second panel on today cards.
+	Ayah Hadith
+}
+
+const calendarImageTTL = 12 * time.Hour
+
+// warmCalendarImages renders every region's calendar in every language with the default
+// theme, so the first /calendar of the day does not wait for a render.
+func (b *Bot) warmCalendarImages() {
+	started := time.Now()
+	opts := renderOptions{Theme: themeDark}
+	rendered := 0
+	for _, region := range regionNames {
+		schedule, ok := b.regionCalendar(region)
+		if !ok {
+			continue
+		}
+		for _, lang := range supportedLangs {
+			if _, err := b.cachedCalendarImage(lang, opts, region, schedule); err != nil {
+				log.Printf("calendar warm-up error (%s, %s): %v", region, lang, err)
+				continue
 			}
+			rendered++
 		}
 	}
-	c.mu.Unlock()
+	log.Printf("Calendar warm-up: %d images in %s", rendered, time.Since(started).Round(time.Millisecond))
+}
+
+// runCalendarWarmup warms the cache now and again whenever the cached images expire.
+func (b *Bot) runCalendarWarmup(ctx context.Context) {
+	ticker := time.NewTicker(calendarImageTTL)
+	defer ticker.Stop()
+	for {
+		b.warmCalendarImages()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Bot) cachedCalendarImage(lang string, opts renderOptions, region string, schedule []DayTimes) ([]byte, error) {
+	start := b.startDate()
+	key := calendarImageCacheKey(lang, opts, region, start, schedule)
+	return b.imageCache.getOrBuild(key, calendarImageTTL, func() ([]byte, error) {
+		return renderCalendarImage(schedule, start, lang, themeByName(opts.Theme), opts.Hadith)
+	})
+}
+
+func (b *Bot) cachedTodayImage(lang string, opts renderOptions, region string, day DayTimes) ([]byte, error) {
+	key := todayImageCacheKey(lang, opts, region, day)
+	ttl := timeUntilNextDay(b.tz)
+	return b.imageCache.getOrBuild(key, ttl, func() ([]byte, error) {
+		return renderTodayImage(region, day, lang, themeByName(opts.Theme), opts.Hadith, opts.Ayah)
+	})
+}
+
+func (b *Bot) cachedCompareImage(lang string, opts renderOptions, regionA string, dayA DayTimes, regionB string, dayB DayTimes) ([]byte, error) {
+	key := "compare:" + strings.TrimPrefix(todayImageCacheKey(lang, opts, regionA, dayA), "today:") + ":" + strings.TrimPrefix(todayImageCacheKey(lang, opts, regionB, dayB), "today:")
+	ttl := timeUntilNextDay(b.tz)
+	return b.imageCache.getOrBuild(key, ttl, func() ([]byte, error) {
+		return renderCompareImage(regionA, dayA, regionB, dayB, lang, themeByName(opts.Theme))
+	})
+}
 
-	return copied, nil
+func (b *Bot) cachedPrayersImage(lang string, opts renderOptions, region string, day DayTimes, next string) ([]byte, error) {
+	key := "prayers:" + strings.TrimPrefix(todayImageCacheKey(lang, opts, region, day), "today:") + ":" + next
+	ttl := timeUntilNextDay(b.tz)
+	return b.imageCache.getOrBuild(key, ttl, func() ([]byte, error) {
+		return renderPrayersImage(region, day, next, lang, themeByName(opts.Theme))
+	})
 }
 
-func (b *Bot) cachedCalendarImage(lang, region string, schedule []DayTimes) ([]byte, error) {
-	key := calendarImageCacheKey(lang, region, b.ramadanStart, schedule)
-	return b.imageCache.getOrBuild(key, 12*time.Hour, func() ([]byte, error) {
-		return renderCalendarImage(schedule, b.ramadanStart, lang)
+func (b *Bot) cachedQiblaImage(lang string, opts renderOptions, region string, bearing float64) ([]byte, error) {
+	key := qiblaImageCacheKey(lang, opts, region, bearing)
+	return b.imageCache.getOrBuild(key, 24*time.Hour, func() ([]byte, error) {
+		return renderQiblaImage(region, bearing, lang, themeByName(opts.Theme))
 	})
 }
 
-func (b *Bot) cachedTodayImage(lang, region string, day DayTimes) ([]byte, error) {
-	key := todayImageCacheKey(lang, region, day)
-	ttl := timeUntilNextDay(b.tz)
-	return b.imageCache.getOrBuild(key, ttl, func() ([]byte, error) {
-		return renderTodayImage(region, day, lang)
+func (rm *ReminderManager) cachedTodayImage(lang string, opts renderOptions, region string, day DayTimes) ([]byte, error) {
+	key := todayImageCacheKey(lang, opts, region, day)
+	ttl := timeUntilNextDay(rm.loc)
+	return rm.imageCache.getOrBuild(key, ttl, func() ([]byte, error) {
+		return renderTodayImage(region, day, lang, themeByName(opts.Theme), opts.Hadith, opts.Ayah)
 	})
 }
 
-func (rm *ReminderManager) cachedReminderImage(lang, region string, day int, ev eventSpec) ([]byte, error) {
-	key := reminderImageCacheKey(lang, region, day, ev)
+func (rm *ReminderManager) cachedReminderImage(lang string, opts renderOptions, region string, day int, ev eventSpec) ([]byte, error) {
+	key := reminderImageCacheKey(lang, opts, region, day, ev)
 	ttl := 2 * time.Hour
 	if !ev.Time.IsZero() {
 		until := time.Until(ev.Time.Add(90 * time.Minute))
@@ -2376,31 +8216,37 @@ func (rm *ReminderManager) cachedReminderImage(lang, region string, day int, ev
 		ttl = 15 * time.Minute
 	}
 	return rm.imageCache.getOrBuild(key, ttl, func() ([]byte, error) {
-		return renderReminderImage(region, day, ev, rm.loc, lang)
+		return renderReminderImage(region, day, ev, rm.loc, lang, themeByName(opts.Theme))
 	})
 }
 
-func calendarImageCacheKey(lang, region string, start time.Time, schedule []DayTimes) string {
+func calendarImageCacheKey(lang string, opts renderOptions, region string, start time.Time, schedule []DayTimes) string {
 	h := fnv.New64a()
-	_, _ = fmt.Fprintf(h, "calendar|%s|%s|%s|%d|", lang, region, start.Format("2006-01-02"), len(schedule))
+	_, _ = fmt.Fprintf(h, "calendar|%s|%+v|%s|%s|%d|%s|", lang, opts, region, start.Format("2006-01-02"), len(schedule), imageBrand.cacheTag())
 	for _, d := range schedule {
 		_, _ = fmt.Fprintf(h, "%s|%d|%d|%d|%d|%d|%d|%d;", d.Data, d.Day, d.SuhoorEnd, d.Fajr, d.Dhuhr, d.Asr, d.Maghrib, d.Isha)
 	}
 	return fmt.Sprintf("calendar:%016x", h.Sum64())
 }
 
-func todayImageCacheKey(lang, region string, day DayTimes) string {
+func todayImageCacheKey(lang string, opts renderOptions, region string, day DayTimes) string {
 	h := fnv.New64a()
-	_, _ = fmt.Fprintf(h, "today|%s|%s|%s|%d|%d|%d|%d|%d|%d|%d", lang, region, day.Data, day.Day, day.SuhoorEnd, day.Fajr, day.Dhuhr, day.Asr, day.Maghrib, day.Isha)
+	_, _ = fmt.Fprintf(h, "today|%s|%+v|%s|%s|%d|%d|%d|%d|%d|%d|%d|%s|%s", lang, opts, region, day.Data, day.Day, day.SuhoorEnd, day.Fajr, day.Dhuhr, day.Asr, day.Maghrib, day.Isha, imageBrand.cacheTag(), regionNotes[region])
 	return fmt.Sprintf("today:%016x", h.Sum64())
 }
 
-func reminderImageCacheKey(lang, region string, day int, ev eventSpec) string {
+func reminderImageCacheKey(lang string, opts renderOptions, region string, day int, ev eventSpec) string {
 	h := fnv.New64a()
-	_, _ = fmt.Fprintf(h, "reminder|%s|%s|%d|%s|%s|%s|%t|%t", lang, region, day, ev.Key, ev.Title, ev.Time.Format(time.RFC3339), ev.UseIftar, ev.UseSuhoor)
+	_, _ = fmt.Fprintf(h, "reminder|%s|%+v|%s|%d|%s|%s|%s|%t|%t", lang, opts, region, day, ev.Key, ev.Title, ev.Time.Format(time.RFC3339), ev.UseIftar, ev.UseSuhoor)
 	return fmt.Sprintf("reminder:%016x", h.Sum64())
 }
 
+func qiblaImageCacheKey(lang string, opts renderOptions, region string, bearing float64) string {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "qibla|%s|%+v|%s|%.2f", lang, opts, region, bearing)
+	return fmt.Sprintf("qibla:%016x", h.Sum64())
+}
+
 func timeUntilNextDay(loc *time.Location) time.Duration {
 	if loc == nil {
 		loc = time.Local
@@ -2434,13 +8280,308 @@ func cleanClock(raw string) string {
 	return b.String()
 }
 
-func renderCalendarImage(schedule []DayTimes, start time.Time, lang string) ([]byte, error) {
+// pdfFont embeds a TrueType font in a PDF as a CID font with Identity-H encoding, so
+// any glyph the font has (Cyrillic included) can be written. It records the glyphs a
+// document uses for the width table and the ToUnicode map.
+type pdfFont struct {
+	font *sfnt.Font
+	raw  []byte
+	buf  sfnt.Buffer
+	used map[sfnt.GlyphIndex]rune
+}
+
+func newPDFFont(ttf []byte) (*pdfFont, error) {
+	f, err := sfnt.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	return &pdfFont{font: f, raw: ttf, used: make(map[sfnt.GlyphIndex]rune)}, nil
+}
+
+// encode returns text as a hex string of glyph ids for the Tj operator.
+func (f *pdfFont) encode(text string) string {
+	var b strings.Builder
+	b.WriteByte('<')
+	for _, r := range normalizeImageText(text) {
+		gid, err := f.font.GlyphIndex(&f.buf, r)
+		if err != nil {
+			gid = 0
+		}
+		if gid != 0 {
+			f.used[gid] = r
+		}
+		fmt.Fprintf(&b, "%04X", uint16(gid))
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+// advance returns a glyph's advance width in PDF glyph space (1/1000 em).
+func (f *pdfFont) advance(gid sfnt.GlyphIndex) int {
+	upem := f.font.UnitsPerEm()
+	adv, err := f.font.GlyphAdvance(&f.buf, gid, fixed.Int26_6(upem)<<6, font.HintingNone)
+	if err != nil || upem == 0 {
+		return 0
+	}
+	return int(math.Round(float64(adv) / 64 * 1000 / float64(upem)))
+}
+
+// width returns how wide text is at size points.
+func (f *pdfFont) width(text string, size float64) float64 {
+	total := 0
+	for _, r := range normalizeImageText(text) {
+		if gid, err := f.font.GlyphIndex(&f.buf, r); err == nil {
+			total += f.advance(gid)
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// objects returns the PDF objects for the font, numbered from first: the Type0 font
+// (which pages reference as first) followed by its descendant, descriptor, file and
+// ToUnicode map.
+func (f *pdfFont) objects(first int) ([]string, error) {
+	gids := make([]int, 0, len(f.used))
+	for gid := range f.used {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+
+	var widths, cmap strings.Builder
+	for _, gid := range gids {
+		fmt.Fprintf(&widths, "%d [%d] ", gid, f.advance(sfnt.GlyphIndex(gid)))
+		fmt.Fprintf(&cmap, "<%04X> <%04X>\n", gid, f.used[sfnt.GlyphIndex(gid)])
+	}
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(f.raw); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	toUnicode := "/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n" +
+		fmt.Sprintf("%d beginbfchar\n%sendbfchar\n", len(gids), cmap.String()) +
+		"endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend"
+
+	return []string{
+		fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /GoRegular /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>", first+1, first+4),
+		fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /GoRegular /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /W [%s] /CIDToGIDMap /Identity >>", first+2, widths.String()),
+		fmt.Sprintf("<< /Type /FontDescriptor /FontName /GoRegular /Flags 32 /FontBBox [-200 -250 1100 950] /ItalicAngle 0 /Ascent 950 /Descent -250 /CapHeight 700 /StemV 80 /FontFile2 %d 0 R >>", first+3),
+		fmt.Sprintf("<< /Length %d /Length1 %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), len(f.raw), compressed.String()),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(toUnicode), toUnicode),
+	}, nil
+}
+
+// writePDF assembles numbered objects (object i+1 is objects[i]) into a PDF file whose
+// catalog is object 1.
+func writePDF(objects []string) []byte {
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
+
+// icsAlarmEvents are the prayers whose calendar events carry a reminderLead alarm.
+var icsAlarmEvents = map[string]bool{"suhoor": true, "maghrib": true}
+
+// renderCalendarICS builds an iCalendar file with one event per prayer per Ramadan day.
+// Times are written as local times in loc's zone, which is described by a VTIMEZONE so
+// calendar apps do not shift them; stamp is used for DTSTAMP.
+func renderCalendarICS(schedule []DayTimes, start time.Time, region, lang string, loc *time.Location, stamp time.Time) []byte {
+	if loc == nil {
+		loc = time.Local
+	}
+	h := fnv.New32a()
+	h.Write([]byte(region))
+	regionID := fmt.Sprintf("%08x", h.Sum32())
+	zoneName, offset := reminderDayBaseTime(start, 1, loc).Zone()
+	dtstamp := stamp.UTC().Format("20060102T150405Z")
+	place := regionDisplayName(region, lang)
+
+	var b bytes.Buffer
+	line := func(s string) {
+		// Lines longer than 75 octets are folded with CRLF and a leading space, without
+		// splitting a UTF-8 sequence.
+		limit := 75
+		for len(s) > limit {
+			cut := limit
+			for cut > 0 && !utf8.RuneStart(s[cut]) {
+				cut--
+			}
+			b.WriteString(s[:cut] + "\r\n ")
+			s = s[cut:]
+			limit = 74
+		}
+		b.WriteString(s + "\r\n")
+	}
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//ramadan-bot//Ramadan timetable//" + strings.ToUpper(lang))
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:" + icsEscape(trf(lang, "calendar_text_title", place)))
+	line("X-WR-TIMEZONE:" + loc.String())
+	line("BEGIN:VTIMEZONE")
+	line("TZID:" + loc.String())
+	line("BEGIN:STANDARD")
+	line("DTSTART:19700101T000000")
+	line("TZOFFSETFROM:" + icsOffset(offset))
+	line("TZOFFSETTO:" + icsOffset(offset))
+	line("TZNAME:" + zoneName)
+	line("END:STANDARD")
+	line("END:VTIMEZONE")
+	for _, day := range schedule {
+		if day.Day < 1 {
+			continue
+		}
+		base := reminderDayBaseTime(start, day.Day, loc)
+		for _, key := range prayerKeys {
+			at := base.Add(time.Duration(prayerMinutes(day, key)) * time.Minute)
+			line("BEGIN:VEVENT")
+			line(fmt.Sprintf("UID:%s-%s-%s@ramadan-bot", base.Format("20060102"), key, regionID))
+			line("DTSTAMP:" + dtstamp)
+			line("DTSTART;TZID=" + loc.String() + ":" + at.Format("20060102T150405"))
+			line("DTEND;TZID=" + loc.String() + ":" + at.Add(15*time.Minute).Format("20060102T150405"))
+			line("SUMMARY:" + icsEscape(tr(lang, "event_"+key)))
+			line("LOCATION:" + icsEscape(place))
+			line("TRANSP:TRANSPARENT")
+			if icsAlarmEvents[key] {
+				line("BEGIN:VALARM")
+				line("ACTION:DISPLAY")
+				line("DESCRIPTION:" + icsEscape(tr(lang, "event_"+key)))
+				line(fmt.Sprintf("TRIGGER:-PT%dM", int(reminderLead/time.Minute)))
+				line("END:VALARM")
+			}
+			line("END:VEVENT")
+		}
+	}
+	line("END:VCALENDAR")
+	return b.Bytes()
+}
+
+// icsEscape escapes a TEXT value per RFC 5545.
+func icsEscape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\n", "\\n").Replace(s)
+}
+
+// icsOffset formats a UTC offset in seconds as +HHMM.
+func icsOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+}
+
+// renderCalendarPDF lays the Ramadan schedule out as a printable one-page A4 table with
+// the same columns as the calendar image.
+func renderCalendarPDF(schedule []DayTimes, start time.Time, region, lang string) ([]byte, error) {
+	lang = normalizeLang(lang)
+	if lang == "" {
+		lang = fallbackLang()
+	}
+	f, err := newPDFFont(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+
+	const (
+		pageW, pageH = 595.0, 842.0
+		margin       = 48.0
+		rowH         = 21.0
+		fontSize     = 11.0
+	)
+	var c strings.Builder
+	text := func(x, y, size float64, s string) {
+		fmt.Fprintf(&c, "BT /F1 %.1f Tf %.2f %.2f Td %s Tj ET\n", size, x, y, f.encode(s))
+	}
+
+	y := pageH - margin - 20
+	c.WriteString("0.12 0.16 0.22 rg\n")
+	text(margin, y, 20, tr(lang, "img_calendar_title"))
+	y -= 24
+	c.WriteString("0.35 0.38 0.45 rg\n")
+	text(margin, y, 12, tr(lang, "img_region_prefix")+regionDisplayName(region, lang)+"    "+tr(lang, "img_start_prefix")+start.Format("2006-01-02"))
+	y -= 28
+
+	headers := []string{tr(lang, "img_col_date"), tr(lang, "img_col_day"), tr(lang, "img_col_suhoor"), tr(lang, "img_col_iftar")}
+	colX := []float64{margin, margin + 170, margin + 260, margin + 380}
+	tableW := pageW - margin*2
+
+	fmt.Fprintf(&c, "0.85 0.88 0.93 rg %.2f %.2f %.2f %.2f re f\n", margin, y-rowH+6, tableW, rowH)
+	c.WriteString("0.12 0.16 0.22 rg\n")
+	for i, h := range headers {
+		text(colX[i]+8, y-rowH+12, fontSize, h)
+	}
+	y -= rowH
+
+	tableTop := y + rowH
+	rows := 0
+	for _, day := range schedule {
+		if day.Day < 1 {
+			continue
+		}
+		if rows%2 == 1 {
+			fmt.Fprintf(&c, "0.95 0.96 0.98 rg %.2f %.2f %.2f %.2f re f\n", margin, y-rowH+6, tableW, rowH)
+		}
+		c.WriteString("0.1 0.1 0.1 rg\n")
+		cells := []string{day.Data, fmt.Sprintf("%02d", day.Day), minutesToClock(day.SuhoorEnd), minutesToClock(day.Maghrib)}
+		for i, cell := range cells {
+			text(colX[i]+8, y-rowH+12, fontSize, cell)
+		}
+		y -= rowH
+		rows++
+	}
+
+	// Grid: outer frame, column separators and a rule under the header.
+	bottom := y + 6
+	fmt.Fprintf(&c, "0.6 0.64 0.7 RG 0.6 w %.2f %.2f %.2f %.2f re S\n", margin, bottom, tableW, tableTop+6-bottom)
+	for _, x := range colX[1:] {
+		fmt.Fprintf(&c, "%.2f %.2f m %.2f %.2f l S\n", x, bottom, x, tableTop+6)
+	}
+	fmt.Fprintf(&c, "%.2f %.2f m %.2f %.2f l S\n", margin, tableTop-rowH+6, margin+tableW, tableTop-rowH+6)
+
+	c.WriteString("0.35 0.38 0.45 rg\n")
+	footer := tr(lang, "img_calendar_footer")
+	text(pageW-margin-f.width(footer, 9), margin-20, 9, footer)
+
+	content := c.String()
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>", pageW, pageH),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+	}
+	fontObjects, err := f.objects(len(objects) + 1)
+	if err != nil {
+		return nil, err
+	}
+	return writePDF(append(objects, fontObjects...)), nil
+}
+
+func renderCalendarImage(schedule []DayTimes, start time.Time, lang string, theme Theme, hadith Hadith) ([]byte, error) {
 	if len(schedule) == 0 {
 		return nil, fmt.Errorf("empty schedule")
 	}
 	lang = normalizeLang(lang)
 	if lang == "" {
-		lang = langTG
+		lang = fallbackLang()
 	}
 
 	schedule = schedule[1:]
@@ -2462,33 +8603,37 @@ func renderCalendarImage(schedule []DayTimes, start time.Time, lang string) ([]b
 	)
 
 	tableH := tableHeaderH + len(schedule)*rowH
+	hadithLines, hadithH := layoutHadithPanel(faces.TableHeader, faces.Footer, hadith.String(), imgW-imgMargin*2-4-36)
 	cardH := headerAreaH + tableH + footerH + 60
+	if hadithH > 0 {
+		cardH += hadithH + 14
+	}
 	imgH := cardH + imgMargin*2
 
 	img := image.NewRGBA(image.Rect(0, 0, imgW, imgH))
-	drawVerticalGradient(img, color.RGBA{R: 8, G: 17, B: 33, A: 255}, color.RGBA{R: 4, G: 10, B: 22, A: 255})
-	drawRadialGlow(img, imgW-190, 120, 250, color.RGBA{R: 69, G: 197, B: 173, A: 100})
-	drawRadialGlow(img, 160, imgH-170, 280, color.RGBA{R: 216, G: 168, B: 79, A: 78})
+	drawVerticalGradient(img, theme.BackgroundTop, theme.BackgroundBottom)
+	drawRadialGlow(img, imgW-190, 120, 250, theme.GlowPrimary)
+	drawRadialGlow(img, 160, imgH-170, 280, theme.GlowSecondary)
 
 	card := image.Rect(imgMargin, imgMargin, imgW-imgMargin, imgMargin+cardH)
 	shadow := image.Rect(card.Min.X+6, card.Min.Y+8, card.Max.X+6, card.Max.Y+8)
-	fillRoundedRect(img, shadow, cardRadius, color.RGBA{R: 2, G: 6, B: 15, A: 120})
-	fillRoundedRect(img, card, cardRadius, color.RGBA{R: 96, G: 124, B: 164, A: 255})
+	fillRoundedRect(img, shadow, cardRadius, theme.Shadow)
+	fillRoundedRect(img, card, cardRadius, theme.CardBorder)
 
 	inner := image.Rect(card.Min.X+2, card.Min.Y+2, card.Max.X-2, card.Max.Y-2)
-	fillRoundedRect(img, inner, cardRadius-2, color.RGBA{R: 13, G: 25, B: 42, A: 255})
+	fillRoundedRect(img, inner, cardRadius-2, theme.CardFill)
 
 	headerRect := image.Rect(inner.Min.X+16, inner.Min.Y+16, inner.Max.X-16, inner.Min.Y+16+headerAreaH)
-	fillRoundedRect(img, headerRect, 18, color.RGBA{R: 23, G: 43, B: 70, A: 255})
+	fillRoundedRect(img, headerRect, 18, theme.HeaderFill)
 	fillRoundedRect(
 		img,
 		image.Rect(headerRect.Min.X+1, headerRect.Min.Y+1, headerRect.Max.X-1, headerRect.Min.Y+headerRect.Dy()/2),
 		16,
-		color.RGBA{R: 31, G: 58, B: 94, A: 255},
+		theme.HeaderHighlight,
 	)
 
-	titleColor := color.RGBA{R: 243, G: 247, B: 252, A: 255}
-	subtitleColor := color.RGBA{R: 177, G: 194, B: 214, A: 255}
+	titleColor := theme.Title
+	subtitleColor := theme.Subtitle
 	drawTextTop(img, faces.Title, headerRect.Min.X+22, headerRect.Min.Y+18, tr(lang, "img_calendar_title"), titleColor)
 	drawTextTop(img, faces.Subtitle, headerRect.Min.X+22, headerRect.Min.Y+66, tr(lang, "img_start_prefix")+start.Format("2006-01-02"), subtitleColor)
 	drawTextTop(img, faces.Subtitle, headerRect.Min.X+22, headerRect.Min.Y+94, tr(lang, "img_calendar_subtitle"), subtitleColor)
@@ -2497,17 +8642,24 @@ func renderCalendarImage(schedule []DayTimes, start time.Time, lang string) ([]b
 	badgeW := measureTextWidth(faces.Badge, badgeText) + 28
 	badgeH := 38
 	badge := image.Rect(headerRect.Max.X-badgeW-18, headerRect.Min.Y+20, headerRect.Max.X-18, headerRect.Min.Y+20+badgeH)
-	fillRoundedRect(img, badge, 12, color.RGBA{R: 230, G: 184, B: 102, A: 255})
+	fillRoundedRect(img, badge, 12, theme.Accent)
 	badgeTextX := badge.Min.X + (badge.Dx()-measureTextWidth(faces.Badge, badgeText))/2
-	drawTextTop(img, faces.Badge, badgeTextX, badge.Min.Y+8, badgeText, color.RGBA{R: 32, G: 25, B: 15, A: 255})
+	drawTextTop(img, faces.Badge, badgeTextX, badge.Min.Y+8, badgeText, theme.AccentText)
+
+	// Moon phase for the start date sits under the badge, clear of the left-aligned title block.
+	moonR := 22
+	moonCX := badge.Max.X - badge.Dx()/2
+	moonCY := badge.Max.Y + (headerRect.Max.Y-badge.Max.Y)/2
+	drawRadialGlow(img, moonCX, moonCY, moonR+16, color.RGBA{R: 230, G: 184, B: 102, A: 60})
+	drawMoonGlyph(img, moonCX, moonCY, moonR, moonPhase(start), moonWaxing(start))
 
 	tableRect := image.Rect(inner.Min.X+18, headerRect.Max.Y+14, inner.Max.X-18, headerRect.Max.Y+14+tableH)
-	fillRoundedRect(img, tableRect, 16, color.RGBA{R: 84, G: 109, B: 145, A: 255})
+	fillRoundedRect(img, tableRect, 16, theme.TableBorder)
 	tableInner := image.Rect(tableRect.Min.X+2, tableRect.Min.Y+2, tableRect.Max.X-2, tableRect.Max.Y-2)
-	fillRoundedRect(img, tableInner, 14, color.RGBA{R: 12, G: 30, B: 49, A: 255})
+	fillRoundedRect(img, tableInner, 14, theme.TableFill)
 
 	headerRow := image.Rect(tableInner.Min.X, tableInner.Min.Y, tableInner.Max.X, tableInner.Min.Y+tableHeaderH)
-	fillRect(img, headerRow, color.RGBA{R: 24, G: 53, B: 85, A: 255})
+	fillRect(img, headerRow, theme.TableHeader)
 
 	colDayW := 92
 	colDateW := int(float64(tableInner.Dx()-colDayW) * 0.42)
@@ -2533,11 +8685,11 @@ func renderCalendarImage(schedule []DayTimes, start time.Time, lang string) ([]b
 		todayDay = -1
 	}
 
-	rowTextColor := color.RGBA{R: 232, G: 240, B: 249, A: 255}
-	rowA := color.RGBA{R: 18, G: 37, B: 61, A: 255}
-	rowB := color.RGBA{R: 14, G: 31, B: 52, A: 255}
-	preStartRow := color.RGBA{R: 31, G: 57, B: 86, A: 255}
-	todayRow := color.RGBA{R: 58, G: 84, B: 120, A: 255}
+	rowTextColor := theme.RowText
+	rowA := theme.RowA
+	rowB := theme.RowB
+	preStartRow := theme.RowPreStart
+	todayRow := theme.RowToday
 
 	rowsTop := headerRow.Max.Y
 	for i, day := range schedule {
@@ -2570,17 +8722,349 @@ func renderCalendarImage(schedule []DayTimes, start time.Time, lang string) ([]b
 		drawTextTop(img, faces.TableRow, x3+padX, textY, minutesToClock(day.Maghrib), rowTextColor)
 	}
 
-	grid := color.RGBA{R: 74, G: 100, B: 132, A: 255}
-	fillRect(img, image.Rect(x1, tableInner.Min.Y, x1+1, tableInner.Max.Y), grid)
-	fillRect(img, image.Rect(x2, tableInner.Min.Y, x2+1, tableInner.Max.Y), grid)
-	fillRect(img, image.Rect(x3, tableInner.Min.Y, x3+1, tableInner.Max.Y), grid)
-	for i := 0; i <= len(schedule); i++ {
-		y := rowsTop + i*rowH
-		fillRect(img, image.Rect(tableInner.Min.X, y, tableInner.Max.X, y+1), grid)
-	}
+	grid := theme.Grid
+	fillRect(img, image.Rect(x1, tableInner.Min.Y, x1+1, tableInner.Max.Y), grid)
+	fillRect(img, image.Rect(x2, tableInner.Min.Y, x2+1, tableInner.Max.Y), grid)
+	fillRect(img, image.Rect(x3, tableInner.Min.Y, x3+1, tableInner.Max.Y), grid)
+	for i := 0; i <= len(schedule); i++ {
+		y := rowsTop + i*rowH
+		fillRect(img, image.Rect(tableInner.Min.X, y, tableInner.Max.X, y+1), grid)
+	}
+
+	footerY := tableRect.Max.Y + 16
+	if hadithH > 0 {
+		panel := image.Rect(tableRect.Min.X, tableRect.Max.Y+14, tableRect.Max.X, tableRect.Max.Y+14+hadithH)
+		drawHadithPanel(img, panel, faces.TableHeader, faces.Footer, tr(lang, "hadith_day_title"), hadithLines, theme)
+		footerY = panel.Max.Y + 16
+	}
+	footer := tr(lang, "img_calendar_footer")
+	drawTextTop(img, faces.Footer, tableRect.Min.X, footerY, footer, subtitleColor)
+	// The brand shares the footer's strip, so it only gets the width to the quote's right.
+	brandLeft := tableRect.Min.X + measureTextWidth(faces.Footer, footer) + 24
+	drawBrand(img, image.Rect(brandLeft, inner.Max.Y-14-brandLogoMax, tableRect.Max.X, inner.Max.Y-14), faces.Footer, subtitleColor)
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+const (
+	hadithPanelPad      = 20
+	hadithPanelLineGap  = 6
+	hadithPanelMaxLines = 10
+)
+
+// layoutHadithPanel wraps hadith to fit a panel of the given width and returns the
+// lines together with the panel height they need. Very long texts are cut off after
+// hadithPanelMaxLines with an ellipsis so the card stays a sensible size.
+func layoutHadithPanel(titleFace, bodyFace font.Face, hadith string, width int) ([]string, int) {
+	lines := wrapText(bodyFace, hadith, width-hadithPanelPad*2)
+	if len(lines) == 0 {
+		return nil, 0
+	}
+	if len(lines) > hadithPanelMaxLines {
+		lines = lines[:hadithPanelMaxLines]
+		lines[len(lines)-1] += " …"
+	}
+	height := hadithPanelPad*2 + faceLineHeight(titleFace) + 10 + len(lines)*(faceLineHeight(bodyFace)+hadithPanelLineGap)
+	return lines, height
+}
+
+// brandLogoMax bounds the logo so it fits the free strip at the bottom of the cards.
+const brandLogoMax = 56
+
+// brandMark is the operator's credit (BRAND_TEXT, BRAND_LOGO) drawn in the bottom-right
+// corner of calendar and today cards.
+type brandMark struct {
+	Text string
+	Logo image.Image
+	// logoSum identifies the logo contents in cache keys.
+	logoSum uint64
+}
+
+var imageBrand brandMark
+
+// loadLogo decodes the PNG at path and scales it down to fit brandLogoMax once, so
+// renders only have to copy it.
+func (m *brandMark) loadLogo(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	logo, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if b := logo.Bounds(); b.Dx() > brandLogoMax || b.Dy() > brandLogoMax {
+		scale := math.Min(float64(brandLogoMax)/float64(b.Dx()), float64(brandLogoMax)/float64(b.Dy()))
+		w := int(math.Max(1, math.Round(float64(b.Dx())*scale)))
+		h := int(math.Max(1, math.Round(float64(b.Dy())*scale)))
+		scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+		xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), logo, b, xdraw.Over, nil)
+		logo = scaled
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	m.Logo = logo
+	m.logoSum = h.Sum64()
+	return nil
+}
+
+// cacheTag changes whenever the brand does, so cached cards are not served with a
+// stale credit.
+func (m brandMark) cacheTag() string {
+	if m.Text == "" && m.Logo == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s|%016x", m.Text, m.logoSum)
+}
+
+// drawBrand puts the logo in the bottom-right corner of area with the text to its left,
+// both bottom-aligned. Text that does not fit beside the logo is cut short.
+func drawBrand(img *image.RGBA, area image.Rectangle, face font.Face, clr color.RGBA) {
+	x := area.Max.X
+	if logo := imageBrand.Logo; logo != nil {
+		lb := logo.Bounds()
+		dst := image.Rect(x-lb.Dx(), area.Max.Y-lb.Dy(), x, area.Max.Y)
+		draw.Draw(img, dst, logo, lb.Min, draw.Over)
+		x = dst.Min.X - 12
+	}
+	if text := fitTextWidth(face, imageBrand.Text, x-area.Min.X); text != "" {
+		x -= measureTextWidth(face, text)
+		drawTextTop(img, face, x, area.Max.Y-faceLineHeight(face), text, clr)
+	}
+}
+
+// fitTextWidth returns text, or its longest prefix ending in "…" that fits in width
+// pixels; "" if not even that fits.
+func fitTextWidth(face font.Face, text string, width int) string {
+	if measureTextWidth(face, text) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for n := len(runes) - 1; n > 0; n-- {
+		if cut := strings.TrimSpace(string(runes[:n])) + "…"; measureTextWidth(face, cut) <= width {
+			return cut
+		}
+	}
+	return ""
+}
+
+func drawHadithPanel(img *image.RGBA, rect image.Rectangle, titleFace, bodyFace font.Face, title string, lines []string, theme Theme) {
+	fillRoundedRect(img, rect, 16, theme.FooterFill)
+	x := rect.Min.X + hadithPanelPad
+	y := rect.Min.Y + hadithPanelPad
+	drawTextTop(img, titleFace, x, y, title, theme.Title)
+	y += faceLineHeight(titleFace) + 10
+	for _, line := range lines {
+		drawTextTop(img, bodyFace, x, y, line, theme.Subtitle)
+		y += faceLineHeight(bodyFace) + hadithPanelLineGap
+	}
+}
+
+func renderTodayImage(region string, day DayTimes, lang string, theme Theme, hadith, ayah Hadith) ([]byte, error) {
+	lang = normalizeLang(lang)
+	if lang == "" {
+		lang = fallbackLang()
+	}
+	faces, err := loadTodayCardFaces()
+	if err != nil {
+		return nil, err
+	}
+	defer faces.Close()
+
+	const (
+		imgW       = 980
+		margin     = 34
+		cardRadius = 24
+	)
+
+	// The card grows by the hadith and ayah panels, if any, below the details box.
+	hadithLines, hadithH := layoutHadithPanel(faces.Subtitle, faces.Footer, hadith.String(), imgW-margin*2-4-36)
+	ayahLines, ayahH := layoutHadithPanel(faces.Subtitle, faces.Footer, ayah.String(), imgW-margin*2-4-36)
+	imgH := 650
+	if hadithH > 0 {
+		imgH += hadithH + 16
+	}
+	if ayahH > 0 {
+		imgH += ayahH + 16
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, imgW, imgH))
+	drawVerticalGradient(img, theme.BackgroundTop, theme.BackgroundBottom)
+	drawRadialGlow(img, imgW-170, 120, 230, theme.GlowPrimary)
+	drawRadialGlow(img, 180, imgH-120, 240, theme.GlowSecondary)
+
+	card := image.Rect(margin, margin, imgW-margin, imgH-margin)
+	shadow := image.Rect(card.Min.X+7, card.Min.Y+9, card.Max.X+7, card.Max.Y+9)
+	fillRoundedRect(img, shadow, cardRadius, theme.Shadow)
+	fillRoundedRect(img, card, cardRadius, theme.CardBorder)
+
+	inner := image.Rect(card.Min.X+2, card.Min.Y+2, card.Max.X-2, card.Max.Y-2)
+	fillRoundedRect(img, inner, cardRadius-2, theme.CardFill)
+
+	header := image.Rect(inner.Min.X+18, inner.Min.Y+18, inner.Max.X-18, inner.Min.Y+170)
+	fillRoundedRect(img, header, 18, theme.HeaderFill)
+	fillRoundedRect(
+		img,
+		image.Rect(header.Min.X+1, header.Min.Y+1, header.Max.X-1, header.Min.Y+header.Dy()/2),
+		16,
+		theme.HeaderHighlight,
+	)
+
+	titleColor := theme.Title
+	subtitleColor := theme.Subtitle
+
+	drawTextTop(img, faces.Title, header.Min.X+22, header.Min.Y+20, tr(lang, "img_today_title"), titleColor)
+	drawTextTop(img, faces.Subtitle, header.Min.X+22, header.Min.Y+70, tr(lang, "img_region_prefix")+regionDisplayName(region, lang), subtitleColor)
+	dateLine := trf(lang, "img_date_day", day.Data, dayLabel(lang, day.Day))
+	if hijri := dayHijriDate(lang, day); hijri != "" {
+		dateLine += "    " + hijri
+	}
+	drawTextTop(
+		img,
+		faces.Subtitle,
+		header.Min.X+22,
+		header.Min.Y+102,
+		dateLine,
+		subtitleColor,
+	)
+
+	progressLabel := fmt.Sprintf("%d/%d", day.Day, ramadanDays)
+	if day.Day < 1 {
+		progressLabel = "—"
+	}
+	progressW := 130
+	progressH := 40
+	progress := image.Rect(header.Max.X-progressW-22, header.Min.Y+24, header.Max.X-22, header.Min.Y+24+progressH)
+	fillRoundedRect(img, progress, 12, theme.Accent)
+	progressTextX := progress.Min.X + (progressW-measureTextWidth(faces.Badge, progressLabel))/2
+	drawTextTop(img, faces.Badge, progressTextX, progress.Min.Y+9, progressLabel, theme.AccentText)
+
+	boxGap := 18
+	boxTop := header.Max.Y + 18
+	boxBottom := boxTop + 194
+	boxW := (inner.Dx() - 18*2 - boxGap) / 2
+	leftBox := image.Rect(inner.Min.X+18, boxTop, inner.Min.X+18+boxW, boxBottom)
+	rightBox := image.Rect(leftBox.Max.X+boxGap, boxTop, leftBox.Max.X+boxGap+boxW, boxBottom)
+	fillRoundedRect(img, leftBox, 18, theme.PanelAltFill)
+	fillRoundedRect(img, rightBox, 18, theme.PanelFill)
+
+	drawTextTop(img, faces.Label, leftBox.Min.X+24, leftBox.Min.Y+26, tr(lang, "img_today_suhoor_label"), subtitleColor)
+	drawTextTop(img, faces.Time, leftBox.Min.X+24, leftBox.Min.Y+72, minutesToClock(day.SuhoorEnd), titleColor)
+
+	drawTextTop(img, faces.Label, rightBox.Min.X+24, rightBox.Min.Y+26, tr(lang, "img_today_iftar_label"), subtitleColor)
+	drawTextTop(img, faces.Time, rightBox.Min.X+24, rightBox.Min.Y+72, minutesToClock(day.Maghrib), titleColor)
+
+	details := image.Rect(inner.Min.X+18, leftBox.Max.Y+16, inner.Max.X-18, leftBox.Max.Y+16+92)
+	fillRoundedRect(img, details, 16, theme.FooterFill)
+
+	footerLines := []string{tr(lang, "img_today_footer")}
+	if note := regionNotes[region]; note != "" {
+		footerLines = wrapText(faces.Footer, note, details.Dx()-40)
+		if len(footerLines) > regionNoteMaxLines {
+			footerLines = footerLines[:regionNoteMaxLines]
+			footerLines[len(footerLines)-1] += " …"
+		}
+	}
+	footerStep := faceLineHeight(faces.Footer) + 6
+	for i, line := range footerLines {
+		drawTextTop(img, faces.Footer, details.Min.X+20, details.Min.Y+52-(len(footerLines)-1-i)*footerStep, line, subtitleColor)
+	}
+
+	panelTop := details.Max.Y + 16
+	if hadithH > 0 {
+		panel := image.Rect(details.Min.X, panelTop, details.Max.X, panelTop+hadithH)
+		drawHadithPanel(img, panel, faces.Subtitle, faces.Footer, tr(lang, "hadith_day_title"), hadithLines, theme)
+		panelTop = panel.Max.Y + 16
+	}
+	if ayahH > 0 {
+		panel := image.Rect(details.Min.X, panelTop, details.Max.X, panelTop+ayahH)
+		drawHadithPanel(img, panel, faces.Subtitle, faces.Footer, tr(lang, "ayah_day_title"), ayahLines, theme)
+	}
+	drawBrand(img, image.Rect(inner.Min.X+18, inner.Max.Y-18-brandLogoMax, inner.Max.X-18, inner.Max.Y-18), faces.Footer, subtitleColor)
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// renderCompareImage draws today's suhoor and iftar for two regions in the two-box
+// layout of the today card, one box per region.
+func renderCompareImage(regionA string, dayA DayTimes, regionB string, dayB DayTimes, lang string, theme Theme) ([]byte, error) {
+	lang = normalizeLang(lang)
+	if lang == "" {
+		lang = fallbackLang()
+	}
+	faces, err := loadTodayCardFaces()
+	if err != nil {
+		return nil, err
+	}
+	defer faces.Close()
+
+	const (
+		imgW       = 980
+		imgH       = 660
+		margin     = 34
+		cardRadius = 24
+	)
+
+	img := image.NewRGBA(image.Rect(0, 0, imgW, imgH))
+	drawVerticalGradient(img, theme.BackgroundTop, theme.BackgroundBottom)
+	drawRadialGlow(img, imgW-170, 120, 230, theme.GlowPrimary)
+	drawRadialGlow(img, 180, imgH-120, 240, theme.GlowSecondary)
+
+	card := image.Rect(margin, margin, imgW-margin, imgH-margin)
+	shadow := image.Rect(card.Min.X+7, card.Min.Y+9, card.Max.X+7, card.Max.Y+9)
+	fillRoundedRect(img, shadow, cardRadius, theme.Shadow)
+	fillRoundedRect(img, card, cardRadius, theme.CardBorder)
+
+	inner := image.Rect(card.Min.X+2, card.Min.Y+2, card.Max.X-2, card.Max.Y-2)
+	fillRoundedRect(img, inner, cardRadius-2, theme.CardFill)
+
+	header := image.Rect(inner.Min.X+18, inner.Min.Y+18, inner.Max.X-18, inner.Min.Y+128)
+	fillRoundedRect(img, header, 18, theme.HeaderFill)
+	fillRoundedRect(
+		img,
+		image.Rect(header.Min.X+1, header.Min.Y+1, header.Max.X-1, header.Min.Y+header.Dy()/2),
+		16,
+		theme.HeaderHighlight,
+	)
+
+	titleColor := theme.Title
+	subtitleColor := theme.Subtitle
+	drawTextTop(img, faces.Title, header.Min.X+22, header.Min.Y+20, tr(lang, "img_compare_title"), titleColor)
+	drawTextTop(img, faces.Subtitle, header.Min.X+22, header.Min.Y+70, trf(lang, "img_date_day", dayA.Data, dayLabel(lang, dayA.Day)), subtitleColor)
 
-	footerY := tableRect.Max.Y + 16
-	drawTextTop(img, faces.Footer, tableRect.Min.X, footerY, tr(lang, "img_calendar_footer"), subtitleColor)
+	boxGap := 18
+	boxTop := header.Max.Y + 18
+	boxBottom := boxTop + 320
+	boxW := (inner.Dx() - 18*2 - boxGap) / 2
+	leftBox := image.Rect(inner.Min.X+18, boxTop, inner.Min.X+18+boxW, boxBottom)
+	rightBox := image.Rect(leftBox.Max.X+boxGap, boxTop, leftBox.Max.X+boxGap+boxW, boxBottom)
+	fillRoundedRect(img, leftBox, 18, theme.PanelAltFill)
+	fillRoundedRect(img, rightBox, 18, theme.PanelFill)
+
+	for _, col := range []struct {
+		box    image.Rectangle
+		region string
+		day    DayTimes
+	}{{leftBox, regionA, dayA}, {rightBox, regionB, dayB}} {
+		x := col.box.Min.X + 24
+		drawTextTop(img, faces.Label, x, col.box.Min.Y+22, regionDisplayName(col.region, lang), titleColor)
+		drawTextTop(img, faces.Subtitle, x, col.box.Min.Y+78, tr(lang, "img_today_suhoor_label"), subtitleColor)
+		drawTextTop(img, faces.Time, x, col.box.Min.Y+108, minutesToClock(col.day.SuhoorEnd), titleColor)
+		drawTextTop(img, faces.Subtitle, x, col.box.Min.Y+188, tr(lang, "img_today_iftar_label"), subtitleColor)
+		drawTextTop(img, faces.Time, x, col.box.Min.Y+218, minutesToClock(col.day.Maghrib), titleColor)
+	}
+
+	details := image.Rect(inner.Min.X+18, leftBox.Max.Y+16, inner.Max.X-18, inner.Max.Y-18)
+	fillRoundedRect(img, details, 16, theme.FooterFill)
+	drawTextTop(img, faces.Footer, details.Min.X+20, details.Min.Y+28, compareDiffLine(lang, regionB, dayA, dayB), subtitleColor)
 
 	var out bytes.Buffer
 	if err := png.Encode(&out, img); err != nil {
@@ -2589,10 +9073,12 @@ func renderCalendarImage(schedule []DayTimes, start time.Time, lang string) ([]b
 	return out.Bytes(), nil
 }
 
-func renderTodayImage(region string, day DayTimes, lang string) ([]byte, error) {
+// renderPrayersImage draws all six of the day's times, one row each, highlighting the
+// row for next when it is set.
+func renderPrayersImage(region string, day DayTimes, next string, lang string, theme Theme) ([]byte, error) {
 	lang = normalizeLang(lang)
 	if lang == "" {
-		lang = langTG
+		lang = fallbackLang()
 	}
 	faces, err := loadTodayCardFaces()
 	if err != nil {
@@ -2602,74 +9088,59 @@ func renderTodayImage(region string, day DayTimes, lang string) ([]byte, error)
 
 	const (
 		imgW       = 980
-		imgH       = 650
 		margin     = 34
 		cardRadius = 24
+		headerH    = 140
+		rowH       = 66
+		rowGap     = 8
 	)
+	rowsH := len(prayerKeys)*(rowH+rowGap) - rowGap
+	imgH := margin*2 + 4 + 18 + headerH + 18 + rowsH + 22
 
 	img := image.NewRGBA(image.Rect(0, 0, imgW, imgH))
-	drawVerticalGradient(img, color.RGBA{R: 9, G: 20, B: 36, A: 255}, color.RGBA{R: 6, G: 13, B: 25, A: 255})
-	drawRadialGlow(img, imgW-170, 120, 230, color.RGBA{R: 85, G: 189, B: 173, A: 95})
-	drawRadialGlow(img, 180, imgH-120, 240, color.RGBA{R: 224, G: 177, B: 93, A: 68})
+	drawVerticalGradient(img, theme.BackgroundTop, theme.BackgroundBottom)
+	drawRadialGlow(img, imgW-170, 120, 230, theme.GlowPrimary)
+	drawRadialGlow(img, 180, imgH-120, 240, theme.GlowSecondary)
 
 	card := image.Rect(margin, margin, imgW-margin, imgH-margin)
 	shadow := image.Rect(card.Min.X+7, card.Min.Y+9, card.Max.X+7, card.Max.Y+9)
-	fillRoundedRect(img, shadow, cardRadius, color.RGBA{R: 2, G: 6, B: 16, A: 120})
-	fillRoundedRect(img, card, cardRadius, color.RGBA{R: 95, G: 123, B: 161, A: 255})
+	fillRoundedRect(img, shadow, cardRadius, theme.Shadow)
+	fillRoundedRect(img, card, cardRadius, theme.CardBorder)
 
 	inner := image.Rect(card.Min.X+2, card.Min.Y+2, card.Max.X-2, card.Max.Y-2)
-	fillRoundedRect(img, inner, cardRadius-2, color.RGBA{R: 13, G: 25, B: 42, A: 255})
+	fillRoundedRect(img, inner, cardRadius-2, theme.CardFill)
 
-	header := image.Rect(inner.Min.X+18, inner.Min.Y+18, inner.Max.X-18, inner.Min.Y+170)
-	fillRoundedRect(img, header, 18, color.RGBA{R: 25, G: 47, B: 74, A: 255})
+	header := image.Rect(inner.Min.X+18, inner.Min.Y+18, inner.Max.X-18, inner.Min.Y+18+headerH)
+	fillRoundedRect(img, header, 18, theme.HeaderFill)
 	fillRoundedRect(
 		img,
 		image.Rect(header.Min.X+1, header.Min.Y+1, header.Max.X-1, header.Min.Y+header.Dy()/2),
 		16,
-		color.RGBA{R: 34, G: 63, B: 98, A: 255},
+		theme.HeaderHighlight,
 	)
 
-	titleColor := color.RGBA{R: 243, G: 247, B: 252, A: 255}
-	subtitleColor := color.RGBA{R: 176, G: 194, B: 215, A: 255}
-
-	drawTextTop(img, faces.Title, header.Min.X+22, header.Min.Y+20, tr(lang, "img_today_title"), titleColor)
-	drawTextTop(img, faces.Subtitle, header.Min.X+22, header.Min.Y+70, tr(lang, "img_region_prefix")+region, subtitleColor)
-	drawTextTop(
-		img,
-		faces.Subtitle,
-		header.Min.X+22,
-		header.Min.Y+102,
-		trf(lang, "img_date_day", day.Data, day.Day),
-		subtitleColor,
-	)
-
-	progressLabel := fmt.Sprintf("%d/30", day.Day)
-	progressW := 130
-	progressH := 40
-	progress := image.Rect(header.Max.X-progressW-22, header.Min.Y+24, header.Max.X-22, header.Min.Y+24+progressH)
-	fillRoundedRect(img, progress, 12, color.RGBA{R: 230, G: 184, B: 101, A: 255})
-	progressTextX := progress.Min.X + (progressW-measureTextWidth(faces.Badge, progressLabel))/2
-	drawTextTop(img, faces.Badge, progressTextX, progress.Min.Y+9, progressLabel, color.RGBA{R: 33, G: 26, B: 16, A: 255})
-
-	boxGap := 18
-	boxTop := header.Max.Y + 18
-	boxBottom := boxTop + 194
-	boxW := (inner.Dx() - 18*2 - boxGap) / 2
-	leftBox := image.Rect(inner.Min.X+18, boxTop, inner.Min.X+18+boxW, boxBottom)
-	rightBox := image.Rect(leftBox.Max.X+boxGap, boxTop, leftBox.Max.X+boxGap+boxW, boxBottom)
-	fillRoundedRect(img, leftBox, 18, color.RGBA{R: 27, G: 56, B: 88, A: 255})
-	fillRoundedRect(img, rightBox, 18, color.RGBA{R: 24, G: 48, B: 76, A: 255})
-
-	drawTextTop(img, faces.Label, leftBox.Min.X+24, leftBox.Min.Y+26, tr(lang, "img_today_suhoor_label"), subtitleColor)
-	drawTextTop(img, faces.Time, leftBox.Min.X+24, leftBox.Min.Y+72, minutesToClock(day.SuhoorEnd), titleColor)
-
-	drawTextTop(img, faces.Label, rightBox.Min.X+24, rightBox.Min.Y+26, tr(lang, "img_today_iftar_label"), subtitleColor)
-	drawTextTop(img, faces.Time, rightBox.Min.X+24, rightBox.Min.Y+72, minutesToClock(day.Maghrib), titleColor)
-
-	details := image.Rect(inner.Min.X+18, leftBox.Max.Y+16, inner.Max.X-18, leftBox.Max.Y+16+92)
-	fillRoundedRect(img, details, 16, color.RGBA{R: 18, G: 40, B: 63, A: 255})
+	titleColor := theme.Title
+	subtitleColor := theme.Subtitle
+	drawTextTop(img, faces.Title, header.Min.X+22, header.Min.Y+20, tr(lang, "img_prayers_title"), titleColor)
+	drawTextTop(img, faces.Subtitle, header.Min.X+22, header.Min.Y+70, tr(lang, "img_region_prefix")+regionDisplayName(region, lang), subtitleColor)
+	drawTextTop(img, faces.Subtitle, header.Min.X+22, header.Min.Y+100, trf(lang, "img_date_day", day.Data, dayLabel(lang, day.Day)), subtitleColor)
 
-	drawTextTop(img, faces.Footer, details.Min.X+20, details.Min.Y+52, tr(lang, "img_today_footer"), subtitleColor)
+	top := header.Max.Y + 18
+	for i, key := range prayerKeys {
+		row := image.Rect(inner.Min.X+18, top, inner.Max.X-18, top+rowH)
+		fill, labelColor, timeColor := theme.PanelFill, subtitleColor, titleColor
+		if i%2 == 1 {
+			fill = theme.PanelAltFill
+		}
+		if key == next {
+			fill, labelColor, timeColor = theme.Accent, theme.AccentText, theme.AccentText
+		}
+		fillRoundedRect(img, row, 14, fill)
+		drawTextTop(img, faces.Label, row.Min.X+24, row.Min.Y+(rowH-faceLineHeight(faces.Label))/2, tr(lang, "img_prayer_"+key), labelColor)
+		clock := minutesToClock(prayerMinutes(day, key))
+		drawTextTop(img, faces.Title, row.Max.X-24-measureTextWidth(faces.Title, clock), row.Min.Y+(rowH-faceLineHeight(faces.Title))/2, clock, timeColor)
+		top += rowH + rowGap
+	}
 
 	var out bytes.Buffer
 	if err := png.Encode(&out, img); err != nil {
@@ -2678,10 +9149,10 @@ func renderTodayImage(region string, day DayTimes, lang string) ([]byte, error)
 	return out.Bytes(), nil
 }
 
-func renderReminderImage(region string, day int, ev eventSpec, loc *time.Location, lang string) ([]byte, error) {
+func renderReminderImage(region string, day int, ev eventSpec, loc *time.Location, lang string, theme Theme) ([]byte, error) {
 	lang = normalizeLang(lang)
 	if lang == "" {
-		lang = langTG
+		lang = fallbackLang()
 	}
 	faces, err := loadReminderCardFaces()
 	if err != nil {
@@ -2697,48 +9168,133 @@ func renderReminderImage(region string, day int, ev eventSpec, loc *time.Locatio
 	)
 
 	img := image.NewRGBA(image.Rect(0, 0, imgW, imgH))
-	drawVerticalGradient(img, color.RGBA{R: 9, G: 19, B: 34, A: 255}, color.RGBA{R: 6, G: 13, B: 24, A: 255})
-	drawRadialGlow(img, imgW-180, 110, 220, color.RGBA{R: 89, G: 188, B: 174, A: 90})
-	drawRadialGlow(img, 150, imgH-90, 220, color.RGBA{R: 224, G: 174, B: 91, A: 65})
+	drawVerticalGradient(img, theme.BackgroundTop, theme.BackgroundBottom)
+	drawRadialGlow(img, imgW-180, 110, 220, theme.GlowPrimary)
+	drawRadialGlow(img, 150, imgH-90, 220, theme.GlowSecondary)
 
 	card := image.Rect(margin, margin, imgW-margin, imgH-margin)
 	shadow := image.Rect(card.Min.X+7, card.Min.Y+9, card.Max.X+7, card.Max.Y+9)
-	fillRoundedRect(img, shadow, cardRadius, color.RGBA{R: 2, G: 6, B: 15, A: 120})
-	fillRoundedRect(img, card, cardRadius, color.RGBA{R: 94, G: 121, B: 158, A: 255})
+	fillRoundedRect(img, shadow, cardRadius, theme.Shadow)
+	fillRoundedRect(img, card, cardRadius, theme.CardBorder)
 
 	inner := image.Rect(card.Min.X+2, card.Min.Y+2, card.Max.X-2, card.Max.Y-2)
-	fillRoundedRect(img, inner, cardRadius-2, color.RGBA{R: 13, G: 25, B: 41, A: 255})
+	fillRoundedRect(img, inner, cardRadius-2, theme.CardFill)
 
 	header := image.Rect(inner.Min.X+18, inner.Min.Y+18, inner.Max.X-18, inner.Min.Y+128)
-	fillRoundedRect(img, header, 18, color.RGBA{R: 26, G: 48, B: 76, A: 255})
+	fillRoundedRect(img, header, 18, theme.HeaderFill)
 	fillRoundedRect(
 		img,
 		image.Rect(header.Min.X+1, header.Min.Y+1, header.Max.X-1, header.Min.Y+header.Dy()/2),
 		16,
-		color.RGBA{R: 34, G: 63, B: 100, A: 255},
+		theme.HeaderHighlight,
 	)
 
-	titleColor := color.RGBA{R: 243, G: 247, B: 252, A: 255}
-	subtitleColor := color.RGBA{R: 176, G: 194, B: 214, A: 255}
+	titleColor := theme.Title
+	subtitleColor := theme.Subtitle
 	drawTextTop(img, faces.Title, header.Min.X+22, header.Min.Y+20, tr(lang, "img_rem_title"), titleColor)
-	drawTextTop(img, faces.Subtitle, header.Min.X+22, header.Min.Y+64, tr(lang, "img_region_prefix")+region, subtitleColor)
+	drawTextTop(img, faces.Subtitle, header.Min.X+22, header.Min.Y+64, tr(lang, "img_region_prefix")+regionDisplayName(region, lang), subtitleColor)
 	drawTextTop(
 		img,
 		faces.Subtitle,
 		header.Min.X+22,
 		header.Min.Y+90,
-		trf(lang, "img_rem_day_date", day, ev.Time.In(loc).Format("02.01.2006")),
+		trf(lang, "img_rem_day_date", dayLabel(lang, day), ev.Time.In(loc).Format("02.01.2006")),
 		subtitleColor,
 	)
 
 	eventBox := image.Rect(inner.Min.X+18, header.Max.Y+18, inner.Max.X-18, header.Max.Y+18+154)
-	fillRoundedRect(img, eventBox, 18, color.RGBA{R: 24, G: 47, B: 74, A: 255})
+	fillRoundedRect(img, eventBox, 18, theme.PanelFill)
 	drawTextTop(img, faces.Event, eventBox.Min.X+24, eventBox.Min.Y+26, eventTitle(lang, ev), titleColor)
 	drawTextTop(img, faces.Time, eventBox.Min.X+24, eventBox.Min.Y+74, ev.Time.In(loc).Format("15:04"), titleColor)
 
 	footer := image.Rect(inner.Min.X+18, eventBox.Max.Y+14, inner.Max.X-18, eventBox.Max.Y+14+74)
-	fillRoundedRect(img, footer, 15, color.RGBA{R: 18, G: 40, B: 63, A: 255})
-	drawTextTop(img, faces.Footer, footer.Min.X+20, footer.Min.Y+24, tr(lang, "img_rem_footer"), subtitleColor)
+	fillRoundedRect(img, footer, 15, theme.FooterFill)
+	drawTextTop(img, faces.Footer, footer.Min.X+20, footer.Min.Y+24, tr(lang, reminderFooterKey(ev)), subtitleColor)
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func reminderFooterKey(ev eventSpec) string {
+	if ev.Key == "tahajjud" {
+		return "img_tahajjud_footer"
+	}
+	return "img_rem_footer"
+}
+
+func renderQiblaImage(region string, bearing float64, lang string, theme Theme) ([]byte, error) {
+	lang = normalizeLang(lang)
+	if lang == "" {
+		lang = fallbackLang()
+	}
+	faces, err := loadReminderCardFaces()
+	if err != nil {
+		return nil, err
+	}
+	defer faces.Close()
+
+	const (
+		imgW       = 980
+		imgH       = 560
+		margin     = 34
+		cardRadius = 24
+	)
+
+	img := image.NewRGBA(image.Rect(0, 0, imgW, imgH))
+	drawVerticalGradient(img, theme.BackgroundTop, theme.BackgroundBottom)
+	drawRadialGlow(img, imgW-180, 110, 220, theme.GlowPrimary)
+	drawRadialGlow(img, 150, imgH-90, 220, theme.GlowSecondary)
+
+	card := image.Rect(margin, margin, imgW-margin, imgH-margin)
+	shadow := image.Rect(card.Min.X+7, card.Min.Y+9, card.Max.X+7, card.Max.Y+9)
+	fillRoundedRect(img, shadow, cardRadius, theme.Shadow)
+	fillRoundedRect(img, card, cardRadius, theme.CardBorder)
+
+	inner := image.Rect(card.Min.X+2, card.Min.Y+2, card.Max.X-2, card.Max.Y-2)
+	fillRoundedRect(img, inner, cardRadius-2, theme.CardFill)
+
+	titleColor := theme.Title
+	subtitleColor := theme.Subtitle
+	accent := theme.Accent
+
+	// Compass dial on the left half of the card.
+	radius := (inner.Dy() - 60) / 2
+	cx := inner.Min.X + 40 + radius
+	cy := inner.Min.Y + inner.Dy()/2
+	drawRadialGlow(img, cx, cy, radius+30, theme.GlowPrimary)
+	fillCircle(img, cx, cy, radius, theme.CardBorder)
+	fillCircle(img, cx, cy, radius-3, theme.PanelFill)
+	for deg := 0; deg < 360; deg += 15 {
+		length := 10
+		if deg%90 == 0 {
+			length = 22
+		} else if deg%45 == 0 {
+			length = 16
+		}
+		x0, y0 := polarPoint(cx, cy, float64(radius-8), float64(deg))
+		x1, y1 := polarPoint(cx, cy, float64(radius-8-length), float64(deg))
+		drawLine(img, x0, y0, x1, y1, 2, subtitleColor)
+	}
+	northX, northY := polarPoint(cx, cy, float64(radius-48), 0)
+	northLabel := tr(lang, "dir_n")
+	drawTextTop(img, faces.Footer, northX-measureTextWidth(faces.Footer, northLabel)/2, northY-faceLineHeight(faces.Footer)/2, northLabel, subtitleColor)
+
+	tipX, tipY := polarPoint(cx, cy, float64(radius-30), bearing)
+	tailX, tailY := polarPoint(cx, cy, float64(radius/3), bearing+180)
+	drawLine(img, tailX, tailY, cx, cy, 6, subtitleColor)
+	drawLine(img, cx, cy, tipX, tipY, 8, accent)
+	fillCircle(img, tipX, tipY, 12, accent)
+	fillCircle(img, cx, cy, 10, titleColor)
+
+	// Text panel on the right half.
+	textX := cx + radius + 50
+	drawTextTop(img, faces.Event, textX, inner.Min.Y+52, tr(lang, "qibla_title"), titleColor)
+	drawTextTop(img, faces.Subtitle, textX, inner.Min.Y+104, tr(lang, "img_region_prefix")+regionDisplayName(region, lang), subtitleColor)
+	drawTextTop(img, faces.Time, textX, inner.Min.Y+170, fmt.Sprintf("%d°", int(math.Round(bearing))%360), accent)
+	drawTextTop(img, faces.Event, textX, inner.Min.Y+276, tr(lang, compassDirectionKey(bearing)), titleColor)
 
 	var out bytes.Buffer
 	if err := png.Encode(&out, img); err != nil {
@@ -2747,6 +9303,204 @@ func renderReminderImage(region string, day int, ev eventSpec, loc *time.Locatio
 	return out.Bytes(), nil
 }
 
+const (
+	synodicMonthDays = 29.530588853
+	// referenceNewMoon is the new moon of 6 January 2000, 18:14 UTC.
+	referenceNewMoonUnix = 947182440
+)
+
+// moonAge returns the days elapsed since the last new moon.
+func moonAge(date time.Time) float64 {
+	days := float64(date.Unix()-referenceNewMoonUnix) / 86400
+	age := math.Mod(days, synodicMonthDays)
+	if age < 0 {
+		age += synodicMonthDays
+	}
+	return age
+}
+
+// moonPhase returns the illuminated fraction of the moon's disc (0 = new, 1 = full).
+func moonPhase(date time.Time) float64 {
+	angle := moonAge(date) / synodicMonthDays * 2 * math.Pi
+	return (1 - math.Cos(angle)) / 2
+}
+
+func moonWaxing(date time.Time) bool {
+	return moonAge(date) < synodicMonthDays/2
+}
+
+// drawMoonGlyph paints a moon disc with the lit part on the right while waxing and on the
+// left while waning, as seen from the northern hemisphere.
+func drawMoonGlyph(img *image.RGBA, cx, cy, radius int, illumination float64, waxing bool) {
+	lit := color.RGBA{R: 246, G: 226, B: 178, A: 255}
+	dark := color.RGBA{R: 38, G: 56, B: 84, A: 255}
+	rim := color.RGBA{R: 246, G: 226, B: 178, A: 110}
+	r := float64(radius)
+	for y := -radius; y <= radius; y++ {
+		ny := float64(y) / r
+		halfW := math.Sqrt(math.Max(0, 1-ny*ny))
+		terminator := halfW * (1 - 2*illumination)
+		for x := -radius; x <= radius; x++ {
+			dist := math.Hypot(float64(x), float64(y))
+			if dist > r+0.5 {
+				continue
+			}
+			nx := float64(x) / r
+			if !waxing {
+				nx = -nx
+			}
+			clr := dark
+			if nx > terminator {
+				clr = lit
+			}
+			if dist > r-1.5 {
+				clr = rim
+			}
+			blendPixel(img, cx+x, cy+y, clr)
+		}
+	}
+}
+
+// polarPoint returns the point at distance r from the centre, with 0° pointing up and
+// angles growing clockwise like a compass.
+func polarPoint(cx, cy int, r, deg float64) (int, int) {
+	rad := deg * math.Pi / 180
+	return cx + int(math.Round(r*math.Sin(rad))), cy - int(math.Round(r*math.Cos(rad)))
+}
+
+func fillCircle(img *image.RGBA, cx, cy, radius int, clr color.RGBA) {
+	if radius <= 0 {
+		return
+	}
+	r2 := radius * radius
+	for y := -radius; y <= radius; y++ {
+		for x := -radius; x <= radius; x++ {
+			if x*x+y*y <= r2 {
+				blendPixel(img, cx+x, cy+y, clr)
+			}
+		}
+	}
+}
+
+func drawLine(img *image.RGBA, x0, y0, x1, y1, width int, clr color.RGBA) {
+	dx := float64(x1 - x0)
+	dy := float64(y1 - y0)
+	steps := int(math.Max(math.Abs(dx), math.Abs(dy)))
+	if steps == 0 {
+		fillCircle(img, x0, y0, width/2, clr)
+		return
+	}
+	half := width / 2
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		x := x0 + int(math.Round(dx*t))
+		y := y0 + int(math.Round(dy*t))
+		fillRect(img, image.Rect(x-half, y-half, x-half+width, y-half+width), clr)
+	}
+}
+
+const (
+	themeDark  = "dark"
+	themeLight = "light"
+)
+
+// Theme holds the palette shared by all rendered cards.
+type Theme struct {
+	Name             string
+	BackgroundTop    color.RGBA
+	BackgroundBottom color.RGBA
+	GlowPrimary      color.RGBA
+	GlowSecondary    color.RGBA
+	Shadow           color.RGBA
+	CardBorder       color.RGBA
+	CardFill         color.RGBA
+	HeaderFill       color.RGBA
+	HeaderHighlight  color.RGBA
+	PanelFill        color.RGBA
+	PanelAltFill     color.RGBA
+	FooterFill       color.RGBA
+	Title            color.RGBA
+	Subtitle         color.RGBA
+	Accent           color.RGBA
+	AccentText       color.RGBA
+	TableBorder      color.RGBA
+	TableFill        color.RGBA
+	TableHeader      color.RGBA
+	RowText          color.RGBA
+	RowA             color.RGBA
+	RowB             color.RGBA
+	RowPreStart      color.RGBA
+	RowToday         color.RGBA
+	Grid             color.RGBA
+}
+
+var themes = map[string]Theme{
+	themeDark: {
+		Name:             themeDark,
+		BackgroundTop:    color.RGBA{R: 8, G: 17, B: 33, A: 255},
+		BackgroundBottom: color.RGBA{R: 4, G: 10, B: 22, A: 255},
+		GlowPrimary:      color.RGBA{R: 69, G: 197, B: 173, A: 100},
+		GlowSecondary:    color.RGBA{R: 216, G: 168, B: 79, A: 78},
+		Shadow:           color.RGBA{R: 2, G: 6, B: 15, A: 120},
+		CardBorder:       color.RGBA{R: 96, G: 124, B: 164, A: 255},
+		CardFill:         color.RGBA{R: 13, G: 25, B: 42, A: 255},
+		HeaderFill:       color.RGBA{R: 25, G: 47, B: 74, A: 255},
+		HeaderHighlight:  color.RGBA{R: 34, G: 63, B: 98, A: 255},
+		PanelFill:        color.RGBA{R: 24, G: 48, B: 76, A: 255},
+		PanelAltFill:     color.RGBA{R: 27, G: 56, B: 88, A: 255},
+		FooterFill:       color.RGBA{R: 18, G: 40, B: 63, A: 255},
+		Title:            color.RGBA{R: 243, G: 247, B: 252, A: 255},
+		Subtitle:         color.RGBA{R: 177, G: 194, B: 214, A: 255},
+		Accent:           color.RGBA{R: 230, G: 184, B: 102, A: 255},
+		AccentText:       color.RGBA{R: 32, G: 25, B: 15, A: 255},
+		TableBorder:      color.RGBA{R: 84, G: 109, B: 145, A: 255},
+		TableFill:        color.RGBA{R: 12, G: 30, B: 49, A: 255},
+		TableHeader:      color.RGBA{R: 24, G: 53, B: 85, A: 255},
+		RowText:          color.RGBA{R: 232, G: 240, B: 249, A: 255},
+		RowA:             color.RGBA{R: 18, G: 37, B: 61, A: 255},
+		RowB:             color.RGBA{R: 14, G: 31, B: 52, A: 255},
+		RowPreStart:      color.RGBA{R: 31, G: 57, B: 86, A: 255},
+		RowToday:         color.RGBA{R: 58, G: 84, B: 120, A: 255},
+		Grid:             color.RGBA{R: 74, G: 100, B: 132, A: 255},
+	},
+	themeLight: {
+		Name:             themeLight,
+		BackgroundTop:    color.RGBA{R: 246, G: 243, B: 236, A: 255},
+		BackgroundBottom: color.RGBA{R: 232, G: 226, B: 214, A: 255},
+		GlowPrimary:      color.RGBA{R: 120, G: 200, B: 185, A: 60},
+		GlowSecondary:    color.RGBA{R: 230, G: 190, B: 110, A: 55},
+		Shadow:           color.RGBA{R: 90, G: 80, B: 60, A: 45},
+		CardBorder:       color.RGBA{R: 196, G: 182, B: 150, A: 255},
+		CardFill:         color.RGBA{R: 253, G: 251, B: 246, A: 255},
+		HeaderFill:       color.RGBA{R: 238, G: 231, B: 216, A: 255},
+		HeaderHighlight:  color.RGBA{R: 245, G: 239, B: 226, A: 255},
+		PanelFill:        color.RGBA{R: 241, G: 235, B: 222, A: 255},
+		PanelAltFill:     color.RGBA{R: 236, G: 228, B: 210, A: 255},
+		FooterFill:       color.RGBA{R: 245, G: 240, B: 230, A: 255},
+		Title:            color.RGBA{R: 33, G: 37, B: 48, A: 255},
+		Subtitle:         color.RGBA{R: 96, G: 102, B: 116, A: 255},
+		Accent:           color.RGBA{R: 196, G: 140, B: 52, A: 255},
+		AccentText:       color.RGBA{R: 255, G: 251, B: 242, A: 255},
+		TableBorder:      color.RGBA{R: 206, G: 194, B: 168, A: 255},
+		TableFill:        color.RGBA{R: 253, G: 251, B: 246, A: 255},
+		TableHeader:      color.RGBA{R: 236, G: 228, B: 210, A: 255},
+		RowText:          color.RGBA{R: 40, G: 44, B: 56, A: 255},
+		RowA:             color.RGBA{R: 251, G: 248, B: 241, A: 255},
+		RowB:             color.RGBA{R: 245, G: 241, B: 232, A: 255},
+		RowPreStart:      color.RGBA{R: 237, G: 231, B: 217, A: 255},
+		RowToday:         color.RGBA{R: 248, G: 226, B: 180, A: 255},
+		Grid:             color.RGBA{R: 216, G: 206, B: 184, A: 255},
+	},
+}
+
+// themeByName resolves a stored theme name, falling back to the dark theme.
+func themeByName(name string) Theme {
+	if theme, ok := themes[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return theme
+	}
+	return themes[themeDark]
+}
+
 func drawVerticalGradient(img *image.RGBA, top, bottom color.RGBA) {
 	bounds := img.Bounds()
 	height := bounds.Dy()
@@ -2830,8 +9584,39 @@ const (
 var (
 	fontBytesMu     sync.Mutex
 	fontBytesByKind = map[fontWeight][]byte{}
+
+	// Parsed fonts are shared by every render; faces are not, since an opentype face
+	// keeps per-face scratch buffers and must not draw from two goroutines at once.
+	// Each render borrows its own faces from a pool per weight and size and returns
+	// them when it is done, so the render* functions are safe to call concurrently
+	// without paying for a fresh face every time. Never cache a face beyond one render.
+	parsedFontsMu sync.Mutex
+	parsedFonts   = map[fontWeight]*opentype.Font{}
+	facePoolsMu   sync.Mutex
+	facePools     = map[faceKey]*sync.Pool{}
 )
 
+type faceKey struct {
+	weight fontWeight
+	size   float64
+}
+
+// pooledFace hands its face back to the pool on Close instead of discarding it.
+type pooledFace struct {
+	font.Face
+	pool *sync.Pool
+	// font is the parsed font behind the face, kept for glyph coverage checks.
+	font *opentype.Font
+}
+
+func (p *pooledFace) Close() error {
+	if p.Face != nil {
+		p.pool.Put(p.Face)
+		p.Face = nil
+	}
+	return nil
+}
+
 func loadTodayCardFaces() (*todayCardFaces, error) {
 	title, err := newTextFace(fontWeightBold, 42, gobold.TTF)
 	if err != nil {
@@ -2974,15 +9759,87 @@ func loadCalendarCardFaces() (*calendarCardFaces, error) {
 	}, nil
 }
 
+// newTextFace returns a face of the given weight and size for one render. The caller
+// owns it until Close, which returns it to the pool for the next render.
 func newTextFace(weight fontWeight, size float64, fallback []byte) (font.Face, error) {
+	parsed, err := parsedFont(weight, fallback)
+	if err != nil {
+		return nil, err
+	}
+	pool := facePoolFor(faceKey{weight: weight, size: size})
+	if face, ok := pool.Get().(font.Face); ok {
+		return &pooledFace{Face: face, pool: pool, font: parsed}, nil
+	}
+	face, err := newOpenTypeFace(parsed, size)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledFace{Face: face, pool: pool, font: parsed}, nil
+}
+
+// missingGlyphWarned makes warnMissingGlyphs speak up once per process.
+var missingGlyphWarned atomic.Bool
+
+// warnMissingGlyphs logs, once, when face cannot draw some character of text. Without
+// a font covering the text the cards silently show empty boxes.
+func warnMissingGlyphs(face font.Face, text string) {
+	pf, ok := face.(*pooledFace)
+	if !ok || pf.font == nil || missingGlyphWarned.Load() {
+		return
+	}
+	var (
+		buf     sfnt.Buffer
+		missing []string
+	)
+	for _, r := range text {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || unicode.Is(unicode.Variation_Selector, r) {
+			continue
+		}
+		if idx, err := pf.font.GlyphIndex(&buf, r); err == nil && idx == 0 {
+			missing = append(missing, fmt.Sprintf("%q (%U)", r, r))
+		}
+	}
+	if len(missing) == 0 || !missingGlyphWarned.CompareAndSwap(false, true) {
+		return
+	}
+	log.Printf("WARN: image font has no glyph for %s in %q, cards will show empty boxes; set RAMADAN_FONT to a TTF that covers these characters", strings.Join(missing, ", "), text)
+}
+
+func facePoolFor(key faceKey) *sync.Pool {
+	facePoolsMu.Lock()
+	defer facePoolsMu.Unlock()
+	pool, ok := facePools[key]
+	if !ok {
+		pool = &sync.Pool{}
+		facePools[key] = pool
+	}
+	return pool
+}
+
+// parsedFont parses the preferred font for weight once, falling back to the bundled
+// one, and keeps the result for later renders.
+func parsedFont(weight fontWeight, fallback []byte) (*opentype.Font, error) {
+	parsedFontsMu.Lock()
+	defer parsedFontsMu.Unlock()
+	if parsed, ok := parsedFonts[weight]; ok {
+		return parsed, nil
+	}
+	var parsed *opentype.Font
 	if preferred := loadPreferredFontBytes(weight); len(preferred) > 0 {
-		face, err := newOpenTypeFace(preferred, size)
-		if err == nil {
-			return face, nil
+		var err error
+		if parsed, err = opentype.Parse(preferred); err != nil {
+			log.Printf("font fallback: cannot use preferred %s font: %v", weight, err)
+			parsed = nil
+		}
+	}
+	if parsed == nil {
+		var err error
+		if parsed, err = opentype.Parse(fallback); err != nil {
+			return nil, err
 		}
-		log.Printf("font fallback: cannot use preferred %s font: %v", weight, err)
 	}
-	return newOpenTypeFace(fallback, size)
+	parsedFonts[weight] = parsed
+	return parsed, nil
 }
 
 func loadPreferredFontBytes(weight fontWeight) []byte {
@@ -3015,7 +9872,25 @@ func findPreferredFontBytes(weight fontWeight) []byte {
 			return data
 		}
 	}
-	return nil
+	return embeddedFontBytes(weight)
+}
+
+// The DejaVu Sans fonts (see fonts/LICENSE) ship inside the binary so cards render
+// Cyrillic and Tajik text even on hosts without any of the preferred system fonts.
+var (
+	//go:embed fonts/DejaVuSans.ttf
+	embeddedRegularTTF []byte
+	//go:embed fonts/DejaVuSans-Bold.ttf
+	embeddedBoldTTF []byte
+)
+
+// embeddedFontBytes is the last preferred font, after RAMADAN_FONT* and the system
+// paths. Medium text uses the bold face, as the system search does.
+func embeddedFontBytes(weight fontWeight) []byte {
+	if weight == fontWeightRegular {
+		return embeddedRegularTTF
+	}
+	return embeddedBoldTTF
 }
 
 func preferredFontPaths(weight fontWeight) []string {
@@ -3072,11 +9947,7 @@ func supportsTajikRunes(ttf []byte) bool {
 	return true
 }
 
-func newOpenTypeFace(ttf []byte, size float64) (font.Face, error) {
-	parsed, err := opentype.Parse(ttf)
-	if err != nil {
-		return nil, err
-	}
+func newOpenTypeFace(parsed *opentype.Font, size float64) (font.Face, error) {
 	return opentype.NewFace(parsed, &opentype.FaceOptions{
 		Size:    size,
 		DPI:     72,
@@ -3109,10 +9980,25 @@ var tajikToRussianImageReplacer = strings.NewReplacer(
 	"ӯ", "у",
 )
 
+// The image fonts have no glyph for the ﷺ ligature, so cards spell it out in the
+// script of the surrounding text.
+const (
+	salawatSign     = "ﷺ"
+	salawatCyrillic = "(с.а.в.)"
+	salawatLatin    = "(s.a.w.)"
+)
+
 func normalizeImageText(text string) string {
 	if text == "" {
 		return ""
 	}
+	if strings.Contains(text, salawatSign) {
+		spelled := salawatLatin
+		if strings.IndexFunc(text, func(r rune) bool { return unicode.Is(unicode.Cyrillic, r) }) >= 0 {
+			spelled = salawatCyrillic
+		}
+		text = strings.ReplaceAll(text, salawatSign, spelled)
+	}
 	return tajikToRussianImageReplacer.Replace(text)
 }
 
@@ -3121,6 +10007,7 @@ func drawTextTop(img *image.RGBA, face font.Face, x, top int, text string, clr c
 	if face == nil || text == "" {
 		return
 	}
+	warnMissingGlyphs(face, text)
 	baseline := top + fixedToInt(face.Metrics().Ascent)
 	d := &font.Drawer{
 		Dst:  img,
@@ -3139,6 +10026,41 @@ func measureTextWidth(face font.Face, text string) int {
 	return fixedToInt(font.MeasureString(face, text))
 }
 
+// wrapText breaks text into lines no wider than maxWidth when drawn with face. Words
+// longer than a whole line are split between runes.
+func wrapText(face font.Face, text string, maxWidth int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if measureTextWidth(face, candidate) <= maxWidth {
+			line = candidate
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+			line = ""
+		}
+		for measureTextWidth(face, word) > maxWidth {
+			runes := []rune(word)
+			cut := len(runes) - 1
+			for cut > 1 && measureTextWidth(face, string(runes[:cut])) > maxWidth {
+				cut--
+			}
+			lines = append(lines, string(runes[:cut]))
+			word = string(runes[cut:])
+		}
+		line = word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
 func faceLineHeight(face font.Face) int {
 	if face == nil {
 		return 0
@@ -3263,82 +10185,186 @@ func mustClockToMinutes(raw string) int {
 	if err != nil {
 		log.Fatalf("cannot parse time %q (cleaned %q): %v", raw, clean, err)
 	}
-	return t.Hour()*60 + t.Minute()
+	return t.Hour()*60 + t.Minute()
+}
+
+// currentDaySchedule returns the DayTimes for today's Ramadan day relative to start.
+func currentDaySchedule(days []DayTimes, start time.Time, loc *time.Location) *DayTimes {
+	return dayScheduleAt(days, start, time.Now().In(loc))
+}
+
+// dayScheduleAt returns the calendar row in effect at now, or nil outside the calendar.
+func dayScheduleAt(days []DayTimes, start, now time.Time) *DayTimes {
+	dayIndex := int(math.Floor(now.Sub(start).Hours()/24.0)) + 1
+	if dayIndex < 0 || dayIndex > len(days) {
+		return nil
+	}
+	for i := range days {
+		if days[i].Day == dayIndex {
+			return &days[i]
+		}
+	}
+	return nil
+}
+
+const minutesPerDay = 24 * 60
+
+// normalizeDayMinutes keeps a minute-of-day value inside [0, 1440). Times pushed past
+// midnight wrap around and are rolled into the next day by reminderEventsForDay; negative
+// times are clamped because a Ramadan day never starts on the previous evening.
+func normalizeDayMinutes(val int) int {
+	if val < 0 {
+		return 0
+	}
+	return val % minutesPerDay
+}
+
+func applyOffset(day DayTimes, offset int) DayTimes {
+	adjust := func(val int) int {
+		return normalizeDayMinutes(val + offset)
+	}
+	return DayTimes{
+		Data:        day.Data,
+		Day:         day.Day,
+		SuhoorEnd:   adjust(day.SuhoorEnd),
+		Fajr:        adjust(day.Fajr),
+		Dhuhr:       adjust(day.Dhuhr),
+		Asr:         adjust(day.Asr),
+		AsrStandard: adjust(day.AsrStandard),
+		Maghrib:     adjust(day.Maghrib),
+		Isha:        adjust(day.Isha),
+	}
+}
+
+func defaultStatePath() string {
+	configDir, err := os.UserConfigDir()
+	if err == nil && strings.TrimSpace(configDir) != "" {
+		return filepath.Join(configDir, "ramadan-bot", "state.json")
+	}
+	return "state.json"
+}
+
+// parseChatIDList parses a comma or space separated list of chat IDs, skipping bad entries.
+func parseChatIDList(raw string) map[int64]bool {
+	ids := make(map[int64]bool)
+	for _, field := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			log.Printf("skip invalid chat id %q in ADMIN_CHAT_IDS", field)
+			continue
+		}
+		ids[id] = true
+	}
+	return ids
+}
+
+const defaultZakatFitrRate = 2.5
+
+// resolveZakatFitrRate reads the per-person zakat al-fitr rate from ZAKAT_FITR_RATE and its
+// unit from ZAKAT_FITR_UNIT. An empty unit means the localized default (kilograms of staple).
+func resolveZakatFitrRate() (float64, string) {
+	rate := defaultZakatFitrRate
+	if env := strings.TrimSpace(os.Getenv("ZAKAT_FITR_RATE")); env != "" {
+		if parsed, err := strconv.ParseFloat(env, 64); err == nil && parsed > 0 {
+			rate = parsed
+		} else {
+			log.Printf("Could not parse ZAKAT_FITR_RATE (%s), using %.1f", env, rate)
+		}
+	}
+	return rate, strings.TrimSpace(os.Getenv("ZAKAT_FITR_UNIT"))
+}
+
+func resolveRamadanStart(loc *time.Location) time.Time {
+	now := time.Now().In(loc)
+	env := strings.TrimSpace(os.Getenv("RAMADAN_START"))
+	if env != "" {
+		if parsed, err := time.ParseInLocation("2006-01-02", env, loc); err == nil {
+			start := time.Date(parsed.Year(), parsed.Month(), parsed.Day(), 0, 0, 0, 0, loc)
+			if !startPlausible(start, now) {
+				log.Printf("WARNING: RAMADAN_START=%s is more than %d days from today (%s); every date will be out of range",
+					env, maxStartDriftDays, now.Format("2006-01-02"))
+			}
+			return start
+		}
+		log.Printf("WARNING: could not parse RAMADAN_START (%q, want YYYY-MM-DD), falling back to the built-in timetables", env)
+	}
+	// Use the first timetable whose Ramadan has not ended yet, or the latest one.
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	var start time.Time
+	for _, year := range ramadanYears() {
+		first, last, ok := timetableRange(year, loc)
+		if !ok {
+			continue
+		}
+		start = first
+		if !today.After(last) {
+			break
+		}
+	}
+	if !startPlausible(start, now) {
+		log.Printf("WARNING: the built-in timetables start on %s, more than %d days from today; set RAMADAN_START or add a timetable",
+			start.Format("2006-01-02"), maxStartDriftDays)
+	}
+	return start
 }
 
-// currentDaySchedule returns the DayTimes for today's Ramadan day relative to start.
-func currentDaySchedule(days []DayTimes, start time.Time, loc *time.Location) *DayTimes {
-	now := time.Now().In(loc)
+// maxStartDriftDays is how far from today a Ramadan start may be before it is
+// almost certainly a stale or mistyped configuration.
+const maxStartDriftDays = 400
 
-	dayIndex := int(math.Floor(now.Sub(start).Hours()/24.0)) + 1
-	if dayIndex < 0 || dayIndex > len(days) {
-		return nil
+// startPlausible reports whether start lies within maxStartDriftDays of now.
+func startPlausible(start, now time.Time) bool {
+	if start.IsZero() {
+		return false
 	}
-	for i := range days {
-		if days[i].Day == dayIndex {
-			return &days[i]
-		}
+	drift := now.Sub(start)
+	if drift < 0 {
+		drift = -drift
 	}
-	return nil
+	return drift <= maxStartDriftDays*24*time.Hour
 }
 
-func applyOffset(day DayTimes, offset int) DayTimes {
-	adjust := func(val int) int {
-		out := val + offset
-		if out < 0 {
-			return 0
+// outOfRangeText explains that today falls outside the calendar and names the
+// configured range, so a stale RAMADAN_START is obvious to the reader.
+func outOfRangeText(lang, key string, start time.Time) string {
+	end := start.AddDate(0, 0, ramadanDays-1)
+	return trf(lang, key, start.Format("02.01.2006"), end.Format("02.01.2006"))
+}
+
+// timetableRange returns the dates of Day 1 and the last day in year's timetable.
+func timetableRange(year int, loc *time.Location) (time.Time, time.Time, bool) {
+	var first, last time.Time
+	for _, row := range ramadanTimetables[year] {
+		date, err := time.ParseInLocation("02.01.2006", row.Date, loc)
+		if err != nil {
+			continue
+		}
+		if row.Day == 1 {
+			first = date
+		}
+		if date.After(last) {
+			last = date
 		}
-		return out
-	}
-	return DayTimes{
-		Data:      day.Data,
-		Day:       day.Day,
-		SuhoorEnd: adjust(day.SuhoorEnd),
-		Fajr:      adjust(day.Fajr),
-		Dhuhr:     adjust(day.Dhuhr),
-		Asr:       adjust(day.Asr),
-		Maghrib:   adjust(day.Maghrib),
-		Isha:      adjust(day.Isha),
 	}
+	return first, last, !first.IsZero()
 }
 
-func defaultStatePath() string {
-	configDir, err := os.UserConfigDir()
-	if err == nil && strings.TrimSpace(configDir) != "" {
-		return filepath.Join(configDir, "ramadan-bot", "state.json")
-	}
-	return "state.json"
+// calendarRow is one line of the Dushanbe timetable; Day 0 is the eve of Ramadan.
+type calendarRow struct {
+	Date    string
+	Day     int
+	Suhur   string
+	Fajr    string
+	Dhuhr   string
+	Asr     string
+	Maghrib string
+	Isha    string
 }
 
-func resolveRamadanStart(loc *time.Location) time.Time {
-	env := strings.TrimSpace(os.Getenv("RAMADAN_START"))
-	if env != "" {
-		if parsed, err := time.ParseInLocation("2006-01-02", env, loc); err == nil {
-			return time.Date(parsed.Year(), parsed.Month(), parsed.Day(), 0, 0, 0, 0, loc)
-		}
-		log.Printf("Could not parse RAMADAN_START (%s), fallback to Feb 19 logic", env)
-	}
-	now := time.Now().In(loc)
-	year := now.Year()
-	feb19 := time.Date(year, time.February, 19, 0, 0, 0, 0, loc)
-	if now.After(feb19) {
-		feb19 = time.Date(year+1, time.February, 19, 0, 0, 0, 0, loc)
-	}
-	return feb19
-}
-
-// buildCalendars loads 30-дневный календарь (19.02–20.03.2026) для Душанбе и применяет смещения по регионам.
-func buildCalendars() map[string][]DayTimes {
-	base := []struct {
-		Date    string
-		Day     int
-		Suhur   string
-		Fajr    string
-		Dhuhr   string
-		Asr     string
-		Maghrib string
-		Isha    string
-	}{
+// ramadanTimetables holds the 30-day Dushanbe timetable keyed by the Gregorian year in
+// which Ramadan falls.
+var ramadanTimetables = map[int][]calendarRow{
+	2026: {
 		{"18.02.2026", 0, "05:42", "06:12", "13:00", "16:40", "18:13", "19:45"},
 		{"19.02.2026", 1, "05:41", "06:11", "13:00", "16:40", "18:14", "19:46"},
 		{"20.02.2026", 2, "05:40", "06:10", "13:00", "16:41", "18:15", "19:47"},
@@ -3370,10 +10396,56 @@ func buildCalendars() map[string][]DayTimes {
 		{"18.03.2026", 28, "05:02", "05:32", "13:00", "16:57", "18:43", "20:14"},
 		{"19.03.2026", 29, "05:01", "05:31", "13:00", "16:58", "18:44", "20:15"},
 		{"20.03.2026", 30, "05:00", "05:30", "13:00", "16:58", "18:45", "20:16"},
-	}
+	},
+}
 
+// regionOffsets shifts the Dushanbe timetable by whole minutes for each region. The
+// offsets are the same every year.
+var regionOffsets = map[string]int{
+	"Душанбе":    0,
+	"Ашт":        -6,
+	"Айни":       1,
+	"Кулоб":      -4,
+	"Рашт":       -6,
+	"Хамадони":   -3,
+	"Худжанд":    -3,
+	"Истаравшан": -1,
+	"Исфара":     -7,
+	"Конибодом":  -6,
+	"Хоруг":      -11,
+	"Мургоб":     -20,
+	"Ш. Шохин":   -5,
+	"Муъминобод": -3,
+	"Панчакент":  5,
+	"Шахритус":   3,
+	"Н. Хусрав":  4,
+	"Турсунзода": 3,
+}
+
+// ramadanYears returns the years covered by ramadanTimetables in ascending order.
+func ramadanYears() []int {
+	years := make([]int, 0, len(ramadanTimetables))
+	for year := range ramadanTimetables {
+		years = append(years, year)
+	}
+	sort.Ints(years)
+	return years
+}
+
+// timetableYear picks the dataset for a Ramadan starting at start. It fails when the
+// muftiate table for that year has not been added yet: another year's rows would put
+// every reminder at the wrong time.
+func timetableYear(start time.Time) (int, error) {
+	if _, ok := ramadanTimetables[start.Year()]; !ok {
+		return 0, fmt.Errorf("no published timetable for Ramadan %d (have %v)", start.Year(), ramadanYears())
+	}
+	return start.Year(), nil
+}
+
+// buildCalendars loads the 30-day Dushanbe timetable for year and applies the regional offsets.
+func buildCalendars(year int) map[string][]DayTimes {
 	var baseDays []DayTimes
-	for _, d := range base {
+	for _, d := range ramadanTimetables[year] {
 		suhur := mustClockToMinutes(d.Suhur)
 		fajr := mustClockToMinutes(d.Fajr)
 		dhuhr := mustClockToMinutes(d.Dhuhr)
@@ -3391,93 +10463,406 @@ func buildCalendars() map[string][]DayTimes {
 			Isha:      isha,
 		})
 	}
+	return buildCalendarsFrom(baseDays, regionOffsets)
+}
 
-	offsets := map[string]int{
-		"Душанбе":    0,
-		"Ашт":        -6,
-		"Айни":       1,
-		"Кулоб":      -4,
-		"Рашт":       -6,
-		"Хамадони":   -3,
-		"Худжанд":    -3,
-		"Истаравшан": -1,
-		"Исфара":     -7,
-		"Конибодом":  -6,
-		"Хоруг":      -11,
-		"Мургоб":     -20,
-		"Ш. Шохин":   -5,
-		"Муъминобод": -3,
-		"Панчакент":  5,
-		"Шахритус":   3,
-		"Н. Хусрав":  4,
-		"Турсунзода": 3,
-	}
-
-	calendars := make(map[string][]DayTimes)
+// buildCalendarsFrom derives every region's schedule from the base (Dushanbe) days by
+// shifting them by the region's offset in minutes. buildCalendars feeds it the built-in
+// timetables; tests can feed it small hand-made ones.
+func buildCalendarsFrom(baseDays []DayTimes, offsets map[string]int) map[string][]DayTimes {
+	calendars := make(map[string][]DayTimes, len(offsets))
+regions:
 	for region, offset := range offsets {
+		for _, bd := range baseDays {
+			if err := checkDayOrder(bd, offset); err != nil {
+				log.Printf("WARN: region %s rejected: day %d (%s) with offset %d: %v", region, bd.Day, bd.Data, offset, err)
+				continue regions
+			}
+		}
 		days := make([]DayTimes, len(baseDays))
+		lat := regionLatitude(region)
 		for i, bd := range baseDays {
 			days[i] = applyOffset(bd, offset)
+			if date, err := time.Parse("02.01.2006", bd.Data); err == nil {
+				days[i].AsrStandard = normalizeDayMinutes(days[i].Asr - hanafiAsrDelta(lat, date))
+			} else {
+				days[i].AsrStandard = days[i].Asr
+			}
 		}
 		calendars[region] = days
 	}
-
 	return calendars
 }
 
-func sampleHadithsByLang() map[string][]string {
-	return map[string][]string{
+// checkDayOrder verifies that day shifted by offset minutes is a possible day: suhoor
+// ends after midnight and no later than fajr, and fajr < dhuhr < asr < maghrib < isha
+// with maghrib before the next midnight. Isha alone may roll past midnight.
+func checkDayOrder(day DayTimes, offset int) error {
+	if day.SuhoorEnd+offset < 0 {
+		return fmt.Errorf("suhoor %d minutes before midnight", -(day.SuhoorEnd + offset))
+	}
+	if day.Maghrib+offset >= minutesPerDay {
+		return fmt.Errorf("maghrib past midnight")
+	}
+	if day.SuhoorEnd > day.Fajr {
+		return fmt.Errorf("suhoor ends after fajr")
+	}
+	times := []struct {
+		name string
+		min  int
+	}{{"fajr", day.Fajr}, {"dhuhr", day.Dhuhr}, {"asr", day.Asr}, {"maghrib", day.Maghrib}, {"isha", day.Isha}}
+	for i := 1; i < len(times); i++ {
+		if times[i].min <= times[i-1].min {
+			return fmt.Errorf("%s (%s) is not after %s (%s)", times[i].name, minutesToClock(times[i].min), times[i-1].name, minutesToClock(times[i-1].min))
+		}
+	}
+	return nil
+}
+
+// Asr calculation methods a chat can pick with /madhab.
+const (
+	asrStandard = "standard"
+	asrHanafi   = "hanafi"
+)
+
+// withAsrMethod returns day with Asr set for method; anything but standard keeps the
+// Hanafi time from the timetable.
+func (d DayTimes) withAsrMethod(method string) DayTimes {
+	if method == asrStandard && d.AsrStandard != 0 {
+		d.Asr = d.AsrStandard
+	}
+	return d
+}
+
+// regionLatitude is the latitude used for a region's astronomical corrections,
+// defaulting to Dushanbe for regions without coordinates.
+func regionLatitude(region string) float64 {
+	if coords, ok := regionCoordinates[region]; ok {
+		return coords.Lat
+	}
+	return regionCoordinates[baseRegion].Lat
+}
+
+// hanafiAsrDelta returns how many minutes after standard asr (shadow factor 1) the
+// Hanafi asr (shadow factor 2) begins at latitude lat on date. The timetables only
+// carry the Hanafi time, so the standard one is derived from it.
+func hanafiAsrDelta(lat float64, date time.Time) int {
+	decl := solarDeclination(date)
+	phi := lat * math.Pi / 180
+	hourAngle := func(factor float64) float64 {
+		alt := math.Atan(1 / (factor + math.Tan(math.Abs(phi-decl))))
+		cosH := (math.Sin(alt) - math.Sin(phi)*math.Sin(decl)) / (math.Cos(phi) * math.Cos(decl))
+		return math.Acos(math.Max(-1, math.Min(1, cosH))) * 180 / math.Pi
+	}
+	// The sun moves 15° of hour angle per hour, i.e. 4 minutes per degree.
+	return int(math.Round((hourAngle(2) - hourAngle(1)) * 4))
+}
+
+// solarDeclination approximates the sun's declination in radians (NOAA series).
+func solarDeclination(date time.Time) float64 {
+	g := 2 * math.Pi / 365 * float64(date.YearDay()-1)
+	return 0.006918 - 0.399912*math.Cos(g) + 0.070257*math.Sin(g) -
+		0.006758*math.Cos(2*g) + 0.000907*math.Sin(2*g) -
+		0.002697*math.Cos(3*g) + 0.00148*math.Sin(3*g)
+}
+
+func asrKeyboard(lang, current string) InlineKeyboardMarkup {
+	standard, hanafi := tr(lang, "asr_standard"), tr(lang, "asr_hanafi")
+	if current == asrStandard {
+		standard = "✅ " + standard
+	} else {
+		hanafi = "✅ " + hanafi
+	}
+	return InlineKeyboardMarkup{
+		InlineKeyboard: [][]InlineKeyboardButton{
+			{{Text: standard, CallbackData: "madhab:" + asrStandard}},
+			{{Text: hanafi, CallbackData: "madhab:" + asrHanafi}},
+		},
+	}
+}
+
+// regionSpec describes one region in a REGIONS_FILE. Only key and offset are required;
+// coordinates, localized names and the deep-link slug fall back to the built-in ones for
+// known keys.
+type regionSpec struct {
+	Key    string            `json:"key"`
+	Offset int               `json:"offset"`
+	Lat    float64           `json:"lat,omitempty"`
+	Lng    float64           `json:"lng,omitempty"`
+	Names  map[string]string `json:"names,omitempty"`
+	Slug   string            `json:"slug,omitempty"`
+	// Note is an operator announcement for the region, e.g. "Times verified by local imam".
+	Note string `json:"note,omitempty"`
+}
+
+// baseRegion is the city of the built-in timetables: region offsets are relative to it
+// and chats without a region fall back to it.
+const baseRegion = "Душанбе"
+
+// loadRegionsFile reads a JSON array of regionSpec. Keys must be unique and non-empty,
+// and baseRegion must be among them.
+func loadRegionsFile(path string) ([]regionSpec, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var specs []regionSpec
+	if err := json.Unmarshal(raw, &specs); err != nil {
+		return nil, err
+	}
+	if len(specs) == 0 {
+		return nil, errors.New("no regions")
+	}
+	seen := make(map[string]bool, len(specs))
+	for i, spec := range specs {
+		key := strings.TrimSpace(spec.Key)
+		if key == "" {
+			return nil, fmt.Errorf("region %d has no key", i+1)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate region %q", key)
+		}
+		seen[key] = true
+		specs[i].Key = key
+	}
+	if !seen[baseRegion] {
+		return nil, fmt.Errorf("missing %q, the region the offsets are relative to", baseRegion)
+	}
+	return specs, nil
+}
+
+// applyRegions replaces the built-in region list and offsets with specs, keeping the
+// built-in coordinates, names and slugs for regions that do not override them. It runs
+// once at startup, before anything reads the region tables.
+func applyRegions(specs []regionSpec) {
+	names := make([]string, 0, len(specs))
+	offsets := make(map[string]int, len(specs))
+	for _, spec := range specs {
+		names = append(names, spec.Key)
+		offsets[spec.Key] = spec.Offset
+		if spec.Lat != 0 || spec.Lng != 0 {
+			regionCoordinates[spec.Key] = latLng{Lat: spec.Lat, Lng: spec.Lng}
+		}
+		if len(spec.Names) > 0 {
+			localized := make(map[string]string, len(spec.Names))
+			for lang, name := range spec.Names {
+				if lang = normalizeLang(lang); lang != "" {
+					localized[lang] = name
+				}
+			}
+			regionDisplayNames[spec.Key] = localized
+		}
+		if slug := strings.ToLower(strings.TrimSpace(spec.Slug)); slug != "" {
+			regionSlugs[slug] = spec.Key
+		}
+		if note := strings.TrimSpace(spec.Note); note != "" {
+			regionNotes[spec.Key] = note
+		}
+	}
+	// Deep links must not select a region the file removed.
+	for slug, key := range regionSlugs {
+		if _, ok := offsets[key]; !ok {
+			delete(regionSlugs, slug)
+		}
+	}
+	regionNames = names
+	regionOffsets = offsets
+}
+
+// regionNotes holds the operator's per-region note from the regions file; the today
+// card shows it in place of the generic footer.
+var regionNotes = map[string]string{}
+
+// regionNoteMaxLines keeps a long note inside the today card's details panel.
+const regionNoteMaxLines = 2
+
+type latLng struct {
+	Lat float64
+	Lng float64
+}
+
+// regionCoordinates holds approximate city-centre coordinates for every region in buildCalendars.
+var regionCoordinates = map[string]latLng{
+	"Душанбе":    {38.5598, 68.7870},
+	"Ашт":        {40.6690, 70.8300},
+	"Айни":       {39.3958, 68.5406},
+	"Кулоб":      {37.9146, 69.7845},
+	"Рашт":       {39.0217, 70.3733},
+	"Хамадони":   {37.6167, 69.6333},
+	"Худжанд":    {40.2826, 69.6222},
+	"Истаравшан": {39.9108, 69.0064},
+	"Исфара":     {40.1265, 70.6253},
+	"Конибодом":  {40.2941, 70.4312},
+	"Хоруг":      {37.4897, 71.5530},
+	"Мургоб":     {38.1700, 73.9650},
+	"Ш. Шохин":   {37.8500, 70.0500},
+	"Муъминобод": {38.1085, 70.0356},
+	"Панчакент":  {39.4950, 67.6090},
+	"Шахритус":   {37.2600, 68.1400},
+	"Н. Хусрав":  {37.2167, 67.9000},
+	"Турсунзода": {38.5108, 68.2303},
+}
+
+const (
+	kaabaLat = 21.4225
+	kaabaLng = 39.8262
+)
+
+// qiblaBearing returns the initial great-circle bearing from the given point to the Kaaba,
+// in degrees clockwise from true north.
+func qiblaBearing(lat, lng float64) float64 {
+	toRad := math.Pi / 180
+	phi1 := lat * toRad
+	phi2 := kaabaLat * toRad
+	dLambda := (kaabaLng - lng) * toRad
+	y := math.Sin(dLambda) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLambda)
+	bearing := math.Atan2(y, x) / toRad
+	return math.Mod(bearing+360, 360)
+}
+
+// maxRegionDistanceKm bounds how far a shared location may be from the nearest
+// region; the timetable offsets say nothing about places farther away.
+const maxRegionDistanceKm = 150
+
+// nearestRegion picks the region whose coordinates are closest to the given point and
+// returns its distance in km; ok is false when no region has coordinates.
+func nearestRegion(lat, lng float64) (string, float64, bool) {
+	best := ""
+	bestDist := math.Inf(1)
+	for _, region := range regionNames {
+		coords, ok := regionCoordinates[region]
+		if !ok {
+			continue
+		}
+		if d := haversineKm(lat, lng, coords.Lat, coords.Lng); d < bestDist {
+			best, bestDist = region, d
+		}
+	}
+	return best, bestDist, best != ""
+}
+
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLng := (lng2 - lng1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+var compassDirectionKeys = []string{"dir_n", "dir_ne", "dir_e", "dir_se", "dir_s", "dir_sw", "dir_w", "dir_nw"}
+
+func compassDirectionKey(bearing float64) string {
+	idx := int(math.Floor(math.Mod(bearing+22.5, 360) / 45))
+	return compassDirectionKeys[idx%len(compassDirectionKeys)]
+}
+
+func sampleHadithsByLang() map[string][]Hadith {
+	return map[string][]Hadith{
 		langTG: {
-			"\"Рӯза сипар аст\" — ҳадис аз Паёмбар ﷺ (Бухорӣ).",
-			"\"Касе ки бо имон ва барои ризои Аллоҳ дар Рамазон рӯза бигирад, гуноҳҳои гузаштааш бахшида мешаванд\" — ҳадис аз Абӯҳурайра (Бухорӣ, Муслим).",
-			"\"Барои рӯзадор ду шодӣ ҳаст: ҳангоми ифтор ва ҳангоми мулоқоти Парвардигораш\" — ҳадис аз Абӯҳурайра (Бухорӣ).",
-			"\"Дуои рӯзадор ҳангоми ифтор рад карда намешавад\" — ҳадис (Тирмизӣ).",
-			"\"Амалҳо ба ниятҳо вобастаанд\" — ҳадис аз Паёмбар ﷺ (Бухорӣ).",
-			"\"Беҳтарини шумо касест, ки Қуръонро омӯзад ва ба дигарон омӯзонад\" — ҳадис аз Паёмбар ﷺ (Бухорӣ).",
-			"\"Покизагӣ нисфи имон аст\" — ҳадис аз Паёмбар ﷺ (Муслим).",
-			"\"Дуо мағзи ибодат аст\" — ҳадис аз Паёмбар ﷺ (Тирмизӣ).",
-			"\"Мусалмон бародари мусалмон аст\" — ҳадис аз Паёмбар ﷺ (Муслим).",
-			"\"Талаби илм бар ҳар мусалмон фарз аст\" — ҳадис аз Паёмбар ﷺ (Ибни Моҷа).",
+			{Text: "\"Рӯза сипар аст\"", Source: "ҳадис аз Паёмбар ﷺ (Бухорӣ).", Tags: []string{"fasting"}},
+			{Text: "\"Касе ки бо имон ва барои ризои Аллоҳ дар Рамазон рӯза бигирад, гуноҳҳои гузаштааш бахшида мешаванд\"", Source: "ҳадис аз Абӯҳурайра (Бухорӣ, Муслим).", Tags: []string{"fasting", "forgiveness"}},
+			{Text: "\"Барои рӯзадор ду шодӣ ҳаст: ҳангоми ифтор ва ҳангоми мулоқоти Парвардигораш\"", Source: "ҳадис аз Абӯҳурайра (Бухорӣ).", Tags: []string{"fasting"}},
+			{Text: "\"Дуои рӯзадор ҳангоми ифтор рад карда намешавад\"", Source: "ҳадис (Тирмизӣ).", Tags: []string{"fasting", "dua"}},
+			{Text: "\"Амалҳо ба ниятҳо вобастаанд\"", Source: "ҳадис аз Паёмбар ﷺ (Бухорӣ).", Tags: []string{"intention"}},
+			{Text: "\"Беҳтарини шумо касест, ки Қуръонро омӯзад ва ба дигарон омӯзонад\"", Source: "ҳадис аз Паёмбар ﷺ (Бухорӣ).", Tags: []string{"quran", "knowledge"}},
+			{Text: "\"Покизагӣ нисфи имон аст\"", Source: "ҳадис аз Паёмбар ﷺ (Муслим).", Tags: []string{"purity"}},
+			{Text: "\"Дуо мағзи ибодат аст\"", Source: "ҳадис аз Паёмбар ﷺ (Тирмизӣ).", Tags: []string{"dua"}},
+			{Text: "\"Мусалмон бародари мусалмон аст\"", Source: "ҳадис аз Паёмбар ﷺ (Муслим).", Tags: []string{"brotherhood"}},
+			{Text: "\"Талаби илм бар ҳар мусалмон фарз аст\"", Source: "ҳадис аз Паёмбар ﷺ (Ибни Моҷа).", Tags: []string{"knowledge"}},
 		},
 		langRU: {
-			"\"Пост — это щит\" — хадис Пророка ﷺ (Бухари).",
-			"\"Кто постится в Рамадан с верой и надеждой на награду, тому простятся прежние грехи\" — хадис от Абу Хурайры (Бухари, Муслим).",
-			"\"У постящегося две радости: при разговении и при встрече со своим Господом\" — хадис от Абу Хурайры (Бухари).",
-			"\"Дуа постящегося во время ифтара не отвергается\" — хадис (Тирмизи).",
-			"\"Дела оцениваются по намерениям\" — хадис Пророка ﷺ (Бухари).",
-			"\"Лучший из вас тот, кто изучает Коран и обучает ему других\" — хадис Пророка ﷺ (Бухари).",
-			"\"Чистота — половина веры\" — хадис Пророка ﷺ (Муслим).",
-			"\"Дуа — суть поклонения\" — хадис Пророка ﷺ (Тирмизи).",
-			"\"Мусульманин — брат мусульманину\" — хадис Пророка ﷺ (Муслим).",
-			"\"Стремление к знанию обязательно для каждого мусульманина\" — хадис Пророка ﷺ (Ибн Маджа).",
+			{Text: "\"Пост — это щит\"", Source: "хадис Пророка ﷺ (Бухари).", Tags: []string{"fasting"}},
+			{Text: "\"Кто постится в Рамадан с верой и надеждой на награду, тому простятся прежние грехи\"", Source: "хадис от Абу Хурайры (Бухари, Муслим).", Tags: []string{"fasting", "forgiveness"}},
+			{Text: "\"У постящегося две радости: при разговении и при встрече со своим Господом\"", Source: "хадис от Абу Хурайры (Бухари).", Tags: []string{"fasting"}},
+			{Text: "\"Дуа постящегося во время ифтара не отвергается\"", Source: "хадис (Тирмизи).", Tags: []string{"fasting", "dua"}},
+			{Text: "\"Дела оцениваются по намерениям\"", Source: "хадис Пророка ﷺ (Бухари).", Tags: []string{"intention"}},
+			{Text: "\"Лучший из вас тот, кто изучает Коран и обучает ему других\"", Source: "хадис Пророка ﷺ (Бухари).", Tags: []string{"quran", "knowledge"}},
+			{Text: "\"Чистота — половина веры\"", Source: "хадис Пророка ﷺ (Муслим).", Tags: []string{"purity"}},
+			{Text: "\"Дуа — суть поклонения\"", Source: "хадис Пророка ﷺ (Тирмизи).", Tags: []string{"dua"}},
+			{Text: "\"Мусульманин — брат мусульманину\"", Source: "хадис Пророка ﷺ (Муслим).", Tags: []string{"brotherhood"}},
+			{Text: "\"Стремление к знанию обязательно для каждого мусульманина\"", Source: "хадис Пророка ﷺ (Ибн Маджа).", Tags: []string{"knowledge"}},
 		},
 		langEN: {
-			"\"Fasting is a shield\" — Hadith of the Prophet ﷺ (Bukhari).",
-			"\"Whoever fasts Ramadan with faith and seeking reward, his previous sins will be forgiven\" — Hadith from Abu Huraira (Bukhari, Muslim).",
-			"\"The fasting person has two joys: at iftar and when meeting his Lord\" — Hadith from Abu Huraira (Bukhari).",
-			"\"The dua of the fasting person at iftar is not rejected\" — Hadith (Tirmidhi).",
-			"\"Actions are judged by intentions\" — Hadith of the Prophet ﷺ (Bukhari).",
-			"\"The best among you are those who learn the Quran and teach it\" — Hadith of the Prophet ﷺ (Bukhari).",
-			"\"Purity is half of faith\" — Hadith of the Prophet ﷺ (Muslim).",
-			"\"Supplication is the essence of worship\" — Hadith of the Prophet ﷺ (Tirmidhi).",
-			"\"A Muslim is a brother to a Muslim\" — Hadith of the Prophet ﷺ (Muslim).",
-			"\"Seeking knowledge is obligatory for every Muslim\" — Hadith of the Prophet ﷺ (Ibn Majah).",
+			{Text: "\"Fasting is a shield\"", Source: "Hadith of the Prophet ﷺ (Bukhari).", Tags: []string{"fasting"}},
+			{Text: "\"Whoever fasts Ramadan with faith and seeking reward, his previous sins will be forgiven\"", Source: "Hadith from Abu Huraira (Bukhari, Muslim).", Tags: []string{"fasting", "forgiveness"}},
+			{Text: "\"The fasting person has two joys: at iftar and when meeting his Lord\"", Source: "Hadith from Abu Huraira (Bukhari).", Tags: []string{"fasting"}},
+			{Text: "\"The dua of the fasting person at iftar is not rejected\"", Source: "Hadith (Tirmidhi).", Tags: []string{"fasting", "dua"}},
+			{Text: "\"Actions are judged by intentions\"", Source: "Hadith of the Prophet ﷺ (Bukhari).", Tags: []string{"intention"}},
+			{Text: "\"The best among you are those who learn the Quran and teach it\"", Source: "Hadith of the Prophet ﷺ (Bukhari).", Tags: []string{"quran", "knowledge"}},
+			{Text: "\"Purity is half of faith\"", Source: "Hadith of the Prophet ﷺ (Muslim).", Tags: []string{"purity"}},
+			{Text: "\"Supplication is the essence of worship\"", Source: "Hadith of the Prophet ﷺ (Tirmidhi).", Tags: []string{"dua"}},
+			{Text: "\"A Muslim is a brother to a Muslim\"", Source: "Hadith of the Prophet ﷺ (Muslim).", Tags: []string{"brotherhood"}},
+			{Text: "\"Seeking knowledge is obligatory for every Muslim\"", Source: "Hadith of the Prophet ﷺ (Ibn Majah).", Tags: []string{"knowledge"}},
 		},
 		langUZ: {
-			"\"Ro‘za qalqondir\" — Payg‘ambar ﷺ hadisi (Buxoriy).",
-			"\"Kim Ramazonda imon bilan va savob umidida ro‘za tutsa, avvalgi gunohlari kechiriladi\" — Abu Hurayra rivoyati (Buxoriy, Muslim).",
-			"\"Ro‘zador uchun ikki xursandchilik bor: iftor paytida va Robbisi bilan uchrashganda\" — Abu Hurayra rivoyati (Buxoriy).",
-			"\"Ro‘zadorning iftor paytidagi duosi rad etilmaydi\" — hadis (Termiziy).",
-			"\"Amallar niyatlarga bog‘liq\" — Payg‘ambar ﷺ hadisi (Buxoriy).",
-			"\"Sizlarning eng yaxshingiz Qur’onni o‘rganib, boshqalarga o‘rgatganingizdir\" — Payg‘ambar ﷺ hadisi (Buxoriy).",
-			"\"Poklik iymonning yarmidir\" — Payg‘ambar ﷺ hadisi (Muslim).",
-			"\"Duo ibodatning mag‘zidir\" — Payg‘ambar ﷺ hadisi (Termiziy).",
-			"\"Musulmon musulmonning birodaridir\" — Payg‘ambar ﷺ hadisi (Muslim).",
-			"\"Ilm talab qilish har bir musulmon uchun farzdir\" — Payg‘ambar ﷺ hadisi (Ibn Moja).",
+			{Text: "\"Ro‘za qalqondir\"", Source: "Payg‘ambar ﷺ hadisi (Buxoriy).", Tags: []string{"fasting"}},
+			{Text: "\"Kim Ramazonda imon bilan va savob umidida ro‘za tutsa, avvalgi gunohlari kechiriladi\"", Source: "Abu Hurayra rivoyati (Buxoriy, Muslim).", Tags: []string{"fasting", "forgiveness"}},
+			{Text: "\"Ro‘zador uchun ikki xursandchilik bor: iftor paytida va Robbisi bilan uchrashganda\"", Source: "Abu Hurayra rivoyati (Buxoriy).", Tags: []string{"fasting"}},
+			{Text: "\"Ro‘zadorning iftor paytidagi duosi rad etilmaydi\"", Source: "hadis (Termiziy).", Tags: []string{"fasting", "dua"}},
+			{Text: "\"Amallar niyatlarga bog‘liq\"", Source: "Payg‘ambar ﷺ hadisi (Buxoriy).", Tags: []string{"intention"}},
+			{Text: "\"Sizlarning eng yaxshingiz Qur’onni o‘rganib, boshqalarga o‘rgatganingizdir\"", Source: "Payg‘ambar ﷺ hadisi (Buxoriy).", Tags: []string{"quran", "knowledge"}},
+			{Text: "\"Poklik iymonning yarmidir\"", Source: "Payg‘ambar ﷺ hadisi (Muslim).", Tags: []string{"purity"}},
+			{Text: "\"Duo ibodatning mag‘zidir\"", Source: "Payg‘ambar ﷺ hadisi (Termiziy).", Tags: []string{"dua"}},
+			{Text: "\"Musulmon musulmonning birodaridir\"", Source: "Payg‘ambar ﷺ hadisi (Muslim).", Tags: []string{"brotherhood"}},
+			{Text: "\"Ilm talab qilish har bir musulmon uchun farzdir\"", Source: "Payg‘ambar ﷺ hadisi (Ibn Moja).", Tags: []string{"knowledge"}},
 		},
 	}
 }
 
+// loadHadithsFile reads a JSON object mapping language codes to hadith lists. Entries
+// are {"text", "source", "tags"} objects or legacy "\"quote\" — source" strings.
+func loadHadithsFile(path string) (map[string][]Hadith, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var byLang map[string][]Hadith
+	if err := json.Unmarshal(raw, &byLang); err != nil {
+		return nil, err
+	}
+	if len(byLang) == 0 {
+		return nil, errors.New("no languages")
+	}
+	loaded := make(map[string][]Hadith, len(byLang))
+	for code, list := range byLang {
+		lang := normalizeLang(code)
+		if lang == "" {
+			return nil, fmt.Errorf("unknown language %q", code)
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("%s: no hadiths", code)
+		}
+		for i, hadith := range list {
+			if hadith.Text == "" {
+				return nil, fmt.Errorf("%s: hadith %d has no text", code, i+1)
+			}
+			for _, tag := range hadith.Tags {
+				if !slices.Contains(hadithTags, tag) {
+					return nil, fmt.Errorf("%s: hadith %d has unknown tag %q", code, i+1, tag)
+				}
+			}
+		}
+		loaded[lang] = list
+	}
+	return loaded, nil
+}
+
+// mergeHadiths lays custom over base: a language in custom replaces that language's
+// built-in list, the other languages keep theirs.
+func mergeHadiths(base, custom map[string][]Hadith) map[string][]Hadith {
+	merged := make(map[string][]Hadith, len(base)+len(custom))
+	for lang, list := range base {
+		merged[lang] = list
+	}
+	for lang, list := range custom {
+		merged[lang] = list
+	}
+	return merged
+}
+
 func niyatTextsByLang() (map[string]string, map[string]string) {
 	niyatSuhoor := map[string]string{
 		langTG: `Нияти Рӯзаи моҳи шарифи Рамазон
@@ -3527,3 +10912,538 @@ Parvardigor! Sening rizoliging uchun ro‘za tutdim, Senga iymon keltirdim, Seng
 
 	return niyatSuhoor, niyatIftar
 }
+
+// duaEntry is one dua of the /dua library. Transliteration, Title, Translation and
+// Source are keyed by language code; the transliteration follows each language's
+// script and spelling.
+type duaEntry struct {
+	Key             string
+	Arabic          string
+	Transliteration map[string]string
+	Title           map[string]string
+	Translation     map[string]string
+	Source          map[string]string
+}
+
+// duaLibrary lists the Ramadan duas offered by /dua next to the suhoor and iftar niyat.
+// Keys are used in dua: callbacks, so they must not collide with duaSuhoor/duaIftar.
+var duaLibrary = []duaEntry{
+	{
+		Key:    "qadr",
+		Arabic: "اللَّهُمَّ إِنَّكَ عَفُوٌّ تُحِبُّ الْعَفْوَ فَاعْفُ عَنِّي",
+		Transliteration: map[string]string{
+			langTG: "Аллоҳумма иннака ъафуввун туҳиббул-ъафва фаъфу ъаннӣ.",
+			langRU: "Аллахумма иннака ‘афуввун тухиббуль-‘афва фа‘фу ‘анни.",
+			langEN: "Allahumma innaka ʿafuwwun tuhibbul-ʿafwa faʿfu ʿanni.",
+			langUZ: "Allohumma innaka afuvvun tuhibbul-afva fa’fu anniy.",
+		},
+		Title: map[string]string{
+			langTG: "✨ Дуои Шаби Қадр",
+			langRU: "✨ Дуа в Ночь Предопределения",
+			langEN: "✨ Laylat al-Qadr dua",
+			langUZ: "✨ Qadr kechasi duosi",
+		},
+		Translation: map[string]string{
+			langTG: "Парвардигоро, Ту бахшандаӣ ва бахшиданро дӯст медорӣ, пас маро бибахш.",
+			langRU: "О Аллах, поистине, Ты — Прощающий, любишь прощать, так прости же меня.",
+			langEN: "O Allah, You are Pardoning and You love to pardon, so pardon me.",
+			langUZ: "Allohim, albatta Sen afv etuvchisan, afvni yaxshi ko‘rasan, meni afv et.",
+		},
+		Source: map[string]string{langTG: "Тирмизӣ", langRU: "Тирмизи", langEN: "Tirmidhi", langUZ: "Termiziy"},
+	},
+	{
+		Key:    "forgiveness",
+		Arabic: "رَبِّ اغْفِرْ لِي وَتُبْ عَلَيَّ إِنَّكَ أَنْتَ التَّوَّابُ الرَّحِيمُ",
+		Transliteration: map[string]string{
+			langTG: "Раббиғфир лӣ ва туб ъалайя иннака антат-Таввобур-Раҳим.",
+			langRU: "Раббигфир ли ва туб ‘аляййа иннака анта-т-Таввабу-р-Рахим.",
+			langEN: "Rabbighfir li wa tub ʿalayya innaka antat-Tawwabur-Rahim.",
+			langUZ: "Robbig‘fir liy va tub ’alayya innaka antat-Tavvobur-Rohiym.",
+		},
+		Title: map[string]string{
+			langTG: "🤲 Дуои истиғфор",
+			langRU: "🤲 Дуа о прощении",
+			langEN: "🤲 Dua for forgiveness",
+			langUZ: "🤲 Istig‘for duosi",
+		},
+		Translation: map[string]string{
+			langTG: "Парвардигоро, маро бибахш ва тавбаамро қабул кун, ки Ту тавбапазиру меҳрубонӣ.",
+			langRU: "Господь мой, прости меня и прими моё покаяние, ведь Ты — Принимающий покаяние, Милосердный.",
+			langEN: "My Lord, forgive me and accept my repentance; You are the Accepter of repentance, the Most Merciful.",
+			langUZ: "Robbim, meni mag‘firat qil va tavbamni qabul et, albatta Sen tavbalarni qabul qiluvchi, rahmlisan.",
+		},
+		Source: map[string]string{langTG: "Абӯдовуд, Тирмизӣ", langRU: "Абу Давуд, Тирмизи", langEN: "Abu Dawud, Tirmidhi", langUZ: "Abu Dovud, Termiziy"},
+	},
+	{
+		Key:    "after_iftar",
+		Arabic: "ذَهَبَ الظَّمَأُ وَابْتَلَّتِ الْعُرُوقُ وَثَبَتَ الْأَجْرُ إِنْ شَاءَ اللَّهُ",
+		Transliteration: map[string]string{
+			langTG: "Заҳабаз-замаъу вабталлатил-ъуруқу ва собатал-аҷру иншоаллоҳ.",
+			langRU: "Захаба-з-зама’у ва-б-таллят-иль-‘уруку ва сабата-ль-аджру ин ша Аллах.",
+			langEN: "Dhahaba-z-zamaʾu wabtallatil-ʿuruqu wa thabatal-ajru in shaʾ Allah.",
+			langUZ: "Zahabaz-zama’u vabtallatil-’uruqu va sabatal-ajru in sha Alloh.",
+		},
+		Title: map[string]string{
+			langTG: "🍽 Дуо пас аз ифтор",
+			langRU: "🍽 Дуа после ифтара",
+			langEN: "🍽 Dua after iftar",
+			langUZ: "🍽 Iftordan keyingi duo",
+		},
+		Translation: map[string]string{
+			langTG: "Ташнагӣ рафт, рагҳо тар шуданд ва савоб, агар Аллоҳ хоҳад, собит гашт.",
+			langRU: "Ушла жажда, увлажнились жилы, и награда утвердилась, если пожелает Аллах.",
+			langEN: "The thirst is gone, the veins are moistened, and the reward is certain, if Allah wills.",
+			langUZ: "Chanqoq ketdi, tomirlar namlandi va ajr sobit bo‘ldi, inshaalloh.",
+		},
+		Source: map[string]string{langTG: "Абӯдовуд", langRU: "Абу Давуд", langEN: "Abu Dawud", langUZ: "Abu Dovud"},
+	},
+	{
+		Key:    "iftar_host",
+		Arabic: "أَفْطَرَ عِنْدَكُمُ الصَّائِمُونَ، وَأَكَلَ طَعَامَكُمُ الْأَبْرَارُ، وَصَلَّتْ عَلَيْكُمُ الْمَلَائِكَةُ",
+		Transliteration: map[string]string{
+			langTG: "Афтара ъиндакумус-соимун, ва акала таъомакумул-аброр, ва саллат ъалайкумул-малоика.",
+			langRU: "Афтара ‘индакуму-с-саимун, ва акаля та‘амакуму-ль-абрар, ва саллят ‘алейкуму-ль-маляика.",
+			langEN: "Aftara ʿindakumus-saʾimun, wa akala taʿamakumul-abrar, wa sallat ʿalaykumul-malaʾikah.",
+			langUZ: "Aftaro ’indakumus-soimun, va akala to’omakumul-abror, va sollat ’alaykumul-malaika.",
+		},
+		Title: map[string]string{
+			langTG: "🏠 Дуо барои мизбони ифтор",
+			langRU: "🏠 Дуа для хозяина ифтара",
+			langEN: "🏠 Dua for the iftar host",
+			langUZ: "🏠 Iftor mezboni uchun duo",
+		},
+		Translation: map[string]string{
+			langTG: "Рӯзадорон дар назди шумо ифтор кунанд, некӯкорон таоми шуморо бихӯранд ва фариштагон бар шумо дуо гӯянд.",
+			langRU: "Пусть разговляются у вас постящиеся, пусть едят вашу пищу праведные, и пусть ангелы молятся за вас.",
+			langEN: "May those fasting break their fast with you, may the righteous eat your food, and may the angels pray for you.",
+			langUZ: "Huzuringizda ro‘zadorlar iftor qilsin, taomingizni solihlar yesin va farishtalar sizga duo qilsin.",
+		},
+		Source: map[string]string{langTG: "Абӯдовуд", langRU: "Абу Давуд", langEN: "Abu Dawud", langUZ: "Abu Dovud"},
+	},
+	{
+		Key:    "crescent",
+		Arabic: "اللَّهُمَّ أَهِلَّهُ عَلَيْنَا بِالْيُمْنِ وَالْإِيمَانِ وَالسَّلَامَةِ وَالْإِسْلَامِ، رَبِّي وَرَبُّكَ اللَّهُ",
+		Transliteration: map[string]string{
+			langTG: "Аллоҳумма аҳиллаҳу ъалайно бил-юмни вал-имон, вас-саломати вал-ислом, раббӣ ва раббукаллоҳ.",
+			langRU: "Аллахумма ахиллаху ‘алейна би-ль-юмни ва-ль-иман, ва-с-салямати ва-ль-ислям, рабби ва раббука-Ллах.",
+			langEN: "Allahumma ahillahu ʿalayna bil-yumni wal-iman, was-salamati wal-islam, rabbi wa rabbukallah.",
+			langUZ: "Allohumma ahillahu ’alayna bil-yumni val-iymon, vas-salomati val-islom, robbiy va robbukalloh.",
+		},
+		Title: map[string]string{
+			langTG: "🌙 Дуо ҳангоми дидани ҳилол",
+			langRU: "🌙 Дуа при виде нового месяца",
+			langEN: "🌙 Dua on seeing the crescent",
+			langUZ: "🌙 Yangi oyni ko‘rganda duo",
+		},
+		Translation: map[string]string{
+			langTG: "Парвардигоро, онро бар мо бо баракат ва имон, саломатӣ ва ислом ҳувайдо кун. Парвардигори ману ту Аллоҳ аст.",
+			langRU: "О Аллах, дай нам увидеть его с благом и верой, благополучием и исламом. Мой Господь и твой Господь — Аллах.",
+			langEN: "O Allah, let it rise over us with blessing and faith, safety and Islam. My Lord and your Lord is Allah.",
+			langUZ: "Allohim, uni bizga baraka va iymon, salomatlik va islom bilan chiqargin. Mening Robbim ham, sening Robbing ham Allohdir.",
+		},
+		Source: map[string]string{langTG: "Тирмизӣ", langRU: "Тирмизи", langEN: "Tirmidhi", langUZ: "Termiziy"},
+	},
+	{
+		Key:    "both_worlds",
+		Arabic: "رَبَّنَا آتِنَا فِي الدُّنْيَا حَسَنَةً وَفِي الْآخِرَةِ حَسَنَةً وَقِنَا عَذَابَ النَّارِ",
+		Transliteration: map[string]string{
+			langTG: "Раббано отино фид-дунё ҳасанатан ва фил-охирати ҳасанатан ва қино ъазобан-нор.",
+			langRU: "Раббана атина фи-д-дунья хасанатан ва фи-ль-ахирати хасанатан ва кына ‘азаба-н-нар.",
+			langEN: "Rabbana atina fid-dunya hasanatan wa fil-akhirati hasanatan wa qina ʿadhaban-nar.",
+			langUZ: "Robbana otina fid-dunyo hasanatan va fil-oxirati hasanatan va qina ’azoban-nor.",
+		},
+		Title: map[string]string{
+			langTG: "🌿 Дуо барои некии ду ҷаҳон",
+			langRU: "🌿 Дуа о благе обоих миров",
+			langEN: "🌿 Dua for good in both worlds",
+			langUZ: "🌿 Ikki dunyo yaxshiligi duosi",
+		},
+		Translation: map[string]string{
+			langTG: "Парвардигоро, ба мо дар дунё некӣ ва дар охират некӣ ато кун ва моро аз азоби дӯзах нигоҳ дор.",
+			langRU: "Господь наш, даруй нам благо в этом мире и благо в Последней жизни и защити нас от мучений Огня.",
+			langEN: "Our Lord, give us good in this world and good in the Hereafter, and protect us from the punishment of the Fire.",
+			langUZ: "Robbimiz, bizga dunyoda ham yaxshilik, oxiratda ham yaxshilik ber va bizni do‘zax azobidan saqla.",
+		},
+		Source: map[string]string{langTG: "Қуръон, 2:201", langRU: "Коран, 2:201", langEN: "Quran 2:201", langUZ: "Qur’on, 2:201"},
+	},
+}
+
+// findDua returns the library entry with key.
+func findDua(key string) (duaEntry, bool) {
+	for _, dua := range duaLibrary {
+		if dua.Key == key {
+			return dua, true
+		}
+	}
+	return duaEntry{}, false
+}
+
+// formatDua lays out a library dua: title, Arabic, transliteration, translation, source.
+func formatDua(lang string, dua duaEntry) string {
+	var b strings.Builder
+	b.WriteString(localizedNiyatText(dua.Title, lang))
+	b.WriteString("\n\n")
+	b.WriteString(dua.Arabic)
+	b.WriteString("\n\n")
+	b.WriteString(localizedNiyatText(dua.Transliteration, lang))
+	b.WriteString("\n\n")
+	b.WriteString(localizedNiyatText(dua.Translation, lang))
+	if source := localizedNiyatText(dua.Source, lang); source != "" {
+		b.WriteString("\n\n")
+		b.WriteString(tr(lang, "hadith_source") + ": " + source)
+	}
+	return b.String()
+}
+
+// Ayah is a Quran verse with its surah:ayah reference and a translation per language.
+// Long verses are quoted in part.
+type Ayah struct {
+	Ref         string
+	Arabic      string
+	Translation map[string]string
+}
+
+// ayahs holds one verse per day of Ramadan; dailyAyah picks by day number.
+var ayahs = []Ayah{
+	{
+		Ref:    "2:183",
+		Arabic: "يا أيها الذين آمنوا كتب عليكم الصيام كما كتب على الذين من قبلكم لعلكم تتقون",
+		Translation: map[string]string{
+			langTG: "Эй касоне, ки имон овардаед, рӯза бар шумо фарз шуд, чунон ки бар пешиниёни шумо фарз шуда буд, то парҳезгор шавед.",
+			langRU: "О те, которые уверовали! Вам предписан пост, как он был предписан вашим предшественникам, — быть может, вы устрашитесь.",
+			langEN: "O you who believe, fasting is prescribed for you as it was prescribed for those before you, so that you may become mindful of God.",
+			langUZ: "Ey iymon keltirganlar! Sizlardan avvalgilarga farz qilinganidek, sizlarga ham ro‘za farz qilindi, shoyad taqvodor bo‘lsangizlar.",
+		},
+	},
+	{
+		Ref:    "2:185",
+		Arabic: "شهر رمضان الذي أنزل فيه القرآن هدى للناس وبينات من الهدى والفرقان",
+		Translation: map[string]string{
+			langTG: "Моҳи Рамазон моҳест, ки дар он Қуръон нозил шуд — роҳнамо барои мардум ва далелҳои равшан аз ҳидоят ва фарқкунандаи ҳақ аз ботил.",
+			langRU: "Месяц Рамадан — тот, в который был ниспослан Коран, верное руководство для людей и ясные доказательства верного руководства и различения.",
+			langEN: "The month of Ramadan is the one in which the Quran was revealed, a guidance for people and clear proofs of guidance and the criterion.",
+			langUZ: "Ramazon oyi — odamlarga hidoyat, hidoyat va ajrimning ochiq dalillari bo‘lib Qur’on nozil qilingan oydir.",
+		},
+	},
+	{
+		Ref:    "2:186",
+		Arabic: "وإذا سألك عبادي عني فإني قريب أجيب دعوة الداع إذا دعان",
+		Translation: map[string]string{
+			langTG: "Ва чун бандагонам аз ту дар бораи Ман пурсанд, Ман наздикам ва дуои дуокунандаро ҳангоме ки Маро бихонад, иҷобат мекунам.",
+			langRU: "Если Мои рабы спросят тебя обо Мне, то ведь Я близок и отвечаю на мольбу молящегося, когда он взывает ко Мне.",
+			langEN: "When My servants ask you about Me, I am near; I answer the call of the caller when he calls on Me.",
+			langUZ: "Bandalarim sendan Men haqimda so‘rasalar, Men yaqinman, duo qiluvchi Menga duo qilsa, duosini ijobat qilaman.",
+		},
+	},
+	{
+		Ref:    "1:5",
+		Arabic: "إياك نعبد وإياك نستعين",
+		Translation: map[string]string{
+			langTG: "Танҳо Туро мепарастем ва танҳо аз Ту мадад мехоҳем.",
+			langRU: "Тебе одному мы поклоняемся и Тебя одного молим о помощи.",
+			langEN: "You alone we worship, and You alone we ask for help.",
+			langUZ: "Faqat Sengagina ibodat qilamiz va faqat Sendangina yordam so‘raymiz.",
+		},
+	},
+	{
+		Ref:    "112:1",
+		Arabic: "قل هو الله أحد",
+		Translation: map[string]string{
+			langTG: "Бигӯ: Ӯ Аллоҳи ягона аст.",
+			langRU: "Скажи: «Он — Аллах Единый».",
+			langEN: "Say: He is Allah, the One.",
+			langUZ: "Ayting: U — Alloh yagonadir.",
+		},
+	},
+	{
+		Ref:    "2:152",
+		Arabic: "فاذكروني أذكركم واشكروا لي ولا تكفرون",
+		Translation: map[string]string{
+			langTG: "Пас Маро ёд кунед, то шуморо ёд кунам; ва Маро шукр гӯед ва ношукрӣ накунед.",
+			langRU: "Поминайте Меня, и Я буду помнить о вас. Благодарите Меня и не будьте неблагодарны.",
+			langEN: "So remember Me, and I will remember you; be grateful to Me and do not deny Me.",
+			langUZ: "Meni eslangiz, Men ham sizlarni eslayman. Menga shukr qilingiz va noshukrlik qilmangiz.",
+		},
+	},
+	{
+		Ref:    "2:153",
+		Arabic: "يا أيها الذين آمنوا استعينوا بالصبر والصلاة إن الله مع الصابرين",
+		Translation: map[string]string{
+			langTG: "Эй касоне, ки имон овардаед, аз сабру намоз мадад ҷӯед. Ҳамоно Аллоҳ бо собирон аст.",
+			langRU: "О те, которые уверовали! Обратитесь за помощью к терпению и намазу. Воистину, Аллах — с терпеливыми.",
+			langEN: "O you who believe, seek help through patience and prayer. Allah is with the patient.",
+			langUZ: "Ey iymon keltirganlar! Sabr va namoz bilan madad so‘rangiz. Albatta, Alloh sabrlilar bilandir.",
+		},
+	},
+	{
+		Ref:    "2:286",
+		Arabic: "لا يكلف الله نفسا إلا وسعها",
+		Translation: map[string]string{
+			langTG: "Аллоҳ ҳеҷ касро ҷуз ба андозаи тавонаш таклиф намекунад.",
+			langRU: "Аллах не возлагает на душу сверх её возможностей.",
+			langEN: "Allah does not burden a soul beyond what it can bear.",
+			langUZ: "Alloh hech bir jonga toqatidan tashqari narsani yuklamaydi.",
+		},
+	},
+	{
+		Ref:    "94:6",
+		Arabic: "إن مع العسر يسرا",
+		Translation: map[string]string{
+			langTG: "Ҳамоно бо ҳар душворӣ осонӣ аст.",
+			langRU: "Воистину, за тягостью наступает облегчение.",
+			langEN: "Indeed, with hardship comes ease.",
+			langUZ: "Albatta, har qiyinchilik bilan birga yengillik bor.",
+		},
+	},
+	{
+		Ref:    "13:28",
+		Arabic: "ألا بذكر الله تطمئن القلوب",
+		Translation: map[string]string{
+			langTG: "Огоҳ бошед, ки дилҳо бо ёди Аллоҳ ором мегиранд.",
+			langRU: "Воистину, при поминании Аллаха утешаются сердца.",
+			langEN: "Surely, in the remembrance of Allah do hearts find rest.",
+			langUZ: "Ogoh bo‘lingizkim, Allohni zikr qilish bilan qalblar orom topadi.",
+		},
+	},
+	{
+		Ref:    "39:53",
+		Arabic: "لا تقنطوا من رحمة الله إن الله يغفر الذنوب جميعا",
+		Translation: map[string]string{
+			langTG: "Аз раҳмати Аллоҳ ноумед нашавед; ҳамоно Аллоҳ ҳамаи гуноҳонро мебахшад.",
+			langRU: "Не отчаивайтесь в милости Аллаха. Воистину, Аллах прощает все грехи.",
+			langEN: "Do not despair of Allah's mercy; Allah forgives all sins.",
+			langUZ: "Allohning rahmatidan noumid bo‘lmangiz. Albatta, Alloh barcha gunohlarni mag‘firat qilur.",
+		},
+	},
+	{
+		Ref:    "65:3",
+		Arabic: "ومن يتوكل على الله فهو حسبه",
+		Translation: map[string]string{
+			langTG: "Ва ҳар кӣ бар Аллоҳ таваккал кунад, Ӯ барояш басанда аст.",
+			langRU: "Тому, кто уповает на Аллаха, достаточно Его.",
+			langEN: "Whoever puts their trust in Allah, He is enough for them.",
+			langUZ: "Kim Allohga tavakkal qilsa, U unga kifoyadir.",
+		},
+	},
+	{
+		Ref:    "40:60",
+		Arabic: "وقال ربكم ادعوني أستجب لكم",
+		Translation: map[string]string{
+			langTG: "Ва Парвардигоратон гуфт: Маро бихонед, то шуморо иҷобат кунам.",
+			langRU: "Ваш Господь сказал: «Взывайте ко Мне, и Я отвечу вам».",
+			langEN: "Your Lord says: Call on Me, and I will answer you.",
+			langUZ: "Robbingiz aytdi: Menga duo qilingiz, sizlarga ijobat qilaman.",
+		},
+	},
+	{
+		Ref:    "3:92",
+		Arabic: "لن تنالوا البر حتى تنفقوا مما تحبون",
+		Translation: map[string]string{
+			langTG: "Ҳаргиз ба некӣ нахоҳед расид, то аз он чи дӯст медоред, инфоқ накунед.",
+			langRU: "Вы не достигнете благочестия, пока не будете расходовать из того, что любите.",
+			langEN: "You will not attain righteousness until you spend from what you love.",
+			langUZ: "Sevgan narsalaringizdan infoq qilmaguningizcha, yaxshilikka erisha olmaysiz.",
+		},
+	},
+	{
+		Ref:    "20:114",
+		Arabic: "وقل رب زدني علما",
+		Translation: map[string]string{
+			langTG: "Ва бигӯ: Парвардигоро, илмамро зиёд кун.",
+			langRU: "И говори: «Господи, приумножь мои знания».",
+			langEN: "And say: My Lord, increase me in knowledge.",
+			langUZ: "Va ayting: Robbim, ilmimni ziyoda qil.",
+		},
+	},
+	{
+		Ref:    "49:13",
+		Arabic: "إن أكرمكم عند الله أتقاكم",
+		Translation: map[string]string{
+			langTG: "Ҳамоно гиромитарини шумо назди Аллоҳ парҳезгортарини шумост.",
+			langRU: "Самый почитаемый из вас перед Аллахом — наиболее богобоязненный.",
+			langEN: "The most noble of you in the sight of Allah is the most God-conscious of you.",
+			langUZ: "Albatta, Alloh huzurida eng hurmatlingiz eng taqvodoringizdir.",
+		},
+	},
+	{
+		Ref:    "17:23",
+		Arabic: "وقضى ربك ألا تعبدوا إلا إياه وبالوالدين إحسانا",
+		Translation: map[string]string{
+			langTG: "Ва Парвардигорат фармон дод, ки ҷуз Ӯро напарастед ва ба падару модар некӣ кунед.",
+			langRU: "Твой Господь предписал вам не поклоняться никому, кроме Него, и делать добро родителям.",
+			langEN: "Your Lord has decreed that you worship none but Him, and be kind to your parents.",
+			langUZ: "Robbing faqat Ungagina ibodat qilishingizni va ota-onaga yaxshilik qilishingizni amr etdi.",
+		},
+	},
+	{
+		Ref:    "3:139",
+		Arabic: "ولا تهنوا ولا تحزنوا وأنتم الأعلون إن كنتم مؤمنين",
+		Translation: map[string]string{
+			langTG: "Ва сустӣ накунед ва ғамгин нашавед, ки агар мӯъмин бошед, шумо болотаред.",
+			langRU: "Не падайте духом и не печальтесь, ведь вы возьмёте верх, если вы верующие.",
+			langEN: "Do not lose heart and do not grieve, for you will have the upper hand if you are believers.",
+			langUZ: "Sustlashmangiz va g‘amgin bo‘lmangiz, agar mo‘min bo‘lsangiz, sizlar ustunsizlar.",
+		},
+	},
+	{
+		Ref:    "14:7",
+		Arabic: "لئن شكرتم لأزيدنكم",
+		Translation: map[string]string{
+			langTG: "Агар шукр гӯед, ҳатман ба шумо зиёдтар медиҳам.",
+			langRU: "Если вы будете благодарны, Я непременно дам вам больше.",
+			langEN: "If you are grateful, I will surely give you more.",
+			langUZ: "Agar shukr qilsangizlar, albatta sizlarga ziyoda qilurman.",
+		},
+	},
+	{
+		Ref:    "29:69",
+		Arabic: "والذين جاهدوا فينا لنهدينهم سبلنا",
+		Translation: map[string]string{
+			langTG: "Ва касоне, ки дар роҳи Мо ҷидду ҷаҳд кунанд, ҳатман онҳоро ба роҳҳои Худ ҳидоят мекунем.",
+			langRU: "Тех, которые усердствуют ради Нас, Мы непременно поведём Нашими путями.",
+			langEN: "Those who strive for Us, We will surely guide them to Our ways.",
+			langUZ: "Bizning yo‘limizda jihod qilganlarni albatta O‘z yo‘llarimizga hidoyat qilurmiz.",
+		},
+	},
+	{
+		Ref:    "97:1",
+		Arabic: "إنا أنزلناه في ليلة القدر",
+		Translation: map[string]string{
+			langTG: "Ҳамоно Мо онро дар Шаби Қадр нозил кардем.",
+			langRU: "Воистину, Мы ниспослали его в Ночь Предопределения.",
+			langEN: "Indeed, We sent it down on the Night of Decree.",
+			langUZ: "Albatta, Biz uni Qadr kechasida nozil qildik.",
+		},
+	},
+	{
+		Ref:    "3:134",
+		Arabic: "والكاظمين الغيظ والعافين عن الناس والله يحب المحسنين",
+		Translation: map[string]string{
+			langTG: "…ва фурӯбарандагони хашм ва афвкунандагони мардум; ва Аллоҳ некӯкоронро дӯст медорад.",
+			langRU: "…сдерживающих гнев и прощающих людей. Аллах любит творящих добро.",
+			langEN: "…who restrain their anger and pardon people; and Allah loves those who do good.",
+			langUZ: "…g‘azabini yutuvchi va odamlarni afv etuvchilar. Alloh yaxshilik qiluvchilarni sevadi.",
+		},
+	},
+	{
+		Ref:    "16:128",
+		Arabic: "إن الله مع الذين اتقوا والذين هم محسنون",
+		Translation: map[string]string{
+			langTG: "Ҳамоно Аллоҳ бо касонест, ки парҳезгорӣ карданд ва касоне, ки некӯкоранд.",
+			langRU: "Воистину, Аллах — с теми, которые богобоязненны и творят добро.",
+			langEN: "Allah is with those who are mindful of Him and those who do good.",
+			langUZ: "Albatta, Alloh taqvo qilganlar va yaxshilik qiluvchilar bilandir.",
+		},
+	},
+	{
+		Ref:    "55:13",
+		Arabic: "فبأي آلاء ربكما تكذبان",
+		Translation: map[string]string{
+			langTG: "Пас кадом як аз неъматҳои Парвардигоратонро дурӯғ мешуморед?",
+			langRU: "Какую же из милостей вашего Господа вы считаете ложью?",
+			langEN: "So which of the favours of your Lord will you deny?",
+			langUZ: "Bas, Robbingizning qaysi ne’matlarini yolg‘on deysizlar?",
+		},
+	},
+	{
+		Ref:    "93:5",
+		Arabic: "ولسوف يعطيك ربك فترضى",
+		Translation: map[string]string{
+			langTG: "Ва ба зудӣ Парвардигорат ба ту ато мекунад, то хушнуд шавӣ.",
+			langRU: "Господь твой непременно одарит тебя, и ты будешь доволен.",
+			langEN: "And your Lord will give you, and you will be satisfied.",
+			langUZ: "Va albatta Robbing senga ato qiladi, sen rozi bo‘lasan.",
+		},
+	},
+	{
+		Ref:    "50:16",
+		Arabic: "ونحن أقرب إليه من حبل الوريد",
+		Translation: map[string]string{
+			langTG: "Ва Мо ба ӯ аз раги гарданаш наздиктарем.",
+			langRU: "Мы ближе к нему, чем яремная вена.",
+			langEN: "We are closer to him than his jugular vein.",
+			langUZ: "Biz unga jon tomiridan ham yaqinroqmiz.",
+		},
+	},
+	{
+		Ref:    "97:3",
+		Arabic: "ليلة القدر خير من ألف شهر",
+		Translation: map[string]string{
+			langTG: "Шаби Қадр аз ҳазор моҳ беҳтар аст.",
+			langRU: "Ночь Предопределения лучше тысячи месяцев.",
+			langEN: "The Night of Decree is better than a thousand months.",
+			langUZ: "Qadr kechasi ming oydan yaxshiroqdir.",
+		},
+	},
+	{
+		Ref:    "2:197",
+		Arabic: "وتزودوا فإن خير الزاد التقوى",
+		Translation: map[string]string{
+			langTG: "Ва тӯша бигиред, ки беҳтарин тӯша парҳезгорӣ аст.",
+			langRU: "Запасайтесь в дорогу, но лучший запас — богобоязненность.",
+			langEN: "Take provision, and the best provision is God-consciousness.",
+			langUZ: "Va o‘zingizga yo‘l ozig‘i olingiz, albatta eng yaxshi ozuqa taqvodir.",
+		},
+	},
+	{
+		Ref:    "57:4",
+		Arabic: "وهو معكم أين ما كنتم",
+		Translation: map[string]string{
+			langTG: "Ва Ӯ бо шумост, ҳар ҷо ки бошед.",
+			langRU: "Он с вами, где бы вы ни были.",
+			langEN: "He is with you wherever you are.",
+			langUZ: "Qayerda bo‘lsangiz ham, U sizlar bilandir.",
+		},
+	},
+	{
+		Ref:    "8:46",
+		Arabic: "واصبروا إن الله مع الصابرين",
+		Translation: map[string]string{
+			langTG: "Ва сабр кунед, ҳамоно Аллоҳ бо собирон аст.",
+			langRU: "Будьте терпеливы, ведь Аллах — с терпеливыми.",
+			langEN: "Be patient; Allah is with the patient.",
+			langUZ: "Sabr qilingiz, albatta Alloh sabrlilar bilandir.",
+		},
+	},
+}
+
+// dailyAyah returns the verse for the Ramadan day that contains date, counting from
+// start. Outside Ramadan it still picks one verse per calendar day.
+func dailyAyah(start, date time.Time) Ayah {
+	day := int(math.Floor(date.Sub(start).Hours()/24.0)) + 1
+	if day >= 1 && day <= len(ayahs) {
+		return ayahs[day-1]
+	}
+	days := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+	return ayahs[int(days%int64(len(ayahs)))]
+}
+
+// asHadith shapes the verse as a hadith block so it shares the hadith formatting: the
+// Arabic above the translation, with the reference as the source. With arabic false
+// only the translation is kept, which is what the cards can draw.
+func (a Ayah) asHadith(lang string, arabic bool) Hadith {
+	if a.Ref == "" {
+		return Hadith{}
+	}
+	text := localizedNiyatText(a.Translation, lang)
+	if arabic {
+		text = a.Arabic + "\n\n" + text
+	}
+	return Hadith{Text: text, Source: trf(lang, "ayah_reference", a.Ref)}
+}
+
+func (b *Bot) sendAyah(chatID int64) {
+	lang := b.userLang(chatID)
+	ayah := dailyAyah(b.startDate(), time.Now().In(b.tz)).asHadith(lang, true)
+	title := tr(lang, "ayah_day_title")
+	plain := formatHadithBlock(lang, title, ayah)
+	var err error
+	if b.useRichText {
+		_, err = sendRichText(b.sender, chatID, formatHadithBlockHTML(lang, title, ayah), plain, nil)
+	} else {
+		_, err = b.sender.SendMessage(chatID, plain, nil)
+	}
+	if err != nil {
+		log.Printf("ayah send error: %v", err)
+	}
+}
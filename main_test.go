@@ -1,11 +1,35 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"maps"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
 )
 
 func dayByNumber(t *testing.T, days []DayTimes, day int) DayTimes {
@@ -49,7 +73,7 @@ func TestReminderEventsForDay(t *testing.T) {
 		Isha:      1170, // 19:30
 	}
 
-	events := reminderEventsForDay(base, day)
+	events := reminderEventsForDay(base, day, reminderOptions{})
 	if len(events) != 6 {
 		t.Fatalf("expected 6 events, got %d", len(events))
 	}
@@ -117,7 +141,7 @@ func TestMarkPastDayRemindersAsSent(t *testing.T) {
 		Maghrib:   1094, // 18:14
 		Isha:      1170, // 19:30
 	}
-	events := reminderEventsForDay(base, day)
+	events := reminderEventsForDay(base, day, reminderOptions{})
 
 	// Bot restarts at 16:00 local time. Reminders due before 16:00 must be skipped.
 	now := time.Date(2026, time.February, 19, 16, 0, 0, 0, loc)
@@ -133,18 +157,125 @@ func TestMarkPastDayRemindersAsSent(t *testing.T) {
 }
 
 func TestBuildCalendarsRegionOffset(t *testing.T) {
-	cal := buildCalendars()
-	dushanbe := cal["Душанбе"]
-	asht := cal["Ашт"] // -6 offset in data table
+	base := []DayTimes{
+		{Data: "19.02.2026", Day: 1, SuhoorEnd: 300, Fajr: 300, Dhuhr: 740, Asr: 930, Maghrib: 1080, Isha: 1170},
+		{Data: "20.02.2026", Day: 2, SuhoorEnd: 299, Fajr: 299, Dhuhr: 741, Asr: 931, Maghrib: 1081, Isha: 1435},
+	}
+	cal := buildCalendarsFrom(base, map[string]int{"Base": 0, "West": -6, "East": 7})
+	if len(cal) != 3 {
+		t.Fatalf("expected 3 regions, got %d", len(cal))
+	}
+
+	west := dayByNumber(t, cal["West"], 1)
+	if west.Fajr != 294 || west.Maghrib != 1074 {
+		t.Fatalf("west offset mismatch: fajr %d maghrib %d", west.Fajr, west.Maghrib)
+	}
+	if got := dayByNumber(t, cal["East"], 2).Isha; got != 2 {
+		t.Fatalf("isha should wrap past midnight, got %d", got)
+	}
+	if base[0].Fajr != 300 {
+		t.Fatal("building calendars must not modify the base days")
+	}
+}
+
+func TestBuildCalendarsRejectsImpossibleDays(t *testing.T) {
+	base := []DayTimes{
+		{Data: "19.02.2026", Day: 1, SuhoorEnd: 300, Fajr: 330, Dhuhr: 740, Asr: 930, Maghrib: 1080, Isha: 1170},
+	}
+	cal := buildCalendarsFrom(base, map[string]int{"Ok": -20, "Early": -320, "Late": 400})
+	if _, ok := cal["Ok"]; !ok {
+		t.Fatal("expected the consistent region to be kept")
+	}
+	for _, region := range []string{"Early", "Late"} {
+		if _, ok := cal[region]; ok {
+			t.Errorf("expected %s to be rejected", region)
+		}
+	}
+
+	swapped := base[0]
+	swapped.Asr, swapped.Dhuhr = swapped.Dhuhr, swapped.Asr
+	if err := checkDayOrder(swapped, 0); err == nil || !strings.Contains(err.Error(), "asr") {
+		t.Fatalf("expected asr before dhuhr to be flagged, got %v", err)
+	}
+}
 
-	day1Dushanbe := dayByNumber(t, dushanbe, 1)
-	day1Asht := dayByNumber(t, asht, 1)
+func TestBuildCalendarsUsesTimetableOffsets(t *testing.T) {
+	cal := buildCalendars(2026)
+	if len(cal) != len(regionOffsets) {
+		t.Fatalf("expected %d regions, got %d", len(regionOffsets), len(cal))
+	}
+	dushanbe := dayByNumber(t, cal["Душанбе"], 1)
+	asht := dayByNumber(t, cal["Ашт"], 1)
+	if asht.Maghrib-dushanbe.Maghrib != regionOffsets["Ашт"] {
+		t.Fatalf("Ашт should be shifted by its table offset %d", regionOffsets["Ашт"])
+	}
+}
+
+func TestApplyRegionsFromFile(t *testing.T) {
+	savedNames, savedOffsets, savedSlugs := regionNames, regionOffsets, maps.Clone(regionSlugs)
+	t.Cleanup(func() {
+		regionNames, regionOffsets, regionSlugs = savedNames, savedOffsets, savedSlugs
+		delete(regionDisplayNames, "Вахдат")
+		delete(regionCoordinates, "Вахдат")
+		delete(regionNotes, "Вахдат")
+	})
+
+	path := filepath.Join(t.TempDir(), "regions.json")
+	raw := `[{"key":"Душанбе","offset":0},{"key":"Вахдат","offset":-1,"lat":38.55,"lng":69.02,"names":{"en":"Vahdat"},"slug":"vahdat","note":"Times verified by the local imam"}]`
+	if err := os.WriteFile(path, []byte(raw), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	specs, err := loadRegionsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyRegions(specs)
+
+	cal := buildCalendars(2026)
+	if len(cal) != 2 {
+		t.Fatalf("expected the two configured regions, got %d", len(cal))
+	}
+	if got := dayByNumber(t, cal["Вахдат"], 1).Maghrib - dayByNumber(t, cal["Душанбе"], 1).Maghrib; got != -1 {
+		t.Fatalf("expected Вахдат one minute earlier, got %d", got)
+	}
+	if regionSlugs["vahdat"] != "Вахдат" || regionSlugs["khujand"] != "" || regionSlugs["dushanbe"] != "Душанбе" {
+		t.Fatal("expected the slugs to follow the configured regions")
+	}
+	if regionDisplayName("Душанбе", langEN) != "Dushanbe" || regionDisplayName("Вахдат", langEN) != "Vahdat" {
+		t.Fatal("expected built-in names to be kept and new ones added")
+	}
+	if region, _, _ := nearestRegion(38.56, 69.0); region != "Вахдат" {
+		t.Fatalf("expected the configured coordinates to be used, got %q", region)
+	}
+	day := dayByNumber(t, cal["Вахдат"], 1)
+	withNote, err := renderTodayImage("Вахдат", day, langEN, themeByName(""), Hadith{}, Hadith{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	noteKey := todayImageCacheKey(langEN, renderOptions{}, "Вахдат", day)
+	delete(regionNotes, "Вахдат")
+	withoutNote, err := renderTodayImage("Вахдат", day, langEN, themeByName(""), Hadith{}, Hadith{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(withNote, withoutNote) {
+		t.Fatal("expected the region note to replace the today card footer")
+	}
+	if todayImageCacheKey(langEN, renderOptions{}, "Вахдат", day) == noteKey {
+		t.Fatal("expected the region note to be part of the today cache key")
+	}
 
-	if day1Asht.Fajr != day1Dushanbe.Fajr-6 {
-		t.Fatalf("fajr offset mismatch: got %d want %d", day1Asht.Fajr, day1Dushanbe.Fajr-6)
+	if err := os.WriteFile(path, []byte(`[{"key":"A"},{"key":"A"}]`), 0o644); err != nil {
+		t.Fatal(err)
 	}
-	if day1Asht.Maghrib != day1Dushanbe.Maghrib-6 {
-		t.Fatalf("maghrib offset mismatch: got %d want %d", day1Asht.Maghrib, day1Dushanbe.Maghrib-6)
+	if _, err := loadRegionsFile(path); err == nil {
+		t.Fatal("expected duplicate keys to be rejected")
+	}
+	if err := os.WriteFile(path, []byte(`[{"key":"Вахдат","offset":-1}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadRegionsFile(path); err == nil {
+		t.Fatal("expected a file without the base region to be rejected")
 	}
 }
 
@@ -167,18 +298,15 @@ func TestCurrentDayScheduleBeforeStartReturnsDayZero(t *testing.T) {
 
 func TestSendReminderUsesLocalizedNiyatAndTime(t *testing.T) {
 	loc := time.FixedZone("UTC+5", 5*3600)
-	var sent string
+	sender := &recordingSender{photoErr: errors.New("photos disabled")}
 
 	rm := &ReminderManager{
 		loc:           loc,
 		niyatSuhoor:   map[string]string{langEN: "EN_SUHOOR", langTG: "TG_SUHOOR"},
 		niyatIftar:    map[string]string{langEN: "EN_IFTAR", langTG: "TG_IFTAR"},
-		hadithsByLang: map[string][]string{langEN: {"EN_HADITH"}},
+		hadithsByLang: map[string][]Hadith{langEN: {{Text: "EN_HADITH"}}},
 		getLangFn:     func(chatID int64) string { return langEN },
-		sendFn: func(chatID int64, text string) error {
-			sent = text
-			return nil
-		},
+		sender:        sender,
 	}
 
 	ev := eventSpec{
@@ -187,6 +315,7 @@ func TestSendReminderUsesLocalizedNiyatAndTime(t *testing.T) {
 		UseSuhoor: true,
 	}
 	rm.sendReminder(1, "Dushanbe", 1, ev)
+	sent := sender.lastMessage()
 
 	if !strings.Contains(sent, "05:41") {
 		t.Fatalf("expected reminder time in message, got: %q", sent)
@@ -207,6 +336,37 @@ func TestResolveCommandHadiths(t *testing.T) {
 	}
 }
 
+func TestResolveCommandAliasesAndTypos(t *testing.T) {
+	state, err := newStateStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Bot{state: state}
+	resolve := func(text string) (string, string) {
+		cmd, args := expandCommandAlias(splitCommand(text))
+		return b.resolveCommand(42, cmd), args
+	}
+	cases := []struct{ text, cmd, args string }{
+		{"/calender", "/calendar", ""},
+		{"/Timings", "/today", ""},
+		{"/tomorrow", "/day", "+1"},
+		{"/tomorrow 5", "/day", "5"},
+		{"/todya", "/today", ""},
+		{"/setings", "/settings", ""},
+		{"календарь", "/calendar", ""},
+		{"Today", "/today", ""},
+		// Region names and short or ambiguous commands stay as typed.
+		{"Душанбе", "душанбе", ""},
+		{"/dau", "/dau", ""},
+		{"/notifyof", "/notifyof", ""},
+	}
+	for _, c := range cases {
+		if cmd, args := resolve(c.text); cmd != c.cmd || args != c.args {
+			t.Fatalf("%q resolved to %q %q, want %q %q", c.text, cmd, args, c.cmd, c.args)
+		}
+	}
+}
+
 func TestRandomHadithFromAPIUsesUserLanguageOnly(t *testing.T) {
 	var requestedLangs []string
 
@@ -242,7 +402,7 @@ func TestRandomHadithFromAPIUsesUserLanguageOnly(t *testing.T) {
 	if err != nil {
 		t.Fatalf("randomHadithFromAPI returned error: %v", err)
 	}
-	if !strings.Contains(got, "Be patient") {
+	if !strings.Contains(got.Text, "Be patient") {
 		t.Fatalf("expected hadith text in response, got: %q", got)
 	}
 
@@ -288,3 +448,2907 @@ func TestFetchHadithCategoriesUsesCache(t *testing.T) {
 		t.Fatalf("expected one API call due to cache, got %d", categoryCalls)
 	}
 }
+
+func TestApplyOffsetRollsIshaIntoNextDay(t *testing.T) {
+	loc := time.FixedZone("UTC+5", 5*3600)
+	base := time.Date(2026, time.February, 19, 0, 0, 0, 0, loc)
+	day := applyOffset(DayTimes{
+		Day:       1,
+		SuhoorEnd: 341,  // 05:41
+		Fajr:      371,  // 06:11
+		Dhuhr:     780,  // 13:00
+		Asr:       1000, // 16:40
+		Maghrib:   1094, // 18:14
+		Isha:      1200, // 20:00
+	}, 250)
+
+	if day.Isha != 10 {
+		t.Fatalf("expected isha to wrap to 00:10, got %s", minutesToClock(day.Isha))
+	}
+
+	events := reminderEventsForDay(base, day, reminderOptions{})
+	isha := events[len(events)-1]
+	want := time.Date(2026, time.February, 20, 0, 10, 0, 0, loc)
+	if !isha.Time.Equal(want) {
+		t.Fatalf("isha must roll into the next day: got %v want %v", isha.Time, want)
+	}
+	if !isha.Time.After(events[4].Time) {
+		t.Fatalf("isha %v must come after maghrib %v", isha.Time, events[4].Time)
+	}
+
+	// Reminder at 23:40 still belongs to the day, so the day ends at midnight.
+	if end := reminderDayEnd(base, events); !end.Equal(base.Add(24 * time.Hour)) {
+		t.Fatalf("unexpected day end: %v", end)
+	}
+}
+
+func TestReminderDayEndWaitsForRolledOverReminder(t *testing.T) {
+	loc := time.FixedZone("UTC+5", 5*3600)
+	base := time.Date(2026, time.February, 19, 0, 0, 0, 0, loc)
+	events := reminderEventsForDay(base, DayTimes{
+		Day:       1,
+		SuhoorEnd: 341,
+		Fajr:      371,
+		Dhuhr:     780,
+		Asr:       1000,
+		Maghrib:   1400,
+		Isha:      45, // 00:45 next day, reminder at 00:15
+	}, reminderOptions{})
+
+	end := reminderDayEnd(base, events)
+	remindAt := time.Date(2026, time.February, 20, 0, 15, 0, 0, loc)
+	if !end.After(remindAt) {
+		t.Fatalf("day end %v must be after the isha reminder %v", end, remindAt)
+	}
+	if !shouldTriggerReminder(remindAt, events[5], map[string]bool{}) {
+		t.Fatal("isha reminder must trigger after midnight")
+	}
+}
+
+func TestMatchRegionsIgnoresCase(t *testing.T) {
+	got := matchRegions("  ДУШАНБЕ ")
+	if len(got) != 1 || got[0] != "Душанбе" {
+		t.Fatalf("expected exact match for Душанбе, got %v", got)
+	}
+
+	got = matchRegions("ху")
+	if len(got) != 1 || got[0] != "Худжанд" {
+		t.Fatalf("expected prefix match for Худжанд, got %v", got)
+	}
+
+	if got := matchRegions(""); len(got) != len(regionNames) {
+		t.Fatalf("expected empty query to list all %d regions, got %d", len(regionNames), len(got))
+	}
+}
+
+func TestParseStartPayload(t *testing.T) {
+	region, lang := parseStartPayload("region_Khorug_lang_en")
+	if region != "Хоруг" || lang != langEN {
+		t.Fatalf("unexpected payload result: region=%q lang=%q", region, lang)
+	}
+
+	region, lang = parseStartPayload("promo_x_region_atlantis")
+	if region != "" || lang != "" {
+		t.Fatalf("expected unknown tokens to be ignored, got region=%q lang=%q", region, lang)
+	}
+}
+
+func TestSplitCommandStripsBotName(t *testing.T) {
+	cmd, args := splitCommand("/start@ramadan_bot region_dushanbe")
+	if cmd != "/start" || args != "region_dushanbe" {
+		t.Fatalf("unexpected split: cmd=%q args=%q", cmd, args)
+	}
+}
+
+func TestQiblaBearingFromDushanbe(t *testing.T) {
+	coords := regionCoordinates["Душанбе"]
+	bearing := qiblaBearing(coords.Lat, coords.Lng)
+	if bearing < 235 || bearing > 245 {
+		t.Fatalf("unexpected qibla bearing from Dushanbe: %.1f", bearing)
+	}
+	if key := compassDirectionKey(bearing); key != "dir_sw" {
+		t.Fatalf("expected south-west, got %s", key)
+	}
+	for region := range buildCalendars(2026) {
+		if _, ok := regionCoordinates[region]; !ok {
+			t.Fatalf("missing coordinates for region %q", region)
+		}
+	}
+}
+
+func TestNearestRegion(t *testing.T) {
+	// Roughly between Khujand's old town and the Syr Darya bridge.
+	region, dist, ok := nearestRegion(40.29, 69.61)
+	if !ok || region != "Худжанд" || dist > 5 {
+		t.Fatalf("expected Худжанд nearby, got %q %.1f km (ok=%v)", region, dist, ok)
+	}
+}
+
+func TestHandleLocationRejectsDistantPoints(t *testing.T) {
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {}, withSender(sender))
+	b.state.SetLanguage(7, langEN)
+
+	// Moscow is far outside every timetable region.
+	b.handleLocation(7, Location{Latitude: 55.75, Longitude: 37.62})
+	if sender.lastMessage() != tr(langEN, "location_too_far") || b.state.Get(7).Region != "" {
+		t.Fatalf("expected a distant location to be refused, got %q", sender.lastMessage())
+	}
+
+	saved := regionCoordinates
+	regionCoordinates = map[string]latLng{}
+	t.Cleanup(func() { regionCoordinates = saved })
+	b.handleLocation(7, Location{Latitude: 38.56, Longitude: 68.79})
+	if sender.lastMessage() != tr(langEN, "choose_region") {
+		t.Fatalf("expected the region keyboard without coordinates, got %q", sender.lastMessage())
+	}
+}
+
+func TestMoonPhase(t *testing.T) {
+	newMoon := time.Date(2026, 2, 17, 12, 0, 0, 0, time.UTC)
+	if got := moonPhase(newMoon); got > 0.03 {
+		t.Fatalf("expected new moon near 17 Feb 2026, illumination %.3f", got)
+	}
+	fullMoon := time.Date(2026, 3, 3, 12, 0, 0, 0, time.UTC)
+	if got := moonPhase(fullMoon); got < 0.97 {
+		t.Fatalf("expected full moon on 3 Mar 2026, illumination %.3f", got)
+	}
+	if !moonWaxing(time.Date(2026, 2, 24, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected waxing moon a week after new moon")
+	}
+}
+
+func TestImageCacheKeysIncludeTheme(t *testing.T) {
+	day := dayByNumber(t, buildCalendars(2026)["Душанбе"], 5)
+	dark := renderOptions{Theme: themeDark}
+	light := renderOptions{Theme: themeLight}
+
+	if todayImageCacheKey(langEN, dark, "Душанбе", day) == todayImageCacheKey(langEN, light, "Душанбе", day) {
+		t.Fatalf("expected today cache keys to differ by theme")
+	}
+	start := time.Date(2026, 2, 18, 0, 0, 0, 0, time.UTC)
+	schedule := []DayTimes{day}
+	if calendarImageCacheKey(langEN, dark, "Душанбе", start, schedule) == calendarImageCacheKey(langEN, light, "Душанбе", start, schedule) {
+		t.Fatalf("expected calendar cache keys to differ by theme")
+	}
+}
+
+func TestTahajjudUsesNextDayFajr(t *testing.T) {
+	loc := time.FixedZone("UTC+5", 5*3600)
+	base := time.Date(2026, time.February, 19, 0, 0, 0, 0, loc)
+	day := DayTimes{Day: 1, SuhoorEnd: 340, Fajr: 370, Dhuhr: 780, Asr: 1000, Maghrib: 1080, Isha: 1170}
+	next := DayTimes{Day: 2, SuhoorEnd: 339, Fajr: 360, Dhuhr: 780, Asr: 1001, Maghrib: 1081, Isha: 1171}
+
+	events := reminderEventsForDay(base, day, reminderOptions{Tahajjud: true, Next: &next})
+	last := events[len(events)-1]
+	if last.Key != "tahajjud" {
+		t.Fatalf("expected tahajjud as the last event, got %q", last.Key)
+	}
+	// Night from 18:00 to 06:00 next day: the last third starts at 02:00.
+	want := time.Date(2026, time.February, 20, 2, 0, 0, 0, loc)
+	if !last.Time.Equal(want) {
+		t.Fatalf("unexpected tahajjud time: got %s want %s", last.Time, want)
+	}
+
+	if got := reminderEventsForDay(base, day, reminderOptions{}); len(got) != 6 {
+		t.Fatalf("expected tahajjud to be opt-in, got %d events", len(got))
+	}
+}
+
+func TestDigestTimeRelativeToSuhoor(t *testing.T) {
+	loc := time.FixedZone("UTC+5", 5*3600)
+	base := time.Date(2026, time.February, 19, 0, 0, 0, 0, loc)
+	day := DayTimes{Day: 1, SuhoorEnd: 341}
+
+	got := digestTime(base, day, digestLeadMinutes(UserSettings{}))
+	if want := time.Date(2026, time.February, 19, 3, 41, 0, 0, loc); !got.Equal(want) {
+		t.Fatalf("unexpected digest time: got %s want %s", got, want)
+	}
+	if got := digestTime(base, day, 360); !got.Equal(base) {
+		t.Fatalf("expected digest to be clamped to midnight, got %s", got)
+	}
+}
+
+func TestReminderLoopStopsWhenRegionDisappears(t *testing.T) {
+	sender := &recordingSender{}
+	rm := &ReminderManager{
+		active:   make(map[int64]*reminderState),
+		calendar: newCalendarStore(nil),
+		loc:      time.UTC,
+		sender:   sender,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rm.active[1] = &reminderState{cancel: cancel, region: "Душанбе"}
+
+	rm.loop(ctx, 1, "Душанбе")
+
+	if len(sender.messages) != 1 {
+		t.Fatalf("expected one notice about the missing region, got %d", len(sender.messages))
+	}
+	if _, ok := rm.active[1]; ok {
+		t.Fatalf("expected loop to unregister itself")
+	}
+}
+
+func TestZakatAmountFormatting(t *testing.T) {
+	got := trf(langEN, "zakat_amount", 5, formatAmount(2.5), "kg", formatAmount(2.5*5))
+	if got != "For 5 person(s): 5 × 2.5 kg = 12.5 kg" {
+		t.Fatalf("unexpected zakat text: %q", got)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	b := &Bot{}
+	handler := b.healthHandler()
+
+	check := func(path string, want int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Fatalf("%s: got status %d want %d", path, rec.Code, want)
+		}
+	}
+
+	check("/healthz", http.StatusServiceUnavailable)
+	check("/readyz", http.StatusServiceUnavailable)
+
+	b.lastPoll.Store(time.Now().UnixNano())
+	b.ready.Store(true)
+	check("/healthz", http.StatusOK)
+	check("/readyz", http.StatusOK)
+
+	b.lastPoll.Store(time.Now().Add(-2 * healthPollThreshold).UnixNano())
+	check("/healthz", http.StatusServiceUnavailable)
+
+	b.scheduler = &ReminderManager{}
+	b.scheduler.drift.record("2026-02-19", 3*time.Minute)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{"ramadan_reminders_late_total 1", "ramadan_reminder_drift_max_seconds 180.000"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("metrics missing %q:\n%s", want, rec.Body.String())
+		}
+	}
+}
+
+func TestDriftStatsResetsMaxEachDay(t *testing.T) {
+	var d driftStats
+	d.record("2026-02-19", 90*time.Second)
+	d.record("2026-02-19", 10*time.Second)
+	if got := d.snapshot(); got.Max != 90*time.Second || got.Last != 10*time.Second || got.Late != 0 {
+		t.Fatalf("unexpected stats %+v", got)
+	}
+	d.record("2026-02-20", 5*time.Second)
+	if got := d.snapshot(); got.Day != "2026-02-20" || got.Max != 5*time.Second || got.Sent != 3 {
+		t.Fatalf("expected a fresh maximum for the new day, got %+v", got)
+	}
+}
+
+func TestPollBackoff(t *testing.T) {
+	if got := pollBackoff(1, errors.New("timeout")); got != 2*time.Second {
+		t.Fatalf("unexpected default backoff: %s", got)
+	}
+	conflict := fmt.Errorf("%w: terminated by other getUpdates request", errPollConflict)
+	if got := pollBackoff(1, conflict); got != 30*time.Second {
+		t.Fatalf("unexpected conflict backoff: %s", got)
+	}
+	if got := pollBackoff(2, conflict); got != time.Minute {
+		t.Fatalf("expected conflict backoff to double, got %s", got)
+	}
+	if got := pollBackoff(20, conflict); got != maxPollBackoff {
+		t.Fatalf("expected backoff to be capped, got %s", got)
+	}
+}
+
+func TestProcessUpdatesSurvivesHandlerPanic(t *testing.T) {
+	// A Bot without a state store panics on any message, standing in for a handler bug.
+	b := &Bot{}
+	updates := []Update{
+		{UpdateID: 10, Message: &Message{Chat: Chat{ID: 1}, Text: "/today"}},
+		{UpdateID: 11, Message: &Message{Chat: Chat{ID: 2}, Text: "/today"}},
+	}
+
+	b.processUpdates(updates)
+
+	if b.offset != 12 {
+		t.Fatalf("expected offset to advance past both updates, got %d", b.offset)
+	}
+}
+
+func TestWorkerIndexKeepsChatsOnOneQueue(t *testing.T) {
+	for _, chatID := range []int64{0, 1, 42, -1001234567890} {
+		first := workerIndex(chatID, 4)
+		if first < 0 || first >= 4 {
+			t.Fatalf("worker index out of range for chat %d: %d", chatID, first)
+		}
+		if again := workerIndex(chatID, 4); again != first {
+			t.Fatalf("chat %d moved between queues: %d vs %d", chatID, first, again)
+		}
+	}
+}
+
+func TestImageCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newImageCache(2, 0)
+	build := func(v string) func() ([]byte, error) {
+		return func() ([]byte, error) { return []byte(v), nil }
+	}
+
+	cache.getOrBuild("a", time.Hour, build("a"))
+	cache.getOrBuild("b", time.Hour, build("b"))
+	// Touch "a" so "b" becomes the least recently used entry.
+	cache.getOrBuild("a", time.Hour, build("a2"))
+	cache.getOrBuild("c", time.Hour, build("c"))
+
+	if _, ok := cache.items["b"]; ok {
+		t.Fatalf("expected least recently used entry to be evicted")
+	}
+	got, _ := cache.getOrBuild("a", time.Hour, build("a3"))
+	if string(got) != "a" {
+		t.Fatalf("expected recently used entry to stay cached, got %q", got)
+	}
+}
+
+func TestImageCacheRespectsByteLimit(t *testing.T) {
+	cache := newImageCache(10, 5)
+	cache.getOrBuild("a", time.Hour, func() ([]byte, error) { return []byte("aaa"), nil })
+	cache.getOrBuild("b", time.Hour, func() ([]byte, error) { return []byte("bbb"), nil })
+
+	if _, ok := cache.items["a"]; ok {
+		t.Fatalf("expected oldest entry to be evicted over the byte limit")
+	}
+	if cache.totalBytes != 3 {
+		t.Fatalf("unexpected total bytes: %d", cache.totalBytes)
+	}
+}
+
+func TestImageCacheStatsCountsHitsAndMisses(t *testing.T) {
+	cache := newImageCache(4, 0)
+	build := func() ([]byte, error) { return []byte("png"), nil }
+	cache.getOrBuild("a", time.Hour, build)
+	cache.getOrBuild("a", time.Hour, build)
+	cache.getOrBuild("b", time.Hour, build)
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Entries != 2 || stats.Bytes != 6 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestTelegramErrorClassification(t *testing.T) {
+	var err error = newTelegramError("sendMessage", 403, "Forbidden: bot was blocked by the user", responseParameters{})
+	if !errors.Is(err, ErrBotBlocked) {
+		t.Fatalf("expected ErrBotBlocked, got %v", err)
+	}
+	err = newTelegramError("sendMessage", 400, "Bad Request: chat not found", responseParameters{})
+	if errors.Is(err, ErrBotBlocked) {
+		t.Fatalf("unexpected ErrBotBlocked for %v", err)
+	}
+
+	err = fmt.Errorf("wrapped: %w", newTelegramError("sendPhoto", 429, "Too Many Requests: retry after 7", responseParameters{RetryAfter: 7}))
+	var apiErr *TelegramError
+	if !errors.As(err, &apiErr) || !apiErr.IsRateLimited() || apiErr.RetryAfter != 7 {
+		t.Fatalf("expected rate-limited TelegramError, got %v", err)
+	}
+}
+
+func newTestBot(t *testing.T, handler http.HandlerFunc, opts ...botOption) *Bot {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	state, err := newStateStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]botOption{withAPIURL(server.URL + "/bot"), withHTTPClient(server.Client())}, opts...)
+	return newBot("test-token", state, map[string][]DayTimes{}, time.UTC, nil, nil, nil, time.Now(), opts...)
+}
+
+func TestSendMessageRequest(t *testing.T) {
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bot/sendMessage" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var body struct {
+			ChatID int64  `json:"chat_id"`
+			Text   string `json:"text"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		if body.ChatID != 42 || body.Text != "salom" {
+			t.Errorf("unexpected body %+v", body)
+		}
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":42}}}`)
+	})
+	sent, err := b.SendMessage(42, "salom", nil)
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if sent == nil || sent.MessageID != 1 {
+		t.Fatalf("expected the sent message back, got %+v", sent)
+	}
+}
+
+func TestSendPhotoReturnsMessage(t *testing.T) {
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bot/sendPhoto" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":77,"chat":{"id":42}}}`)
+	})
+	sent, err := b.SendPhoto(42, []byte("png"), "caption")
+	if err != nil {
+		t.Fatalf("SendPhoto: %v", err)
+	}
+	if sent.MessageID != 77 || sent.Chat.ID != 42 {
+		t.Fatalf("unexpected message %+v", sent)
+	}
+}
+
+func TestSendDocumentReturnsMessage(t *testing.T) {
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bot/sendDocument" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":78,"chat":{"id":42}}}`)
+	})
+	sent, err := b.SendDocument(42, []byte("{}"), "state.json", "")
+	if err != nil {
+		t.Fatalf("SendDocument: %v", err)
+	}
+	if sent.MessageID != 78 {
+		t.Fatalf("unexpected message %+v", sent)
+	}
+}
+
+func TestSendMessageBlocked(t *testing.T) {
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`)
+	})
+	if _, err := b.SendMessage(42, "salom", nil); !errors.Is(err, ErrBotBlocked) {
+		t.Fatalf("expected ErrBotBlocked, got %v", err)
+	}
+}
+
+func TestSendPhotoRequest(t *testing.T) {
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bot/sendPhoto" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.FormValue("chat_id"); got != "42" {
+			t.Errorf("chat_id = %q", got)
+		}
+		if got := r.FormValue("caption"); got != "today" {
+			t.Errorf("caption = %q", got)
+		}
+		if _, _, err := r.FormFile("photo"); err != nil {
+			t.Errorf("photo missing: %v", err)
+		}
+		fmt.Fprint(w, `{"ok":true}`)
+	})
+	if _, err := b.SendPhoto(42, []byte("png"), "today"); err != nil {
+		t.Fatalf("SendPhoto: %v", err)
+	}
+}
+
+func TestAnswerCallbackReportsErrors(t *testing.T) {
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.FormValue("callback_query_id"); got != "cb-1" {
+			t.Errorf("callback_query_id = %q", got)
+		}
+		fmt.Fprint(w, `{"ok":false,"error_code":400,"description":"Bad Request: query is too old"}`)
+	})
+	var apiErr *TelegramError
+	if err := b.AnswerCallback("cb-1"); !errors.As(err, &apiErr) || apiErr.Code != 400 {
+		t.Fatalf("expected TelegramError 400, got %v", err)
+	}
+}
+
+// recordingSender is a Sender that keeps outgoing messages in memory.
+type recordingSender struct {
+	mu        sync.Mutex
+	messages  []string
+	photos    []string
+	voices    []string
+	documents []string
+	reactions []string
+	pins      []int
+	pinErr    error
+	// admins are the user IDs GetChatMember reports as group administrators.
+	admins   map[int64]bool
+	photoErr error
+	richErr  error
+	// lastID numbers the messages the sender pretends to create.
+	lastID int
+}
+
+func (s *recordingSender) SendMessage(chatID int64, text string, markup interface{}) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, text)
+	s.lastID++
+	return &Message{MessageID: s.lastID, Chat: Chat{ID: chatID}, Text: text}, nil
+}
+
+func (s *recordingSender) SendMessageWithMode(chatID int64, text string, markup interface{}, parseMode string) (*Message, error) {
+	if parseMode != "" && s.richErr != nil {
+		return nil, s.richErr
+	}
+	return s.SendMessage(chatID, text, markup)
+}
+
+func (s *recordingSender) SendMessageWithPreview(chatID int64, text string, markup interface{}) (*Message, error) {
+	return s.SendMessage(chatID, text, markup)
+}
+
+func (s *recordingSender) SendPhoto(chatID int64, photo []byte, caption string) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.photoErr != nil {
+		return nil, s.photoErr
+	}
+	s.photos = append(s.photos, caption)
+	s.lastID++
+	return &Message{MessageID: s.lastID, Chat: Chat{ID: chatID}}, nil
+}
+
+func (s *recordingSender) SendPhotoWithMarkup(chatID int64, photo []byte, caption string, markup interface{}) (*Message, error) {
+	return s.SendPhoto(chatID, photo, caption)
+}
+
+func (s *recordingSender) EditMessagePhoto(chatID int64, messageID int, photo []byte, caption string, markup interface{}) error {
+	_, err := s.SendPhoto(chatID, photo, caption)
+	return err
+}
+
+func (s *recordingSender) SendVoice(chatID int64, audio []byte, caption string) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.voices = append(s.voices, caption)
+	s.lastID++
+	return &Message{MessageID: s.lastID, Chat: Chat{ID: chatID}}, nil
+}
+
+func (s *recordingSender) SendDocument(chatID int64, data []byte, filename, caption string) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.documents = append(s.documents, filename)
+	s.lastID++
+	return &Message{MessageID: s.lastID, Chat: Chat{ID: chatID}}, nil
+}
+
+func (s *recordingSender) EditMessageText(chatID int64, messageID int, text string, markup interface{}) error {
+	_, err := s.SendMessage(chatID, text, markup)
+	return err
+}
+
+func (s *recordingSender) EditMessageReplyMarkup(chatID int64, messageID int, markup interface{}) error {
+	return nil
+}
+
+func (s *recordingSender) AnswerCallback(id string) error {
+	return nil
+}
+
+func (s *recordingSender) PinChatMessage(chatID int64, messageID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pinErr != nil {
+		return s.pinErr
+	}
+	s.pins = append(s.pins, messageID)
+	return nil
+}
+
+func (s *recordingSender) GetChatMember(chatID, userID int64) (*ChatMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.admins[userID] {
+		return &ChatMember{Status: "administrator"}, nil
+	}
+	return &ChatMember{Status: "member"}, nil
+}
+
+func (s *recordingSender) SetMessageReaction(chatID int64, messageID int, emoji string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reactions = append(s.reactions, fmt.Sprintf("%d:%s", messageID, emoji))
+	return nil
+}
+
+func (s *recordingSender) lastMessage() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.messages) == 0 {
+		return ""
+	}
+	return s.messages[len(s.messages)-1]
+}
+
+func TestTodayCommandUsesSender(t *testing.T) {
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Bot API call to %s", r.URL.Path)
+	}, withSender(sender))
+	b.state.SetLanguage(7, langEN)
+	b.state.SetRegion(7, "Душанбе")
+
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/today"})
+
+	if len(sender.messages)+len(sender.photos) == 0 {
+		t.Fatalf("expected /today to reply through the sender")
+	}
+}
+
+func TestLangCommandWithCodeSwitchesDirectly(t *testing.T) {
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Bot API call to %s", r.URL.Path)
+	}, withSender(sender))
+	b.state.SetLanguage(7, langTG)
+	b.state.SetRegion(7, "Душанбе")
+
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/lang EN"})
+	if got := b.state.Get(7).Language; got != langEN {
+		t.Fatalf("expected English after /lang EN, got %q", got)
+	}
+	if sender.messages[0] != tr(langEN, "language_saved") {
+		t.Fatalf("expected the confirmation first, got %q", sender.messages[0])
+	}
+
+	sender.messages = nil
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/lang klingon"})
+	if b.state.Get(7).Language != langEN || sender.lastMessage() != tr(langEN, "choose_language") {
+		t.Fatalf("expected an unknown code to open the keyboard, got %q", sender.lastMessage())
+	}
+}
+
+func TestRegionCommandWithNameSelectsDirectly(t *testing.T) {
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Bot API call to %s", r.URL.Path)
+	}, withSender(sender))
+	b.state.SetLanguage(7, langEN)
+
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/region khorug"})
+	if got := b.state.Get(7).Region; got != "Хоруг" {
+		t.Fatalf("expected Хоруг after /region khorug, got %q", got)
+	}
+	if want := trf(langEN, "region_selected", "Khorog"); sender.lastMessage() != want {
+		t.Fatalf("expected the region confirmation, got %q", sender.lastMessage())
+	}
+
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/region Atlantis"})
+	if b.state.Get(7).Region != "Хоруг" || sender.lastMessage() != tr(langEN, "choose_region") {
+		t.Fatalf("expected an unknown name to open the keyboard, got %q", sender.lastMessage())
+	}
+}
+
+func TestExportIsAdminOnly(t *testing.T) {
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Bot API call to %s", r.URL.Path)
+	}, withSender(sender))
+	b.adminIDs = map[int64]bool{1: true}
+	b.state.SetLanguage(7, langEN)
+
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/export"})
+	if len(sender.documents) != 0 {
+		t.Fatalf("non-admin received an export")
+	}
+	b.handleMessage(&Message{Chat: Chat{ID: 1}, Text: "/export"})
+	if len(sender.documents) != 1 || sender.documents[0] != "state.json" {
+		t.Fatalf("expected state.json for the admin, got %v", sender.documents)
+	}
+	raw, err := b.state.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data persistedStateData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Users["7"].Language != langEN {
+		t.Fatalf("export is missing chat 7: %s", raw)
+	}
+}
+
+func TestBotAddedToGroupPinsTimetable(t *testing.T) {
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Bot API call to %s", r.URL.Path)
+	}, withSender(sender))
+	b.calendars.Replace(buildCalendars(2026))
+	b.ramadanStart = time.Now().In(b.tz).Truncate(24 * time.Hour)
+	b.scheduler.SetStart(b.ramadanStart)
+	const group = -1001234
+
+	b.dispatchUpdate(Update{MyChatMember: &ChatMemberUpdated{
+		Chat:          Chat{ID: group, Type: "supergroup"},
+		From:          User{ID: 5, LanguageCode: "ru"},
+		OldChatMember: ChatMember{Status: "left"},
+		NewChatMember: ChatMember{Status: "member"},
+	}})
+	if len(sender.pins) != 1 || len(sender.photos) != 1 {
+		t.Fatalf("expected the timetable to be posted and pinned, got %d photos, pins %v", len(sender.photos), sender.pins)
+	}
+	if got := b.userLang(group); got != langRU {
+		t.Fatalf("expected the group to adopt the inviter's language, got %q", got)
+	}
+
+	sender.pinErr = &TelegramError{Method: "pinChatMessage", Code: 400, Description: "Bad Request: not enough rights to manage pinned messages in the chat"}
+	sender.admins = map[int64]bool{5: true}
+	b.handleMessage(&Message{Chat: Chat{ID: group}, From: &User{ID: 5}, Text: "/pintoday@ramadan_bot"})
+	if got := sender.lastMessage(); got != tr(langRU, "pin_no_rights") {
+		t.Fatalf("expected the missing rights notice, got %q", got)
+	}
+}
+
+func TestGroupSettingsAreAdminOnly(t *testing.T) {
+	sender := &recordingSender{admins: map[int64]bool{5: true}}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Bot API call to %s", r.URL.Path)
+	}, withSender(sender))
+	const group = -1001234
+	b.state.SetLanguage(group, langEN)
+	b.state.SetRegion(group, "Душанбе")
+
+	b.handleMessage(&Message{Chat: Chat{ID: group}, From: &User{ID: 9}, Text: "/notifyoff"})
+	if !b.state.Get(group).Notifications || sender.lastMessage() != tr(langEN, "group_admin_only") {
+		t.Fatalf("expected a member to be refused, got %q", sender.lastMessage())
+	}
+	b.handleCallback(&CallbackQuery{ID: "1", From: User{ID: 9}, Data: "region:Худжанд", Message: &Message{Chat: Chat{ID: group}}})
+	if b.state.Get(group).Region != "Душанбе" {
+		t.Fatal("expected a member's keyboard press to be refused")
+	}
+
+	// Other ways of changing the group's region and language are gated too.
+	b.handleMessage(&Message{Chat: Chat{ID: group}, From: &User{ID: 9}, Location: &Location{Latitude: 40.28, Longitude: 69.62}})
+	if b.state.Get(group).Region != "Душанбе" {
+		t.Fatal("expected a member's shared location to be refused")
+	}
+	b.handleMessage(&Message{Chat: Chat{ID: group}, From: &User{ID: 9}, Text: "/start region_khujand_lang_ru"})
+	if got := b.state.Get(group); got.Region != "Душанбе" || got.Language != langEN {
+		t.Fatalf("expected a member's /start payload to be refused, got %s/%s", got.Region, got.Language)
+	}
+	b.handleMessage(&Message{Chat: Chat{ID: group}, From: &User{ID: 9}, Text: "Худжанд"})
+	if b.state.Get(group).Region != "Душанбе" {
+		t.Fatal("expected a member's region name to be ignored")
+	}
+
+	b.handleMessage(&Message{Chat: Chat{ID: group}, From: &User{ID: 5}, Text: "/notifyoff"})
+	if b.state.Get(group).Notifications {
+		t.Fatal("expected the admin to turn reminders off")
+	}
+	b.handleMessage(&Message{Chat: Chat{ID: group}, SenderChat: &Chat{ID: group}, Text: "/notifyon"})
+	if !b.state.Get(group).Notifications {
+		t.Fatal("expected an anonymous admin to turn reminders on")
+	}
+
+	// Private chats are never gated.
+	b.state.SetLanguage(9, langEN)
+	b.state.SetRegion(9, "Душанбе")
+	b.handleMessage(&Message{Chat: Chat{ID: 9}, From: &User{ID: 9}, Text: "/notifyoff"})
+	if b.state.Get(9).Notifications {
+		t.Fatal("expected a private chat to change its own settings")
+	}
+}
+
+func TestMemberLanguageInGroups(t *testing.T) {
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Bot API call to %s", r.URL.Path)
+	}, withSender(sender))
+	const group = -1001234
+	b.state.SetLanguage(group, langTG)
+	b.state.SetRegion(group, "Душанбе")
+
+	b.handleMessage(&Message{Chat: Chat{ID: group}, From: &User{ID: 9}, Text: "/mylang ru"})
+	if got := b.userLang(group); got != langTG {
+		t.Fatalf("expected the group to keep Tajik, got %q", got)
+	}
+	if got := b.memberLang(group, 9); got != langRU {
+		t.Fatalf("expected the member to get Russian, got %q", got)
+	}
+	if b.memberLang(group, 10) != langTG {
+		t.Fatal("expected other members to get the group language")
+	}
+	b.handleMessage(&Message{Chat: Chat{ID: group}, From: &User{ID: 9}, Text: "/notifyoff"})
+	if sender.lastMessage() != tr(langRU, "group_admin_only") {
+		t.Fatalf("expected the refusal in the member's language, got %q", sender.lastMessage())
+	}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := newStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetRegion(group, "Душанбе")
+	store.SetMemberLanguage(-500, 9, langUZ)
+	store.SetMemberLanguage(group, 9, langEN)
+	reloaded, err := newStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if member, ok := reloaded.Member(group, 9); !ok || member.Language != langEN {
+		t.Fatalf("expected the member language to persist, got %+v %v", member, ok)
+	}
+	if reloaded.Get(group).Region != "Душанбе" || reloaded.MemberLanguage(9) != langEN {
+		t.Fatal("expected chat settings and the member language to load together")
+	}
+	reloaded.SetMemberLanguage(group, 9, "")
+	if _, ok := reloaded.Member(group, 9); ok {
+		t.Fatal("expected /mylang off to drop the member entry")
+	}
+	if got := reloaded.MemberLanguage(9); got != langUZ {
+		t.Fatalf("expected the other group's language after /mylang off, got %q", got)
+	}
+	reloaded.SetMemberLanguage(group, 9, langRU)
+	if got := reloaded.MemberLanguage(9); got != langRU {
+		t.Fatalf("expected the most recent choice to win, got %q", got)
+	}
+}
+
+func TestGroupChatsGetReminders(t *testing.T) {
+	state, err := newStateStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const group = -1001234
+	state.SetRegion(group, "Душанбе")
+	if got := state.ActiveNotificationRegions()[group]; got != "Душанбе" {
+		t.Fatalf("expected the group to be subscribed, got %q", got)
+	}
+}
+
+func TestDefaultLangDrivesFallbacks(t *testing.T) {
+	defaultLang = langRU
+	t.Cleanup(func() { defaultLang = "" })
+
+	if got := tr("", "welcome"); got != translations[langRU]["welcome"] {
+		t.Fatalf("expected the Russian fallback, got %q", got)
+	}
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {}, withSender(sender))
+	if got := b.userLang(7); got != langRU {
+		t.Fatalf("expected new chats to default to Russian, got %q", got)
+	}
+	if lang, ok := b.requireLanguage(7); !ok || lang != langRU {
+		t.Fatalf("expected requireLanguage to accept the default, got %q %v", lang, ok)
+	}
+	if len(sender.messages) != 0 {
+		t.Fatalf("expected no language prompt, got %q", sender.messages)
+	}
+
+	defaultLang = ""
+	if got := b.userLang(7); got != langTG {
+		t.Fatalf("expected Tajik without DEFAULT_LANG, got %q", got)
+	}
+}
+
+func TestGregorianToHijri(t *testing.T) {
+	cases := []struct {
+		date             time.Time
+		year, month, day int
+	}{
+		{time.Date(2026, time.February, 18, 0, 0, 0, 0, time.UTC), 1447, 9, 1},
+		{time.Date(2026, time.March, 20, 0, 0, 0, 0, time.UTC), 1447, 10, 1},
+	}
+	for _, c := range cases {
+		year, month, day, _ := gregorianToHijri(c.date)
+		if year != c.year || month != c.month || day != c.day {
+			t.Fatalf("%s: got %d-%d-%d want %d-%d-%d", c.date.Format("2006-01-02"), year, month, day, c.year, c.month, c.day)
+		}
+	}
+	if got := formatHijriDate(langEN, time.Date(2026, time.March, 5, 0, 0, 0, 0, time.UTC)); got != "16 Ramadan 1447" {
+		t.Fatalf("unexpected formatted date %q", got)
+	}
+
+	// The arithmetic calendar starts Ramadan on 18.02, a day before the bot's Day 1.
+	days := buildCalendars(2026)["Душанбе"]
+	if got := dayHijriDate(langEN, dayByNumber(t, days, 1)); got != "1 Ramadan 1447" {
+		t.Fatalf("Day 1 should be 1 Ramadan, got %q", got)
+	}
+	if got := dayHijriDate(langEN, dayByNumber(t, days, ramadanDays)); got != "30 Ramadan 1447" {
+		t.Fatalf("the last day should be 30 Ramadan, got %q", got)
+	}
+}
+
+func TestTimetablesCoverEachYear(t *testing.T) {
+	for _, year := range ramadanYears() {
+		first, last, ok := timetableRange(year, time.UTC)
+		if !ok {
+			t.Fatalf("%d: timetable has no Day 1", year)
+		}
+		if first.Year() != year || last.Sub(first) != 29*24*time.Hour {
+			t.Fatalf("%d: unexpected range %s – %s", year, first.Format("2006-01-02"), last.Format("2006-01-02"))
+		}
+		calendars := buildCalendars(year)
+		if len(calendars) != len(regionOffsets) || len(calendars["Душанбе"]) != 31 {
+			t.Fatalf("%d: unexpected calendar shape", year)
+		}
+		if got, err := timetableYear(first); err != nil || got != year {
+			t.Fatalf("%d: timetableYear = %d, %v", year, got, err)
+		}
+	}
+	if _, err := timetableYear(time.Date(2027, time.February, 8, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Fatal("a year without a published timetable must be refused")
+	}
+}
+
+func TestDayNavKeyboardStopsAtEdges(t *testing.T) {
+	first := dayNavKeyboard(1).InlineKeyboard[0]
+	if len(first) != 1 || first[0].CallbackData != "day:2" {
+		t.Fatalf("unexpected first-day buttons: %+v", first)
+	}
+	middle := dayNavKeyboard(12).InlineKeyboard[0]
+	if len(middle) != 2 || middle[0].CallbackData != "day:11" || middle[1].CallbackData != "day:13" {
+		t.Fatalf("unexpected buttons: %+v", middle)
+	}
+	last := dayNavKeyboard(ramadanDays).InlineKeyboard[0]
+	if len(last) != 1 || last[0].CallbackData != fmt.Sprintf("day:%d", ramadanDays-1) {
+		t.Fatalf("unexpected last-day buttons: %+v", last)
+	}
+}
+
+func TestSendDuaPlaysConfiguredRecording(t *testing.T) {
+	path := t.TempDir() + "/iftar.ogg"
+	if err := os.WriteFile(path, []byte("OggS"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {}, withSender(sender))
+	b.niyatIftar = map[string]string{langEN: "EN_IFTAR"}
+	b.duaAudioPaths = map[string]string{duaIftar: path}
+	b.state.SetLanguage(7, langEN)
+
+	b.sendDua(7, duaIftar)
+	b.sendDua(7, duaSuhoor)
+
+	if len(sender.voices) != 1 {
+		t.Fatalf("expected one voice message, got %d", len(sender.voices))
+	}
+	if len(sender.messages) != 2 || !strings.Contains(sender.messages[0], "EN_IFTAR") {
+		t.Fatalf("expected niyat texts after the recording, got %q", sender.messages)
+	}
+}
+
+func TestDuaLibrary(t *testing.T) {
+	seen := map[string]bool{duaSuhoor: true, duaIftar: true}
+	for _, dua := range duaLibrary {
+		if seen[dua.Key] {
+			t.Fatalf("duplicate dua key %q", dua.Key)
+		}
+		seen[dua.Key] = true
+		if dua.Arabic == "" {
+			t.Errorf("%s: missing Arabic", dua.Key)
+		}
+		for _, lang := range []string{langTG, langRU, langEN, langUZ} {
+			if dua.Title[lang] == "" || dua.Translation[lang] == "" || dua.Source[lang] == "" || dua.Transliteration[lang] == "" {
+				t.Errorf("%s: missing %s text", dua.Key, lang)
+			}
+		}
+		// Tajik and Russian readers get the transliteration in Cyrillic.
+		for _, lang := range []string{langTG, langRU} {
+			if !strings.ContainsFunc(dua.Transliteration[lang], func(r rune) bool { return unicode.Is(unicode.Cyrillic, r) }) {
+				t.Errorf("%s: %s transliteration is not Cyrillic: %q", dua.Key, lang, dua.Transliteration[lang])
+			}
+		}
+	}
+	if rows := duaKeyboard(langEN).InlineKeyboard; len(rows) != 1+len(duaLibrary) {
+		t.Fatalf("expected a button per library dua, got %d rows", len(rows))
+	}
+
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {}, withSender(sender))
+	b.state.SetLanguage(7, langRU)
+	b.handleCallback(&CallbackQuery{ID: "1", Data: "dua:qadr", Message: &Message{Chat: Chat{ID: 7}}})
+	got := sender.lastMessage()
+	if !strings.Contains(got, "اللَّهُمَّ إِنَّكَ عَفُوٌّ") || !strings.Contains(got, "так прости же меня") || !strings.Contains(got, "Источник: Тирмизи") {
+		t.Fatalf("unexpected dua text:\n%s", got)
+	}
+	sender.messages = nil
+	b.sendDua(7, "unknown")
+	if len(sender.messages) != 0 {
+		t.Fatalf("an unknown dua must send nothing, got %q", sender.messages)
+	}
+}
+
+func TestTasbihCounter(t *testing.T) {
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {}, withSender(sender))
+	b.state.SetLanguage(7, langEN)
+
+	b.handleTasbih(7, nil, "33")
+	for i := 0; i < 33; i++ {
+		b.handleTasbih(7, nil, "inc")
+	}
+	settings := b.state.Get(7)
+	if settings.TasbihCount != 33 || settings.TasbihTarget != 33 {
+		t.Fatalf("unexpected tasbih state: %+v", settings)
+	}
+	if !strings.Contains(sender.lastMessage(), tr(langEN, "tasbih_done")) {
+		t.Fatalf("expected target reached message, got %q", sender.lastMessage())
+	}
+
+	b.handleTasbih(7, nil, "reset")
+	if settings := b.state.Get(7); settings.TasbihCount != 0 || settings.TasbihTarget != 33 {
+		t.Fatalf("expected reset to keep the target, got %+v", settings)
+	}
+}
+
+func TestRegionDisplayNamesCoverAllRegions(t *testing.T) {
+	for _, region := range regionNames {
+		for _, lang := range supportedLangs {
+			if _, ok := regionDisplayNames[region][lang]; !ok {
+				t.Fatalf("missing %s name for region %q", lang, region)
+			}
+		}
+	}
+	if got := regionDisplayName("Худжанд", langEN); got != "Khujand" {
+		t.Fatalf("unexpected English name %q", got)
+	}
+	if got := regionDisplayName("Unknown", langEN); got != "Unknown" {
+		t.Fatalf("expected unknown keys to pass through, got %q", got)
+	}
+}
+
+func TestRegionKeyboardPagination(t *testing.T) {
+	regions := []string{"a", "b", "c", "d", "e"}
+
+	first := regionKeyboardPage(regions, langEN, 1, 4, 2).InlineKeyboard
+	if len(first) != 3 || len(first[0]) != 2 || len(first[1]) != 2 {
+		t.Fatalf("expected two full rows plus navigation, got %+v", first)
+	}
+	if nav := first[2]; len(nav) != 1 || nav[0].CallbackData != "regionpage:2" {
+		t.Fatalf("expected a More button, got %+v", nav)
+	}
+
+	second := regionKeyboardPage(regions, langEN, 2, 4, 2).InlineKeyboard
+	if len(second) != 2 || second[0][0].CallbackData != "region:e" || second[1][0].CallbackData != "regionpage:1" {
+		t.Fatalf("unexpected second page %+v", second)
+	}
+
+	single := regionKeyboardPage(regions, langEN, 1, 10, 3).InlineKeyboard
+	if len(single) != 2 {
+		t.Fatalf("expected no navigation row on a single page, got %+v", single)
+	}
+}
+
+func TestRegionKeyboardOnlyOffersRegionsWithCalendars(t *testing.T) {
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {})
+	b.calendars.Replace(buildCalendars(2026))
+	offered := 0
+	for _, row := range b.regionKeyboard(langEN).InlineKeyboard {
+		for _, button := range row {
+			region, ok := strings.CutPrefix(button.CallbackData, "region:")
+			if !ok {
+				continue
+			}
+			offered++
+			if _, found := b.calendars.Get(region); !found {
+				t.Errorf("keyboard offers %q, which has no calendar", region)
+			}
+		}
+	}
+	if offered != len(b.calendars.Regions()) {
+		t.Fatalf("expected every calendar region on the keyboard, got %d of %d", offered, len(b.calendars.Regions()))
+	}
+}
+
+func TestOrderedRegionsPutsDefaultFirstThenAlphabetical(t *testing.T) {
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {})
+	b.calendars.Replace(buildCalendars(2026))
+
+	tg := b.orderedRegions(langTG)
+	if tg[0] != b.defaultRegion {
+		t.Fatalf("expected %s first, got %v", b.defaultRegion, tg)
+	}
+	names := make([]string, len(tg))
+	for i, region := range tg {
+		names[i] = regionDisplayName(region, langTG)
+	}
+	pos := func(name string) int {
+		for i, n := range names {
+			if n == name {
+				return i
+			}
+		}
+		t.Fatalf("%s missing from %v", name, names)
+		return -1
+	}
+	// Ҳ sorts right after Х, not after Я as its code point would have it.
+	if !(pos("Хуҷанд") < pos("Ҳамадонӣ") && pos("Ҳамадонӣ") < pos("Шаҳритус")) {
+		t.Fatalf("unexpected Tajik order %v", names)
+	}
+	for i := 2; i < len(names); i++ {
+		if regionNameLess(names[i], names[i-1]) {
+			t.Fatalf("%s sorted before %s", names[i-1], names[i])
+		}
+	}
+	if en := b.orderedRegions(langEN); en[0] != b.defaultRegion || en[1] != "Ашт" {
+		t.Fatalf("expected Dushanbe then Asht in English, got %v", en[:2])
+	}
+}
+
+func TestMatchRegionsFuzzy(t *testing.T) {
+	cases := map[string][]string{
+		"Хуҷанд":  {"Худжанд"},
+		"khujand": {"Худжанд"},
+		"xo'jand": {"Худжанд"},
+		"КӮЛОБ":   {"Кулоб"},
+		"tursun":  {"Турсунзода"},
+		"ш шохин": {"Ш. Шохин"},
+		"zzz":     nil,
+	}
+	for query, want := range cases {
+		got := matchRegions(query)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("matchRegions(%q) = %q, want %q", query, got, want)
+		}
+	}
+	if got := matchRegions("ис"); len(got) < 2 {
+		t.Fatalf("expected several candidates for a short prefix, got %q", got)
+	}
+}
+
+func TestFreeTextSelectsUniqueRegion(t *testing.T) {
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {}, withSender(sender))
+	b.state.SetLanguage(7, langEN)
+
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "Khorog"})
+	defer b.scheduler.Stop(7)
+
+	if got := b.state.Get(7).Region; got != "Хоруг" {
+		t.Fatalf("expected region to be set from free text, got %q", got)
+	}
+
+	// In groups free text is chatter, even from admins.
+	const group = -1001234
+	b.state.SetLanguage(group, langEN)
+	b.handleMessage(&Message{Chat: Chat{ID: group}, SenderChat: &Chat{ID: group}, Text: "Khorog"})
+	if got := b.state.Get(group).Region; got != "" {
+		t.Fatalf("expected group free text to be ignored, got %q", got)
+	}
+}
+
+func TestImageCacheTTL(t *testing.T) {
+	now := time.Date(2026, time.February, 19, 12, 0, 0, 0, time.UTC)
+	cache := newImageCache(8, 0)
+	cache.now = func() time.Time { return now }
+	builds := 0
+	build := func() ([]byte, error) {
+		builds++
+		return []byte(fmt.Sprintf("v%d", builds)), nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if got, _ := cache.getOrBuild("k", time.Hour, build); string(got) != "v1" {
+			t.Fatalf("expected cached v1, got %q", got)
+		}
+	}
+	if builds != 1 {
+		t.Fatalf("expected one build within TTL, got %d", builds)
+	}
+
+	now = now.Add(time.Hour)
+	if got, _ := cache.getOrBuild("k", time.Hour, build); string(got) != "v2" {
+		t.Fatalf("expected rebuild after expiry, got %q", got)
+	}
+	if stats := cache.Stats(); stats.Entries != 1 {
+		t.Fatalf("expected the expired entry to be replaced, got %+v", stats)
+	}
+}
+
+func TestImageCacheBypass(t *testing.T) {
+	builds := 0
+	build := func() ([]byte, error) {
+		builds++
+		return []byte("png"), nil
+	}
+	var nilCache *imageCache
+	nilCache.getOrBuild("k", time.Hour, build)
+	nilCache.getOrBuild("k", time.Hour, build)
+
+	cache := newImageCache(8, 0)
+	cache.getOrBuild("k", 0, build)
+	cache.getOrBuild("k", -time.Second, build)
+
+	if builds != 4 {
+		t.Fatalf("expected every call to build, got %d builds", builds)
+	}
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Fatalf("expected nothing cached without a TTL, got %+v", stats)
+	}
+}
+
+func TestImageCacheErrorsAreNotCached(t *testing.T) {
+	cache := newImageCache(8, 0)
+	if _, err := cache.getOrBuild("k", time.Hour, func() ([]byte, error) { return nil, errors.New("render failed") }); err == nil {
+		t.Fatalf("expected build error")
+	}
+	got, err := cache.getOrBuild("k", time.Hour, func() ([]byte, error) { return []byte("ok"), nil })
+	if err != nil || string(got) != "ok" {
+		t.Fatalf("expected retry after a failed build, got %q, %v", got, err)
+	}
+}
+
+func TestImageCacheBreakerSkipsRenderingAfterFailures(t *testing.T) {
+	now := time.Date(2026, time.February, 19, 12, 0, 0, 0, time.UTC)
+	cache := newImageCache(8, 0)
+	cache.now = func() time.Time { return now }
+	builds := 0
+	fail := func() ([]byte, error) {
+		builds++
+		return nil, errors.New("font missing")
+	}
+	ok := func() ([]byte, error) {
+		builds++
+		return []byte("png"), nil
+	}
+
+	for i := 0; i < renderBreakerThreshold; i++ {
+		cache.getOrBuild(fmt.Sprintf("k%d", i), time.Hour, fail)
+	}
+	if _, err := cache.getOrBuild("other", time.Hour, ok); !errors.Is(err, errRenderingSuspended) {
+		t.Fatalf("expected rendering to be suspended, got %v", err)
+	}
+	if builds != renderBreakerThreshold || cache.Stats().SuspendedUntil.IsZero() {
+		t.Fatalf("expected no build while suspended, got %d builds, %+v", builds, cache.Stats())
+	}
+
+	now = now.Add(renderBreakerCooldown)
+	if _, err := cache.getOrBuild("k", time.Hour, fail); err == nil || errors.Is(err, errRenderingSuspended) {
+		t.Fatalf("expected one trial build after the cooldown, got %v", err)
+	}
+	if _, err := cache.getOrBuild("k", time.Hour, ok); !errors.Is(err, errRenderingSuspended) {
+		t.Fatalf("expected a failed trial to suspend again, got %v", err)
+	}
+
+	now = now.Add(renderBreakerCooldown)
+	trial := func() ([]byte, error) {
+		// A second request while the trial runs must not start another build.
+		if _, err := cache.getOrBuild("concurrent", time.Hour, ok); !errors.Is(err, errRenderingSuspended) {
+			t.Errorf("expected a single trial build, got %v", err)
+		}
+		return nil, errors.New("still broken")
+	}
+	cache.getOrBuild("k", time.Hour, trial)
+
+	now = now.Add(renderBreakerCooldown)
+	if got, err := cache.getOrBuild("k", time.Hour, ok); err != nil || string(got) != "png" {
+		t.Fatalf("expected recovery after a successful build, got %q, %v", got, err)
+	}
+	cache.getOrBuild("k2", time.Hour, fail)
+	if _, err := cache.getOrBuild("k3", time.Hour, ok); err != nil {
+		t.Fatalf("a success must reset the failure count, got %v", err)
+	}
+}
+
+func TestSendDayFallsBackToTextWhileRenderingSuspended(t *testing.T) {
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {}, withSender(sender))
+	b.calendars.Replace(buildCalendars(2026))
+	b.state.SetLanguage(7, langEN)
+	b.state.SetRegion(7, "Душанбе")
+	b.imageCache = newImageCache(4, 0)
+	b.imageCache.failures = renderBreakerThreshold
+	b.imageCache.suspendedUntil = time.Now().Add(renderBreakerCooldown)
+
+	b.sendDay(7, nil, 3)
+	if len(sender.photos) != 0 || len(sender.messages) != 1 {
+		t.Fatalf("expected one text message, got %d photos and %q", len(sender.photos), sender.messages)
+	}
+	cal, _ := b.regionCalendar("Душанбе")
+	if day := dayByNumber(t, cal, 3); !strings.Contains(sender.messages[0], minutesToClock(day.Maghrib)) {
+		t.Fatalf("expected the day's timetable, got %q", sender.messages[0])
+	}
+}
+
+func TestImageCacheStaysWithinEntryCap(t *testing.T) {
+	cache := newImageCache(3, 0)
+	for i := 0; i < 10; i++ {
+		cache.getOrBuild(fmt.Sprintf("k%d", i), time.Hour, func() ([]byte, error) { return []byte("x"), nil })
+	}
+	if stats := cache.Stats(); stats.Entries != 3 || stats.Bytes != 3 {
+		t.Fatalf("expected cache to stay at its cap, got %+v", stats)
+	}
+}
+
+// simulateReminderDay runs a reminder loop for Ramadan day 1 in Dushanbe on a fake clock
+// that advances two minutes per tick, and returns the reminder captions sent plus the
+// day's events.
+func simulateReminderDay(t *testing.T, configure func(rm *ReminderManager)) ([]string, []eventSpec) {
+	t.Helper()
+	loc := time.FixedZone("UTC+5", 5*3600)
+	calendar := buildCalendars(2026)
+	start := time.Date(2026, time.February, 19, 0, 0, 0, 0, loc)
+	now := start.Add(time.Minute)
+	stopAt := start.Add(24*time.Hour + 30*time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sender := &recordingSender{}
+	rm := &ReminderManager{
+		active:       make(map[int64]*reminderState),
+		calendar:     newCalendarStore(calendar),
+		loc:          loc,
+		ramadanStart: start,
+		sender:       sender,
+		getLangFn:    func(chatID int64) string { return langEN },
+		tick:         time.Millisecond,
+		now: func() time.Time {
+			now = now.Add(2 * time.Minute)
+			if now.After(stopAt) {
+				cancel()
+			}
+			return now
+		},
+	}
+	if configure != nil {
+		configure(rm)
+	}
+
+	rm.loop(ctx, 1, "Душанбе")
+
+	day := dayByNumber(t, calendar["Душанбе"], 1)
+	events := reminderEventsForDay(reminderDayBaseTime(start, 1, loc), day, reminderOptions{Next: findDaySchedule(calendar["Душанбе"], 2)})
+	return sender.photos, events
+}
+
+func TestReminderLoopSendsEachEventOnceOverSimulatedDay(t *testing.T) {
+	var manager *ReminderManager
+	sent, events := simulateReminderDay(t, func(rm *ReminderManager) { manager = rm })
+	if len(sent) != len(events) {
+		t.Fatalf("expected %d reminders, got %d: %q", len(events), len(sent), sent)
+	}
+	if drift := manager.drift.snapshot(); drift.Sent != int64(len(events)) || drift.Max <= 0 {
+		t.Fatalf("expected drift recorded for every reminder, got %+v", drift)
+	}
+	for i, ev := range events {
+		if !strings.Contains(sent[i], ev.Time.Format("15:04")) {
+			t.Fatalf("reminder %d: expected %s in %q", i, ev.Time.Format("15:04"), sent[i])
+		}
+	}
+}
+
+func TestReminderLoopSkipsPersistedReminders(t *testing.T) {
+	state, err := newStateStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.MarkReminderSent(1, "2026-02-19", "maghrib")
+	sent, events := simulateReminderDay(t, func(rm *ReminderManager) {
+		rm.sentFn = state.SentReminders
+		rm.markSentFn = state.MarkReminderSent
+	})
+	if len(sent) != len(events)-1 {
+		t.Fatalf("expected the persisted maghrib reminder to be skipped, got %d of %d", len(sent), len(events))
+	}
+	if got := state.SentReminders(1, "2026-02-19"); len(got) != len(events) {
+		t.Fatalf("expected every event to be recorded, got %v", got)
+	}
+	if got := state.SentReminders(1, "2026-02-20"); len(got) != 0 {
+		t.Fatalf("expected a new day to start empty, got %v", got)
+	}
+}
+
+func TestMarkReminderSentBatchesFileWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state, err := newStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.SetRegion(1, "Душанбе")
+	state.MarkReminderSent(1, "2026-02-19", "suhoor")
+	state.MarkReminderSent(1, "2026-02-19", "fajr")
+	state.mu.Lock()
+	pending := state.sentFlush
+	state.mu.Unlock()
+	if pending == nil {
+		t.Fatal("expected a batched flush to be scheduled")
+	}
+	pending.Stop()
+
+	reloaded, err := newStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.SentReminders(1, "2026-02-19"); len(got) != 0 {
+		t.Fatalf("expected sent reminders to wait for the flush, got %v", got)
+	}
+	state.flushSentReminders()
+	reloaded, err = newStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.SentReminders(1, "2026-02-19"); !got["suhoor"] || !got["fajr"] {
+		t.Fatalf("expected the flush to persist both reminders, got %v", got)
+	}
+}
+
+func TestReminderLoopHonoursQuietHours(t *testing.T) {
+	sent, events := simulateReminderDay(t, func(rm *ReminderManager) {
+		rm.settingsFn = func(chatID int64) UserSettings {
+			return UserSettings{QuietFrom: 0, QuietTo: 23*60 + 59}
+		}
+	})
+	if len(sent) != 1 || events[0].Key != "suhoor" || !strings.Contains(sent[0], events[0].Time.Format("15:04")) {
+		t.Fatalf("expected only the exempt suhoor reminder, got %q", sent)
+	}
+}
+
+// notifyingSender reports each sent message on a channel so tests can wait for it.
+type notifyingSender struct {
+	*recordingSender
+	sent chan string
+}
+
+func (s notifyingSender) SendMessage(chatID int64, text string, markup interface{}) (*Message, error) {
+	s.sent <- text
+	return s.recordingSender.SendMessage(chatID, text, markup)
+}
+
+func TestMutedChatResumesWhenMuteEnds(t *testing.T) {
+	sender := notifyingSender{recordingSender: &recordingSender{}, sent: make(chan string, 1)}
+	now := time.Date(2026, 2, 19, 12, 0, 0, 0, time.UTC)
+	mutedUntil := now.Add(time.Hour)
+	type timer struct {
+		wait time.Duration
+		fire func()
+	}
+	timers := make(chan timer, 1)
+	rm := &ReminderManager{
+		active:     make(map[int64]*reminderState),
+		calendar:   newCalendarStore(nil),
+		loc:        time.UTC,
+		sender:     sender,
+		settingsFn: func(chatID int64) UserSettings { return UserSettings{MutedUntil: &mutedUntil} },
+		now:        func() time.Time { return now },
+		afterFunc: func(d time.Duration, f func()) func() bool {
+			timers <- timer{wait: d, fire: f}
+			return func() bool { return true }
+		},
+	}
+	t.Cleanup(func() { rm.Stop(1) })
+
+	// With no calendar for the region, a running loop reports that straight away.
+	rm.Start(1, "Душанбе")
+	var armed timer
+	select {
+	case armed = <-timers:
+	default:
+		t.Fatal("a muted chat should wait on a timer instead of starting its loop")
+	}
+	if armed.wait != time.Hour {
+		t.Fatalf("expected the timer to run until the mute ends, got %s", armed.wait)
+	}
+	select {
+	case text := <-sender.sent:
+		t.Fatalf("muted chat started its loop early: %q", text)
+	default:
+	}
+
+	now = mutedUntil
+	armed.fire()
+	select {
+	case <-sender.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("loop did not resume after the mute ended")
+	}
+}
+
+func TestParseMuteDuration(t *testing.T) {
+	for raw, want := range map[string]time.Duration{"3h": 3 * time.Hour, "90m": 90 * time.Minute, "2": 2 * time.Hour} {
+		if got, err := parseMuteDuration(raw); err != nil || got != want {
+			t.Errorf("parseMuteDuration(%q) = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{"", "soon", "0", "100h"} {
+		if _, err := parseMuteDuration(raw); err == nil {
+			t.Errorf("parseMuteDuration(%q) should fail", raw)
+		}
+	}
+}
+
+func TestMissedReminders(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2026, 2, 19, h, m, 0, 0, time.UTC) }
+	events := []eventSpec{
+		{Key: "isha", Time: at(19, 40)},
+		{Key: "suhoor", Time: at(5, 20), UseSuhoor: true},
+		{Key: "fajr", Time: at(5, 40)},
+		{Key: "dhuhr", Time: at(12, 50)},
+	}
+	quiet := UserSettings{Notifications: true, QuietFrom: 22 * 60, QuietTo: 5*60 + 30}
+
+	missed := missedReminders(events, quiet, nil, at(12, 25))
+	var keys []string
+	for _, ev := range missed {
+		keys = append(keys, ev.Key)
+	}
+	// fajr's reminder (05:10) fell in quiet hours; dhuhr's is due at 12:20, isha's later.
+	if strings.Join(keys, ",") != "suhoor,dhuhr" {
+		t.Fatalf("unexpected missed reminders %v", keys)
+	}
+	if got := missedReminders(events, UserSettings{}, nil, at(4, 0)); len(got) != 0 {
+		t.Fatalf("expected nothing missed before suhoor, got %v", got)
+	}
+	// suhoor went out on time, so only dhuhr is left to replay.
+	if got := missedReminders(events, quiet, map[string]bool{"suhoor": true}, at(12, 25)); len(got) != 1 || got[0].Key != "dhuhr" {
+		t.Fatalf("expected a delivered reminder to be skipped, got %v", got)
+	}
+	// Muted until 10:00: suhoor's reminder fell inside the mute, dhuhr's after it.
+	muted := quiet
+	mutedUntil := at(10, 0)
+	muted.MutedUntil = &mutedUntil
+	if got := missedReminders(events, muted, nil, at(12, 25)); len(got) != 1 || got[0].Key != "dhuhr" {
+		t.Fatalf("expected muted reminders to stay silenced, got %v", got)
+	}
+	off := quiet
+	off.Notifications = false
+	if got := missedReminders(events, off, nil, at(12, 25)); len(got) != 0 {
+		t.Fatalf("expected nothing to replay with reminders off, got %v", got)
+	}
+}
+
+func TestQuietHoursActive(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2026, 2, 19, h, m, 0, 0, time.UTC) }
+	overnight := UserSettings{QuietFrom: 22 * 60, QuietTo: 5 * 60}
+	daytime := UserSettings{QuietFrom: 13 * 60, QuietTo: 15 * 60}
+	cases := []struct {
+		settings UserSettings
+		t        time.Time
+		want     bool
+	}{
+		{overnight, at(23, 30), true},
+		{overnight, at(2, 0), true},
+		{overnight, at(5, 0), false},
+		{overnight, at(21, 59), false},
+		{daytime, at(14, 0), true},
+		{daytime, at(15, 0), false},
+		{UserSettings{}, at(3, 0), false},
+	}
+	for _, c := range cases {
+		if got := quietHoursActive(c.settings, c.t); got != c.want {
+			t.Errorf("quietHoursActive(%d–%d, %s) = %v, want %v", c.settings.QuietFrom, c.settings.QuietTo, c.t.Format("15:04"), got, c.want)
+		}
+	}
+	if _, _, err := parseQuietHours([]string{"22:00", "22:00"}); err == nil {
+		t.Errorf("expected an empty window to be rejected")
+	}
+}
+
+func TestReminderEventsKeepWallClockOnDSTDay(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	// Clocks spring forward at 02:00 on 8 March 2026, so the day is 23 hours long.
+	base := reminderDayBaseTime(time.Date(2026, time.March, 8, 0, 0, 0, 0, loc), 1, loc)
+	day := DayTimes{Day: 1, SuhoorEnd: 5*60 + 30, Fajr: 6 * 60, Dhuhr: 13 * 60, Asr: 16*60 + 30, Maghrib: 19*60 + 5, Isha: 20*60 + 30}
+
+	events := reminderEventsForDay(base, day, reminderOptions{})
+	want := []string{"05:30", "06:00", "13:00", "16:30", "19:05", "20:30"}
+	for i, ev := range events {
+		if got := ev.Time.Format("15:04"); got != want[i] {
+			t.Fatalf("%s: got %s want %s", ev.Key, got, want[i])
+		}
+	}
+	if end := reminderDayEnd(base, events); !end.Equal(time.Date(2026, time.March, 9, 0, 0, 0, 0, loc)) {
+		t.Fatalf("expected the day to end at the next midnight, got %s", end)
+	}
+}
+
+func TestPreviewSendsEveryEventCard(t *testing.T) {
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {}, withSender(sender))
+	b.calendars.Replace(buildCalendars(2026))
+	b.ramadanStart = time.Now().In(b.tz).AddDate(0, 0, -2)
+	b.state.SetLanguage(7, langEN)
+	b.state.SetRegion(7, "Душанбе")
+	b.state.SetTahajjud(7, true)
+
+	b.sendPreview(7)
+
+	if len(sender.photos) != 7 {
+		t.Fatalf("expected six prayer cards plus tahajjud, got %d", len(sender.photos))
+	}
+
+	sender.photos, sender.messages = nil, nil
+	b.state.SetImagesDisabled(7, true)
+	b.sendPreview(7)
+	if len(sender.photos) != 0 || len(sender.messages) != 1 {
+		t.Fatalf("text mode: expected one message and no cards, got %d photos, %d messages", len(sender.photos), len(sender.messages))
+	}
+	if got := strings.Count(sender.lastMessage(), "\n\n"); got != 7 {
+		t.Fatalf("expected a headline block per event, got %q", sender.lastMessage())
+	}
+}
+
+func TestTestNotifyEventArgument(t *testing.T) {
+	sender := &recordingSender{photoErr: errors.New("photos disabled")}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {}, withSender(sender))
+	b.calendars.Replace(buildCalendars(2026))
+	b.ramadanStart = time.Now().In(b.tz).AddDate(0, 0, -2)
+	b.scheduler.ramadanStart = b.ramadanStart
+	b.state.SetLanguage(7, langEN)
+	b.state.SetRegion(7, "Душанбе")
+
+	b.sendTestNotification(7, "sunset")
+	if !strings.Contains(sender.lastMessage(), "maghrib") {
+		t.Fatalf("expected the list of valid events, got %q", sender.lastMessage())
+	}
+
+	b.sendTestNotification(7, "maghrib")
+	if !strings.Contains(sender.lastMessage(), tr(langEN, "niyat_iftar_label")) {
+		t.Fatalf("expected the real iftar reminder, got %q", sender.lastMessage())
+	}
+}
+
+func TestDebugBodyKeepsRunesWhole(t *testing.T) {
+	// "ӣ" is two bytes, so a one-byte prefix puts the limit inside every rune.
+	raw := []byte("x" + strings.Repeat("ӣ", debugLogLimit))
+	got := debugBody("application/json", raw)
+	if !utf8.ValidString(got) || !strings.HasSuffix(got, fmt.Sprintf("… (%d bytes total)", len(raw))) {
+		t.Fatalf("expected a valid truncated body, got %q", got[len(got)-40:])
+	}
+}
+
+func TestDebugTransportRedactsToken(t *testing.T) {
+	var logged strings.Builder
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":true}`)
+	})
+	b.apiURL = strings.Replace(b.apiURL, "/bot", "/bot123:SECRET", 1)
+	b.client = &http.Client{Transport: &debugTransport{next: http.DefaultTransport, secret: "123:SECRET"}}
+
+	if _, err := b.SendMessage(42, "token 123:SECRET in text", nil); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	out := logged.String()
+	if strings.Contains(out, "SECRET") {
+		t.Fatalf("token leaked into debug log: %s", out)
+	}
+	if !strings.Contains(out, "sendMessage") || !strings.Contains(out, "200 OK") {
+		t.Fatalf("expected request and response to be logged, got: %s", out)
+	}
+}
+
+func TestStartPlausible(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		start time.Time
+		want  bool
+	}{
+		{time.Date(2026, 2, 19, 0, 0, 0, 0, time.UTC), true},
+		{time.Date(2027, 2, 8, 0, 0, 0, 0, time.UTC), true},
+		{time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), false},
+		{time.Date(2028, 1, 28, 0, 0, 0, 0, time.UTC), false},
+		{time.Time{}, false},
+	}
+	for _, c := range cases {
+		if got := startPlausible(c.start, now); got != c.want {
+			t.Errorf("startPlausible(%s) = %v, want %v", c.start.Format("2006-01-02"), got, c.want)
+		}
+	}
+}
+
+func TestOutOfRangeTextNamesConfiguredRange(t *testing.T) {
+	start := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	got := outOfRangeText("en", "out_of_range", start)
+	if !strings.Contains(got, "11.03.2024") || !strings.Contains(got, "09.04.2024") {
+		t.Fatalf("out_of_range text should name the configured range, got %q", got)
+	}
+}
+
+func TestAboutTextIncludesBuildInfo(t *testing.T) {
+	got := aboutText("en", "https://t.me/support")
+	for _, want := range []string{version, commit, buildDate, "go1", "https://t.me/support"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("about text missing %q: %q", want, got)
+		}
+	}
+	if strings.Contains(aboutText("en", ""), "Support") {
+		t.Error("support line should be omitted when SUPPORT_URL is unset")
+	}
+}
+
+func TestReminderImageFollowsLanguageSwitch(t *testing.T) {
+	loc := time.FixedZone("UTC+5", 5*3600)
+	ev := eventSpec{Key: "maghrib", Title: "Iftar", Time: time.Now().Add(time.Hour), UseIftar: true}
+	opts := renderOptions{Theme: themeDark}
+	if reminderImageCacheKey(langEN, opts, "Душанбе", 1, ev) == reminderImageCacheKey(langRU, opts, "Душанбе", 1, ev) {
+		t.Fatal("reminder image cache key must differ between languages")
+	}
+
+	lang := langEN
+	sender := &recordingSender{}
+	rm := &ReminderManager{
+		loc:        loc,
+		sender:     sender,
+		imageCache: newImageCache(16, 16<<20),
+		getLangFn:  func(chatID int64) string { return lang },
+	}
+	if err := rm.sendReminder(1, "Душанбе", 1, ev); err != nil {
+		t.Fatal(err)
+	}
+	lang = langRU
+	if err := rm.sendReminder(1, "Душанбе", 1, ev); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := rm.imageCache.Stats()
+	if stats.Hits != 0 || stats.Misses != 2 {
+		t.Fatalf("expected a fresh render per language, got %+v", stats)
+	}
+	if len(sender.photos) != 2 || sender.photos[0] == sender.photos[1] {
+		t.Fatalf("expected captions in two languages, got %q", sender.photos)
+	}
+	if want := reminderHeadline(langRU, "Душанбе", 1, ev, loc); sender.photos[1] != want {
+		t.Fatalf("second reminder caption = %q, want %q", sender.photos[1], want)
+	}
+}
+
+func TestWrapTextFitsWidth(t *testing.T) {
+	faces, err := loadTodayCardFaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer faces.Close()
+
+	text := "The best of you are those who learn the Quran and teach it. Supercalifragilisticexpialidociouswordthatisfartoolong"
+	lines := wrapText(faces.Footer, text, 240)
+	if len(lines) < 3 {
+		t.Fatalf("expected text to wrap, got %q", lines)
+	}
+	for _, line := range lines {
+		if w := measureTextWidth(faces.Footer, line); w > 240 {
+			t.Errorf("line %q is %dpx wide, want <= 240", line, w)
+		}
+	}
+	if got := strings.Join(lines, ""); strings.ReplaceAll(text, " ", "") != strings.ReplaceAll(got, " ", "") {
+		t.Errorf("wrapping lost text: %q", lines)
+	}
+}
+
+func TestHadithPanelGrowsCards(t *testing.T) {
+	day := dayByNumber(t, buildCalendars(2026)["Душанбе"], 3)
+	height := func(img []byte, err error) int {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := png.DecodeConfig(bytes.NewReader(img))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg.Height
+	}
+	short := "Fasting is a shield."
+	long := strings.Repeat("Whoever fasts Ramadan out of faith and hope of reward will be forgiven his past sins. ", 4)
+
+	plain := height(renderTodayImage("Душанбе", day, langEN, themeByName(""), Hadith{}, Hadith{}))
+	withShort := height(renderTodayImage("Душанбе", day, langEN, themeByName(""), Hadith{Text: short}, Hadith{}))
+	withLong := height(renderTodayImage("Душанбе", day, langEN, themeByName(""), Hadith{Text: long}, Hadith{}))
+	if !(plain < withShort && withShort < withLong) {
+		t.Fatalf("today card heights should grow with the hadith: %d, %d, %d", plain, withShort, withLong)
+	}
+	withAyah := height(renderTodayImage("Душанбе", day, langEN, themeByName(""), Hadith{Text: short}, Hadith{Text: short}))
+	if withAyah <= withShort {
+		t.Fatalf("today card should grow with the ayah panel: %d vs %d", withShort, withAyah)
+	}
+
+	start := time.Date(2026, 2, 19, 0, 0, 0, 0, time.UTC)
+	schedule := buildCalendars(2026)["Душанбе"]
+	calPlain := height(renderCalendarImage(schedule, start, langEN, themeByName(""), Hadith{}))
+	calLong := height(renderCalendarImage(schedule, start, langEN, themeByName(""), Hadith{Text: long}))
+	if calLong <= calPlain {
+		t.Fatalf("calendar card should grow with the hadith: %d vs %d", calPlain, calLong)
+	}
+}
+
+func TestTextFacesShareOneParsedFont(t *testing.T) {
+	first, err := newTextFace(fontWeightBold, 42, gobold.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedFontsMu.Lock()
+	parsed := parsedFonts[fontWeightBold]
+	parsedFontsMu.Unlock()
+	if parsed == nil {
+		t.Fatal("expected the bold font to be parsed and cached")
+	}
+	closeFace(first)
+	closeFace(first) // a second Close must not hand the face out twice
+
+	second, err := newTextFace(fontWeightBold, 62, gobold.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeFace(second)
+	parsedFontsMu.Lock()
+	again := parsedFonts[fontWeightBold]
+	parsedFontsMu.Unlock()
+	if again != parsed {
+		t.Fatal("expected other sizes to reuse the parsed font")
+	}
+	if w := measureTextWidth(second, "Iftar"); w <= 0 {
+		t.Fatalf("expected a usable face, measured width %d", w)
+	}
+}
+
+func TestEmbeddedFontsCoverTajik(t *testing.T) {
+	// Everything the cards draw: the img_* texts and the built-in hadiths.
+	var texts []string
+	for _, lang := range supportedLangs {
+		for key, text := range translations[lang] {
+			if strings.HasPrefix(key, "img_") {
+				texts = append(texts, text)
+			}
+		}
+	}
+	for _, hadiths := range sampleHadithsByLang() {
+		for _, h := range hadiths {
+			texts = append(texts, h.String())
+		}
+	}
+	for _, weight := range []fontWeight{fontWeightRegular, fontWeightMedium, fontWeightBold} {
+		ttf := embeddedFontBytes(weight)
+		if !supportsTajikRunes(ttf) {
+			t.Fatalf("embedded %s font cannot draw Tajik letters", weight)
+		}
+		parsed, err := sfnt.Parse(ttf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf sfnt.Buffer
+		for _, text := range texts {
+			for _, r := range normalizeImageText(text) {
+				if unicode.IsSpace(r) || unicode.IsControl(r) {
+					continue
+				}
+				if idx, err := parsed.GlyphIndex(&buf, r); err != nil || idx == 0 {
+					t.Errorf("embedded %s font has no glyph for %q (%U) in %q", weight, r, r, text)
+				}
+			}
+		}
+	}
+	if got := normalizeImageText("Hadith of the Prophet ﷺ (Bukhari)."); got != "Hadith of the Prophet (s.a.w.) (Bukhari)." {
+		t.Fatalf("unexpected Latin salawat %q", got)
+	}
+	if got := normalizeImageText("хадис Пророка ﷺ (Бухари)."); got != "хадис Пророка (с.а.в.) (Бухари)." {
+		t.Fatalf("unexpected Cyrillic salawat %q", got)
+	}
+}
+
+func TestMissingGlyphsAreReportedOnce(t *testing.T) {
+	parsed, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face, err := newOpenTypeFace(parsed, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf := &pooledFace{Face: face, pool: &sync.Pool{}, font: parsed}
+
+	var logged strings.Builder
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	missingGlyphWarned.Store(false)
+	t.Cleanup(func() { missingGlyphWarned.Store(false) })
+
+	warnMissingGlyphs(pf, "Ифтор 18:16")
+	if logged.Len() != 0 {
+		t.Fatalf("expected no warning for covered text, got %q", logged.String())
+	}
+	warnMissingGlyphs(pf, "Пайғамбар ﷺ")
+	warnMissingGlyphs(pf, "☪ Ramadan")
+	out := logged.String()
+	if !strings.Contains(out, "U+FDFA") || !strings.Contains(out, "RAMADAN_FONT") {
+		t.Fatalf("expected the missing rune and the RAMADAN_FONT hint, got %q", out)
+	}
+	if strings.Count(out, "WARN") != 1 {
+		t.Fatalf("expected a single warning, got %q", out)
+	}
+}
+
+// TestConcurrentRendersDoNotRace is meant for go test -race: the cards share parsed fonts
+// and pooled faces, and no face may be drawn by two renders at once.
+func TestConcurrentRendersDoNotRace(t *testing.T) {
+	cals := buildCalendars(2026)
+	day := dayByNumber(t, cals["Душанбе"], 3)
+	other := dayByNumber(t, cals["Худжанд"], 3)
+	start := time.Date(2026, 2, 19, 0, 0, 0, 0, time.UTC)
+	ev := eventSpec{Key: "iftar", Time: time.Date(2026, 2, 21, 18, 16, 0, 0, time.UTC), UseIftar: true}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 4; i++ {
+		lang := []string{langTG, langRU, langEN, langUZ}[i%4]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, render := range []func() ([]byte, error){
+				func() ([]byte, error) {
+					return renderTodayImage("Душанбе", day, lang, themeByName(""), Hadith{}, Hadith{})
+				},
+				func() ([]byte, error) {
+					return renderReminderImage("Душанбе", 3, ev, time.UTC, lang, themeByName(""))
+				},
+				func() ([]byte, error) {
+					return renderCompareImage("Душанбе", day, "Худжанд", other, lang, themeByName(""))
+				},
+				func() ([]byte, error) {
+					return renderCalendarImage(cals["Душанбе"], start, lang, themeByName(""), Hadith{})
+				},
+			} {
+				if _, err := render(); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestBrandIsDrawnAndKeysCache(t *testing.T) {
+	day := dayByNumber(t, buildCalendars(2026)["Душанбе"], 3)
+	opts := renderOptions{Theme: themeDark}
+	plain, err := renderTodayImage("Душанбе", day, langEN, themeByName(""), Hadith{}, Hadith{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainKey := todayImageCacheKey(langEN, opts, "Душанбе", day)
+
+	logo := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(logo, logo.Bounds(), &image.Uniform{C: color.RGBA{R: 255, A: 255}}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, logo); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "logo.png")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := imageBrand
+	t.Cleanup(func() { imageBrand = saved })
+	imageBrand = brandMark{Text: "Masjid Al-Noor"}
+	if err := imageBrand.loadLogo(path); err != nil {
+		t.Fatal(err)
+	}
+	if b := imageBrand.Logo.Bounds(); b.Dx() != brandLogoMax || b.Dy() != brandLogoMax/2 {
+		t.Fatalf("expected the logo scaled to fit %dpx, got %v", brandLogoMax, b)
+	}
+
+	branded, err := renderTodayImage("Душанбе", day, langEN, themeByName(""), Hadith{}, Hadith{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(plain, branded) {
+		t.Fatal("expected the brand to change the today card")
+	}
+	if todayImageCacheKey(langEN, opts, "Душанбе", day) == plainKey {
+		t.Fatal("expected the brand to change the today cache key")
+	}
+	if _, err := renderCalendarImage(buildCalendars(2026)["Душанбе"], time.Date(2026, 2, 19, 0, 0, 0, 0, time.UTC), langEN, themeByName(""), Hadith{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A long brand text is cut to the area instead of running past its left edge.
+	imageBrand.Text = strings.Repeat("Masjid Al-Noor community centre ", 6)
+	faces, err := loadTodayCardFaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer faces.Close()
+	canvas := image.NewRGBA(image.Rect(0, 0, 800, 120))
+	area := image.Rect(400, 10, 780, 110)
+	drawBrand(canvas, area, faces.Footer, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	drawn := false
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 800; x++ {
+			if canvas.RGBAAt(x, y).A == 0 {
+				continue
+			}
+			if x < area.Min.X {
+				t.Fatalf("brand drawn at x=%d, left of the area at %d", x, area.Min.X)
+			}
+			drawn = true
+		}
+	}
+	if !drawn {
+		t.Fatal("expected the shortened brand to be drawn")
+	}
+}
+
+func TestPrayersCardShowsEveryTime(t *testing.T) {
+	day := dayByNumber(t, buildCalendars(2026)["Душанбе"], 3)
+	cases := []struct {
+		minute int
+		want   string
+	}{
+		{0, "suhoor"},
+		{day.Fajr, "dhuhr"},
+		{day.Asr, "maghrib"},
+		{day.Isha, ""},
+	}
+	for _, c := range cases {
+		if got := nextPrayer(day, c.minute); got != c.want {
+			t.Fatalf("nextPrayer at %s = %q, want %q", minutesToClock(c.minute), got, c.want)
+		}
+	}
+
+	plain, err := renderPrayersImage("Душанбе", day, "", langRU, themeByName(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	highlighted, err := renderPrayersImage("Душанбе", day, "asr", langRU, themeByName(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(plain, highlighted) {
+		t.Fatal("expected the next prayer to be highlighted")
+	}
+}
+
+func TestCompareRegions(t *testing.T) {
+	cases := []struct {
+		args, own, a, b string
+		ok              bool
+	}{
+		{"Душанбе Худжанд", "", "Душанбе", "Худжанд", true},
+		{"dushanbe ш. шохин", "", "Душанбе", "Ш. Шохин", true},
+		{"Khujand", "Душанбе", "Душанбе", "Худжанд", true},
+		{"Khujand", "", "", "", false},
+		{"Душанбе Душанбе", "", "", "", false},
+		{"Атлантида Худжанд", "", "", "", false},
+	}
+	for _, c := range cases {
+		a, b, ok := parseCompareRegions(c.args, c.own)
+		if ok != c.ok || a != c.a || b != c.b {
+			t.Fatalf("parseCompareRegions(%q, %q) = %q, %q, %v; want %q, %q, %v", c.args, c.own, a, b, ok, c.a, c.b, c.ok)
+		}
+	}
+
+	cals := buildCalendars(2026)
+	dayA := dayByNumber(t, cals["Душанбе"], 3)
+	dayB := dayByNumber(t, cals["Худжанд"], 3)
+	text := formatCompareText(langEN, "Душанбе", dayA, "Худжанд", dayB)
+	if !strings.Contains(text, "Dushanbe: ") || !strings.Contains(text, minutesToClock(dayA.SuhoorEnd)) || !strings.Contains(text, "Khujand: ") {
+		t.Fatalf("compare text misses a region:\n%s", text)
+	}
+	if !strings.Contains(text, signedMinutes(dayB.Maghrib-dayA.Maghrib)+" min") {
+		t.Fatalf("compare text misses the iftar difference:\n%s", text)
+	}
+
+	img, err := renderCompareImage("Душанбе", dayA, "Худжанд", dayB, langTG, themeByName(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.DecodeConfig(bytes.NewReader(img)); err != nil {
+		t.Fatalf("compare card is not a PNG: %v", err)
+	}
+}
+
+func TestDailyHadithIsStableWithinADay(t *testing.T) {
+	hadiths := map[string][]Hadith{langEN: {{Text: "a"}, {Text: "b"}, {Text: "c"}}}
+	morning := time.Date(2026, 2, 20, 6, 0, 0, 0, time.UTC)
+	evening := time.Date(2026, 2, 20, 22, 0, 0, 0, time.UTC)
+	if dailyHadithForLang(hadiths, langEN, morning).Text != dailyHadithForLang(hadiths, langEN, evening).Text {
+		t.Fatal("hadith of the day changed within the day")
+	}
+	if dailyHadithForLang(hadiths, langEN, morning).Text == dailyHadithForLang(hadiths, langEN, morning.AddDate(0, 0, 1)).Text {
+		t.Fatal("hadith of the day should rotate daily")
+	}
+}
+
+func TestChatLimiterTokenBucket(t *testing.T) {
+	now := time.Date(2026, 2, 20, 12, 0, 0, 0, time.UTC)
+	l := newChatLimiter(60, 2)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow(1); !ok {
+			t.Fatalf("update %d within burst was rejected", i)
+		}
+	}
+	if ok, warn := l.allow(1); ok || !warn {
+		t.Fatalf("first update over the limit: ok=%v warn=%v, want rejected with warning", ok, warn)
+	}
+	if ok, warn := l.allow(1); ok || warn {
+		t.Fatalf("second update over the limit: ok=%v warn=%v, want silently rejected", ok, warn)
+	}
+	if ok, _ := l.allow(2); !ok {
+		t.Fatal("another chat must have its own bucket")
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := l.allow(1); !ok {
+		t.Fatal("bucket should refill at the configured rate")
+	}
+
+	now = now.Add(chatLimiterIdle + time.Minute)
+	l.allow(3)
+	if _, found := l.buckets[1]; found {
+		t.Fatal("idle buckets should be cleaned up")
+	}
+
+	var disabled *chatLimiter
+	if ok, _ := disabled.allow(1); !ok {
+		t.Fatal("a nil limiter must allow everything")
+	}
+}
+
+func TestTasbihCallbacksSkipRateLimit(t *testing.T) {
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {}, withSender(sender))
+	b.limiter = newChatLimiter(30, 2)
+	b.state.SetLanguage(7, langEN)
+
+	for i := 0; i < 33; i++ {
+		b.handleCallback(&CallbackQuery{ID: "1", Data: "tasbih:inc", Message: &Message{Chat: Chat{ID: 7}}})
+	}
+	if got := b.state.Get(7).TasbihCount; got != 33 {
+		t.Fatalf("expected every tap to count, got %d", got)
+	}
+	for _, text := range sender.messages {
+		if text == tr(langEN, "rate_limited") {
+			t.Fatal("tasbih taps must not trigger the rate limit notice")
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		b.handleCallback(&CallbackQuery{ID: "1", Data: "dua:qadr", Message: &Message{Chat: Chat{ID: 7}}})
+	}
+	if sender.lastMessage() != tr(langEN, "rate_limited") {
+		t.Fatalf("other callbacks should still be limited, got %q", sender.lastMessage())
+	}
+}
+
+func TestCalendarStoreConcurrentAccess(t *testing.T) {
+	store := newCalendarStore(buildCalendars(2026))
+	regions := store.Regions()
+	if len(regions) == 0 || !sort.StringsAreSorted(regions) {
+		t.Fatalf("Regions() should list sorted keys, got %q", regions)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for _, region := range regions {
+				store.Get(region)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			store.Set(fmt.Sprintf("custom-%d", i), []DayTimes{{Day: 1}})
+			store.Replace(buildCalendars(2026))
+		}(i)
+	}
+	wg.Wait()
+
+	days := []DayTimes{{Day: 1, Maghrib: 1100}}
+	store.Set("custom", days)
+	days[0].Maghrib = 0
+	got, ok := store.Get("custom")
+	if !ok || got[0].Maghrib != 1100 {
+		t.Fatalf("Set should store a copy, got %+v", got)
+	}
+}
+
+func TestTranslationsShareKeySet(t *testing.T) {
+	for _, lang := range []string{langTG, langRU, langEN, langUZ} {
+		if _, ok := translations[lang]; !ok {
+			t.Errorf("no translations for %s", lang)
+		}
+	}
+	for lang, keys := range missingTranslationKeys() {
+		t.Errorf("%s is missing translation keys: %q", lang, keys)
+	}
+}
+
+func TestMissingTranslationKeysReportsGaps(t *testing.T) {
+	const key = "test_only_in_en"
+	translations[langEN][key] = "english only"
+	t.Cleanup(func() { delete(translations[langEN], key) })
+
+	missing := missingTranslationKeys()
+	for _, lang := range []string{langTG, langRU, langUZ} {
+		if keys := missing[lang]; len(keys) != 1 || keys[0] != key {
+			t.Errorf("missing[%s] = %q, want [%q]", lang, keys, key)
+		}
+	}
+	if _, ok := missing[langEN]; ok {
+		t.Errorf("en has every key and should not be reported, got %q", missing[langEN])
+	}
+}
+
+func TestTrFallbackChain(t *testing.T) {
+	const key = "test_fallback_key"
+	translations[langTG][key] = "tajik text"
+	t.Cleanup(func() { delete(translations[langTG], key) })
+
+	if got := tr(langRU, key); got != "tajik text" {
+		t.Errorf("key missing in ru should fall back to tg, got %q", got)
+	}
+	if got := tr("xx", key); got != "tajik text" {
+		t.Errorf("unknown language should use tg, got %q", got)
+	}
+	if got := tr(langEN, "no_such_key_anywhere"); got != "no_such_key_anywhere" {
+		t.Errorf("missing key should render as itself, got %q", got)
+	}
+	if got := trf(langEN, "day_out_of_range", 30); !strings.Contains(got, "30") {
+		t.Errorf("trf should format arguments, got %q", got)
+	}
+}
+
+func TestFormatHadithBlockHTMLEscapes(t *testing.T) {
+	got := formatHadithBlockHTML(langEN, "Hadith <of the day>", Hadith{Text: "Fasting is a shield & protection", Source: "Bukhari"})
+	want := "<b>Hadith &lt;of the day&gt;</b>\nFasting is a shield &amp; protection\n\n<i>Source: Bukhari</i>"
+	if got != want {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestHadithParsesLegacyStrings(t *testing.T) {
+	cases := []struct {
+		in   string
+		want Hadith
+	}{
+		{`"Пост — это щит" — хадис Пророка ﷺ (Бухари).`, Hadith{Text: `"Пост — это щит"`, Source: "хадис Пророка ﷺ (Бухари)."}},
+		{`«Чистота — половина веры» – Муслим`, Hadith{Text: `«Чистота — половина веры»`, Source: "Муслим"}},
+		{"Fasting is a shield — Bukhari", Hadith{Text: "Fasting is a shield — Bukhari"}},
+		{`"Unclosed quote — Bukhari`, Hadith{Text: `"Unclosed quote — Bukhari`}},
+	}
+	for _, c := range cases {
+		if got := parseLegacyHadith(c.in); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseLegacyHadith(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+
+	var list []Hadith
+	if err := json.Unmarshal([]byte(`[{"text": "Dua is worship", "source": "Tirmidhi"}, "\"Fasting is a shield\" — Bukhari"]`), &list); err != nil {
+		t.Fatal(err)
+	}
+	if want := []Hadith{{Text: "Dua is worship", Source: "Tirmidhi"}, {Text: `"Fasting is a shield"`, Source: "Bukhari"}}; !reflect.DeepEqual(list, want) {
+		t.Fatalf("got %+v, want %+v", list, want)
+	}
+
+	for lang, hadiths := range sampleHadithsByLang() {
+		for _, h := range hadiths {
+			if h.Text == "" || h.Source == "" {
+				t.Errorf("%s: built-in hadith without text or source: %+v", lang, h)
+			}
+		}
+	}
+}
+
+func TestHadithsByTopic(t *testing.T) {
+	for _, c := range []struct{ in, want string }{
+		{"fasting", "fasting"}, {"Пост", "fasting"}, {"рӯза", "fasting"}, {"roza", "fasting"}, {"ДУА", "dua"}, {"weather", ""},
+	} {
+		if got := matchHadithTag(c.in); got != c.want {
+			t.Errorf("matchHadithTag(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+	for lang, hadiths := range sampleHadithsByLang() {
+		for _, h := range hadiths {
+			for _, tag := range h.Tags {
+				if !slices.Contains(hadithTags, tag) {
+					t.Errorf("%s: unknown tag %q on %q", lang, tag, h.Text)
+				}
+			}
+		}
+	}
+
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("a topic must not call the hadith API, got %s", r.URL.Path)
+	}, withSender(sender))
+	b.hadithsByLang = sampleHadithsByLang()
+	b.state.SetLanguage(7, langEN)
+	for i := 0; i < 10; i++ {
+		b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/hadith knowledge"})
+		if got := sender.lastMessage(); !strings.Contains(got, "knowledge") && !strings.Contains(got, "Quran") {
+			t.Fatalf("expected a knowledge hadith, got %q", got)
+		}
+	}
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/hadiths weather"})
+	if got := sender.lastMessage(); got != trf(langEN, "hadith_tag_usage", hadithTagList(langEN)) {
+		t.Fatalf("expected the topic list for an unknown topic, got %q", got)
+	}
+}
+
+func TestAyahOfTheDay(t *testing.T) {
+	if len(ayahs) < ramadanDays {
+		t.Fatalf("expected an ayah for each of the %d days, got %d", ramadanDays, len(ayahs))
+	}
+	for _, ayah := range ayahs {
+		if ayah.Ref == "" || ayah.Arabic == "" {
+			t.Errorf("ayah %q: missing reference or Arabic", ayah.Ref)
+		}
+		for _, lang := range supportedLangs {
+			if ayah.Translation[lang] == "" {
+				t.Errorf("ayah %s: missing %s translation", ayah.Ref, lang)
+			}
+		}
+	}
+
+	start := time.Date(2026, 2, 19, 0, 0, 0, 0, time.UTC)
+	if got := dailyAyah(start, start.Add(2*time.Hour)); got.Ref != ayahs[0].Ref {
+		t.Fatalf("day 1 should use the first ayah, got %s", got.Ref)
+	}
+	if got := dailyAyah(start, start.AddDate(0, 0, 26).Add(20*time.Hour)); got.Ref != ayahs[26].Ref {
+		t.Fatalf("day 27 should use the 27th ayah, got %s", got.Ref)
+	}
+	after := start.AddDate(0, 2, 0)
+	if dailyAyah(start, after).Ref != dailyAyah(start, after.Add(3*time.Hour)).Ref {
+		t.Fatal("outside Ramadan the ayah should still be fixed for the day")
+	}
+
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {}, withSender(sender))
+	b.ramadanStart = time.Now().In(b.tz).AddDate(0, 0, -2)
+	b.state.SetLanguage(7, langRU)
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/ayah"})
+	got := sender.lastMessage()
+	if !strings.Contains(got, ayahs[2].Arabic) || !strings.Contains(got, ayahs[2].Translation[langRU]) || !strings.Contains(got, "Коран, "+ayahs[2].Ref) {
+		t.Fatalf("unexpected ayah text:\n%s", got)
+	}
+
+	if opts := b.hadithCardOptions(7, langRU); opts.Ayah.Text != "" {
+		t.Fatal("the ayah panel must be opt-in")
+	}
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/ayahcard"})
+	opts := b.hadithCardOptions(7, langRU)
+	if opts.Ayah.Text != ayahs[2].Translation[langRU] || strings.Contains(opts.Ayah.String(), ayahs[2].Arabic) {
+		t.Fatalf("the card should carry only the translation, got %+v", opts.Ayah)
+	}
+}
+
+func TestLoadHadithsFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, raw string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(raw), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	custom, err := loadHadithsFile(write("ok.json", `{"EN": [{"text": "Smiling is charity", "source": "Tirmidhi", "tags": ["brotherhood"]}, "\"Dua is worship\" — Tirmidhi"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := mergeHadiths(sampleHadithsByLang(), custom)
+	if got := merged[langEN]; len(got) != 2 || got[0].Source != "Tirmidhi" || got[1].Text != `"Dua is worship"` {
+		t.Fatalf("expected the file to replace the English hadiths, got %+v", got)
+	}
+	if len(merged[langRU]) != len(sampleHadithsByLang()[langRU]) {
+		t.Fatal("languages missing from the file must keep the built-in hadiths")
+	}
+
+	for name, raw := range map[string]string{
+		"syntax.json":  `{"en": [`,
+		"lang.json":    `{"fr": ["Texte"]}`,
+		"empty.json":   `{"en": []}`,
+		"notext.json":  `{"en": [{"source": "Bukhari"}]}`,
+		"tag.json":     `{"en": [{"text": "x", "tags": ["weather"]}]}`,
+		"nothing.json": `{}`,
+	} {
+		if _, err := loadHadithsFile(write(name, raw)); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestRichReminderFallsBackToPlain(t *testing.T) {
+	ev := eventSpec{Key: "dhuhr", Title: "Dhuhr", Time: time.Now().Add(time.Hour)}
+	sender := &recordingSender{
+		photoErr: errors.New("photos disabled"),
+		richErr:  newTelegramError("sendMessage", http.StatusBadRequest, "Bad Request: can't parse entities", responseParameters{}),
+	}
+	rm := &ReminderManager{
+		loc:           time.UTC,
+		sender:        sender,
+		imageCache:    newImageCache(4, 1<<20),
+		getLangFn:     func(chatID int64) string { return langEN },
+		hadithsByLang: map[string][]Hadith{langEN: {{Text: "Fasting is a shield", Source: "Bukhari"}}},
+		useRichText:   true,
+	}
+	if err := rm.sendReminder(1, "Душанбе", 1, ev); err != nil {
+		t.Fatalf("sendReminder: %v", err)
+	}
+	got := sender.lastMessage()
+	if strings.Contains(got, "<b>") || !strings.Contains(got, "╔") {
+		t.Fatalf("expected the plain boxed hadith after HTML was rejected, got %q", got)
+	}
+}
+
+func TestHadithFrameBordersMatch(t *testing.T) {
+	for _, lang := range []string{langTG, langRU, langEN, langUZ} {
+		block := formatHadithBlock(lang, tr(lang, "hadith_day_title"), Hadith{Text: "Fasting is a shield", Source: "Bukhari"})
+		lines := strings.Split(block, "\n")
+		top, bottom := lines[0], lines[len(lines)-1]
+		if utf8.RuneCountInString(top) != utf8.RuneCountInString(bottom) {
+			t.Errorf("%s: top %q and bottom %q borders differ in width", lang, top, bottom)
+		}
+	}
+}
+
+func TestHideMenuPersistsAndRemovesKeyboard(t *testing.T) {
+	var markups []string
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ReplyMarkup json.RawMessage `json:"reply_markup"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		markups = append(markups, string(body.ReplyMarkup))
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":1}}`)
+	})
+	b.state.SetLanguage(7, langEN)
+
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/hidemenu"})
+	if !b.state.Get(7).MenuHidden {
+		t.Fatal("/hidemenu should persist MenuHidden")
+	}
+	b.sendHelp(7)
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/showmenu"})
+
+	if len(markups) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(markups))
+	}
+	if markups[0] != `{"remove_keyboard":true}` {
+		t.Errorf("/hidemenu markup = %s", markups[0])
+	}
+	if markups[1] != "" && markups[1] != "null" {
+		t.Errorf("help should carry no keyboard while hidden, got %s", markups[1])
+	}
+	if !strings.Contains(markups[2], `"keyboard"`) || b.state.Get(7).MenuHidden {
+		t.Errorf("/showmenu should restore the keyboard, got %s", markups[2])
+	}
+}
+
+func TestFormatCalendarTextAlignsColumns(t *testing.T) {
+	schedule := buildCalendars(2026)["Душанбе"]
+	text := formatCalendarText(langRU, "Душанбе", schedule)
+	if !strings.Contains(text, "<pre>") || !strings.HasSuffix(text, "</pre>") {
+		t.Fatalf("expected a <pre> table, got %q", text)
+	}
+	body := text[strings.Index(text, "<pre>")+len("<pre>") : len(text)-len("</pre>")]
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	if len(lines) != ramadanDays+1 {
+		t.Fatalf("expected header plus %d days, got %d lines", ramadanDays, len(lines))
+	}
+	iftarCol := strings.LastIndex(lines[1], " ") + 1
+	for _, line := range lines {
+		if got := utf8.RuneCountInString(line[:strings.LastIndex(line, " ")+1]); got != utf8.RuneCountInString(lines[1][:iftarCol]) {
+			t.Fatalf("iftar column misaligned in %q", line)
+		}
+	}
+}
+
+func TestRenderCalendarPDF(t *testing.T) {
+	schedule := buildCalendars(2026)["Душанбе"]
+	doc, err := renderCalendarPDF(schedule, time.Date(2026, 2, 19, 0, 0, 0, 0, time.UTC), "Душанбе", langRU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(doc, []byte("%PDF-1.4")) || !bytes.HasSuffix(doc, []byte("%%EOF\n")) {
+		t.Fatalf("not a PDF: %q...", doc[:16])
+	}
+	xref := bytes.LastIndex(doc, []byte("startxref\n"))
+	var offset int
+	if _, err := fmt.Sscanf(string(doc[xref+len("startxref\n"):]), "%d", &offset); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(doc[offset:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", offset)
+	}
+	if !bytes.Contains(doc, []byte("/FontFile2")) || !bytes.Contains(doc, []byte("/ToUnicode")) {
+		t.Fatal("expected an embedded font with a ToUnicode map")
+	}
+}
+
+func TestReminderPhotoGetsReaction(t *testing.T) {
+	sender := &recordingSender{}
+	rm := &ReminderManager{
+		loc:        time.UTC,
+		sender:     sender,
+		imageCache: newImageCache(4, 8<<20),
+		getLangFn:  func(chatID int64) string { return langEN },
+		reaction:   "🙏",
+	}
+	ev := eventSpec{Key: "maghrib", Title: "Iftar", Time: time.Now().Add(time.Hour), UseIftar: true}
+	if err := rm.sendReminder(1, "Душанбе", 1, ev); err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.photos) != 1 || len(sender.reactions) != 1 || sender.reactions[0] != "1:🙏" {
+		t.Fatalf("expected a reaction on the reminder photo, got photos %d reactions %q", len(sender.photos), sender.reactions)
+	}
+}
+
+func TestTextModeSkipsReminderImages(t *testing.T) {
+	sender := &recordingSender{}
+	rm := &ReminderManager{
+		loc:        time.UTC,
+		sender:     sender,
+		imageCache: newImageCache(4, 1<<20),
+		getLangFn:  func(chatID int64) string { return langEN },
+		settingsFn: func(chatID int64) UserSettings { return UserSettings{ImagesDisabled: true} },
+	}
+	ev := eventSpec{Key: "maghrib", Title: "Iftar", Time: time.Now().Add(time.Hour), UseIftar: true}
+	if err := rm.sendReminder(1, "Душанбе", 1, ev); err != nil {
+		t.Fatal(err)
+	}
+	if len(sender.photos) != 0 {
+		t.Fatalf("text mode must not send photos, got %d", len(sender.photos))
+	}
+	if !strings.HasPrefix(sender.lastMessage(), reminderHeadline(langEN, "Душанбе", 1, ev, time.UTC)) {
+		t.Fatalf("text reminder should start with the headline, got %q", sender.lastMessage())
+	}
+}
+
+func TestHanafiAsrIsLater(t *testing.T) {
+	// Around 38-40°N in late February the Hanafi asr falls roughly 40-60 minutes later.
+	delta := hanafiAsrDelta(38.56, time.Date(2026, 2, 25, 0, 0, 0, 0, time.UTC))
+	if delta < 35 || delta > 65 {
+		t.Fatalf("unexpected Hanafi asr delta %d minutes", delta)
+	}
+
+	// The muftiate's timetable for Dushanbe lists asr at 16:40 on 19.02.2026, the
+	// Hanafi time; the standard asr that day is around 15:40.
+	day := dayByNumber(t, buildCalendars(2026)["Душанбе"], 1)
+	if got := minutesToClock(day.withAsrMethod("").Asr); got != "16:40" {
+		t.Fatalf("default asr = %s, want the published 16:40", got)
+	}
+	if got := minutesToClock(day.withAsrMethod(asrHanafi).Asr); got != "16:40" {
+		t.Fatalf("Hanafi asr = %s, want the published 16:40", got)
+	}
+	standard := day.withAsrMethod(asrStandard).Asr
+	if standard < mustClockToMinutes("15:30") || standard > mustClockToMinutes("16:05") {
+		t.Fatalf("standard asr = %s, want about 15:40", minutesToClock(standard))
+	}
+}
+
+func TestConfirmFastCountsEachDayOnce(t *testing.T) {
+	state, err := newStateStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const season = "2026-02-19"
+	for _, day := range []int{1, 2, 2, 4} {
+		state.ConfirmFast(7, season, day)
+	}
+	settings := state.Get(7)
+	if settings.FastCount != 3 || settings.FastStreak != 1 || settings.LastFastDay != 4 {
+		t.Fatalf("got count=%d streak=%d last=%d, want 3/1/4", settings.FastCount, settings.FastStreak, settings.LastFastDay)
+	}
+	if got, counted := state.ConfirmFast(7, season, 3); !counted || got.FastCount != 4 || got.FastStreak != 4 || got.LastFastDay != 4 {
+		t.Fatalf("an unconfirmed earlier day should still count and join the streak, got %+v", got)
+	}
+	if _, counted := state.ConfirmFast(7, season, 3); counted {
+		t.Fatal("a confirmed earlier day must count once")
+	}
+	if got, _ := state.ConfirmFast(7, season, 5); got.FastStreak != 5 {
+		t.Fatalf("consecutive day should extend the streak, got %d", got.FastStreak)
+	}
+	if got, _ := state.ConfirmFast(7, "2027-02-08", 1); got.FastCount != 1 || got.FastStreak != 1 {
+		t.Fatalf("a new season should reset the counters, got %+v", got)
+	}
+	if got := progressText(langEN, 12, *state.Get(7), "2027-02-08"); got != "Day 12/30, fasts confirmed: 1, streak: 1" {
+		t.Fatalf("unexpected progress text %q", got)
+	}
+}
+
+func TestLinkPreviewFlag(t *testing.T) {
+	var flags []bool
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		var body sendMessageRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		flags = append(flags, body.DisableWebPagePreview)
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":1}}`)
+	})
+	if _, err := b.SendMessage(1, "https://example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.SendMessageWithPreview(1, "https://example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(flags) != 2 || !flags[0] || flags[1] {
+		t.Fatalf("disable_web_page_preview = %v, want [true false]", flags)
+	}
+}
+
+func TestRamadanEveIsLabelled(t *testing.T) {
+	cal := buildCalendars(2026)["Душанбе"]
+	eve := dayByNumber(t, cal, 0)
+
+	caption := trf(langEN, "day_caption", "Dushanbe", eve.Data, dayHijriDate(langEN, eve), dayLabel(langEN, eve.Day))
+	if strings.Contains(caption, "Day 0") || !strings.Contains(caption, "Ramadan eve") {
+		t.Fatalf("day 0 caption should use the eve label, got %q", caption)
+	}
+	if got := dayLabel(langEN, 1); got != "Day 1" {
+		t.Fatalf("dayLabel(1) = %q", got)
+	}
+	ev := eventSpec{Key: "isha", Time: time.Date(2026, 2, 18, 19, 30, 0, 0, time.UTC)}
+	if got := reminderHeadline(langEN, "Душанбе", 0, ev, time.UTC); strings.Contains(got, "day 0") {
+		t.Fatalf("eve reminder headline shows day 0: %q", got)
+	}
+	if fastedMarkup(langEN, 0) != nil {
+		t.Fatal("no fasting button on the eve of Ramadan")
+	}
+	if _, err := renderTodayImage("Душанбе", eve, langEN, themeByName(""), Hadith{}, Hadith{}); err != nil {
+		t.Fatalf("rendering the eve card: %v", err)
+	}
+}
+
+func TestCountdownText(t *testing.T) {
+	loc := time.FixedZone("UTC+5", 5*3600)
+	start := time.Date(2026, 2, 19, 0, 0, 0, 0, loc)
+	cases := []struct {
+		now  time.Time
+		want string
+	}{
+		{start.Add(-(3*24 + 5) * time.Hour), "3 days and 5 hours until Ramadan."},
+		{start.Add(-90 * time.Minute), "0 days and 2 hours until Ramadan."},
+		{start.Add(time.Hour), "Ramadan is ongoing: day 1 of 30."},
+		{start.AddDate(0, 0, 29).Add(23 * time.Hour), "Ramadan is ongoing: day 30 of 30."},
+		{start.AddDate(0, 0, 30), "This year’s Ramadan has ended. Eid Mubarak!"},
+	}
+	for _, c := range cases {
+		if got := countdownText(langEN, start, c.now); got != c.want {
+			t.Errorf("countdownText at %s = %q, want %q", c.now, got, c.want)
+		}
+	}
+}
+
+func TestDayLabelsUseLocalOrdinals(t *testing.T) {
+	cases := map[string][2]string{
+		langEN: {"Day 5", "Ramadan day 5"},
+		langRU: {"5-й день", "5-й день Рамадана"},
+		langTG: {"Рӯзи 5-ум", "Рӯзи 5-уми Рамазон"},
+		langUZ: {"5-kun", "Ramazonning 5-kuni"},
+	}
+	for lang, want := range cases {
+		if got := dayLabel(lang, 5); got != want[0] {
+			t.Errorf("dayLabel(%s) = %q, want %q", lang, got, want[0])
+		}
+		if got := ramadanDayLabel(lang, 5); got != want[1] {
+			t.Errorf("ramadanDayLabel(%s) = %q, want %q", lang, got, want[1])
+		}
+	}
+	for day, want := range map[int]string{1: "1-ум", 2: "2-юм", 3: "3-юм", 12: "12-ум", 13: "13-ум", 20: "20-ум", 23: "23-юм", 30: "30-юм"} {
+		if got := dayOrdinal(langTG, day); got != want {
+			t.Errorf("dayOrdinal(tg, %d) = %q, want %q", day, got, want)
+		}
+	}
+}
+
+func TestCalendarICS(t *testing.T) {
+	loc := time.FixedZone("+05", 5*3600)
+	start := time.Date(2026, 2, 19, 0, 0, 0, 0, loc)
+	schedule := buildCalendars(2026)["Душанбе"]
+	doc := string(renderCalendarICS(schedule, start, "Душанбе", langRU, loc, start))
+
+	if !strings.HasPrefix(doc, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(doc, "END:VCALENDAR\r\n") {
+		t.Fatal("expected a CRLF-delimited VCALENDAR")
+	}
+	days := 0
+	for _, day := range schedule {
+		if day.Day >= 1 {
+			days++
+		}
+	}
+	if got, want := strings.Count(doc, "BEGIN:VEVENT"), days*len(prayerKeys); got != want {
+		t.Fatalf("got %d events, want %d", got, want)
+	}
+	if got := strings.Count(doc, "TRIGGER:-PT30M"); got != days*2 {
+		t.Fatalf("got %d alarms, want %d (suhoor and iftar)", got, days*2)
+	}
+	first := dayByNumber(t, schedule, 1)
+	want := "DTSTART;TZID=+05:20260219T" + strings.ReplaceAll(minutesToClock(first.SuhoorEnd), ":", "") + "00"
+	if !strings.Contains(doc, want) {
+		t.Fatalf("missing %q", want)
+	}
+	if !strings.Contains(doc, "TZOFFSETTO:+0500") {
+		t.Fatal("missing VTIMEZONE offset")
+	}
+	for _, l := range strings.Split(doc, "\r\n") {
+		if len(l) > 75 {
+			t.Fatalf("line not folded: %q", l)
+		}
+	}
+}
+
+func TestSubscribeServesCalendarFeed(t *testing.T) {
+	sender := &recordingSender{}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Bot API call to %s", r.URL.Path)
+	}, withSender(sender))
+	b.calendars.Replace(buildCalendars(2026))
+	b.state.SetLanguage(7, langEN)
+	b.state.SetRegion(7, "Душанбе")
+
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/subscribe"})
+	if sender.lastMessage() != tr(langEN, "subscribe_unavailable") {
+		t.Fatalf("expected subscriptions to need PUBLIC_URL, got %q", sender.lastMessage())
+	}
+
+	b.publicURL = "https://bot.example.com"
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/subscribe"})
+	token := b.state.Get(7).CalendarToken
+	if len(token) != 32 {
+		t.Fatalf("expected a random token, got %q", token)
+	}
+	if want := "webcal://bot.example.com/calendar/" + token + ".ics"; !strings.Contains(sender.lastMessage(), want) {
+		t.Fatalf("expected %s in %q", want, sender.lastMessage())
+	}
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/subscribe"})
+	if b.state.Get(7).CalendarToken != token {
+		t.Fatal("the token must stay the same across /subscribe calls")
+	}
+
+	handler := b.healthHandler()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/calendar/"+token+".ics", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/calendar") {
+		t.Fatalf("feed: got status %d, type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "SUMMARY:"+tr(langEN, "event_maghrib")) {
+		t.Fatal("expected the feed in the chat's language")
+	}
+
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/subscribe reset"})
+	fresh := b.state.Get(7).CalendarToken
+	if fresh == token || !strings.Contains(sender.lastMessage(), fresh) {
+		t.Fatalf("expected a new link after reset, got %q", sender.lastMessage())
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/calendar/"+fresh+".ics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("new feed: got status %d", rec.Code)
+	}
+
+	for _, path := range []string{"/calendar/nope.ics", "/calendar/" + fresh, "/calendar/" + token + ".ics"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("%s: got status %d want 404", path, rec.Code)
+		}
+	}
+
+	// The token index is rebuilt when the state is loaded.
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := newStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetRegion(8, "Худжанд")
+	saved := store.EnsureCalendarToken(8)
+	reloaded, err := newStateStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := reloaded.ChatByCalendarToken(saved); !ok || got.Region != "Худжанд" {
+		t.Fatalf("expected the reloaded token to resolve, got %+v, %v", got, ok)
+	}
+}
+
+func TestFailedCardsFallBackToText(t *testing.T) {
+	sender := &recordingSender{photoErr: errors.New("request entity too large")}
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Bot API call to %s", r.URL.Path)
+	}, withSender(sender))
+	b.calendars.Replace(buildCalendars(time.Now().Year()))
+	b.state.SetLanguage(7, langEN)
+	b.state.SetRegion(7, "Душанбе")
+
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/calendar"})
+	if !strings.Contains(sender.lastMessage(), "<pre>") {
+		t.Fatalf("expected the calendar table after a failed photo, got %q", sender.lastMessage())
+	}
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/today"})
+	if !strings.Contains(sender.lastMessage(), tr(langEN, "event_maghrib")) {
+		t.Fatalf("expected today's timetable as text, got %q", sender.lastMessage())
+	}
+
+	sender.richErr = errors.New("can't parse entities")
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/calendar"})
+	if sender.lastMessage() != tr(langEN, "card_failed") {
+		t.Fatalf("expected the try-again note when the text fails too, got %q", sender.lastMessage())
+	}
+
+	sender.messages = nil
+	sender.photoErr = &TelegramError{Method: "sendPhoto", Code: http.StatusForbidden, Description: "Forbidden: bot was blocked by the user"}
+	b.handleMessage(&Message{Chat: Chat{ID: 7}, Text: "/calendar"})
+	if len(sender.messages) != 0 {
+		t.Fatalf("blocked chats must get nothing, got %q", sender.messages)
+	}
+}
+
+func TestSetCommandsPublishesEveryScopeAndLanguage(t *testing.T) {
+	type menuKey struct{ scope, code string }
+	var mu sync.Mutex
+	menus := map[menuKey][]BotCommand{}
+	menuButtons := 0
+	b := newTestBot(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/bot/setMyCommands":
+			var body struct {
+				Commands []BotCommand `json:"commands"`
+				Scope    struct {
+					Type string `json:"type"`
+				} `json:"scope"`
+				LanguageCode string `json:"language_code"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("decode body: %v", err)
+			}
+			menus[menuKey{body.Scope.Type, body.LanguageCode}] = body.Commands
+		case "/bot/setChatMenuButton":
+			menuButtons++
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"ok":true,"result":true}`)
+	})
+	if err := b.setCommands(); err != nil {
+		t.Fatal(err)
+	}
+	if len(menus) != 3*(len(supportedLangs)+1) || menuButtons != 1 {
+		t.Fatalf("expected a default and %d localized menus per scope plus the menu button, got %d menus, %d buttons", len(supportedLangs), len(menus), menuButtons)
+	}
+	for _, name := range menuCommands {
+		if !slices.Contains(knownCommands, "/"+name) {
+			t.Errorf("menu command /%s is not handled", name)
+		}
+	}
+	has := func(commands []BotCommand, name string) bool {
+		return slices.ContainsFunc(commands, func(c BotCommand) bool { return c.Command == name })
+	}
+	for key, commands := range menus {
+		lang := key.code
+		if lang == "" {
+			lang = langEN
+		}
+		for _, cmd := range commands {
+			if cmd.Description == "" || cmd.Description != tr(lang, "cmd_"+cmd.Command) {
+				t.Fatalf("%+v: /%s described as %q", key, cmd.Command, cmd.Description)
+			}
+		}
+		switch key.scope {
+		case commandScopePrivate:
+			if has(commands, "mylang") || !has(commands, "tasbih") {
+				t.Fatalf("%+v: private menu should list personal commands only", key)
+			}
+		case commandScopeGroups:
+			if has(commands, "tasbih") || !has(commands, "mylang") {
+				t.Fatalf("%+v: group menu should list shared commands only", key)
+			}
+		case commandScopeDefault:
+			if len(commands) != len(menuCommands) {
+				t.Fatalf("%+v: default menu should list every command", key)
+			}
+		}
+	}
+	if menus[menuKey{commandScopePrivate, langRU}][0].Description == menus[menuKey{commandScopePrivate, ""}][0].Description {
+		t.Fatal("expected Russian descriptions in the ru menu")
+	}
+}